@@ -0,0 +1,58 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/urfave/cli/v2"
+
+	"github.com/usedbytes/osgrid"
+	"github.com/usedbytes/osgrid/osdata/bintile"
+	"github.com/usedbytes/osgrid/osdata/terrain50"
+)
+
+func runConvert(c *cli.Context) error {
+	src := c.String("elevation")
+
+	dst := c.String("outdir")
+	if dst == "" {
+		if strings.ToLower(filepath.Ext(src)) == ".zip" {
+			return fmt.Errorf("--outdir is required when converting from the zip file")
+		}
+		dst = src
+	}
+
+	enc, err := bintile.ParseEncoding(c.String("encoding"))
+	if err != nil {
+		return err
+	}
+
+	err = terrain50.Convert(src, dst, 10*osgrid.Kilometre, enc)
+	if err != nil {
+		return fmt.Errorf("converting: %w", err)
+	}
+
+	return nil
+}
+
+var convertCmd cli.Command = cli.Command{
+	Name: "convert",
+	Usage: "Convert Terrain 50 data to binary tiles, which are much faster to load\n" +
+		"\n" +
+		"The binary tiles are used in preference to the original data.",
+	Flags: []cli.Flag{
+		elevationFlag(),
+		&cli.StringFlag{
+			Name:        "outdir",
+			Usage:       "`DIR` to write the binary tiles to (they're written into a 'data' folder)",
+			DefaultText: "alongside the elevation data",
+		},
+		&cli.StringFlag{
+			Name:  "encoding",
+			Usage: "Sample `ENCODING`: int16 (decimetres) or float32",
+			Value: "int16",
+		},
+	},
+	Action: runConvert,
+}
@@ -1,11 +1,30 @@
 package main
 
 import (
+	"errors"
 	"fmt"
+	"io"
+	"io/ioutil"
 	"os"
+	"path/filepath"
+	"strconv"
 	"strings"
 
 	"github.com/urfave/cli/v2"
+
+	"github.com/usedbytes/osgrid"
+	"github.com/usedbytes/osgrid/osdata"
+	"github.com/usedbytes/osgrid/osdata/ascgrid"
+	"github.com/usedbytes/osgrid/osdata/boundaryline"
+	"github.com/usedbytes/osgrid/osdata/codepoint"
+	"github.com/usedbytes/osgrid/osdata/geotiff"
+	"github.com/usedbytes/osgrid/osdata/httptile"
+	"github.com/usedbytes/osgrid/osdata/opennames"
+	"github.com/usedbytes/osgrid/osdata/osdatatest"
+	"github.com/usedbytes/osgrid/osdata/raster"
+	"github.com/usedbytes/osgrid/osdata/terrain5"
+	"github.com/usedbytes/osgrid/osdata/terrain50"
+	"github.com/usedbytes/osgrid/osdata/wmts"
 )
 
 const snowdon = "SH 60986 54375"
@@ -33,22 +52,392 @@ func elevationFlag() *cli.StringFlag {
 	return &cli.StringFlag{
 		Name:     "elevation",
 		Aliases:  []string{"e"},
-		Usage:    "`PATH` to elevation data (should contain 'data' folder)",
+		Usage:    "`PATH` to elevation data (should contain 'data' folder, or tiles), or the Terrain 50 zip",
 		Required: true,
 		EnvVars:  []string{"OSMODEL_ELEVATION_DB"},
 	}
 }
 
+func elevationTypeFlag() *cli.StringFlag {
+	return &cli.StringFlag{
+		Name:        "elevation-type",
+		Usage:       "`TYPE` of elevation data: terrain50, terrain5, asc (any ESRI ASCII Grid tiles), geotiff (single-band DEM GeoTIFFs) or synthetic (--elevation is one of: plane, cone, noise)",
+		DefaultText: "detect from the data",
+		EnvVars:     []string{"OSMODEL_ELEVATION_TYPE"},
+	}
+}
+
+// Guess the type of an elevation dataset from the names of the tiles
+func detectElevationType(path string) (string, error) {
+	if strings.ToLower(filepath.Ext(path)) == ".zip" {
+		// Terrain 50 can be read straight from the distributed archive
+		return "terrain50", nil
+	}
+
+	datapath := filepath.Join(path, "data")
+
+	squares, err := ioutil.ReadDir(datapath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			// Not an OS dataset, so treat it as a directory of tiles
+			return detectTileType(path)
+		}
+		return "", err
+	}
+
+	for _, square := range squares {
+		if !square.IsDir() {
+			continue
+		}
+
+		tiles, err := ioutil.ReadDir(filepath.Join(datapath, square.Name()))
+		if err != nil {
+			return "", err
+		}
+
+		for _, tile := range tiles {
+			if terrain5.IsTileName(tile.Name()) {
+				return "terrain5", nil
+			}
+		}
+	}
+
+	return "terrain50", nil
+}
+
+// Find the type of the first tile file under path
+func detectTileType(path string) (string, error) {
+	errFound := fmt.Errorf("found")
+	tileType := ""
+
+	err := filepath.Walk(path, func(p string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		switch strings.ToLower(filepath.Ext(p)) {
+		case ".asc":
+			tileType = "asc"
+		case ".tif", ".tiff":
+			tileType = "geotiff"
+		default:
+			return nil
+		}
+
+		return errFound
+	})
+	if err != nil && err != errFound {
+		return "", err
+	}
+
+	if tileType == "" {
+		return "", fmt.Errorf("couldn't determine elevation-type for %s", path)
+	}
+
+	return tileType, nil
+}
+
+// Returns a function to close any files held open by the database (e.g. the
+// zip file of a Terrain 50 archive). The Float64Database interface doesn't
+// have Close, and the database may be wrapped, so it's done here.
+func openElevationDatabase(c *cli.Context) (osdata.Float64Database, func(), error) {
+	path := c.String("elevation")
+
+	dbType := c.String("elevation-type")
+	if dbType == "" {
+		var err error
+		dbType, err = detectElevationType(path)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+
+	var db osdata.Float64Database
+	var tileSize osgrid.Distance
+	var err error
+
+	switch dbType {
+	case "terrain50":
+		tileSize = 10 * osgrid.Kilometre
+		db, err = terrain50.OpenDatabase(path, tileSize)
+	case "terrain5":
+		tileSize = terrain5.TileSize
+		db, err = terrain5.OpenDatabase(path)
+	case "asc":
+		db, err = ascgrid.OpenDatabase(path)
+		if err == nil {
+			tileSize, _ = db.(*ascgrid.Database).TileSize()
+		}
+	case "geotiff":
+		// Tiles needn't be on a regular grid, so this is just the size
+		// of the filled-in tiles. Squares which are only partly
+		// covered aren't remembered as missing (see
+		// osdata.PartialCoverage).
+		tileSize = 1 * osgrid.Kilometre
+		db, err = geotiff.OpenDatabase(path)
+	case "synthetic":
+		tileSize = 10 * osgrid.Kilometre
+		db, err = openSyntheticDatabase(path, tileSize)
+	default:
+		return nil, nil, fmt.Errorf("unknown elevation-type: '%s'", dbType)
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+
+	closeDB := func() {}
+	if closer, ok := db.(io.Closer); ok {
+		closeDB = func() { closer.Close() }
+	}
+
+	wrapped, err := applyMissingTilesPolicy(c, db, tileSize)
+	if err != nil {
+		closeDB()
+		return nil, nil, err
+	}
+
+	return wrapped, closeDB, nil
+}
+
+// Generated terrain, for trying things out without downloading any data
+func openSyntheticDatabase(name string, tileSize osgrid.Distance) (osdata.Float64Database, error) {
+	var f osdatatest.Float64Func
+
+	switch name {
+	case "plane":
+		// Gently sloping up towards the North-East
+		f = osdatatest.Plane(0, 0.0005, 0.001)
+	case "cone":
+		summit, _ := osgrid.ParseGridRef(snowdon)
+		f = osdatatest.Cone(summit, 1085, 3000)
+	case "noise":
+		f = osdatatest.FractalNoise(1, 1000, 20000, 6)
+	default:
+		return nil, fmt.Errorf("unknown synthetic elevation: '%s'", name)
+	}
+
+	return osdatatest.NewFuncDatabase(f, 50*osgrid.Metre, tileSize), nil
+}
+
+func missingTilesFlag() *cli.StringFlag {
+	return &cli.StringFlag{
+		Name: "missing-tiles",
+		Usage: "`POLICY` for elevation tiles missing from the dataset (e.g. open sea). " +
+			"'error', 'nearest' (use the closest available data), or a value to fill with",
+		Value: "error",
+	}
+}
+
+func applyMissingTilesPolicy(c *cli.Context, db osdata.Float64Database, tileSize osgrid.Distance) (osdata.Float64Database, error) {
+	policy := c.String("missing-tiles")
+
+	switch policy {
+	case "error":
+		return db, nil
+	case "nearest":
+		return osdata.FillMissingTiles(db, tileSize, osdata.MissingTileFillNearest, 0), nil
+	}
+
+	v, err := strconv.ParseFloat(policy, 64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid missing-tiles policy: '%s'", policy)
+	}
+
+	return osdata.FillMissingTiles(db, tileSize, osdata.MissingTileFillConstant, v), nil
+}
+
 func rasterFlag(required bool) *cli.StringFlag {
 	return &cli.StringFlag{
 		Name:     "raster",
 		Aliases:  []string{"r"},
-		Usage:    "`PATH` to raster data (should contain 'data' folder)",
+		Usage:    "`PATH` to raster data (should contain 'data' folder), or a WMTS GetCapabilities URL",
 		Required: required,
 		EnvVars:  []string{"OSMODEL_RASTER_DB"},
 	}
 }
 
+func wmtsLayerFlag() *cli.StringFlag {
+	return &cli.StringFlag{
+		Name:  "wmts-layer",
+		Usage: "`LAYER` to use when --raster is a WMTS GetCapabilities URL",
+	}
+}
+
+func wmtsZoomFlag() *cli.StringFlag {
+	return &cli.StringFlag{
+		Name:  "wmts-zoom",
+		Usage: "`ZOOM` level (tile matrix identifier or index) to use when --raster is a WMTS GetCapabilities URL",
+	}
+}
+
+// Open the --raster database, which is either a local directory or a WMTS
+// GetCapabilities URL
+func openRasterDatabase(c *cli.Context) (osdata.ImageDatabase, error) {
+	path := c.String("raster")
+
+	if !strings.HasPrefix(path, "http://") && !strings.HasPrefix(path, "https://") {
+		return raster.OpenDatabase(path, 10*osgrid.Kilometre)
+	}
+
+	if c.String("wmts-layer") == "" || c.String("wmts-zoom") == "" {
+		return nil, fmt.Errorf("--wmts-layer and --wmts-zoom are required for WMTS")
+	}
+
+	return wmts.OpenDatabase(httptile.NewClient(), path, c.String("wmts-layer"), c.String("wmts-zoom"))
+}
+
+func namesFlag() *cli.StringFlag {
+	return &cli.StringFlag{
+		Name:    "names",
+		Usage:   "`PATH` to OS Open Names data, so that GRID_REFERENCE can be a place name",
+		EnvVars: []string{"OSMODEL_NAMES_DB"},
+	}
+}
+
+func postcodesFlag() *cli.StringFlag {
+	return &cli.StringFlag{
+		Name:    "postcodes",
+		Usage:   "`PATH` to OS Code-Point Open data, so that GRID_REFERENCE can be a postcode",
+		EnvVars: []string{"OSMODEL_POSTCODES_DB"},
+	}
+}
+
+// Parse the GRID_REFERENCE argument, which can be a postcode if --postcodes
+// is set, or a place name if --names is set
+func parseGridRefArg(c *cli.Context) (osgrid.GridRef, error) {
+	arg := snowdon
+	if c.NArg() > 0 {
+		arg = strings.Join(c.Args().Slice(), " ")
+	}
+
+	return parseLocation(arg, c.String("postcodes"), c.String("names"))
+}
+
+// Find the location arg refers to. Postcodes are tried first, because
+// postcode districts like "SE10" are also valid grid references, then grid
+// references, and then place names. Empty paths disable postcodes or place
+// names.
+func parseLocation(arg, postcodes, names string) (osgrid.GridRef, error) {
+	var postcodeErr error
+	if postcodes != "" && codepoint.IsPostcode(arg) {
+		db, err := codepoint.Open(postcodes)
+		if err != nil {
+			return osgrid.GridRef{}, fmt.Errorf("opening postcodes database: %w", err)
+		}
+
+		ext, err := db.Lookup(arg)
+		if err == nil {
+			return ext.Centroid, nil
+		} else if !errors.Is(err, codepoint.ErrNotFound) {
+			return osgrid.GridRef{}, err
+		}
+
+		// Not a real postcode, so try the other kinds
+		postcodeErr = err
+	}
+
+	ref, err := osgrid.ParseGridRef(arg)
+	if err == nil {
+		return ref, nil
+	}
+
+	if names == "" {
+		if postcodeErr != nil {
+			return osgrid.GridRef{}, postcodeErr
+		}
+		return ref, err
+	}
+
+	// Postcodes and roads aren't useful, and take a lot of memory
+	g, err := opennames.Open(names,
+		opennames.TypesOpt("populatedPlace", "landform", "hydrography", "landcover", "other"))
+	if err != nil {
+		return osgrid.GridRef{}, fmt.Errorf("opening names database: %w", err)
+	}
+
+	f, err := g.Lookup(arg)
+	if err == nil {
+		return f.GridRef, nil
+	}
+
+	matches := g.Search(arg, opennames.SearchLimitOpt(5))
+	if len(matches) == 0 {
+		return osgrid.GridRef{}, err
+	}
+
+	var suggestions []string
+	for _, m := range matches {
+		suggestions = append(suggestions, m.Feature.String())
+	}
+
+	return osgrid.GridRef{}, fmt.Errorf("%w, did you mean: %s", err, strings.Join(suggestions, "; "))
+}
+
+func boundariesFlag() *cli.StringFlag {
+	return &cli.StringFlag{
+		Name:    "boundaries",
+		Usage:   "`PATH` to OS Boundary-Line data (or other boundary shapefiles), for --outline",
+		EnvVars: []string{"OSMODEL_BOUNDARIES_DB"},
+	}
+}
+
+func outlineFlag() *cli.StringFlag {
+	return &cli.StringFlag{
+		Name: "outline",
+		Usage: "Cut the output to the outline of the boundary called `NAME` (requires --boundaries). " +
+			"If GRID_REFERENCE and --width aren't set, the region is fitted to the boundary.",
+	}
+}
+
+// Apply --outline, masking db to the boundary, and fitting gridRef and width
+// to it unless they were set explicitly
+func applyOutline(c *cli.Context, db osdata.Float64Database,
+	gridRef *osgrid.GridRef, width *osgrid.Distance) (osdata.Float64Database, error) {
+
+	if !c.IsSet("outline") {
+		return db, nil
+	}
+
+	if c.String("boundaries") == "" {
+		return nil, fmt.Errorf("--outline requires --boundaries")
+	}
+
+	bdb, err := boundaryline.Open(c.String("boundaries"))
+	if err != nil {
+		return nil, fmt.Errorf("opening boundaries: %w", err)
+	}
+
+	b, err := bdb.Lookup(c.String("outline"))
+	if err != nil {
+		return nil, err
+	}
+
+	rect, err := b.Rect()
+	if err != nil {
+		return nil, err
+	}
+
+	if c.NArg() == 0 {
+		*gridRef, err = rect.Centre()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if !c.IsSet("width") {
+		size := rect.Width
+		if rect.Height > size {
+			size = rect.Height
+		}
+
+		// Round up, so the whole boundary is included
+		p := db.Precision()
+		*width = (size + p - 1) / p * p
+	}
+
+	return boundaryline.NewMask(db, b), nil
+}
+
 func hresFlag() *cli.UintFlag {
 	return &cli.UintFlag{
 		Name:        "hres",
@@ -84,6 +473,7 @@ func main() {
 			&surfaceCmd,
 			&textureCmd,
 			&meshCmd,
+			&convertCmd,
 		},
 	}
 
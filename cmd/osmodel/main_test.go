@@ -0,0 +1,105 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/usedbytes/osgrid"
+)
+
+// Write files, by their paths, into a new temporary directory
+func writeFiles(t *testing.T, files map[string]string) string {
+	dir, err := ioutil.TempDir("", "osmodel")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for name, data := range files {
+		path := filepath.Join(dir, name)
+		os.MkdirAll(filepath.Dir(path), 0755)
+
+		err = ioutil.WriteFile(path, []byte(data), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	return dir
+}
+
+// Write a Code-Point Open directory with a postcode in the SE10 district
+func writePostcodes(t *testing.T) string {
+	return writeFiles(t, map[string]string{
+		"Data/CSV/se.csv":                        "\"SE10 9NF\",10,538500,177500,\"E92000001\",\"E19000001\",\"E18000007\",\"\",\"E09000011\",\"E05000220\"\n",
+		"Doc/Code-Point_Open_Column_Headers.csv": "PC,PQ,EA,NO,CY,RH,LH,CC,DC,WC\n",
+	})
+}
+
+// Write an Open Names directory with the village of Ae, whose name looks
+// like a postcode area
+func writeNames(t *testing.T) string {
+	fields := make([]string, 34)
+	fields[0] = "osgb1"
+	fields[2] = "Ae"
+	fields[6] = "populatedPlace"
+	fields[7] = "Village"
+	fields[8] = "298000"
+	fields[9] = "589000"
+
+	return writeFiles(t, map[string]string{
+		"DATA/NX.csv": strings.Join(fields, ",") + "\n",
+	})
+}
+
+func TestParseLocation(t *testing.T) {
+	dir := writePostcodes(t)
+	defer os.RemoveAll(dir)
+
+	greenwich, _ := osgrid.Origin().Add(538500, 177500)
+	se10, _ := osgrid.ParseGridRef("SE10")
+	sh65, _ := osgrid.ParseGridRef("SH65")
+	summit, _ := osgrid.ParseGridRef(snowdon)
+
+	for _, tc := range []struct {
+		arg       string
+		postcodes string
+		exp       osgrid.GridRef
+	}{
+		// A postcode district, which is also a grid reference
+		{"SE10", dir, greenwich},
+		{"se10 9nf", dir, greenwich},
+		{"SE10", "", se10},
+		// Looks like a postcode, but there's no SH area
+		{"SH65", dir, sh65},
+		{snowdon, dir, summit},
+	} {
+		ref, err := parseLocation(tc.arg, tc.postcodes, "")
+		if err != nil {
+			t.Errorf("%s: %v", tc.arg, err)
+			continue
+		}
+
+		if ref != tc.exp {
+			t.Errorf("%s: expected %s, got %s", tc.arg, tc.exp, ref)
+		}
+	}
+
+	// Not a postcode or a grid reference, but a place name
+	names := writeNames(t)
+	defer os.RemoveAll(names)
+
+	ae, _ := osgrid.Origin().Add(298000, 589000)
+	ref, err := parseLocation("Ae", dir, names)
+	if err != nil || ref != ae {
+		t.Errorf("Ae: expected %s, got %s, %v", ae, ref, err)
+	}
+
+	// A postcode which doesn't exist, and isn't a grid reference
+	_, err = parseLocation("SE10 1ZZ", dir, "")
+	if err == nil {
+		t.Errorf("expected an error for a missing postcode")
+	}
+}
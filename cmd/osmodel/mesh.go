@@ -18,8 +18,6 @@ import (
 	"github.com/usedbytes/osgrid/lib/texture"
 	"github.com/usedbytes/osgrid/lib/x3d"
 	"github.com/usedbytes/osgrid/osdata"
-	"github.com/usedbytes/osgrid/osdata/raster"
-	"github.com/usedbytes/osgrid/osdata/terrain50"
 )
 
 type meshOutputOpts struct {
@@ -27,15 +25,16 @@ type meshOutputOpts struct {
 }
 
 type meshConfig struct {
-	elevationDB osdata.Float64Database
-	rasterDB    osdata.ImageDatabase
-	width       osgrid.Distance
-	outFile     io.WriteCloser
-	gridRef     osgrid.GridRef
-	formatter   MeshFormatter
-	meshOpts    []geometry.GenerateMeshOpt
-	textureOpts []texture.GenerateTextureOpt
-	outputOpts  meshOutputOpts
+	elevationDB      osdata.Float64Database
+	closeElevationDB func()
+	rasterDB         osdata.ImageDatabase
+	width            osgrid.Distance
+	outFile          io.WriteCloser
+	gridRef          osgrid.GridRef
+	formatter        MeshFormatter
+	meshOpts         []geometry.GenerateMeshOpt
+	textureOpts      []texture.GenerateTextureOpt
+	outputOpts       meshOutputOpts
 }
 
 type MeshFormatter func(io.Writer, *geometry.Mesh, *meshOutputOpts) error
@@ -247,10 +246,11 @@ func parseMeshArgs(c *cli.Context) (meshConfig, error) {
 	}()
 
 	// elevation
-	cfg.elevationDB, err = terrain50.OpenDatabase(c.String("elevation"), 10*osgrid.Kilometre)
+	cfg.elevationDB, cfg.closeElevationDB, err = openElevationDatabase(c)
 	if err != nil {
 		return meshConfig{}, fmt.Errorf("opening elevation database: %w", err)
 	}
+	cleanup = append(cleanup, cfg.closeElevationDB)
 
 	// raster
 	if c.Bool("texture") {
@@ -258,19 +258,14 @@ func parseMeshArgs(c *cli.Context) (meshConfig, error) {
 			return meshConfig{}, fmt.Errorf("--raster is required to generate textures")
 		}
 
-		cfg.rasterDB, err = raster.OpenDatabase(c.String("raster"), 10*osgrid.Kilometre)
+		cfg.rasterDB, err = openRasterDatabase(c)
 		if err != nil {
 			return meshConfig{}, fmt.Errorf("opening raster database: %w", err)
 		}
 	}
 
 	// GRID_REFERENCE
-	gridRef := snowdon
-	if c.NArg() > 0 {
-		gridRef = strings.Join(c.Args().Slice(), "")
-	}
-
-	cfg.gridRef, err = osgrid.ParseGridRef(gridRef)
+	cfg.gridRef, err = parseGridRefArg(c)
 	if err != nil {
 		return meshConfig{}, fmt.Errorf("parsing GRID_REFERENCE: %w", err)
 	}
@@ -292,6 +287,12 @@ func parseMeshArgs(c *cli.Context) (meshConfig, error) {
 	// width
 	cfg.width = osgrid.Distance(c.Uint("width"))
 
+	// outline
+	cfg.elevationDB, err = applyOutline(c, cfg.elevationDB, &cfg.gridRef, &cfg.width)
+	if err != nil {
+		return meshConfig{}, err
+	}
+
 	cfg.outFile, err = os.Create(c.String("outfile"))
 	if err != nil {
 		return meshConfig{}, fmt.Errorf("opening outfile: %w", err)
@@ -338,6 +339,7 @@ func runMesh(c *cli.Context) error {
 		return err
 	}
 	defer cfg.outFile.Close()
+	defer cfg.closeElevationDB()
 
 	surface, err := geometry.GenerateSurface(cfg.elevationDB, cfg.gridRef, cfg.width, cfg.width)
 	if err != nil {
@@ -407,14 +409,22 @@ var meshCmd cli.Command = cli.Command{
 		"Default GRID_REFERENCE is Snowdon summit (" + snowdon + ")",
 	ArgsUsage: "GRID_REFERENCE",
 	Flags: []cli.Flag{
+		boundariesFlag(),
 		elevationFlag(),
+		elevationTypeFlag(),
 		formatsFlag([]string{"scad", "stl", "x3d"}),
 		hscaleFlag(),
+		missingTilesFlag(),
+		namesFlag(),
+		outlineFlag(),
 		outfileFlag(true),
+		postcodesFlag(),
 		rasterFlag(false),
 		textureFlag(),
 		vscaleFlag(),
 		widthFlag(),
+		wmtsLayerFlag(),
+		wmtsZoomFlag(),
 	},
 	Action: runMesh,
 }
@@ -16,7 +16,6 @@ import (
 	"github.com/usedbytes/osgrid"
 	"github.com/usedbytes/osgrid/lib/geometry"
 	"github.com/usedbytes/osgrid/osdata"
-	"github.com/usedbytes/osgrid/osdata/terrain50"
 )
 
 type SurfaceFormatter func(io.Writer, *geometry.Surface) error
@@ -27,6 +26,10 @@ func writeSurfaceImage(w io.Writer, s *geometry.Surface, encoder func(io.Writer,
 
 	for y, row := range s.Data {
 		for x, v := range row {
+			if osdata.IsNoData(v) {
+				// Leave as black
+				continue
+			}
 			pix := color.Gray16{uint16((v - s.Min) * scale)}
 			gray.SetGray16(x, y, pix)
 		}
@@ -91,12 +94,13 @@ func surfaceFormatterFromFormat(format string, c *cli.Context) (SurfaceFormatter
 }
 
 type surfaceConfig struct {
-	elevationDB osdata.Float64Database
-	width       osgrid.Distance
-	outFile     io.WriteCloser
-	gridRef     osgrid.GridRef
-	formatter   SurfaceFormatter
-	opts        []geometry.GenerateSurfaceOpt
+	elevationDB      osdata.Float64Database
+	closeElevationDB func()
+	width            osgrid.Distance
+	outFile          io.WriteCloser
+	gridRef          osgrid.GridRef
+	formatter        SurfaceFormatter
+	opts             []geometry.GenerateSurfaceOpt
 }
 
 func parseSurfaceArgs(c *cli.Context) (surfaceConfig, error) {
@@ -115,23 +119,49 @@ func parseSurfaceArgs(c *cli.Context) (surfaceConfig, error) {
 	}()
 
 	// elevation
-	cfg.elevationDB, err = terrain50.OpenDatabase(c.String("elevation"), 10*osgrid.Kilometre)
+	cfg.elevationDB, cfg.closeElevationDB, err = openElevationDatabase(c)
 	if err != nil {
 		return surfaceConfig{}, fmt.Errorf("opening elevation database: %w", err)
 	}
+	cleanup = append(cleanup, cfg.closeElevationDB)
 
 	// hres
 	if c.IsSet("hres") {
-		cfg.opts = append(cfg.opts, geometry.SurfaceResolutionOpt(osgrid.Distance(c.Uint("hres"))))
+		hres := osgrid.Distance(c.Uint("hres"))
+
+		// resample
+		if c.IsSet("resample") {
+			agg, err := osdata.ParseAggregateMethod(c.String("resample"))
+			if err != nil {
+				return surfaceConfig{}, err
+			}
+
+			cfg.elevationDB, err = osdata.NewResampler(cfg.elevationDB, hres, osdata.ResampleAggregateOpt(agg))
+			if err != nil {
+				return surfaceConfig{}, fmt.Errorf("resampling: %w", err)
+			}
+		}
+
+		cfg.opts = append(cfg.opts, geometry.SurfaceResolutionOpt(hres))
+	} else if c.IsSet("resample") {
+		return surfaceConfig{}, fmt.Errorf("--resample requires --hres")
 	}
 
-	// GRID_REFERENCE
-	gridRef := snowdon
-	if c.NArg() > 0 {
-		gridRef = strings.Join(c.Args().Slice(), "")
+	// layer
+	if c.IsSet("layer") {
+		layer, err := osdata.ParseTerrainLayer(c.String("layer"))
+		if err != nil {
+			return surfaceConfig{}, err
+		}
+
+		cfg.elevationDB, err = osdata.NewDerived(cfg.elevationDB, layer)
+		if err != nil {
+			return surfaceConfig{}, err
+		}
 	}
 
-	cfg.gridRef, err = osgrid.ParseGridRef(gridRef)
+	// GRID_REFERENCE
+	cfg.gridRef, err = parseGridRefArg(c)
 	if err != nil {
 		return surfaceConfig{}, fmt.Errorf("parsing GRID_REFERENCE: %w", err)
 	}
@@ -139,6 +169,12 @@ func parseSurfaceArgs(c *cli.Context) (surfaceConfig, error) {
 	// width
 	cfg.width = osgrid.Distance(c.Uint("width"))
 
+	// outline
+	cfg.elevationDB, err = applyOutline(c, cfg.elevationDB, &cfg.gridRef, &cfg.width)
+	if err != nil {
+		return surfaceConfig{}, err
+	}
+
 	format := "txt"
 
 	// outfile
@@ -183,6 +219,7 @@ func runSurface(c *cli.Context) error {
 		return err
 	}
 	defer cfg.outFile.Close()
+	defer cfg.closeElevationDB()
 
 	surface, err := geometry.GenerateSurface(cfg.elevationDB, cfg.gridRef, cfg.width, cfg.width, cfg.opts...)
 	if err != nil {
@@ -202,6 +239,22 @@ func flipFlag() *cli.BoolFlag {
 	}
 }
 
+func layerFlag() *cli.StringFlag {
+	return &cli.StringFlag{
+		Name: "layer",
+		Usage: "Output `LAYER` derived from the elevation data instead of elevation: " +
+			"slope (degrees), slope-percent, aspect (degrees from North), plan-curvature or profile-curvature",
+	}
+}
+
+func resampleFlag() *cli.StringFlag {
+	return &cli.StringFlag{
+		Name: "resample",
+		Usage: "Resample the elevation data to --hres, combining the points in each cell with `METHOD`: " +
+			"mean, min, max or median. Without this, every Nth point is used",
+	}
+}
+
 func sepFlag() *cli.StringFlag {
 	return &cli.StringFlag{
 		Name:  "sep",
@@ -225,11 +278,19 @@ var surfaceCmd cli.Command = cli.Command{
 		"Default GRID_REFERENCE is Snowdon summit (" + snowdon + ")",
 	ArgsUsage: "GRID_REFERENCE",
 	Flags: []cli.Flag{
+		boundariesFlag(),
 		elevationFlag(),
+		elevationTypeFlag(),
 		formatsFlag([]string{"csv", "dat", "tsv", "txt"}),
 		flipFlag(),
 		hresFlag(),
+		layerFlag(),
+		missingTilesFlag(),
+		namesFlag(),
+		outlineFlag(),
 		outfileFlag(false),
+		postcodesFlag(),
+		resampleFlag(),
 		sepFlag(),
 		srgbFlag(),
 		widthFlag(),
@@ -8,14 +8,12 @@ import (
 	"io"
 	"os"
 	"path"
-	"strings"
 
 	"github.com/urfave/cli/v2"
 
 	"github.com/usedbytes/osgrid"
 	"github.com/usedbytes/osgrid/lib/texture"
 	"github.com/usedbytes/osgrid/osdata"
-	"github.com/usedbytes/osgrid/osdata/raster"
 )
 
 type TextureFormatter func(io.Writer, image.Image) error
@@ -56,18 +54,13 @@ func parseTextureArgs(c *cli.Context) (textureConfig, error) {
 	}()
 
 	// raster
-	cfg.rasterDB, err = raster.OpenDatabase(c.String("raster"), 10*osgrid.Kilometre)
+	cfg.rasterDB, err = openRasterDatabase(c)
 	if err != nil {
 		return textureConfig{}, fmt.Errorf("opening raster database: %w", err)
 	}
 
 	// GRID_REFERENCE
-	gridRef := snowdon
-	if c.NArg() > 0 {
-		gridRef = strings.Join(c.Args().Slice(), "")
-	}
-
-	cfg.gridRef, err = osgrid.ParseGridRef(gridRef)
+	cfg.gridRef, err = parseGridRefArg(c)
 	if err != nil {
 		return textureConfig{}, fmt.Errorf("parsing GRID_REFERENCE: %w", err)
 	}
@@ -125,8 +118,12 @@ var textureCmd cli.Command = cli.Command{
 	Flags: []cli.Flag{
 		rasterFlag(true),
 		formatsFlag([]string{"jpeg", "png"}),
+		namesFlag(),
 		outfileFlag(false),
+		postcodesFlag(),
 		widthFlag(),
+		wmtsLayerFlag(),
+		wmtsZoomFlag(),
 	},
 	Action: runTexture,
 }
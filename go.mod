@@ -8,4 +8,5 @@ require (
 	github.com/nfnt/resize v0.0.0-20180221191011-83c6a9932646
 	github.com/urfave/cli/v2 v2.10.2
 	golang.org/x/image v0.0.0-20211028202545-6944b10bf410
+	modernc.org/sqlite v1.22.0
 )
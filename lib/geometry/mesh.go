@@ -110,6 +110,11 @@ func GenerateMesh(s *Surface, opts ...GenerateMeshOpt) Mesh {
 		for c, v := range row {
 			x := float64(c) * hstep
 
+			if len(s.NoData) != 0 && s.NoData[r][c] {
+				// Drop holes down to the base
+				v = math.Min(s.Min, 0) / m.VScale
+			}
+
 			topIdx := r*cols + c
 			baseIdx := cols*rows + topIdx
 
@@ -9,7 +9,11 @@ import (
 )
 
 type Surface struct {
-	Data       [][]float64
+	Data [][]float64
+	// NoData is nil if every point has data. Otherwise it has the same
+	// layout as Data, and is true for points with no data (which hold
+	// osdata.NoData in Data).
+	NoData     [][]bool
 	Max, Min   float64
 	Resolution osgrid.Distance
 	// NorthToSouth is true if Data[0][0] is the North-West corner.
@@ -42,6 +46,21 @@ func (s *Surface) Scale(scale float64) {
 	s.Max *= scale
 }
 
+// Replace all no-data points with v
+func (s *Surface) FillNoData(v float64) {
+	for y, row := range s.NoData {
+		for x, nodata := range row {
+			if nodata {
+				s.Data[y][x] = v
+			}
+		}
+	}
+
+	s.NoData = nil
+	s.Max = math.Max(s.Max, v)
+	s.Min = math.Min(s.Min, v)
+}
+
 type GenerateSurfaceOpt func(*Surface)
 
 func SurfaceResolutionOpt(res osgrid.Distance) GenerateSurfaceOpt {
@@ -87,6 +106,8 @@ func GenerateSurface(db osdata.Float64Database, centre osgrid.GridRef,
 	nrows := int(height/surf.Resolution) + 1
 	ncols := int(width/surf.Resolution) + 1
 	data := make([][]float64, nrows)
+	mask := make([][]bool, nrows)
+	anyNoData := false
 
 	maxElevation := float64(0.0)
 	minElevation := math.MaxFloat64
@@ -94,6 +115,7 @@ func GenerateSurface(db osdata.Float64Database, centre osgrid.GridRef,
 	i := 0
 	for north := osgrid.Distance(0); north <= height; north += surf.Resolution {
 		row := make([]float64, 0, ncols)
+		maskRow := make([]bool, 0, ncols)
 
 		for east := osgrid.Distance(0); east <= width; east += surf.Resolution {
 			ref, err := southWest.Add(east, north)
@@ -106,6 +128,14 @@ func GenerateSurface(db osdata.Float64Database, centre osgrid.GridRef,
 				return Surface{}, err
 			}
 
+			nodata := osdata.IsNoData(val)
+			maskRow = append(maskRow, nodata)
+			if nodata {
+				anyNoData = true
+				row = append(row, val)
+				continue
+			}
+
 			if val > maxElevation {
 				maxElevation = val
 			}
@@ -119,13 +149,23 @@ func GenerateSurface(db osdata.Float64Database, centre osgrid.GridRef,
 
 		if surf.NorthToSouth {
 			data[nrows-i-1] = row
+			mask[nrows-i-1] = maskRow
 		} else {
 			data[i] = row
+			mask[i] = maskRow
 		}
 		i++
 	}
 
+	if minElevation > maxElevation {
+		// Nothing but no-data
+		minElevation = maxElevation
+	}
+
 	surf.Data = data
+	if anyNoData {
+		surf.NoData = mask
+	}
 	surf.Max = maxElevation
 	surf.Min = minElevation
 
@@ -1,40 +1,25 @@
 package geometry
 
 import (
-	"errors"
 	"strings"
 	"testing"
 
 	"github.com/usedbytes/osgrid"
 	"github.com/usedbytes/osgrid/osdata"
+	"github.com/usedbytes/osgrid/osdata/osdatatest"
 )
 
-type TestDatabase struct {
-	resolution osgrid.Distance
-}
-
-func (db *TestDatabase) GetTile(osgrid.GridRef) (osdata.Tile, error) {
-	return nil, errors.New("GetTile not implemented")
-}
-
-func (db *TestDatabase) Precision() osgrid.Distance {
-	if db.resolution == 0 {
-		return 1 * osgrid.Metre
+// Value is East + North, in metres from the origin
+func newTestDatabase(resolution osgrid.Distance) osdata.Float64Database {
+	if resolution == 0 {
+		resolution = 1 * osgrid.Metre
 	}
 
-	return db.resolution
-}
-
-func (db *TestDatabase) GetFloat64(ref osgrid.GridRef) (float64, error) {
-	return float64(ref.TileEasting() + ref.TileNorthing()), nil
-}
-
-func (db *TestDatabase) GetFloat64Tile(ref osgrid.GridRef) (osdata.Float64Tile, error) {
-	return nil, errors.New("GetFloat64Tile not implemented")
+	return osdatatest.NewFuncDatabase(osdatatest.Plane(0, 1, 1), resolution, 1*osgrid.Kilometre)
 }
 
 func TestGenerateSurfaceSimple(t *testing.T) {
-	db := &TestDatabase{}
+	db := newTestDatabase(0)
 
 	centre, err := osgrid.Origin().Add(50, 50)
 	if err != nil {
@@ -74,7 +59,7 @@ func TestGenerateSurfaceSimple(t *testing.T) {
 }
 
 func TestGenerateSurfaceResolution(t *testing.T) {
-	db := &TestDatabase{}
+	db := newTestDatabase(0)
 
 	res := 20 * osgrid.Metre
 
@@ -112,7 +97,7 @@ func TestGenerateSurfaceResolution(t *testing.T) {
 }
 
 func TestGenerateSurfaceInvalidResolution(t *testing.T) {
-	db := &TestDatabase{3 * osgrid.Metre}
+	db := newTestDatabase(3 * osgrid.Metre)
 
 	centre, err := osgrid.Origin().Add(50, 50)
 	if err != nil {
@@ -139,7 +124,7 @@ func TestGenerateSurfaceInvalidResolution(t *testing.T) {
 }
 
 func TestGenerateSurfaceNorthToSouth(t *testing.T) {
-	db := &TestDatabase{}
+	db := newTestDatabase(0)
 
 	centre, err := osgrid.Origin().Add(50, 50)
 	if err != nil {
@@ -226,3 +211,59 @@ func TestSurfaceScale(t *testing.T) {
 		}
 	}
 }
+
+func TestGenerateSurfaceNoData(t *testing.T) {
+	// No data for points less than 50 m East
+	db := osdatatest.NewFuncDatabase(func(east, north float64) float64 {
+		if east < 50 {
+			return osdata.NoData
+		}
+
+		return east + north
+	}, 1*osgrid.Metre, 1*osgrid.Kilometre)
+
+	centre, err := osgrid.Origin().Add(50, 50)
+	if err != nil {
+		t.Fatalf("centre: %v", err)
+	}
+
+	s, err := GenerateSurface(db, centre, 100*osgrid.Metre, 100*osgrid.Metre)
+	if err != nil {
+		t.Fatalf("GenerateSurface failed: %v", err)
+	}
+
+	if s.NoData == nil {
+		t.Fatal("expected NoData mask")
+	}
+
+	// No-data points shouldn't count towards Min
+	if s.Min != 50 {
+		t.Errorf("Expected Min == 50, got %v", s.Min)
+	}
+
+	for y, row := range s.NoData {
+		for x, nodata := range row {
+			if nodata != (x < 50) {
+				t.Fatalf("(%v, %v) expected nodata %v got %v", x, y, x < 50, nodata)
+			}
+
+			if nodata != osdata.IsNoData(s.Data[y][x]) {
+				t.Fatalf("(%v, %v) mask doesn't match data %v", x, y, s.Data[y][x])
+			}
+		}
+	}
+
+	s.FillNoData(-10)
+
+	if s.NoData != nil {
+		t.Error("expected mask to be cleared")
+	}
+
+	if s.Min != -10 {
+		t.Errorf("Expected Min == -10, got %v", s.Min)
+	}
+
+	if s.Data[0][0] != -10 {
+		t.Errorf("Expected filled value -10, got %v", s.Data[0][0])
+	}
+}
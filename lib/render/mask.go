@@ -0,0 +1,221 @@
+package render
+
+import (
+	"image"
+	"math"
+	"sort"
+)
+
+// Number of scanlines sampled per row of pixels, for anti-aliasing. Coverage
+// along each scanline is exact.
+const subsamples = 4
+
+// Number of straight segments in a full circle, for round joins and points
+const circleSegments = 16
+
+// A point in pixel coordinates: x to the right and y down from the top-left
+// corner of the image
+type pt struct {
+	x, y float64
+}
+
+type edge struct {
+	x0, y0, x1, y1 float64
+}
+
+// mask is the coverage (0 to 1) of each pixel by a set of shapes
+type mask struct {
+	w, h int
+	cov  []float32
+
+	// Reused between calls to fill()
+	scratch []float32
+	edges   []edge
+	xs      []float64
+}
+
+func newMask(w, h int) *mask {
+	return &mask{
+		w:   w,
+		h:   h,
+		cov: make([]float32, w*h),
+	}
+}
+
+func (m *mask) clear() {
+	for i := range m.cov {
+		m.cov[i] = 0
+	}
+}
+
+// Add the area inside rings (even-odd, so rings inside other rings are
+// holes) to the mask. Overlapping shapes from separate calls don't add up:
+// each pixel gets the largest coverage of any shape, so that strokes made of
+// lots of pieces have an even colour.
+func (m *mask) fill(rings [][]pt) {
+	minX, minY := math.Inf(1), math.Inf(1)
+	maxX, maxY := math.Inf(-1), math.Inf(-1)
+
+	m.edges = m.edges[:0]
+	for _, ring := range rings {
+		for i, p := range ring {
+			minX, maxX = math.Min(minX, p.x), math.Max(maxX, p.x)
+			minY, maxY = math.Min(minY, p.y), math.Max(maxY, p.y)
+
+			// Rings are implicitly closed
+			q := ring[(i+1)%len(ring)]
+			if p.y == q.y {
+				continue
+			}
+
+			if p.y < q.y {
+				m.edges = append(m.edges, edge{p.x, p.y, q.x, q.y})
+			} else {
+				m.edges = append(m.edges, edge{q.x, q.y, p.x, p.y})
+			}
+		}
+	}
+
+	// The pixels which the shape could touch
+	x0 := int(math.Max(0, math.Floor(minX)))
+	x1 := int(math.Min(float64(m.w), math.Ceil(maxX)))
+	y0 := int(math.Max(0, math.Floor(minY)))
+	y1 := int(math.Min(float64(m.h), math.Ceil(maxY)))
+	if x0 >= x1 || y0 >= y1 || len(m.edges) == 0 {
+		return
+	}
+
+	bw := x1 - x0
+	size := bw * (y1 - y0)
+	if cap(m.scratch) < size {
+		m.scratch = make([]float32, size)
+	}
+	scratch := m.scratch[:size]
+	for i := range scratch {
+		scratch[i] = 0
+	}
+
+	sort.Slice(m.edges, func(i, j int) bool {
+		return m.edges[i].y0 < m.edges[j].y0
+	})
+
+	var active []edge
+	next := 0
+	weight := 1.0 / subsamples
+
+	for sy := y0 * subsamples; sy < y1*subsamples; sy++ {
+		y := (float64(sy) + 0.5) / subsamples
+
+		for next < len(m.edges) && m.edges[next].y0 <= y {
+			active = append(active, m.edges[next])
+			next++
+		}
+
+		m.xs = m.xs[:0]
+		n := 0
+		for _, e := range active {
+			if e.y1 <= y {
+				continue
+			}
+			active[n] = e
+			n++
+
+			m.xs = append(m.xs, e.x0+(y-e.y0)*(e.x1-e.x0)/(e.y1-e.y0))
+		}
+		active = active[:n]
+
+		sort.Float64s(m.xs)
+
+		row := scratch[(sy/subsamples-y0)*bw : (sy/subsamples-y0+1)*bw]
+		for i := 0; i+1 < len(m.xs); i += 2 {
+			addSpan(row, m.xs[i]-float64(x0), m.xs[i+1]-float64(x0), weight)
+		}
+	}
+
+	for y := y0; y < y1; y++ {
+		row := m.cov[y*m.w+x0 : y*m.w+x1]
+		for x, c := range scratch[(y-y0)*bw : (y-y0+1)*bw] {
+			if c > row[x] {
+				row[x] = c
+			}
+		}
+	}
+}
+
+// Add the coverage of the span from a to b along one scanline of row
+func addSpan(row []float32, a, b, weight float64) {
+	a = math.Max(a, 0)
+	b = math.Min(b, float64(len(row)))
+	if a >= b {
+		return
+	}
+
+	ia, ib := int(a), int(b)
+	if ia == ib {
+		row[ia] += float32((b - a) * weight)
+		return
+	}
+
+	row[ia] += float32((float64(ia+1) - a) * weight)
+	for x := ia + 1; x < ib; x++ {
+		row[x] += float32(weight)
+	}
+
+	if ib < len(row) {
+		row[ib] += float32((b - float64(ib)) * weight)
+	}
+}
+
+// Polygon approximating a circle
+func circle(c pt, r float64) []pt {
+	ring := make([]pt, circleSegments)
+	for i := range ring {
+		a := 2 * math.Pi * float64(i) / circleSegments
+		ring[i] = pt{c.x + r*math.Cos(a), c.y + r*math.Sin(a)}
+	}
+
+	return ring
+}
+
+// Add a line of width w, with round joins and ends
+func (m *mask) stroke(line []pt, w float64) {
+	r := w / 2
+
+	for i, p := range line {
+		m.fill([][]pt{circle(p, r)})
+
+		if i == 0 {
+			continue
+		}
+
+		q := line[i-1]
+		dx, dy := p.x-q.x, p.y-q.y
+		l := math.Hypot(dx, dy)
+		if l == 0 {
+			continue
+		}
+
+		// Normal, with length r
+		nx, ny := -dy/l*r, dx/l*r
+		m.fill([][]pt{{
+			{q.x + nx, q.y + ny},
+			{p.x + nx, p.y + ny},
+			{p.x - nx, p.y - ny},
+			{q.x - nx, q.y - ny},
+		}})
+	}
+}
+
+// The mask as an image
+func (m *mask) alpha() *image.Alpha {
+	img := image.NewAlpha(image.Rect(0, 0, m.w, m.h))
+	for i, c := range m.cov {
+		if c >= 1 {
+			img.Pix[i] = 0xff
+		} else if c > 0 {
+			img.Pix[i] = uint8(c*0xff + 0.5)
+		}
+	}
+
+	return img
+}
@@ -0,0 +1,306 @@
+// Package render draws vector features, such as roads, rivers, woodland and
+// buildings from OS OpenData vector products, as images.
+//
+// The images are osdata.RGBATiles, and Database provides them as an
+// osdata.ImageDatabase, so they can be used as textures in the same way as
+// raster data.
+package render
+
+import (
+	"fmt"
+	"image"
+	"image/draw"
+	"math"
+
+	"github.com/usedbytes/osgrid"
+	"github.com/usedbytes/osgrid/osdata"
+	"github.com/usedbytes/osgrid/osdata/vector"
+)
+
+var mustBeImageDatabase osdata.ImageDatabase = &Database{}
+
+// Lines narrower than this many pixels are drawn this wide, so that they
+// don't fade away
+const minLinePixels = 1.0
+
+// Source is a set of features which can be searched by area, such as a
+// shapefile.File or gpkg.Layer
+type Source interface {
+	SearchBounds(b vector.Bounds) ([]*vector.Feature, error)
+}
+
+// Features is an in-memory Source
+type Features []*vector.Feature
+
+func (fs Features) SearchBounds(b vector.Bounds) ([]*vector.Feature, error) {
+	var found []*vector.Feature
+	for _, f := range fs {
+		if f.Geometry != nil && f.Geometry.Bounds().Intersects(b) {
+			found = append(found, f)
+		}
+	}
+
+	return found, nil
+}
+
+// Layer is a named Source. Style rules refer to layers by name.
+type Layer struct {
+	Name   string
+	Source Source
+}
+
+// Converts grid coordinates to pixels
+type transform struct {
+	e0, n1 float64
+	// Pixels per metre
+	scale float64
+}
+
+func (tr transform) point(p vector.Point) pt {
+	return pt{(p.E - tr.e0) * tr.scale, (tr.n1 - p.N) * tr.scale}
+}
+
+func (tr transform) points(ps []vector.Point) []pt {
+	out := make([]pt, len(ps))
+	for i, p := range ps {
+		out[i] = tr.point(p)
+	}
+
+	return out
+}
+
+// Features to draw, with the layer they're from
+type layerFeatures struct {
+	name     string
+	features []*vector.Feature
+}
+
+// Render draws the features in layers which are in r, according to style.
+// Each pixel covers precision/pixelPrecision metres, so r must be a whole
+// number of precision wide and high.
+func Render(layers []Layer, style *Style, r osgrid.Rect,
+	precision osgrid.Distance, pixelPrecision int) (*osdata.RGBATile, error) {
+	if precision <= 0 || pixelPrecision <= 0 {
+		return nil, fmt.Errorf("invalid precision %d, %d", precision, pixelPrecision)
+	}
+
+	if r.Width <= 0 || r.Height <= 0 || r.Width%precision != 0 || r.Height%precision != 0 {
+		return nil, fmt.Errorf("size (%d x %d) must be a multiple of precision (%d)",
+			r.Width, r.Height, precision)
+	}
+
+	w := int(r.Width/precision) * pixelPrecision
+	h := int(r.Height/precision) * pixelPrecision
+
+	tr := transform{
+		e0:    float64(r.BottomLeft.AbsEasting()),
+		n1:    float64(r.BottomLeft.AbsNorthing() + r.Height),
+		scale: float64(pixelPrecision) / float64(precision),
+	}
+
+	// Include features just outside, whose lines reach inside
+	margin := math.Max(style.maxWidth()/2, minLinePixels/tr.scale)
+	search := vector.BoundsFromRect(r)
+	search.Min.E -= margin
+	search.Min.N -= margin
+	search.Max.E += margin
+	search.Max.N += margin
+
+	var found []layerFeatures
+	for _, l := range layers {
+		features, err := l.Source.SearchBounds(search)
+		if err != nil {
+			return nil, fmt.Errorf("layer '%s': %w", l.Name, err)
+		}
+
+		found = append(found, layerFeatures{l.Name, features})
+	}
+
+	img := image.NewRGBA(image.Rect(0, 0, w, h))
+	if style.Background.A != 0 {
+		draw.Draw(img, img.Bounds(), image.NewUniform(style.Background), image.Point{}, draw.Src)
+	}
+
+	m := newMask(w, h)
+
+	for _, rule := range style.sortedRules() {
+		if rule.Fill.A != 0 {
+			m.clear()
+			drawn := false
+			for _, lf := range found {
+				for _, f := range lf.features {
+					if rule.Matches(lf.name, f) {
+						drawn = fillFeature(m, tr, f, rule.Width) || drawn
+					}
+				}
+			}
+
+			if drawn {
+				draw.DrawMask(img, img.Bounds(), image.NewUniform(rule.Fill), image.Point{},
+					m.alpha(), image.Point{}, draw.Over)
+			}
+		}
+
+		if rule.Stroke.A != 0 {
+			width := math.Max(rule.Width*tr.scale, minLinePixels)
+
+			m.clear()
+			drawn := false
+			for _, lf := range found {
+				for _, f := range lf.features {
+					if rule.Matches(lf.name, f) {
+						drawn = strokeFeature(m, tr, f, width) || drawn
+					}
+				}
+			}
+
+			if drawn {
+				draw.DrawMask(img, img.Bounds(), image.NewUniform(rule.Stroke), image.Point{},
+					m.alpha(), image.Point{}, draw.Over)
+			}
+		}
+	}
+
+	return osdata.NewRGBATile(r.BottomLeft, precision, pixelPrecision, img), nil
+}
+
+// Fill polygons, and points as discs of diameter width (metres). Returns
+// true if anything was added to the mask.
+func fillFeature(m *mask, tr transform, f *vector.Feature, width float64) bool {
+	switch g := f.Geometry.(type) {
+	case *vector.Polygon:
+		rings := make([][]pt, len(g.Rings))
+		for i, ring := range g.Rings {
+			rings[i] = tr.points(ring)
+		}
+		m.fill(rings)
+
+		return true
+	case *vector.MultiPoint:
+		r := math.Max(width*tr.scale, minLinePixels) / 2
+		for _, p := range g.Points {
+			m.fill([][]pt{circle(tr.point(p), r)})
+		}
+
+		return len(g.Points) > 0
+	}
+
+	return false
+}
+
+// Stroke lines and polygon outlines, width pixels wide. Returns true if
+// anything was added to the mask.
+func strokeFeature(m *mask, tr transform, f *vector.Feature, width float64) bool {
+	switch g := f.Geometry.(type) {
+	case *vector.MultiLineString:
+		for _, line := range g.Lines {
+			m.stroke(tr.points(line), width)
+		}
+
+		return len(g.Lines) > 0
+	case *vector.Polygon:
+		for _, ring := range g.Rings {
+			if len(ring) == 0 {
+				continue
+			}
+
+			// Rings are implicitly closed
+			line := tr.points(ring)
+			m.stroke(append(line, line[0]), width)
+		}
+
+		return len(g.Rings) > 0
+	}
+
+	return false
+}
+
+// Database renders tiles of a set of layers on demand, as an
+// osdata.ImageDatabase
+type Database struct {
+	layers         []Layer
+	style          *Style
+	tileSize       osgrid.Distance
+	precision      osgrid.Distance
+	pixelPrecision int
+	cache          *osdata.Cache
+}
+
+type Opt func(*Database)
+
+// Size of the database's tiles, default 1 km. Must divide 100 km.
+func TileSizeOpt(size osgrid.Distance) Opt {
+	return func(d *Database) {
+		d.tileSize = size
+	}
+}
+
+// Options for the database's tile cache
+func DatabaseOpts(opts ...osdata.DatabaseOpt) Opt {
+	return func(d *Database) {
+		d.cache = osdata.NewDatabaseConfig(opts...).Cache
+	}
+}
+
+// NewDatabase renders layers according to style, with pixelPrecision pixels
+// per precision metres. Every tile exists: tiles without any features are
+// just the background.
+func NewDatabase(layers []Layer, style *Style, precision osgrid.Distance, pixelPrecision int,
+	opts ...Opt) (*Database, error) {
+	d := &Database{
+		layers:         layers,
+		style:          style,
+		tileSize:       1 * osgrid.Kilometre,
+		precision:      precision,
+		pixelPrecision: pixelPrecision,
+	}
+
+	for _, opt := range opts {
+		opt(d)
+	}
+
+	if d.cache == nil {
+		d.cache = osdata.NewDatabaseConfig().Cache
+	}
+
+	if d.tileSize <= 0 || (100*osgrid.Kilometre)%d.tileSize != 0 {
+		return nil, fmt.Errorf("tile size (%d) must divide 100 km", d.tileSize)
+	}
+
+	if d.precision <= 0 || d.pixelPrecision <= 0 || d.tileSize%d.precision != 0 {
+		return nil, fmt.Errorf("tile size (%d) must be a multiple of precision (%d)", d.tileSize, d.precision)
+	}
+
+	return d, nil
+}
+
+func (d *Database) GetImageTile(ref osgrid.GridRef) (osdata.ImageTile, error) {
+	ref = ref.Align(d.tileSize)
+
+	tile, err := d.cache.Load(ref, func() (osdata.Tile, error) {
+		r := osgrid.Rect{BottomLeft: ref, Width: d.tileSize, Height: d.tileSize}
+		return Render(d.layers, d.style, r, d.precision, d.pixelPrecision)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return tile.(*osdata.RGBATile), nil
+}
+
+func (d *Database) GetTile(ref osgrid.GridRef) (osdata.Tile, error) {
+	return d.GetImageTile(ref)
+}
+
+func (d *Database) Precision() osgrid.Distance {
+	return d.precision
+}
+
+// PixelPrecision returns the number of pixels per Precision() in the tiles
+func (d *Database) PixelPrecision() int {
+	return d.pixelPrecision
+}
+
+func (d *Database) Stats() osdata.Stats {
+	return d.cache.Stats()
+}
@@ -0,0 +1,264 @@
+package render
+
+import (
+	"errors"
+	"image/color"
+	"strings"
+	"testing"
+
+	"github.com/usedbytes/osgrid"
+	"github.com/usedbytes/osgrid/lib/texture"
+	"github.com/usedbytes/osgrid/osdata/vector"
+	"github.com/usedbytes/osgrid/osdata/vector/vectortest"
+	"github.com/usedbytes/osgrid/osgridtest"
+)
+
+var (
+	white = Color{R: 255, G: 255, B: 255, A: 255}
+	green = Color{G: 255, A: 255}
+	red   = Color{R: 255, A: 255}
+	blue  = Color{B: 255, A: 255}
+)
+
+// A wood with a clearing, a road through it and a house
+func testLayers() []Layer {
+	return []Layer{
+		{"woodland", Features{
+			{ID: 1, Geometry: &vector.Polygon{Rings: [][]vector.Point{
+				vectortest.Square(1000, 1000, 100),
+				vectortest.Square(1040, 1040, 20),
+			}}},
+		}},
+		{"roads", Features{
+			{ID: 1, Geometry: &vector.MultiLineString{Lines: [][]vector.Point{
+				{{E: 1000, N: 1090.25}, {E: 1100, N: 1090.25}},
+			}}, Attributes: map[string]interface{}{"class": "A Road"}},
+			{ID: 2, Geometry: &vector.MultiLineString{Lines: [][]vector.Point{
+				{{E: 1010, N: 1000}, {E: 1010, N: 1100}},
+			}}, Attributes: map[string]interface{}{"class": "Track"}},
+		}},
+		{"buildings", Features{
+			{ID: 1, Geometry: &vector.MultiPoint{Points: []vector.Point{{E: 1080.5, N: 1020.5}}}},
+		}},
+	}
+}
+
+func testStyle() *Style {
+	return &Style{
+		Background: white,
+		Rules: []Rule{
+			{Layer: "roads", Filter: map[string][]string{"class": {"A Road"}}, Stroke: red, Width: 4, Z: 1},
+			{Layer: "woodland", Fill: green},
+			{Layer: "buildings", Fill: blue, Width: 5},
+		},
+	}
+}
+
+func TestRender(t *testing.T) {
+	r := osgrid.Rect{BottomLeft: osgridtest.MustRef(t, 1000, 1000), Width: 100, Height: 100}
+
+	// 2 pixels per metre
+	tile, err := Render(testLayers(), testStyle(), r, 1, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if b := tile.Bounds(); b.Dx() != 200 || b.Dy() != 200 {
+		t.Fatalf("unexpected image size %v", b)
+	}
+
+	at := func(e, n osgrid.Distance) color.Color {
+		x, y, err := tile.GetPixelCoord(osgridtest.MustRef(t, e, n))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// y is the bottom edge, so the pixel above it is inside the cell
+		return color.RGBAModel.Convert(tile.At(x, y-1))
+	}
+
+	for _, tc := range []struct {
+		e, n osgrid.Distance
+		c    Color
+	}{
+		{1005, 1005, green},
+		// The clearing
+		{1050, 1050, white},
+		// The road is on top of the wood
+		{1050, 1090, red},
+		{1050, 1091, red},
+		{1050, 1093, green},
+		// The track isn't drawn
+		{1010, 1050, green},
+		{1080, 1020, blue},
+		{1080, 1025, green},
+	} {
+		exp := color.RGBAModel.Convert(tc.c)
+		if c := at(tc.e, tc.n); c != exp {
+			t.Errorf("(%d, %d): expected %v, got %v", tc.e, tc.n, exp, c)
+		}
+	}
+
+	// The road is 8 px wide, centred in the middle of row 19, so its edges
+	// are anti-aliased
+	c := color.RGBAModel.Convert(tile.At(100, 15)).(color.RGBA)
+	if c.R < 100 || c.R > 155 || c.G < 100 || c.G > 155 {
+		t.Errorf("expected a blend of red and green at the road edge, got %v", c)
+	}
+
+	if _, err := Render(testLayers(), testStyle(), r, 3, 1); err == nil {
+		t.Errorf("expected error for size not a multiple of precision")
+	}
+}
+
+func TestMask(t *testing.T) {
+	m := newMask(10, 10)
+
+	// Half-pixel offsets, with a hole
+	m.fill([][]pt{
+		{{0.5, 0.5}, {8.5, 0.5}, {8.5, 8.5}, {0.5, 8.5}},
+		{{2, 2}, {4, 2}, {4, 4}, {2, 4}},
+	})
+
+	for _, tc := range []struct {
+		x, y int
+		cov  float32
+	}{
+		{0, 0, 0.25},
+		{1, 0, 0.5},
+		{1, 1, 1},
+		{3, 3, 0},
+		{8, 5, 0.5},
+		{9, 9, 0},
+	} {
+		if c := m.cov[tc.y*m.w+tc.x]; c != tc.cov {
+			t.Errorf("(%d, %d): expected %v, got %v", tc.x, tc.y, tc.cov, c)
+		}
+	}
+
+	// Overlapping shapes don't add up
+	m.clear()
+	m.fill([][]pt{{{0, 0}, {5, 0}, {5, 5}, {0, 5}}})
+	m.fill([][]pt{{{2, 2}, {7, 2}, {7, 7}, {2, 7}}})
+	if c := m.cov[3*m.w+3]; c != 1 {
+		t.Errorf("expected coverage 1 in overlap, got %v", c)
+	}
+
+	// Shapes off the edge are clipped
+	m.clear()
+	m.stroke([]pt{{-5, 5}, {15, 5}}, 2)
+	if m.cov[5*m.w] != 1 || m.cov[5*m.w+9] != 1 || m.cov[2*m.w+5] != 0 {
+		t.Errorf("unexpected stroke coverage")
+	}
+}
+
+func TestDatabase(t *testing.T) {
+	db, err := NewDatabase(testLayers(), testStyle(), 1, 1, TileSizeOpt(100))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tile, err := db.GetImageTile(osgridtest.MustRef(t, 1050, 1050))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if tile.BottomLeft() != osgridtest.MustRef(t, 1000, 1000) || tile.GetImage().Bounds().Dx() != 100 {
+		t.Errorf("unexpected tile %v", tile)
+	}
+
+	// A texture across four tiles, which is mostly background
+	tex, err := texture.GenerateTexture(db, osgridtest.MustRef(t, 1000, 1000), 200, 200)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if b := tex.Image.Bounds(); b.Dx() != 200 || b.Dy() != 200 {
+		t.Fatalf("unexpected texture size %v", b)
+	}
+
+	// Top-right quarter is the wood
+	exp := color.RGBAModel.Convert(green)
+	if c := color.RGBAModel.Convert(tex.Image.At(105, 95)); c != exp {
+		t.Errorf("expected %v, got %v", exp, c)
+	}
+
+	exp = color.RGBAModel.Convert(white)
+	if c := color.RGBAModel.Convert(tex.Image.At(50, 150)); c != exp {
+		t.Errorf("expected %v, got %v", exp, c)
+	}
+}
+
+type errSource struct{}
+
+func (errSource) SearchBounds(vector.Bounds) ([]*vector.Feature, error) {
+	return nil, errors.New("broken")
+}
+
+func TestSourceError(t *testing.T) {
+	r := osgrid.Rect{BottomLeft: osgridtest.MustRef(t, 0, 0), Width: 10, Height: 10}
+	_, err := Render([]Layer{{"broken", errSource{}}}, testStyle(), r, 1, 1)
+	if err == nil || !strings.Contains(err.Error(), "broken") {
+		t.Errorf("expected error from source, got %v", err)
+	}
+}
+
+func TestParseStyle(t *testing.T) {
+	s, err := ParseStyle(strings.NewReader(`{
+		"background": "#ffffff",
+		"rules": [
+			{ "layer": "roads", "filter": { "class": ["A Road"] }, "stroke": "#ff000080", "width": 4, "z": 1 },
+			{ "layer": "woodland", "fill": "#00ff00" }
+		]
+	}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if s.Background != white || len(s.Rules) != 2 {
+		t.Fatalf("unexpected style %+v", s)
+	}
+
+	if r := s.Rules[0]; r.Stroke != (Color{R: 255, A: 128}) || r.Width != 4 || r.Z != 1 || r.Fill.A != 0 {
+		t.Errorf("unexpected rule %+v", r)
+	}
+
+	if rules := s.sortedRules(); rules[0].Layer != "woodland" {
+		t.Errorf("expected woodland first, got %v", rules[0].Layer)
+	}
+
+	for _, bad := range []string{
+		`{"rules": [{"fill": "#00ff00"}]}`,
+		`{"rules": [{"layer": "a", "fill": "green"}]}`,
+		`{"rules": [{"layer": "a", "colour": "#00ff00"}]}`,
+		`{"rules": [{"layer": "a", "width": -1}]}`,
+	} {
+		if _, err := ParseStyle(strings.NewReader(bad)); err == nil {
+			t.Errorf("expected error for %s", bad)
+		}
+	}
+
+	if c, err := ParseColor("#0a0B0c"); err != nil || c.String() != "#0a0b0c" {
+		t.Errorf("unexpected colour %v, %v", c, err)
+	}
+}
+
+func TestRuleMatches(t *testing.T) {
+	f := &vector.Feature{Attributes: map[string]interface{}{"class": "A Road", "lanes": int64(2)}}
+
+	for _, tc := range []struct {
+		rule  Rule
+		match bool
+	}{
+		{Rule{Layer: "roads"}, true},
+		{Rule{Layer: "rivers"}, false},
+		{Rule{Layer: "roads", Filter: map[string][]string{"class": {"B Road", "A Road"}}}, true},
+		{Rule{Layer: "roads", Filter: map[string][]string{"lanes": {"2"}}}, true},
+		{Rule{Layer: "roads", Filter: map[string][]string{"lanes": {"1"}}}, false},
+		{Rule{Layer: "roads", Filter: map[string][]string{"name": {"A1"}}}, false},
+	} {
+		if tc.rule.Matches("roads", f) != tc.match {
+			t.Errorf("%+v: expected %v", tc.rule, tc.match)
+		}
+	}
+}
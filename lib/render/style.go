@@ -0,0 +1,178 @@
+package render
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"image/color"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/usedbytes/osgrid/osdata/vector"
+)
+
+// Color is a non-premultiplied colour, which can be parsed from "#rrggbb"
+// or "#rrggbbaa". The zero Color is transparent, meaning "don't draw".
+type Color color.NRGBA
+
+// ParseColor parses a "#rrggbb" or "#rrggbbaa" colour
+func ParseColor(s string) (Color, error) {
+	b, err := hex.DecodeString(strings.TrimPrefix(s, "#"))
+	if err != nil || (len(b) != 3 && len(b) != 4) || !strings.HasPrefix(s, "#") {
+		return Color{}, fmt.Errorf("invalid colour '%s', expected #rrggbb or #rrggbbaa", s)
+	}
+
+	c := Color{R: b[0], G: b[1], B: b[2], A: 0xff}
+	if len(b) == 4 {
+		c.A = b[3]
+	}
+
+	return c, nil
+}
+
+func (c Color) RGBA() (r, g, b, a uint32) {
+	return color.NRGBA(c).RGBA()
+}
+
+func (c Color) String() string {
+	if c.A == 0xff {
+		return fmt.Sprintf("#%02x%02x%02x", c.R, c.G, c.B)
+	}
+
+	return fmt.Sprintf("#%02x%02x%02x%02x", c.R, c.G, c.B, c.A)
+}
+
+func (c Color) MarshalJSON() ([]byte, error) {
+	return json.Marshal(c.String())
+}
+
+func (c *Color) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return err
+	}
+
+	parsed, err := ParseColor(s)
+	if err != nil {
+		return err
+	}
+
+	*c = parsed
+
+	return nil
+}
+
+// Rule says how to draw some of the features in a layer
+type Rule struct {
+	// Name of the layer the rule applies to
+	Layer string `json:"layer"`
+	// Only draw features with these attribute values. Each attribute must
+	// have one of the values listed for it. Numbers are compared as text.
+	Filter map[string][]string `json:"filter,omitempty"`
+
+	// Colour to fill polygons, and to draw points with
+	Fill Color `json:"fill,omitempty"`
+	// Colour to draw lines and polygon outlines with
+	Stroke Color `json:"stroke,omitempty"`
+	// Width of lines and outlines, and diameter of points, in metres
+	Width float64 `json:"width,omitempty"`
+
+	// Rules with a higher Z are drawn on top. Rules with the same Z are
+	// drawn in the order they're listed.
+	Z int `json:"z,omitempty"`
+}
+
+// Matches returns true if the rule applies to feature f in layer
+func (r *Rule) Matches(layer string, f *vector.Feature) bool {
+	if r.Layer != layer {
+		return false
+	}
+
+	for attr, values := range r.Filter {
+		v, ok := f.Attributes[attr]
+		if !ok {
+			return false
+		}
+
+		s := fmt.Sprint(v)
+		found := false
+		for _, want := range values {
+			if s == want {
+				found = true
+				break
+			}
+		}
+
+		if !found {
+			return false
+		}
+	}
+
+	return true
+}
+
+// Style says how to draw the features in a set of layers. A feature is
+// drawn by every rule which matches it, so for example a road can be drawn
+// with a wide dark line, and then a narrower light one on top.
+type Style struct {
+	Background Color  `json:"background,omitempty"`
+	Rules      []Rule `json:"rules"`
+}
+
+// ParseStyle reads a style from JSON, for example:
+//
+//	{
+//		"background": "#f8f6f0",
+//		"rules": [
+//			{ "layer": "woodland", "fill": "#c8e0b4" },
+//			{ "layer": "roads", "filter": { "class": ["A Road"] }, "stroke": "#e06040", "width": 12, "z": 2 }
+//		]
+//	}
+func ParseStyle(r io.Reader) (*Style, error) {
+	s := &Style{}
+
+	dec := json.NewDecoder(r)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(s); err != nil {
+		return nil, fmt.Errorf("parsing style: %w", err)
+	}
+
+	for i, rule := range s.Rules {
+		if rule.Layer == "" {
+			return nil, fmt.Errorf("rule %d has no layer", i)
+		}
+
+		if rule.Width < 0 {
+			return nil, fmt.Errorf("rule %d has negative width", i)
+		}
+	}
+
+	return s, nil
+}
+
+// The rules, in the order they should be drawn
+func (s *Style) sortedRules() []*Rule {
+	rules := make([]*Rule, len(s.Rules))
+	for i := range s.Rules {
+		rules[i] = &s.Rules[i]
+	}
+
+	sort.SliceStable(rules, func(i, j int) bool {
+		return rules[i].Z < rules[j].Z
+	})
+
+	return rules
+}
+
+// Largest stroke width used by any rule
+func (s *Style) maxWidth() float64 {
+	max := 0.0
+	for _, r := range s.Rules {
+		if r.Width > max {
+			max = r.Width
+		}
+	}
+
+	return max
+}
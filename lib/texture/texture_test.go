@@ -110,6 +110,10 @@ func (db *TestImageDatabase) GetTile(ref osgrid.GridRef) (osdata.Tile, error) {
 	return db.GetImageTile(ref)
 }
 
+func (db *TestImageDatabase) Stats() osdata.Stats {
+	return osdata.Stats{}
+}
+
 func (db *TestImageDatabase) Precision() osgrid.Distance {
 	return 1 * osgrid.Metre
 }
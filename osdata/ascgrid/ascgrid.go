@@ -0,0 +1,530 @@
+// Package ascgrid reads elevation (or other) data in the ESRI ASCII Grid
+// format, as used by OS Terrain 50, Environment Agency LiDAR and many other
+// raster products.
+package ascgrid
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/usedbytes/osgrid"
+	"github.com/usedbytes/osgrid/osdata"
+)
+
+var mustBeFloat64Tile osdata.Float64Tile = &Tile{}
+var mustBeFloat64Database osdata.Float64Database = &Database{}
+
+// The spec says NODATA_value is optional, defaulting to -9999
+const DefaultNoDataValue float64 = -9999
+
+type Header struct {
+	NCols, NRows int
+	// Position of the lower-left corner of the grid. If the file uses
+	// xllcenter/yllcenter, these are adjusted by half a cell.
+	XLLCorner, YLLCorner float64
+	CellSize             float64
+	NoDataValue          float64
+}
+
+// Grid is the parsed contents of an ASCII Grid file
+type Grid struct {
+	Header
+	// Data[0] is the Southern-most row (opposite to the file order).
+	// No-data values are replaced with osdata.NoData
+	Data [][]float32
+}
+
+func isNumber(s string) bool {
+	_, err := strconv.ParseFloat(s, 64)
+	return err == nil
+}
+
+// ReadHeader reads the header lines from r, which is left positioned at the
+// start of the data.
+func ReadHeader(r *bufio.Reader) (Header, error) {
+	hdr := Header{
+		NoDataValue: DefaultNoDataValue,
+	}
+
+	var haveCols, haveRows, haveX, haveY, haveSize bool
+	var xCentre, yCentre bool
+
+	for {
+		// Peek so that we don't consume the first line of data
+		peek, err := r.Peek(1)
+		if err != nil {
+			if err == io.EOF {
+				break
+			}
+			return Header{}, err
+		}
+
+		c := peek[0]
+		if c == '-' || c == '+' || c == '.' || (c >= '0' && c <= '9') {
+			// Start of the data
+			break
+		}
+
+		line, err := r.ReadString('\n')
+		if err != nil && err != io.EOF {
+			return Header{}, err
+		}
+
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			if err == io.EOF {
+				break
+			}
+			continue
+		}
+
+		if len(fields) != 2 {
+			return Header{}, fmt.Errorf("Unexpected header data: %s", strings.TrimSpace(line))
+		}
+
+		value, perr := strconv.ParseFloat(fields[1], 64)
+		if perr != nil {
+			return Header{}, fmt.Errorf("Invalid header value: %s", strings.TrimSpace(line))
+		}
+
+		switch strings.ToLower(fields[0]) {
+		case "ncols":
+			hdr.NCols, haveCols = int(value), true
+		case "nrows":
+			hdr.NRows, haveRows = int(value), true
+		case "xllcorner":
+			hdr.XLLCorner, haveX = value, true
+		case "xllcenter", "xllcentre":
+			hdr.XLLCorner, haveX, xCentre = value, true, true
+		case "yllcorner":
+			hdr.YLLCorner, haveY = value, true
+		case "yllcenter", "yllcentre":
+			hdr.YLLCorner, haveY, yCentre = value, true, true
+		case "cellsize":
+			hdr.CellSize, haveSize = value, true
+		case "nodata_value":
+			hdr.NoDataValue = value
+		case "byteorder":
+			// Only relevant for binary grids
+		default:
+			return Header{}, fmt.Errorf("Unknown header field: %s", fields[0])
+		}
+
+		if err == io.EOF {
+			break
+		}
+	}
+
+	if !haveCols || !haveRows || !haveX || !haveY || !haveSize {
+		return Header{}, fmt.Errorf("Incomplete header")
+	}
+
+	if hdr.NCols <= 0 || hdr.NRows <= 0 || hdr.CellSize <= 0 {
+		return Header{}, fmt.Errorf("Invalid grid size")
+	}
+
+	if xCentre {
+		hdr.XLLCorner -= hdr.CellSize / 2
+	}
+
+	if yCentre {
+		hdr.YLLCorner -= hdr.CellSize / 2
+	}
+
+	return hdr, nil
+}
+
+func readData(r io.Reader, hdr Header) ([][]float32, error) {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	scanner.Split(bufio.ScanWords)
+
+	vals := make([][]float32, hdr.NRows)
+	backing := make([]float32, hdr.NRows*hdr.NCols)
+	for i := range vals {
+		vals[i] = backing[i*hdr.NCols : (i+1)*hdr.NCols]
+	}
+
+	n := 0
+	for scanner.Scan() {
+		if n >= len(backing) {
+			return nil, fmt.Errorf("Invalid amount of data")
+		}
+
+		v, err := strconv.ParseFloat(scanner.Text(), 64)
+		if err != nil {
+			return nil, err
+		}
+
+		if v == hdr.NoDataValue {
+			v = osdata.NoData
+		}
+
+		// We need to flip the Y axis
+		// The file data is NW to SE
+		// But we want the origin in the bottom-left (SW to NE)
+		y, x := n/hdr.NCols, n%hdr.NCols
+		vals[hdr.NRows-y-1][x] = float32(v)
+		n++
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	if n != len(backing) {
+		return nil, fmt.Errorf("Invalid amount of data")
+	}
+
+	return vals, nil
+}
+
+func Read(r io.Reader) (*Grid, error) {
+	buf := bufio.NewReader(r)
+
+	hdr, err := ReadHeader(buf)
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := readData(buf, hdr)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Grid{
+		Header: hdr,
+		Data:   data,
+	}, nil
+}
+
+func wholeMetres(v float64) (osgrid.Distance, bool) {
+	r := math.Round(v)
+	if math.Abs(v-r) > 1e-6 {
+		return 0, false
+	}
+
+	return osgrid.Distance(r), true
+}
+
+// Tile is a Grid positioned on the National Grid
+type Tile struct {
+	bottomLeft    osgrid.GridRef
+	width, height osgrid.Distance
+	precision     osgrid.Distance
+	data          [][]float32
+}
+
+// NewTile positions g on the National Grid. Its cell size and corner must be
+// a whole number of metres.
+func NewTile(g *Grid) (*Tile, error) {
+	precision, ok := wholeMetres(g.CellSize)
+	if !ok || precision <= 0 {
+		return nil, fmt.Errorf("cellsize must be a whole number of metres, got %v", g.CellSize)
+	}
+
+	x, okx := wholeMetres(g.XLLCorner)
+	y, oky := wholeMetres(g.YLLCorner)
+	if !okx || !oky {
+		return nil, fmt.Errorf("corner must be a whole number of metres, got %v,%v", g.XLLCorner, g.YLLCorner)
+	}
+
+	bottomLeft, err := osgrid.Origin().Add(x, y)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Tile{
+		bottomLeft: bottomLeft,
+		width:      osgrid.Distance(g.NCols) * precision,
+		height:     osgrid.Distance(g.NRows) * precision,
+		precision:  precision,
+		data:       g.Data,
+	}, nil
+}
+
+func ReadTile(r io.Reader) (*Tile, error) {
+	g, err := Read(r)
+	if err != nil {
+		return nil, err
+	}
+
+	return NewTile(g)
+}
+
+func OpenTile(path string) (*Tile, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	return ReadTile(f)
+}
+
+func (t *Tile) String() string {
+	return t.bottomLeft.String()
+}
+
+func (t *Tile) BottomLeft() osgrid.GridRef {
+	return t.bottomLeft
+}
+
+func (t *Tile) Precision() osgrid.Distance {
+	return t.precision
+}
+
+func (t *Tile) Width() osgrid.Distance {
+	return t.width
+}
+
+func (t *Tile) Height() osgrid.Distance {
+	return t.height
+}
+
+// Data returns the tile's values, with Data()[0] being the Southern-most row
+func (t *Tile) Data() [][]float32 {
+	return t.data
+}
+
+func (t *Tile) MemorySize() int {
+	if len(t.data) == 0 {
+		return 0
+	}
+
+	return len(t.data) * len(t.data[0]) * 4
+}
+
+func (t *Tile) GetFloat64(ref osgrid.GridRef) (float64, error) {
+	east := ref.AbsEasting() - t.bottomLeft.AbsEasting()
+	north := ref.AbsNorthing() - t.bottomLeft.AbsNorthing()
+
+	if east < 0 || north < 0 || east >= t.width || north >= t.height {
+		return osdata.NoData, fmt.Errorf("Coordinate outside tile")
+	}
+
+	x := int(east / t.precision)
+	y := int(north / t.precision)
+
+	return float64(t.data[y][x]), nil
+}
+
+// Database is a set of equally sized ASCII Grid tiles, laid out on a
+// regular grid (i.e. each tile's corner is a multiple of the tile size).
+type Database struct {
+	tileWidth, tileHeight osgrid.Distance
+	precision             osgrid.Distance
+
+	paths map[[2]osgrid.Distance]string
+	cache *osdata.Cache
+}
+
+func readFileHeader(path string) (Header, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return Header{}, err
+	}
+	defer f.Close()
+
+	return ReadHeader(bufio.NewReader(f))
+}
+
+// OpenDatabase indexes all of the '.asc' files under path (recursively).
+// Only the headers are read until tiles are requested.
+func OpenDatabase(path string, opts ...osdata.DatabaseOpt) (osdata.Float64Database, error) {
+	cfg := osdata.NewDatabaseConfig(opts...)
+
+	d := &Database{
+		paths: make(map[[2]osgrid.Distance]string),
+		cache: cfg.Cache,
+	}
+
+	err := filepath.Walk(path, func(p string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if fi.IsDir() || strings.ToLower(filepath.Ext(p)) != ".asc" {
+			return nil
+		}
+
+		hdr, err := readFileHeader(p)
+		if err != nil {
+			return fmt.Errorf("%s: %w", p, err)
+		}
+
+		return d.addTile(p, hdr)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if len(d.paths) == 0 {
+		return nil, fmt.Errorf("no .asc tiles found in %s", path)
+	}
+
+	return d, nil
+}
+
+func (d *Database) addTile(path string, hdr Header) error {
+	precision, ok := wholeMetres(hdr.CellSize)
+	if !ok || precision <= 0 {
+		return fmt.Errorf("%s: cellsize must be a whole number of metres, got %v", path, hdr.CellSize)
+	}
+
+	width := osgrid.Distance(hdr.NCols) * precision
+	height := osgrid.Distance(hdr.NRows) * precision
+
+	if d.precision == 0 {
+		d.precision = precision
+		d.tileWidth = width
+		d.tileHeight = height
+	} else if precision != d.precision || width != d.tileWidth || height != d.tileHeight {
+		return fmt.Errorf("%s: tile dimensions don't match the rest of the dataset", path)
+	}
+
+	x, okx := wholeMetres(hdr.XLLCorner)
+	y, oky := wholeMetres(hdr.YLLCorner)
+	if !okx || !oky || x%width != 0 || y%height != 0 {
+		return fmt.Errorf("%s: tile isn't aligned to the tile grid", path)
+	}
+
+	d.paths[[2]osgrid.Distance{x / width, y / height}] = path
+
+	return nil
+}
+
+func (d *Database) tileIndex(ref osgrid.GridRef) [2]osgrid.Distance {
+	// Grid references can't be negative, so truncating division is fine
+	return [2]osgrid.Distance{ref.AbsEasting() / d.tileWidth, ref.AbsNorthing() / d.tileHeight}
+}
+
+func (d *Database) getTile(ref osgrid.GridRef) (*Tile, error) {
+	idx := d.tileIndex(ref)
+
+	bottomLeft, err := osgrid.Origin().Add(idx[0]*d.tileWidth, idx[1]*d.tileHeight)
+	if err != nil {
+		return nil, err
+	}
+
+	tile, err := d.cache.Load(bottomLeft, func() (osdata.Tile, error) {
+		path, ok := d.paths[idx]
+		if !ok {
+			return nil, fmt.Errorf("Tile %s %w", bottomLeft, osdata.ErrTileNotFound)
+		}
+
+		return OpenTile(path)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return tile.(*Tile), nil
+}
+
+func (d *Database) GetTile(ref osgrid.GridRef) (osdata.Tile, error) {
+	return d.getTile(ref)
+}
+
+func (d *Database) GetFloat64Tile(ref osgrid.GridRef) (osdata.Float64Tile, error) {
+	return d.getTile(ref)
+}
+
+func (d *Database) GetFloat64(ref osgrid.GridRef) (float64, error) {
+	tile, err := d.getTile(ref)
+	if err != nil {
+		return osdata.NoData, err
+	}
+
+	return tile.GetFloat64(ref)
+}
+
+func (d *Database) Precision() osgrid.Distance {
+	return d.precision
+}
+
+// TileSize returns the width and height of the tiles in the database
+func (d *Database) TileSize() (osgrid.Distance, osgrid.Distance) {
+	return d.tileWidth, d.tileHeight
+}
+
+func (d *Database) Stats() osdata.Stats {
+	return d.cache.Stats()
+}
+
+// Write writes t as an ASCII Grid. No-data values are written as
+// DefaultNoDataValue.
+func Write(w io.Writer, t osdata.Float64Tile) error {
+	precision := t.Precision()
+	if precision <= 0 || t.Width()%precision != 0 || t.Height()%precision != 0 {
+		return fmt.Errorf("tile size must be a multiple of its precision")
+	}
+
+	ncols := int(t.Width() / precision)
+	nrows := int(t.Height() / precision)
+	bottomLeft := t.BottomLeft()
+
+	bw := bufio.NewWriter(w)
+
+	fmt.Fprintf(bw, "ncols %d\n", ncols)
+	fmt.Fprintf(bw, "nrows %d\n", nrows)
+	fmt.Fprintf(bw, "xllcorner %d\n", bottomLeft.AbsEasting())
+	fmt.Fprintf(bw, "yllcorner %d\n", bottomLeft.AbsNorthing())
+	fmt.Fprintf(bw, "cellsize %d\n", precision)
+	fmt.Fprintf(bw, "NODATA_value %v\n", DefaultNoDataValue)
+
+	// The file data is NW to SE
+	for y := nrows - 1; y >= 0; y-- {
+		for x := 0; x < ncols; x++ {
+			ref, err := bottomLeft.Add(osgrid.Distance(x)*precision, osgrid.Distance(y)*precision)
+			if err != nil {
+				return err
+			}
+
+			v, err := t.GetFloat64(ref)
+			if err != nil {
+				return err
+			}
+
+			if osdata.IsNoData(v) {
+				v = DefaultNoDataValue
+			}
+
+			if x > 0 {
+				bw.WriteByte(' ')
+			}
+			// Tiles are read as float32, so there's no point writing more
+			bw.WriteString(strconv.FormatFloat(v, 'f', -1, 32))
+		}
+		bw.WriteByte('\n')
+	}
+
+	return bw.Flush()
+}
+
+// WriteFile writes t as an ASCII Grid to path. The file is written under a
+// temporary name and then renamed, so readers never see a partial tile.
+func WriteFile(path string, t osdata.Float64Tile) error {
+	tmp := path + ".tmp"
+
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+
+	err = Write(f, t)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+
+	return os.Rename(tmp, path)
+}
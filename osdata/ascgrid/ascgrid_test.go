@@ -0,0 +1,221 @@
+package ascgrid
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/usedbytes/osgrid"
+	"github.com/usedbytes/osgrid/osdata"
+)
+
+var testLiDARData string = "NCOLS 3\r\n" +
+	"NROWS 2\r\n" +
+	"XLLCENTER 260000.5\r\n" +
+	"YLLCENTER 354000.5\r\n" +
+	"CELLSIZE 1.0\r\n" +
+	"NODATA_VALUE -9999.0\r\n" +
+	"1.5 2.5 -9999\r\n" +
+	"4.5 5.5 6.5\r\n"
+
+func TestReadHeaderVariants(t *testing.T) {
+	r := strings.NewReader(testLiDARData)
+
+	g, err := Read(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if g.NCols != 3 || g.NRows != 2 {
+		t.Errorf("size: expected 3x2, got %dx%d", g.NCols, g.NRows)
+	}
+
+	if g.XLLCorner != 260000 || g.YLLCorner != 354000 {
+		t.Errorf("corner: expected 260000,354000, got %v,%v", g.XLLCorner, g.YLLCorner)
+	}
+
+	if g.CellSize != 1 {
+		t.Errorf("cellsize: expected 1, got %v", g.CellSize)
+	}
+
+	// Flipped, so the first row in the file is the last row
+	if g.Data[0][0] != 4.5 || g.Data[1][1] != 2.5 {
+		t.Errorf("unexpected data: %v", g.Data)
+	}
+
+	if !osdata.IsNoData(float64(g.Data[1][2])) {
+		t.Errorf("expected NoData, got %v", g.Data[1][2])
+	}
+}
+
+func TestReadAnyOrder(t *testing.T) {
+	data := `cellsize 50
+xllcorner 10000
+nrows 2
+yllcorner 20000
+ncols 4
+1 2
+3 4 5 6
+7 8`
+
+	g, err := Read(strings.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if g.NoDataValue != DefaultNoDataValue {
+		t.Errorf("nodata: expected default %v, got %v", DefaultNoDataValue, g.NoDataValue)
+	}
+
+	for i, row := range [][]float32{{5, 6, 7, 8}, {1, 2, 3, 4}} {
+		for j, v := range row {
+			if g.Data[i][j] != v {
+				t.Errorf("(%d, %d): expected %v, got %v", j, i, v, g.Data[i][j])
+			}
+		}
+	}
+}
+
+func TestReadErrors(t *testing.T) {
+	tests := map[string]string{
+		"incomplete":  "ncols 2\nnrows 1\ncellsize 1\n1 2",
+		"too little":  "ncols 2\nnrows 2\nxllcorner 0\nyllcorner 0\ncellsize 1\n1 2 3",
+		"too much":    "ncols 2\nnrows 1\nxllcorner 0\nyllcorner 0\ncellsize 1\n1 2 3",
+		"bad header":  "ncols two\nnrows 1\nxllcorner 0\nyllcorner 0\ncellsize 1\n1 2",
+		"bad field":   "ncols 2\nnrows 1\nfoo 1\nxllcorner 0\nyllcorner 0\ncellsize 1\n1 2",
+		"bad data":    "ncols 2\nnrows 1\nxllcorner 0\nyllcorner 0\ncellsize 1\n1 x",
+		"zero size":   "ncols 0\nnrows 1\nxllcorner 0\nyllcorner 0\ncellsize 1\n",
+		"extra value": "ncols 2 3\nnrows 1\nxllcorner 0\nyllcorner 0\ncellsize 1\n1 2",
+	}
+
+	for name, data := range tests {
+		if _, err := Read(strings.NewReader(data)); err == nil {
+			t.Errorf("%s: expected error", name)
+		}
+	}
+}
+
+func TestNewTile(t *testing.T) {
+	tile, err := ReadTile(strings.NewReader(testLiDARData))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sh6054, _ := osgrid.ParseGridRef("SH 60000 54000")
+	if tile.BottomLeft() != sh6054 {
+		t.Errorf("bottomLeft: expected %s, got %s", sh6054, tile.BottomLeft())
+	}
+
+	if tile.Width() != 3 || tile.Height() != 2 || tile.Precision() != 1 {
+		t.Errorf("unexpected dimensions %v x %v @ %v", tile.Width(), tile.Height(), tile.Precision())
+	}
+
+	ref, _ := sh6054.Add(1, 1)
+	v, err := tile.GetFloat64(ref)
+	if err != nil || v != 2.5 {
+		t.Errorf("expected 2.5, got %v, %v", v, err)
+	}
+
+	ref, _ = sh6054.Add(3, 0)
+	if _, err := tile.GetFloat64(ref); err == nil {
+		t.Errorf("expected error outside tile")
+	}
+
+	_, err = ReadTile(strings.NewReader("ncols 1\nnrows 1\nxllcorner 0\nyllcorner 0\ncellsize 0.5\n1"))
+	if err == nil {
+		t.Errorf("expected error for fractional cellsize")
+	}
+}
+
+func writeTile(t *testing.T, dir, name string, x, y int, value string) {
+	data := fmt.Sprintf("ncols 2\nnrows 2\nxllcorner %d\nyllcorner %d\ncellsize 500\n", x, y) +
+		strings.Repeat(value+" ", 4)
+
+	err := ioutil.WriteFile(filepath.Join(dir, name), []byte(data), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestDatabase(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ascgrid")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	os.Mkdir(filepath.Join(dir, "sub"), 0755)
+	writeTile(t, dir, "a.asc", 0, 0, "1")
+	writeTile(t, filepath.Join(dir, "sub"), "b.ASC", 1000, 0, "2")
+	ioutil.WriteFile(filepath.Join(dir, "readme.txt"), []byte("not a tile"), 0644)
+
+	db, err := OpenDatabase(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if db.Precision() != 500 {
+		t.Errorf("precision: expected 500, got %v", db.Precision())
+	}
+
+	for _, test := range []struct {
+		east osgrid.Distance
+		exp  float64
+	}{{0, 1}, {999, 1}, {1000, 2}, {1999, 2}} {
+		ref, _ := osgrid.Origin().Add(test.east, 10)
+		v, err := db.GetFloat64(ref)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if v != test.exp {
+			t.Errorf("%v: expected %v, got %v", ref, test.exp, v)
+		}
+	}
+
+	ref, _ := osgrid.Origin().Add(2000, 0)
+	_, err = db.GetFloat64(ref)
+	if !errors.Is(err, osdata.ErrTileNotFound) {
+		t.Errorf("expected ErrTileNotFound, got %v", err)
+	}
+}
+
+func TestWrite(t *testing.T) {
+	tile, err := ReadTile(strings.NewReader(testLiDARData))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	buf := &strings.Builder{}
+	err = Write(buf, tile)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	exp := "ncols 3\n" +
+		"nrows 2\n" +
+		"xllcorner 260000\n" +
+		"yllcorner 354000\n" +
+		"cellsize 1\n" +
+		"NODATA_value -9999\n" +
+		"1.5 2.5 -9999\n" +
+		"4.5 5.5 6.5\n"
+	if buf.String() != exp {
+		t.Errorf("expected:\n%s\ngot:\n%s", exp, buf.String())
+	}
+
+	back, err := ReadTile(strings.NewReader(buf.String()))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if back.BottomLeft() != tile.BottomLeft() || back.Width() != tile.Width() ||
+		back.Height() != tile.Height() || back.Precision() != tile.Precision() {
+		t.Errorf("expected %s %dx%d, got %s %dx%d", tile, tile.Width(), tile.Height(),
+			back, back.Width(), back.Height())
+	}
+}
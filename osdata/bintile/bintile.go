@@ -0,0 +1,370 @@
+// Package bintile implements a compact binary format for elevation tiles,
+// which is much faster to load than text formats like ESRI ASCII Grid.
+//
+// A file is a fixed-size little-endian header, followed by the samples in
+// rows, starting with the Southern-most row:
+//
+//	Offset  Size  Field
+//	0       4     Magic, "OSBT"
+//	4       1     Version (1)
+//	5       1     Encoding
+//	6       2     Reserved (0)
+//	8       4     Easting of the bottom-left corner, in metres (int32)
+//	12      4     Northing of the bottom-left corner, in metres (int32)
+//	16      4     Number of columns (uint32)
+//	20      4     Number of rows (uint32)
+//	24      4     Cell size, in metres (uint32)
+//
+// With EncodingInt16Decimetres, each sample is an int16 number of decimetres,
+// with math.MinInt16 meaning no-data. With EncodingFloat32, each sample is a
+// float32, with NaN meaning no-data.
+package bintile
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"os"
+	"runtime"
+
+	"github.com/usedbytes/osgrid"
+	"github.com/usedbytes/osgrid/osdata"
+)
+
+var mustBeFloat64Tile osdata.Float64Tile = &Tile{}
+
+// Ext is the file extension used for binary tiles
+const Ext = ".osbt"
+
+const (
+	magic      = "OSBT"
+	version    = 1
+	headerSize = 28
+
+	noDataInt16 = math.MinInt16
+)
+
+type Encoding uint8
+
+const (
+	// 2 bytes per sample, with 0.1 m resolution and a range of +/- 3276.7 m.
+	// Enough for Terrain 50 and Terrain 5.
+	EncodingInt16Decimetres Encoding = 1
+	// 4 bytes per sample, for data which needs more range or resolution
+	EncodingFloat32 Encoding = 2
+)
+
+func (e Encoding) String() string {
+	switch e {
+	case EncodingInt16Decimetres:
+		return "int16"
+	case EncodingFloat32:
+		return "float32"
+	}
+
+	return fmt.Sprintf("Encoding(%d)", e)
+}
+
+// ParseEncoding parses the String() representation of an Encoding
+func ParseEncoding(s string) (Encoding, error) {
+	switch s {
+	case "int16":
+		return EncodingInt16Decimetres, nil
+	case "float32":
+		return EncodingFloat32, nil
+	}
+
+	return 0, fmt.Errorf("unknown encoding '%s'", s)
+}
+
+func (e Encoding) sampleSize() int {
+	switch e {
+	case EncodingInt16Decimetres:
+		return 2
+	case EncodingFloat32:
+		return 4
+	}
+
+	return 0
+}
+
+type header struct {
+	Magic    [4]byte
+	Version  uint8
+	Encoding Encoding
+	Reserved uint16
+	Easting  int32
+	Northing int32
+	NCols    uint32
+	NRows    uint32
+	CellSize uint32
+}
+
+// Tile is a binary tile. The samples are kept in their encoded form, and
+// decoded on access.
+type Tile struct {
+	bottomLeft    osgrid.GridRef
+	width, height osgrid.Distance
+	precision     osgrid.Distance
+	encoding      Encoding
+
+	ncols int
+	data  []byte
+}
+
+func parseHeader(b []byte) (header, error) {
+	var hdr header
+
+	if len(b) < headerSize {
+		return hdr, fmt.Errorf("short header")
+	}
+
+	err := binary.Read(bytes.NewReader(b[:headerSize]), binary.LittleEndian, &hdr)
+	if err != nil {
+		return hdr, err
+	}
+
+	if string(hdr.Magic[:]) != magic {
+		return hdr, fmt.Errorf("not a binary tile")
+	}
+
+	if hdr.Version != version {
+		return hdr, fmt.Errorf("unsupported version %d", hdr.Version)
+	}
+
+	if hdr.Encoding.sampleSize() == 0 {
+		return hdr, fmt.Errorf("unsupported encoding %d", hdr.Encoding)
+	}
+
+	if hdr.NCols == 0 || hdr.NRows == 0 || hdr.CellSize == 0 {
+		return hdr, fmt.Errorf("invalid dimensions")
+	}
+
+	return hdr, nil
+}
+
+// newTile creates a tile from the whole contents of a file. b is used
+// directly, so mustn't be modified afterwards.
+func newTile(b []byte) (*Tile, error) {
+	hdr, err := parseHeader(b)
+	if err != nil {
+		return nil, err
+	}
+
+	data := b[headerSize:]
+	need := int(hdr.NCols) * int(hdr.NRows) * hdr.Encoding.sampleSize()
+	if len(data) < need {
+		return nil, fmt.Errorf("expected %d bytes of data, got %d", need, len(data))
+	}
+
+	bottomLeft, err := osgrid.Origin().Add(osgrid.Distance(hdr.Easting), osgrid.Distance(hdr.Northing))
+	if err != nil {
+		return nil, err
+	}
+
+	precision := osgrid.Distance(hdr.CellSize)
+
+	return &Tile{
+		bottomLeft: bottomLeft,
+		width:      osgrid.Distance(hdr.NCols) * precision,
+		height:     osgrid.Distance(hdr.NRows) * precision,
+		precision:  precision,
+		encoding:   hdr.Encoding,
+		ncols:      int(hdr.NCols),
+		data:       data[:need],
+	}, nil
+}
+
+// ReadTile reads a whole binary tile into memory
+func ReadTile(r io.Reader) (*Tile, error) {
+	b, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+
+	return newTile(b)
+}
+
+// OpenTile reads a whole binary tile file into memory. See also MapTile.
+func OpenTile(path string) (*Tile, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	return newTile(b)
+}
+
+func (t *Tile) String() string {
+	return t.bottomLeft.String()
+}
+
+func (t *Tile) BottomLeft() osgrid.GridRef {
+	return t.bottomLeft
+}
+
+func (t *Tile) Precision() osgrid.Distance {
+	return t.precision
+}
+
+func (t *Tile) Width() osgrid.Distance {
+	return t.width
+}
+
+func (t *Tile) Height() osgrid.Distance {
+	return t.height
+}
+
+func (t *Tile) Encoding() Encoding {
+	return t.encoding
+}
+
+// MemorySize is the size of the samples. Memory-mapped tiles are counted
+// too, so that the cache budget also limits the amount of address space used.
+func (t *Tile) MemorySize() int {
+	return len(t.data)
+}
+
+func (t *Tile) get(x, y int) float64 {
+	size := t.encoding.sampleSize()
+	b := t.data[(y*t.ncols+x)*size:]
+
+	var v float64
+	if t.encoding == EncodingInt16Decimetres {
+		dm := int16(binary.LittleEndian.Uint16(b))
+		if dm == noDataInt16 {
+			v = osdata.NoData
+		} else {
+			v = float64(dm) / 10
+		}
+	} else {
+		v = float64(math.Float32frombits(binary.LittleEndian.Uint32(b)))
+	}
+
+	// For memory-mapped tiles, b points into the mapping, which is released
+	// by t's finalizer. t must stay reachable until b has been read.
+	runtime.KeepAlive(t)
+
+	return v
+}
+
+func (t *Tile) GetFloat64(ref osgrid.GridRef) (float64, error) {
+	east := ref.AbsEasting() - t.bottomLeft.AbsEasting()
+	north := ref.AbsNorthing() - t.bottomLeft.AbsNorthing()
+
+	if east < 0 || north < 0 || east >= t.width || north >= t.height {
+		return osdata.NoData, fmt.Errorf("Coordinate outside tile")
+	}
+
+	return t.get(int(east/t.precision), int(north/t.precision)), nil
+}
+
+func putInt16(b []byte, v int16) {
+	binary.LittleEndian.PutUint16(b, uint16(v))
+}
+
+func encodeSample(b []byte, enc Encoding, v float64) error {
+	if enc == EncodingFloat32 {
+		binary.LittleEndian.PutUint32(b, math.Float32bits(float32(v)))
+		return nil
+	}
+
+	if osdata.IsNoData(v) {
+		putInt16(b, noDataInt16)
+		return nil
+	}
+
+	dm := math.Round(v * 10)
+	if dm <= noDataInt16 || dm > math.MaxInt16 {
+		return fmt.Errorf("value %v out of range for %s encoding", v, enc)
+	}
+	putInt16(b, int16(dm))
+
+	return nil
+}
+
+// Write encodes t in the binary format. Any Float64Tile can be written, but
+// its size must be a multiple of its precision.
+func Write(w io.Writer, t osdata.Float64Tile, enc Encoding) error {
+	size := enc.sampleSize()
+	if size == 0 {
+		return fmt.Errorf("unsupported encoding %d", enc)
+	}
+
+	precision := t.Precision()
+	if precision <= 0 || t.Width()%precision != 0 || t.Height()%precision != 0 {
+		return fmt.Errorf("tile size must be a multiple of its precision")
+	}
+
+	ncols := int(t.Width() / precision)
+	nrows := int(t.Height() / precision)
+	bottomLeft := t.BottomLeft()
+
+	hdr := header{
+		Version:  version,
+		Encoding: enc,
+		Easting:  int32(bottomLeft.AbsEasting()),
+		Northing: int32(bottomLeft.AbsNorthing()),
+		NCols:    uint32(ncols),
+		NRows:    uint32(nrows),
+		CellSize: uint32(precision),
+	}
+	copy(hdr.Magic[:], magic)
+
+	err := binary.Write(w, binary.LittleEndian, &hdr)
+	if err != nil {
+		return err
+	}
+
+	row := make([]byte, ncols*size)
+	for y := 0; y < nrows; y++ {
+		for x := 0; x < ncols; x++ {
+			ref, err := bottomLeft.Add(osgrid.Distance(x)*precision, osgrid.Distance(y)*precision)
+			if err != nil {
+				return err
+			}
+
+			v, err := t.GetFloat64(ref)
+			if err != nil {
+				return err
+			}
+
+			err = encodeSample(row[x*size:], enc, v)
+			if err != nil {
+				return fmt.Errorf("%s: %w", ref, err)
+			}
+		}
+
+		_, err = w.Write(row)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// WriteFile writes t to a binary tile file at path. The file is written to a
+// temporary name first, so a partially written tile is never left at path.
+func WriteFile(path string, t osdata.Float64Tile, enc Encoding) error {
+	tmp := path + ".tmp"
+
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+
+	err = Write(f, t, enc)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+
+	return os.Rename(tmp, path)
+}
@@ -0,0 +1,162 @@
+package bintile
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/usedbytes/osgrid"
+	"github.com/usedbytes/osgrid/osdata"
+	"github.com/usedbytes/osgrid/osdata/ascgrid"
+)
+
+var testASCData string = `ncols 3
+nrows 2
+xllcorner 520000
+yllcorner 180000
+cellsize 50
+NODATA_value -9999
+1.25 -2.0 -9999
+100.0 1085.4 0.05`
+
+func testTile(t *testing.T) *ascgrid.Tile {
+	tile, err := ascgrid.ReadTile(strings.NewReader(testASCData))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return tile
+}
+
+func checkTile(t *testing.T, tile *Tile, expected []float64) {
+	tq2282, _ := osgrid.ParseGridRef("TQ 20000 80000")
+
+	if tile.BottomLeft() != tq2282 {
+		t.Errorf("BottomLeft: expected %s got %s", tq2282, tile.BottomLeft())
+	}
+
+	if tile.Width() != 150 || tile.Height() != 100 || tile.Precision() != 50 {
+		t.Errorf("unexpected dimensions %dx%d, %d", tile.Width(), tile.Height(), tile.Precision())
+	}
+
+	// South-first, as the data is stored
+	for i, exp := range expected {
+		ref, _ := tq2282.Add(osgrid.Distance(i%3)*50, osgrid.Distance(i/3)*50)
+
+		v, err := tile.GetFloat64(ref)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if osdata.IsNoData(exp) {
+			if !osdata.IsNoData(v) {
+				t.Errorf("%s: expected no-data, got %v", ref, v)
+			}
+		} else if v != exp {
+			t.Errorf("%s: expected %v, got %v", ref, exp, v)
+		}
+	}
+
+	outside, _ := tq2282.Add(150, 0)
+	_, err := tile.GetFloat64(outside)
+	if err == nil {
+		t.Errorf("expected error outside tile")
+	}
+}
+
+func TestRoundTrip(t *testing.T) {
+	testCases := []struct {
+		enc      Encoding
+		expected []float64
+	}{
+		{EncodingInt16Decimetres, []float64{100, 1085.4, 0.1, 1.3, -2, osdata.NoData}},
+		{EncodingFloat32, []float64{100, float64(float32(1085.4)), float64(float32(0.05)), 1.25, -2, osdata.NoData}},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.enc.String(), func(t *testing.T) {
+			buf := &bytes.Buffer{}
+
+			err := Write(buf, testTile(t), tc.enc)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if buf.Len() != headerSize+6*tc.enc.sampleSize() {
+				t.Errorf("unexpected size %d", buf.Len())
+			}
+
+			tile, err := ReadTile(buf)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if tile.Encoding() != tc.enc {
+				t.Errorf("expected encoding %s, got %s", tc.enc, tile.Encoding())
+			}
+
+			checkTile(t, tile, tc.expected)
+		})
+	}
+}
+
+func TestMapTile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "bintile")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "tq28"+Ext)
+	err = WriteFile(path, testTile(t), EncodingInt16Decimetres)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tile, err := MapTile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	checkTile(t, tile, []float64{100, 1085.4, 0.1, 1.3, -2, osdata.NoData})
+}
+
+func TestInvalid(t *testing.T) {
+	buf := &bytes.Buffer{}
+	err := Write(buf, testTile(t), EncodingFloat32)
+	if err != nil {
+		t.Fatal(err)
+	}
+	good := buf.Bytes()
+
+	corrupt := func(off int, v byte) []byte {
+		b := append([]byte{}, good...)
+		b[off] = v
+		return b
+	}
+
+	testCases := map[string][]byte{
+		"empty":     []byte{},
+		"magic":     corrupt(0, 'X'),
+		"version":   corrupt(4, 9),
+		"encoding":  corrupt(5, 9),
+		"truncated": good[:len(good)-1],
+	}
+
+	for name, b := range testCases {
+		_, err := ReadTile(bytes.NewReader(b))
+		if err == nil {
+			t.Errorf("%s: expected error", name)
+		}
+	}
+
+	// Out of range for int16 decimetres
+	tile, _ := ascgrid.ReadTile(strings.NewReader("ncols 1\nnrows 1\nxllcorner 0\nyllcorner 0\ncellsize 50\n4000"))
+	err = Write(&bytes.Buffer{}, tile, EncodingInt16Decimetres)
+	if err == nil {
+		t.Errorf("expected out-of-range error")
+	}
+}
@@ -0,0 +1,9 @@
+//go:build !darwin && !dragonfly && !freebsd && !linux && !netbsd && !openbsd && !solaris
+// +build !darwin,!dragonfly,!freebsd,!linux,!netbsd,!openbsd,!solaris
+
+package bintile
+
+// MapTile falls back to OpenTile on platforms without mmap support
+func MapTile(path string) (*Tile, error) {
+	return OpenTile(path)
+}
@@ -0,0 +1,49 @@
+//go:build darwin || dragonfly || freebsd || linux || netbsd || openbsd || solaris
+// +build darwin dragonfly freebsd linux netbsd openbsd solaris
+
+package bintile
+
+import (
+	"os"
+	"runtime"
+	"syscall"
+)
+
+// MapTile memory-maps a binary tile file, so that only the parts which are
+// used are read from disk. The mapping is released when the tile is garbage
+// collected, rather than when it's evicted from a cache, because callers may
+// still be using an evicted tile.
+func MapTile(path string) (*Tile, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	fi, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+
+	if fi.Size() < headerSize {
+		// Let newTile report the error
+		return OpenTile(path)
+	}
+
+	b, err := syscall.Mmap(int(f.Fd()), 0, int(fi.Size()), syscall.PROT_READ, syscall.MAP_SHARED)
+	if err != nil {
+		return nil, err
+	}
+
+	t, err := newTile(b)
+	if err != nil {
+		syscall.Munmap(b)
+		return nil, err
+	}
+
+	runtime.SetFinalizer(t, func(t *Tile) {
+		syscall.Munmap(b)
+	})
+
+	return t, nil
+}
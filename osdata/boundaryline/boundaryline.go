@@ -0,0 +1,340 @@
+// Package boundaryline finds the administrative areas (counties, districts,
+// parishes, etc.) which contain a point, and provides their boundaries, using
+// the OS Boundary-Line dataset.
+//
+// Boundary-Line is distributed as a set of shapefiles, one for each type of
+// area, e.g. 'Data/GB/county_region.shp'. Each shapefile is a "layer", named
+// after the file. Any other polygon shapefiles with a NAME attribute, such as
+// National Park boundaries, can be used as layers too.
+package boundaryline
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/usedbytes/osgrid"
+	"github.com/usedbytes/osgrid/osdata/shapefile"
+	"github.com/usedbytes/osgrid/osdata/vector"
+)
+
+var (
+	ErrNotFound  = errors.New("not found")
+	ErrAmbiguous = errors.New("ambiguous")
+)
+
+// Size of the cells in the spatial index
+const indexCellSize = 10 * osgrid.Kilometre
+
+// Boundary is a single area
+type Boundary struct {
+	// Layer the boundary is in, e.g. "county_region"
+	Layer string
+	// e.g. "Cambridgeshire"
+	Name string
+	// GSS code, e.g. "E10000003", if there is one
+	Code string
+	// All of the attributes from the dataset
+	Attributes map[string]interface{}
+
+	Polygon *vector.Polygon
+	bounds  vector.Bounds
+}
+
+func (b *Boundary) String() string {
+	return fmt.Sprintf("%s (%s)", b.Name, b.Layer)
+}
+
+// Bounds returns the bounding box of the boundary
+func (b *Boundary) Bounds() vector.Bounds {
+	return b.bounds
+}
+
+// Rect returns the smallest Rect containing the whole boundary
+func (b *Boundary) Rect() (osgrid.Rect, error) {
+	return b.bounds.Rect()
+}
+
+// Contains returns true if the centre of the 1 m square at ref is inside the
+// boundary
+func (b *Boundary) Contains(ref osgrid.GridRef) bool {
+	return b.contains(centre(ref))
+}
+
+func (b *Boundary) contains(p vector.Point) bool {
+	return b.bounds.Contains(p) && b.Polygon.Contains(p)
+}
+
+func centre(ref osgrid.GridRef) vector.Point {
+	p := vector.FromGridRef(ref)
+	return vector.Point{E: p.E + 0.5, N: p.N + 0.5}
+}
+
+type layer struct {
+	name       string
+	boundaries []*Boundary
+	index      *vector.Index
+}
+
+// Database holds the boundaries of one or more layers in memory
+type Database struct {
+	layers map[string]*layer
+}
+
+type Opt func(*openConfig)
+
+type openConfig struct {
+	layers map[string]bool
+}
+
+// Only load the given layers (e.g. "county_region"), to save time and memory
+func LayersOpt(layers ...string) Opt {
+	return func(cfg *openConfig) {
+		cfg.layers = make(map[string]bool)
+		for _, l := range layers {
+			cfg.layers[strings.ToLower(l)] = true
+		}
+	}
+}
+
+// Open loads the boundaries in path, which can be a single shapefile, or a
+// directory containing shapefiles (e.g. the extracted 'Data' directory).
+func Open(path string, opts ...Opt) (*Database, error) {
+	var cfg openConfig
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+
+	d := newDatabase()
+
+	fi, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var files []string
+	if fi.IsDir() {
+		err = filepath.Walk(path, func(p string, fi os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+
+			if !fi.IsDir() && strings.ToLower(filepath.Ext(p)) == ".shp" {
+				files = append(files, p)
+			}
+
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		files = []string{path}
+	}
+
+	for _, f := range files {
+		name := layerName(f)
+		if cfg.layers != nil && !cfg.layers[name] {
+			continue
+		}
+
+		features, err := readShapefile(f)
+		if err != nil {
+			return nil, err
+		}
+
+		if err := d.addLayer(name, features); err != nil {
+			return nil, fmt.Errorf("%s: %w", f, err)
+		}
+	}
+
+	if len(d.layers) == 0 {
+		return nil, fmt.Errorf("no boundaries found in %s", path)
+	}
+
+	return d, nil
+}
+
+// e.g. "Data/GB/county_region.shp" -> "county_region"
+func layerName(path string) string {
+	base := filepath.Base(path)
+	return strings.ToLower(strings.TrimSuffix(base, filepath.Ext(base)))
+}
+
+func readShapefile(path string) ([]*vector.Feature, error) {
+	f, err := shapefile.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	features, err := f.ReadAll()
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+
+	return features, nil
+}
+
+func newDatabase() *Database {
+	return &Database{
+		layers: make(map[string]*layer),
+	}
+}
+
+// Look up an attribute, ignoring the case of its name
+func attr(attrs map[string]interface{}, name string) string {
+	for k, v := range attrs {
+		if s, ok := v.(string); ok && strings.EqualFold(k, name) {
+			return s
+		}
+	}
+
+	return ""
+}
+
+func (d *Database) addLayer(name string, features []*vector.Feature) error {
+	l := &layer{
+		name:  name,
+		index: vector.NewIndex(indexCellSize),
+	}
+
+	for _, f := range features {
+		if f.Geometry == nil {
+			continue
+		}
+
+		poly, ok := f.Geometry.(*vector.Polygon)
+		if !ok {
+			return fmt.Errorf("layer %s: feature %d isn't a polygon", name, f.ID)
+		}
+
+		b := &Boundary{
+			Layer:      name,
+			Name:       attr(f.Attributes, "NAME"),
+			Code:       attr(f.Attributes, "CODE"),
+			Attributes: f.Attributes,
+			Polygon:    poly,
+			bounds:     poly.Bounds(),
+		}
+
+		l.index.Insert(b.bounds)
+		l.boundaries = append(l.boundaries, b)
+	}
+
+	d.layers[name] = l
+
+	return nil
+}
+
+// Layers returns the names of all of the layers, e.g. "county_region"
+func (d *Database) Layers() []string {
+	names := make([]string, 0, len(d.layers))
+	for n := range d.layers {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+// Get the named layers, or all of them
+func (d *Database) getLayers(names []string) ([]*layer, error) {
+	if len(names) == 0 {
+		names = d.Layers()
+	}
+
+	layers := make([]*layer, 0, len(names))
+	for _, n := range names {
+		l, ok := d.layers[strings.ToLower(n)]
+		if !ok {
+			return nil, fmt.Errorf("layer '%s' %w", n, ErrNotFound)
+		}
+
+		layers = append(layers, l)
+	}
+
+	return layers, nil
+}
+
+// At returns the boundaries, in the given layers (or all layers), which
+// contain ref. They're in the order of the layers, which is alphabetical
+// by default.
+func (d *Database) At(ref osgrid.GridRef, layers ...string) ([]*Boundary, error) {
+	ls, err := d.getLayers(layers)
+	if err != nil {
+		return nil, err
+	}
+
+	p := centre(ref)
+
+	var result []*Boundary
+	for _, l := range ls {
+		for _, id := range l.index.SearchPoint(p) {
+			b := l.boundaries[id]
+			if b.contains(p) {
+				result = append(result, b)
+			}
+		}
+	}
+
+	return result, nil
+}
+
+// Find returns all of the boundaries in the given layers (or all layers)
+// named name, ignoring case. If there aren't any, boundaries whose name
+// starts with name are returned instead, so "Cambridge" will find
+// "Cambridge District (B)".
+func (d *Database) Find(name string, layers ...string) ([]*Boundary, error) {
+	ls, err := d.getLayers(layers)
+	if err != nil {
+		return nil, err
+	}
+
+	name = strings.ToLower(strings.TrimSpace(name))
+
+	var exact, prefix []*Boundary
+	for _, l := range ls {
+		for _, b := range l.boundaries {
+			n := strings.ToLower(b.Name)
+			if n == name {
+				exact = append(exact, b)
+			} else if strings.HasPrefix(n, name+" ") {
+				prefix = append(prefix, b)
+			}
+		}
+	}
+
+	if len(exact) > 0 {
+		return exact, nil
+	}
+
+	return prefix, nil
+}
+
+// Lookup returns the single boundary in the given layers (or all layers)
+// named name (see Find). If there's more than one, the error wraps
+// ErrAmbiguous, and lists them.
+func (d *Database) Lookup(name string, layers ...string) (*Boundary, error) {
+	found, err := d.Find(name, layers...)
+	if err != nil {
+		return nil, err
+	}
+
+	switch len(found) {
+	case 0:
+		return nil, fmt.Errorf("boundary '%s' %w", name, ErrNotFound)
+	case 1:
+		return found[0], nil
+	}
+
+	names := make([]string, len(found))
+	for i, b := range found {
+		names[i] = b.String()
+	}
+
+	return nil, fmt.Errorf("boundary '%s' %w, could be: %s", name, ErrAmbiguous, strings.Join(names, "; "))
+}
@@ -0,0 +1,212 @@
+package boundaryline
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/usedbytes/osgrid"
+	"github.com/usedbytes/osgrid/osdata"
+	"github.com/usedbytes/osgrid/osdata/osdatatest"
+	"github.com/usedbytes/osgrid/osdata/vector"
+	"github.com/usedbytes/osgrid/osgridtest"
+)
+
+func rect(e, n, w, h float64) []vector.Point {
+	return []vector.Point{
+		{E: e, N: n}, {E: e, N: n + h}, {E: e + w, N: n + h}, {E: e + w, N: n},
+	}
+}
+
+func feature(id int64, name, code string, rings ...[]vector.Point) *vector.Feature {
+	return &vector.Feature{
+		ID:       id,
+		Geometry: &vector.Polygon{Rings: rings},
+		Attributes: map[string]interface{}{
+			"NAME": name,
+			"CODE": code,
+		},
+	}
+}
+
+func testDatabase(t *testing.T) *Database {
+	d := newDatabase()
+
+	// Two counties side by side, around TL 00
+	err := d.addLayer("county_region", []*vector.Feature{
+		feature(1, "Westshire County", "E10000001", rect(500000, 200000, 50000, 100000)),
+		feature(2, "Eastshire County", "E10000002", rect(550000, 200000, 50000, 100000)),
+		{ID: 3, Attributes: map[string]interface{}{"NAME": "Null"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = d.addLayer("district_borough_unitary_region", []*vector.Feature{
+		// With an island, and an enclave (of Newton) cut out of it
+		feature(1, "Oldtown District (B)", "E07000001",
+			rect(500000, 200000, 20000, 20000),
+			rect(505000, 205000, 5000, 5000),
+			rect(560000, 280000, 1000, 1000)),
+		feature(2, "Newton", "E07000002", rect(505000, 205000, 5000, 5000)),
+		feature(3, "Newton", "E07000003", rect(570000, 210000, 5000, 5000)),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return d
+}
+
+func names(bs []*Boundary) []string {
+	var ns []string
+	for _, b := range bs {
+		ns = append(ns, b.Name)
+	}
+	return ns
+}
+
+func TestAt(t *testing.T) {
+	d := testDatabase(t)
+
+	if ls := d.Layers(); len(ls) != 2 || ls[0] != "county_region" {
+		t.Errorf("unexpected layers %v", ls)
+	}
+
+	for _, tc := range []struct {
+		e, n   osgrid.Distance
+		layers []string
+		exp    []string
+	}{
+		{501000, 201000, nil, []string{"Westshire County", "Oldtown District (B)"}},
+		{506000, 206000, nil, []string{"Westshire County", "Newton"}},
+		{506000, 206000, []string{"county_region"}, []string{"Westshire County"}},
+		{560500, 280500, nil, []string{"Eastshire County", "Oldtown District (B)"}},
+		{572000, 212000, []string{"District_Borough_Unitary_Region"}, []string{"Newton"}},
+		{560000, 250000, []string{"district_borough_unitary_region"}, nil},
+		{700000, 250000, nil, nil},
+	} {
+		bs, err := d.At(osgridtest.MustRef(t, tc.e, tc.n), tc.layers...)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		got := names(bs)
+		if len(got) != len(tc.exp) {
+			t.Errorf("At(%d, %d): expected %v, got %v", tc.e, tc.n, tc.exp, got)
+			continue
+		}
+
+		for i := range got {
+			if got[i] != tc.exp[i] {
+				t.Errorf("At(%d, %d): expected %v, got %v", tc.e, tc.n, tc.exp, got)
+				break
+			}
+		}
+	}
+
+	if _, err := d.At(osgridtest.MustRef(t, 0, 0), "parish_region"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("expected ErrNotFound for missing layer, got %v", err)
+	}
+}
+
+func TestLookup(t *testing.T) {
+	d := testDatabase(t)
+
+	b, err := d.Lookup("westshire county")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if b.Code != "E10000001" || b.Layer != "county_region" {
+		t.Errorf("unexpected boundary %v %s", b, b.Code)
+	}
+
+	r, err := b.Rect()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if r.BottomLeft != osgridtest.MustRef(t, 500000, 200000) || r.Width != 50000 || r.Height != 100000 {
+		t.Errorf("unexpected rect %v", r)
+	}
+
+	// By prefix
+	b, err = d.Lookup("Oldtown")
+	if err != nil || b.Code != "E07000001" {
+		t.Errorf("expected Oldtown District (B), got %v, %v", b, err)
+	}
+
+	_, err = d.Lookup("Newton")
+	if !errors.Is(err, ErrAmbiguous) {
+		t.Errorf("expected ErrAmbiguous, got %v", err)
+	}
+
+	found, err := d.Find("Newton")
+	if err != nil || len(found) != 2 {
+		t.Errorf("expected 2 Newtons, got %v, %v", found, err)
+	}
+
+	_, err = d.Lookup("Newton", "county_region")
+	if !errors.Is(err, ErrNotFound) {
+		t.Errorf("expected ErrNotFound, got %v", err)
+	}
+
+	// Only whole-word prefixes
+	_, err = d.Lookup("West")
+	if !errors.Is(err, ErrNotFound) {
+		t.Errorf("expected ErrNotFound, got %v", err)
+	}
+}
+
+func TestMask(t *testing.T) {
+	d := testDatabase(t)
+
+	db := osdatatest.NewFuncDatabase(func(e, n float64) float64 { return 1 },
+		50, 10*osgrid.Kilometre)
+
+	b, err := d.Lookup("Oldtown District (B)")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	m := NewMask(db, b)
+
+	tile, err := m.GetFloat64Tile(osgridtest.MustRef(t, 505000, 205000))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		e, n   osgrid.Distance
+		inside bool
+	}{
+		{501000, 201000, true},
+		// The enclave
+		{506000, 206000, false},
+		{521000, 201000, false},
+		{560500, 280500, true},
+	} {
+		ref := osgridtest.MustRef(t, tc.e, tc.n)
+		v, err := m.GetFloat64(ref)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if osdata.IsNoData(v) == tc.inside {
+			t.Errorf("GetFloat64(%d, %d): expected inside %v, got %v", tc.e, tc.n, tc.inside, v)
+		}
+
+		if ref.Align(10*osgrid.Kilometre) != tile.BottomLeft() {
+			continue
+		}
+
+		v, err = tile.GetFloat64(ref)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if osdata.IsNoData(v) == tc.inside {
+			t.Errorf("tile GetFloat64(%d, %d): expected inside %v, got %v", tc.e, tc.n, tc.inside, v)
+		}
+	}
+}
@@ -0,0 +1,79 @@
+package boundaryline
+
+import (
+	"github.com/usedbytes/osgrid"
+	"github.com/usedbytes/osgrid/osdata"
+)
+
+var mustBeMask osdata.Float64Database = &Mask{}
+
+// Mask presents the data in a database which is inside any of a set of
+// boundaries. Everywhere else is NoData, so for example, a mesh of a county
+// is cut out to its outline.
+type Mask struct {
+	db         osdata.Float64Database
+	boundaries []*Boundary
+}
+
+type maskTile struct {
+	osdata.Float64Tile
+	mask *Mask
+}
+
+func (t *maskTile) GetFloat64(ref osgrid.GridRef) (float64, error) {
+	if !t.mask.contains(ref) {
+		return osdata.NoData, nil
+	}
+
+	return t.Float64Tile.GetFloat64(ref)
+}
+
+// NewMask masks db with boundaries
+func NewMask(db osdata.Float64Database, boundaries ...*Boundary) *Mask {
+	return &Mask{
+		db:         db,
+		boundaries: boundaries,
+	}
+}
+
+func (m *Mask) contains(ref osgrid.GridRef) bool {
+	for _, b := range m.boundaries {
+		if b.Contains(ref) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (m *Mask) GetTile(ref osgrid.GridRef) (osdata.Tile, error) {
+	return m.GetFloat64Tile(ref)
+}
+
+func (m *Mask) GetFloat64Tile(ref osgrid.GridRef) (osdata.Float64Tile, error) {
+	tile, err := m.db.GetFloat64Tile(ref)
+	if err != nil {
+		return nil, err
+	}
+
+	return &maskTile{tile, m}, nil
+}
+
+// GetFloat64 returns NoData outside of the boundaries. Missing tiles are
+// still reported as errors, so the edges of the data can be found.
+func (m *Mask) GetFloat64(ref osgrid.GridRef) (float64, error) {
+	v, err := m.db.GetFloat64(ref)
+	if err != nil || !m.contains(ref) {
+		return osdata.NoData, err
+	}
+
+	return v, nil
+}
+
+func (m *Mask) Precision() osgrid.Distance {
+	return m.db.Precision()
+}
+
+func (m *Mask) Stats() osdata.Stats {
+	return m.db.Stats()
+}
@@ -0,0 +1,513 @@
+// Package codepoint resolves postcodes to grid references, using the OS
+// Code-Point Open dataset.
+//
+// The dataset is distributed as one CSV file (without a header row) per
+// postcode area, e.g. 'Data/CSV/cb.csv', in a zip. Either the zip, or the
+// directory it's extracted to, can be opened. Only the list of areas is read
+// up-front, each area's postcodes are loaded the first time they're needed.
+package codepoint
+
+import (
+	"archive/zip"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/usedbytes/osgrid"
+)
+
+var ErrNotFound = errors.New("not found")
+
+// Column indices in the CSV files
+const (
+	colPostcode          = 0
+	colQuality           = 1
+	colEastings          = 2
+	colNorthings         = 3
+	colCountryCode       = 4
+	colAdminCountyCode   = 7
+	colAdminDistrictCode = 8
+	colAdminWardCode     = 9
+
+	numCols = 10
+)
+
+// Postcodes with this positional quality don't have coordinates
+const qualityNoCoordinates = 90
+
+// Level is how much of a postcode is given, e.g. "CB" is an area, "CB2" a
+// district, "CB2 1" a sector and "CB2 1TN" a unit.
+type Level int
+
+const (
+	LevelArea Level = iota
+	LevelDistrict
+	LevelSector
+	LevelUnit
+)
+
+func (l Level) String() string {
+	switch l {
+	case LevelArea:
+		return "area"
+	case LevelDistrict:
+		return "district"
+	case LevelSector:
+		return "sector"
+	case LevelUnit:
+		return "unit"
+	}
+
+	return fmt.Sprintf("Level(%d)", int(l))
+}
+
+// Postcode is a single postcode unit
+type Postcode struct {
+	// Formatted with a single space, e.g. "CB2 1TN"
+	Postcode string
+	// Representative point of the postcode's delivery points
+	GridRef osgrid.GridRef
+	// How GridRef was determined: 10 is within a building, 60 is the
+	// centre of the postcode sector (see the Code-Point Open user guide)
+	Quality int
+
+	// GSS codes of the country and administrative areas
+	CountryCode       string
+	AdminCountyCode   string
+	AdminDistrictCode string
+	AdminWardCode     string
+}
+
+func (p *Postcode) String() string {
+	return fmt.Sprintf("%s (%s)", p.Postcode, p.GridRef)
+}
+
+// Extent describes where a full or partial postcode is
+type Extent struct {
+	// Normalised postcode, e.g. "CB2 1"
+	Postcode string
+	Level    Level
+	// Mean of the postcode units' positions
+	Centroid osgrid.GridRef
+	// Bounding box of the postcode units' positions. Zero size for a single
+	// unit.
+	Bounds osgrid.Rect
+	// Number of postcode units
+	Count int
+}
+
+func (e *Extent) String() string {
+	return fmt.Sprintf("%s (%s, %d postcodes)", e.Postcode, e.Centroid, e.Count)
+}
+
+// Where to load an area from
+type source struct {
+	// Path of the zip, or the CSV file itself
+	path string
+	// Name of the CSV file in the zip, or empty for a plain file
+	name string
+}
+
+// Database loads postcode areas on demand. It's safe for concurrent use.
+type Database struct {
+	sources map[string]source
+
+	lock sync.Mutex
+	// Postcodes in each loaded area, sorted by Postcode
+	areas map[string][]Postcode
+}
+
+// Open indexes the Code-Point Open CSV files in path, which can be the
+// distribution zip, or a directory containing the CSV files (e.g. the
+// extracted 'Data/CSV' directory).
+func Open(path string) (*Database, error) {
+	d := &Database{
+		sources: make(map[string]source),
+		areas:   make(map[string][]Postcode),
+	}
+
+	fi, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+
+	if fi.IsDir() {
+		err = filepath.Walk(path, func(p string, fi os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+
+			if !fi.IsDir() && isDataFile(p) {
+				d.sources[areaName(p)] = source{path: p}
+			}
+
+			return nil
+		})
+	} else {
+		var zr *zip.ReadCloser
+		zr, err = zip.OpenReader(path)
+		if err == nil {
+			for _, f := range zr.File {
+				if isDataFile(f.Name) {
+					d.sources[areaName(f.Name)] = source{path: path, name: f.Name}
+				}
+			}
+			zr.Close()
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if len(d.sources) == 0 {
+		return nil, fmt.Errorf("no postcode areas found in %s", path)
+	}
+
+	return d, nil
+}
+
+func isDataFile(path string) bool {
+	return strings.ToLower(filepath.Ext(path)) == ".csv" &&
+		!strings.Contains(strings.ToLower(filepath.Base(path)), "header")
+}
+
+// e.g. "Data/CSV/cb.csv" -> "CB"
+func areaName(path string) string {
+	base := filepath.Base(filepath.ToSlash(path))
+	return strings.ToUpper(strings.TrimSuffix(base, filepath.Ext(base)))
+}
+
+// Areas returns the postcode areas in the dataset, e.g. "CB"
+func (d *Database) Areas() []string {
+	areas := make([]string, 0, len(d.sources))
+	for a := range d.sources {
+		areas = append(areas, a)
+	}
+	sort.Strings(areas)
+
+	return areas
+}
+
+// Get the postcodes in area, loading them if needed
+func (d *Database) area(area string) ([]Postcode, error) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	if postcodes, ok := d.areas[area]; ok {
+		return postcodes, nil
+	}
+
+	src, ok := d.sources[area]
+	if !ok {
+		return nil, fmt.Errorf("postcode area '%s' %w", area, ErrNotFound)
+	}
+
+	postcodes, err := src.load()
+	if err != nil {
+		return nil, err
+	}
+
+	d.areas[area] = postcodes
+
+	return postcodes, nil
+}
+
+func (s source) load() ([]Postcode, error) {
+	if s.name == "" {
+		f, err := os.Open(s.path)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+
+		return read(f, s.path)
+	}
+
+	zr, err := zip.OpenReader(s.path)
+	if err != nil {
+		return nil, err
+	}
+	defer zr.Close()
+
+	for _, f := range zr.File {
+		if f.Name != s.name {
+			continue
+		}
+
+		r, err := f.Open()
+		if err != nil {
+			return nil, err
+		}
+		defer r.Close()
+
+		return read(r, s.name)
+	}
+
+	return nil, fmt.Errorf("%s: %s %w", s.path, s.name, ErrNotFound)
+}
+
+func parseDistance(s string) (osgrid.Distance, error) {
+	v, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return 0, err
+	}
+
+	return osgrid.Distance(math.Round(v)), nil
+}
+
+func read(r io.Reader, name string) ([]Postcode, error) {
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = numCols
+	cr.ReuseRecord = true
+
+	// Share the many repeated codes
+	codes := make(map[string]string)
+	intern := func(s string) string {
+		if v, ok := codes[s]; ok {
+			return v
+		}
+		s = string([]byte(s))
+		codes[s] = s
+		return s
+	}
+
+	var postcodes []Postcode
+	for line := 1; ; line++ {
+		record, err := cr.Read()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, fmt.Errorf("%s: %w", name, err)
+		}
+
+		// Tolerate a header row
+		if record[colPostcode] == "Postcode" {
+			continue
+		}
+
+		p, err := parseRecord(record, intern)
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", name, line, err)
+		}
+
+		if p.Quality == qualityNoCoordinates {
+			continue
+		}
+
+		postcodes = append(postcodes, p)
+	}
+
+	sort.Slice(postcodes, func(i, j int) bool {
+		return postcodes[i].Postcode < postcodes[j].Postcode
+	})
+
+	return postcodes, nil
+}
+
+func parseRecord(record []string, intern func(string) string) (Postcode, error) {
+	code, level, ok := normalise(record[colPostcode])
+	if !ok || level != LevelUnit {
+		return Postcode{}, fmt.Errorf("invalid postcode '%s'", record[colPostcode])
+	}
+
+	p := Postcode{
+		Postcode:          string([]byte(code)),
+		CountryCode:       intern(record[colCountryCode]),
+		AdminCountyCode:   intern(record[colAdminCountyCode]),
+		AdminDistrictCode: intern(record[colAdminDistrictCode]),
+		AdminWardCode:     intern(record[colAdminWardCode]),
+	}
+
+	var err error
+	p.Quality, err = strconv.Atoi(record[colQuality])
+	if err != nil {
+		return Postcode{}, fmt.Errorf("invalid positional quality: %w", err)
+	}
+
+	if p.Quality == qualityNoCoordinates {
+		return p, nil
+	}
+
+	e, err := parseDistance(record[colEastings])
+	if err != nil {
+		return Postcode{}, fmt.Errorf("invalid eastings: %w", err)
+	}
+
+	n, err := parseDistance(record[colNorthings])
+	if err != nil {
+		return Postcode{}, fmt.Errorf("invalid northings: %w", err)
+	}
+
+	p.GridRef, err = osgrid.Origin().Add(e, n)
+	if err != nil {
+		return Postcode{}, err
+	}
+
+	return p, nil
+}
+
+var (
+	// A postcode with its parts separated by a space, e.g. "CB2 1TN", "CB2 1"
+	// or "CB2"
+	spacedRegexp = regexp.MustCompile(`^([A-Z]{1,2})([0-9][0-9A-Z]?)?(?: ([0-9])([A-Z]{2})?)?$`)
+	// A full postcode without the space, e.g. "CB21TN"
+	unitRegexp = regexp.MustCompile(`^([A-Z]{1,2}[0-9][0-9A-Z]?)([0-9][A-Z]{2})$`)
+)
+
+// normalise a full or partial postcode, e.g. " cb21tn" -> "CB2 1TN", and
+// work out its level. Without a space, a partial postcode like "CB21" is
+// taken to be a district, not a sector.
+func normalise(s string) (string, Level, bool) {
+	s = strings.ToUpper(strings.Join(strings.Fields(s), " "))
+
+	if m := unitRegexp.FindStringSubmatch(s); m != nil {
+		return m[1] + " " + m[2], LevelUnit, true
+	}
+
+	m := spacedRegexp.FindStringSubmatch(s)
+	if m == nil {
+		return "", LevelArea, false
+	}
+
+	switch {
+	case m[2] == "" && m[3] != "":
+		// e.g. "CB 1"
+		return "", LevelArea, false
+	case m[4] != "":
+		return s, LevelUnit, true
+	case m[3] != "":
+		return s, LevelSector, true
+	case m[2] != "":
+		return s, LevelDistrict, true
+	}
+
+	return s, LevelArea, true
+}
+
+// IsPostcode returns true if s looks like a full or partial postcode. It
+// doesn't check that the postcode exists.
+func IsPostcode(s string) bool {
+	_, _, ok := normalise(s)
+	return ok
+}
+
+// Find the postcodes starting with code
+func (d *Database) find(code string, level Level) ([]Postcode, error) {
+	area := code
+	if i := strings.IndexAny(code, "0123456789"); i >= 0 {
+		area = code[:i]
+	}
+
+	postcodes, err := d.area(area)
+	if err != nil {
+		return nil, err
+	}
+
+	prefix := code
+	if level == LevelDistrict {
+		// So that "CB2" doesn't match "CB21"
+		prefix += " "
+	}
+
+	start := sort.Search(len(postcodes), func(i int) bool {
+		return postcodes[i].Postcode >= prefix
+	})
+
+	end := start
+	for end < len(postcodes) && strings.HasPrefix(postcodes[end].Postcode, prefix) {
+		end++
+	}
+
+	if start == end {
+		return nil, fmt.Errorf("postcode '%s' %w", code, ErrNotFound)
+	}
+
+	return postcodes[start:end], nil
+}
+
+// Postcode returns a single postcode unit, e.g. "CB2 1TN"
+func (d *Database) Postcode(postcode string) (*Postcode, error) {
+	code, level, ok := normalise(postcode)
+	if !ok || level != LevelUnit {
+		return nil, fmt.Errorf("'%s' isn't a full postcode", postcode)
+	}
+
+	postcodes, err := d.find(code, level)
+	if err != nil {
+		return nil, err
+	}
+
+	p := postcodes[0]
+
+	return &p, nil
+}
+
+// Lookup finds where a full or partial postcode is, e.g. "CB2 1TN" (a unit),
+// "CB2 1" (a sector), "CB2" (a district) or "CB" (an area).
+func (d *Database) Lookup(postcode string) (*Extent, error) {
+	code, level, ok := normalise(postcode)
+	if !ok {
+		return nil, fmt.Errorf("'%s' isn't a postcode", postcode)
+	}
+
+	postcodes, err := d.find(code, level)
+	if err != nil {
+		return nil, err
+	}
+
+	var sumE, sumN float64
+	minE, minN := postcodes[0].GridRef.AbsEasting(), postcodes[0].GridRef.AbsNorthing()
+	maxE, maxN := minE, minN
+
+	for _, p := range postcodes {
+		e, n := p.GridRef.AbsEasting(), p.GridRef.AbsNorthing()
+		sumE += float64(e)
+		sumN += float64(n)
+
+		if e < minE {
+			minE = e
+		}
+		if e > maxE {
+			maxE = e
+		}
+		if n < minN {
+			minN = n
+		}
+		if n > maxN {
+			maxN = n
+		}
+	}
+
+	count := float64(len(postcodes))
+	centroid, err := osgrid.Origin().Add(
+		osgrid.Distance(math.Round(sumE/count)), osgrid.Distance(math.Round(sumN/count)))
+	if err != nil {
+		return nil, err
+	}
+
+	bottomLeft, err := osgrid.Origin().Add(minE, minN)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Extent{
+		Postcode: code,
+		Level:    level,
+		Centroid: centroid,
+		Bounds: osgrid.Rect{
+			BottomLeft: bottomLeft,
+			Width:      maxE - minE,
+			Height:     maxN - minN,
+		},
+		Count: len(postcodes),
+	}, nil
+}
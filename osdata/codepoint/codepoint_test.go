@@ -0,0 +1,209 @@
+package codepoint
+
+import (
+	"archive/zip"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/usedbytes/osgrid"
+	"github.com/usedbytes/osgrid/osgridtest"
+)
+
+func testRecord(postcode string, quality int, e, n int) string {
+	return fmt.Sprintf("\"%s\",%d,%d,%d,\"E92000001\",\"E19000001\",\"E18000001\",\"E10000003\",\"E07000008\",\"E05002709\"\n",
+		postcode, quality, e, n)
+}
+
+var testData = map[string]string{
+	"Data/CSV/cb.csv": testRecord("CB2 1TN", 10, 544900, 258000) +
+		testRecord("CB2 1TP", 10, 545100, 258200) +
+		testRecord("CB2 3QZ", 10, 545000, 257500) +
+		testRecord("CB213AA", 10, 560000, 250000) +
+		testRecord("CB2 9ZZ", 90, 0, 0),
+	"Data/CSV/ec.csv":                        testRecord("EC1A1BB", 10, 531900, 181400),
+	"Data/CSV/b.csv":                         testRecord("B1  1AA", 20, 406500, 286500),
+	"Doc/Code-Point_Open_Column_Headers.csv": "PC,PQ,EA,NO,CY,RH,LH,CC,DC,WC\n",
+}
+
+func writeTestDir(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "codepoint")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for name, data := range testData {
+		path := filepath.Join(dir, name)
+		os.MkdirAll(filepath.Dir(path), 0755)
+
+		err = ioutil.WriteFile(path, []byte(data), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	return dir
+}
+
+func writeTestZip(t *testing.T, dir string) string {
+	path := filepath.Join(dir, "codepo_gb.zip")
+
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	zw := zip.NewWriter(f)
+	for name, data := range testData {
+		w, err := zw.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		w.Write([]byte(data))
+	}
+
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	return path
+}
+
+func TestNormalise(t *testing.T) {
+	for _, tc := range []struct {
+		in    string
+		out   string
+		level Level
+		ok    bool
+	}{
+		{"CB2 1TN", "CB2 1TN", LevelUnit, true},
+		{" cb21tn ", "CB2 1TN", LevelUnit, true},
+		{"EC1A1BB", "EC1A 1BB", LevelUnit, true},
+		{"B1  1AA", "B1 1AA", LevelUnit, true},
+		{"CB2 1", "CB2 1", LevelSector, true},
+		{"CB2", "CB2", LevelDistrict, true},
+		{"CB21", "CB21", LevelDistrict, true},
+		{"cb", "CB", LevelArea, true},
+		{"CB 1", "", LevelArea, false},
+		{"Cambridge", "", LevelArea, false},
+		{"CB2 1T", "", LevelArea, false},
+	} {
+		out, level, ok := normalise(tc.in)
+		if out != tc.out || level != tc.level || ok != tc.ok {
+			t.Errorf("normalise(%q): expected %q, %v, %v got %q, %v, %v",
+				tc.in, tc.out, tc.level, tc.ok, out, level, ok)
+		}
+	}
+}
+
+func TestLookup(t *testing.T) {
+	dir := writeTestDir(t)
+	defer os.RemoveAll(dir)
+
+	zipPath := writeTestZip(t, dir)
+
+	for _, path := range []string{dir, zipPath} {
+		d, err := Open(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if areas := d.Areas(); len(areas) != 3 || areas[0] != "B" || areas[1] != "CB" {
+			t.Errorf("%s: unexpected areas %v", path, areas)
+		}
+
+		for _, tc := range []struct {
+			query    string
+			postcode string
+			level    Level
+			centroid osgrid.GridRef
+			bounds   osgrid.Rect
+			count    int
+		}{
+			{"CB2 1TN", "CB2 1TN", LevelUnit, osgridtest.MustRef(t, 544900, 258000),
+				osgrid.Rect{BottomLeft: osgridtest.MustRef(t, 544900, 258000)}, 1},
+			{"cb2 1", "CB2 1", LevelSector, osgridtest.MustRef(t, 545000, 258100),
+				osgrid.Rect{BottomLeft: osgridtest.MustRef(t, 544900, 258000), Width: 200, Height: 200}, 2},
+			// Doesn't include CB21, or the postcode without coordinates
+			{"CB2", "CB2", LevelDistrict, osgridtest.MustRef(t, 545000, 257900),
+				osgrid.Rect{BottomLeft: osgridtest.MustRef(t, 544900, 257500), Width: 200, Height: 700}, 3},
+			{"CB", "CB", LevelArea, osgridtest.MustRef(t, 548750, 255925),
+				osgrid.Rect{BottomLeft: osgridtest.MustRef(t, 544900, 250000), Width: 15100, Height: 8200}, 4},
+			{"EC1A 1BB", "EC1A 1BB", LevelUnit, osgridtest.MustRef(t, 531900, 181400),
+				osgrid.Rect{BottomLeft: osgridtest.MustRef(t, 531900, 181400)}, 1},
+			{"B1", "B1", LevelDistrict, osgridtest.MustRef(t, 406500, 286500),
+				osgrid.Rect{BottomLeft: osgridtest.MustRef(t, 406500, 286500)}, 1},
+		} {
+			ext, err := d.Lookup(tc.query)
+			if err != nil {
+				t.Errorf("%s: Lookup(%q): %v", path, tc.query, err)
+				continue
+			}
+
+			if ext.Postcode != tc.postcode || ext.Level != tc.level || ext.Count != tc.count {
+				t.Errorf("%s: Lookup(%q): expected %s %v %d, got %s %v %d", path, tc.query,
+					tc.postcode, tc.level, tc.count, ext.Postcode, ext.Level, ext.Count)
+			}
+
+			if ext.Centroid != tc.centroid {
+				t.Errorf("%s: Lookup(%q): expected centroid %s, got %s", path, tc.query, tc.centroid, ext.Centroid)
+			}
+
+			if ext.Bounds != tc.bounds {
+				t.Errorf("%s: Lookup(%q): expected bounds %s, got %s", path, tc.query, tc.bounds, ext.Bounds)
+			}
+		}
+
+		for _, q := range []string{"CB2 9ZZ", "CB3", "ZZ1 1AA", "CB2 2"} {
+			_, err := d.Lookup(q)
+			if !errors.Is(err, ErrNotFound) {
+				t.Errorf("%s: Lookup(%q): expected ErrNotFound, got %v", path, q, err)
+			}
+		}
+
+		if _, err := d.Lookup("Cambridge"); err == nil || errors.Is(err, ErrNotFound) {
+			t.Errorf("%s: expected invalid postcode error, got %v", path, err)
+		}
+
+		// Only the areas which have been used should be loaded
+		if len(d.areas) != 3 {
+			t.Errorf("%s: expected 3 areas loaded, got %d", path, len(d.areas))
+		}
+	}
+}
+
+func TestLazyLoad(t *testing.T) {
+	dir := writeTestDir(t)
+	defer os.RemoveAll(dir)
+
+	d, err := Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(d.areas) != 0 {
+		t.Fatalf("expected no areas loaded, got %d", len(d.areas))
+	}
+
+	p, err := d.Postcode("ec1a1bb")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if p.Postcode != "EC1A 1BB" || p.GridRef != osgridtest.MustRef(t, 531900, 181400) ||
+		p.Quality != 10 || p.AdminDistrictCode != "E07000008" {
+		t.Errorf("unexpected postcode %+v", p)
+	}
+
+	if _, ok := d.areas["EC"]; !ok || len(d.areas) != 1 {
+		t.Errorf("expected only EC to be loaded, got %d areas", len(d.areas))
+	}
+
+	if _, err := d.Postcode("CB2"); err == nil {
+		t.Errorf("expected error for partial postcode")
+	}
+}
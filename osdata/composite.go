@@ -0,0 +1,281 @@
+package osdata
+
+import (
+	"errors"
+	"fmt"
+	"math"
+
+	"github.com/usedbytes/osgrid"
+)
+
+var mustBeFloat64Database Float64Database = &Composite{}
+
+// Composite layers several databases, in priority order. Each query is
+// answered by the first database which has a value for it - if a database
+// doesn't have a tile, or has no-data at that point, the next one is used.
+//
+// For example, high-resolution LiDAR for some areas can be layered on top of
+// Terrain 50 for everywhere else.
+type Composite struct {
+	sources   []Float64Database
+	precision osgrid.Distance
+	tileSize  osgrid.Distance
+	method    SampleMethod
+	blend     osgrid.Distance
+}
+
+type compositeTile struct {
+	db         *Composite
+	bottomLeft osgrid.GridRef
+}
+
+func (t *compositeTile) Width() osgrid.Distance {
+	return t.db.tileSize
+}
+
+func (t *compositeTile) Height() osgrid.Distance {
+	return t.db.tileSize
+}
+
+func (t *compositeTile) Precision() osgrid.Distance {
+	return t.db.precision
+}
+
+func (t *compositeTile) BottomLeft() osgrid.GridRef {
+	return t.bottomLeft
+}
+
+func (t *compositeTile) String() string {
+	return t.bottomLeft.String()
+}
+
+func (t *compositeTile) GetFloat64(ref osgrid.GridRef) (float64, error) {
+	if ref.Align(t.db.tileSize) != t.bottomLeft {
+		return NoData, fmt.Errorf("Coordinate outside tile")
+	}
+
+	return t.db.GetFloat64(ref)
+}
+
+type CompositeOpt func(*Composite)
+
+// Report precision from Precision(), instead of the finest precision of all
+// the sources
+func CompositePrecisionOpt(precision osgrid.Distance) CompositeOpt {
+	return func(c *Composite) {
+		c.precision = precision
+	}
+}
+
+// Size of the tiles returned by GetTile() and GetFloat64Tile(), default 10 km
+func CompositeTileSizeOpt(size osgrid.Distance) CompositeOpt {
+	return func(c *Composite) {
+		c.tileSize = size
+	}
+}
+
+// How to sample sources which are coarser than the composite's precision,
+// default SampleBilinear
+func CompositeSampleOpt(method SampleMethod) CompositeOpt {
+	return func(c *Composite) {
+		c.method = method
+	}
+}
+
+// Blend between sources within distance of the edge of a source's data,
+// instead of switching abruptly. This avoids "cliffs" where sources don't
+// agree exactly, but each query near an edge is much more expensive.
+func CompositeBlendOpt(distance osgrid.Distance) CompositeOpt {
+	return func(c *Composite) {
+		c.blend = distance
+	}
+}
+
+// NewComposite layers sources, with sources[0] having the highest priority.
+func NewComposite(sources []Float64Database, opts ...CompositeOpt) (*Composite, error) {
+	if len(sources) == 0 {
+		return nil, fmt.Errorf("composite needs at least one source")
+	}
+
+	c := &Composite{
+		sources:  sources,
+		tileSize: 10 * osgrid.Kilometre,
+		method:   SampleBilinear,
+	}
+
+	for _, s := range sources {
+		if c.precision == 0 || s.Precision() < c.precision {
+			c.precision = s.Precision()
+		}
+	}
+
+	for _, opt := range opts {
+		opt(c)
+	}
+
+	if c.precision <= 0 || c.tileSize <= 0 || c.tileSize%c.precision != 0 {
+		return nil, fmt.Errorf("tile size (%d) must be a multiple of precision (%d)", c.tileSize, c.precision)
+	}
+
+	return c, nil
+}
+
+// Get the value from a single source. Returns false if the source doesn't
+// have a value, and whether it has a tile.
+func (c *Composite) sourceValue(i int, ref osgrid.GridRef) (float64, bool, bool, error) {
+	db := c.sources[i]
+
+	var v float64
+	var err error
+	if db.Precision() > c.precision {
+		v, err = Sample(db, ref, c.method)
+	} else {
+		v, err = db.GetFloat64(ref)
+	}
+
+	if errors.Is(err, ErrTileNotFound) {
+		return NoData, false, false, nil
+	} else if err != nil {
+		return NoData, false, false, err
+	}
+
+	return v, !IsNoData(v), true, nil
+}
+
+// Find the first source, from index 'from', with a value for ref. Returns -1
+// if there isn't one, and whether any of the sources have a tile.
+func (c *Composite) first(ref osgrid.GridRef, from int) (int, float64, bool, error) {
+	anyTile := false
+
+	for i := from; i < len(c.sources); i++ {
+		v, ok, hasTile, err := c.sourceValue(i, ref)
+		if err != nil {
+			return -1, NoData, false, err
+		}
+
+		anyTile = anyTile || hasTile
+		if ok {
+			return i, v, true, nil
+		}
+	}
+
+	return -1, NoData, anyTile, nil
+}
+
+// Find roughly how far ref is from the edge of source i's data, up to
+// c.blend, by searching outwards in 8 directions
+func (c *Composite) edgeDistance(i int, ref osgrid.GridRef) (osgrid.Distance, error) {
+	step := c.sources[i].Precision()
+	if step < c.precision {
+		step = c.precision
+	}
+
+	for dist := step; dist < c.blend; dist += step {
+		diag := osgrid.Distance(math.Round(float64(dist) / math.Sqrt2))
+
+		for _, dir := range [][2]osgrid.Distance{
+			{dist, 0}, {-dist, 0}, {0, dist}, {0, -dist},
+			{diag, diag}, {diag, -diag}, {-diag, diag}, {-diag, -diag},
+		} {
+			p, err := ref.Add(dir[0], dir[1])
+			if err != nil {
+				// Off the edge of the grid
+				return dist, nil
+			}
+
+			_, ok, _, err := c.sourceValue(i, p)
+			if err != nil {
+				return 0, err
+			}
+
+			if !ok {
+				return dist, nil
+			}
+		}
+	}
+
+	return c.blend, nil
+}
+
+func (c *Composite) GetFloat64(ref osgrid.GridRef) (float64, error) {
+	i, v, anyTile, err := c.first(ref, 0)
+	if err != nil {
+		return NoData, err
+	}
+
+	if i < 0 {
+		if !anyTile {
+			return NoData, fmt.Errorf("Tile %s %w", ref.Align(c.tileSize), ErrTileNotFound)
+		}
+
+		return NoData, nil
+	}
+
+	if c.blend <= 0 || i == len(c.sources)-1 {
+		return v, nil
+	}
+
+	dist, err := c.edgeDistance(i, ref)
+	if err != nil {
+		return NoData, err
+	}
+
+	if dist >= c.blend {
+		return v, nil
+	}
+
+	j, next, _, err := c.first(ref, i+1)
+	if err != nil {
+		return NoData, err
+	}
+
+	if j < 0 {
+		// Nothing to blend with
+		return v, nil
+	}
+
+	w := float64(dist) / float64(c.blend)
+
+	return w*v + (1-w)*next, nil
+}
+
+// getTile returns a tile if any of the sources have a tile containing ref.
+// The tile's values come from the composite, so it may have values outside
+// of the sources' tiles.
+func (c *Composite) getTile(ref osgrid.GridRef) (Float64Tile, error) {
+	for _, s := range c.sources {
+		_, err := s.GetFloat64Tile(ref)
+		if err == nil {
+			return &compositeTile{
+				db:         c,
+				bottomLeft: ref.Align(c.tileSize),
+			}, nil
+		} else if !errors.Is(err, ErrTileNotFound) {
+			return nil, err
+		}
+	}
+
+	return nil, fmt.Errorf("Tile %s %w", ref.Align(c.tileSize), ErrTileNotFound)
+}
+
+func (c *Composite) GetTile(ref osgrid.GridRef) (Tile, error) {
+	return c.getTile(ref)
+}
+
+func (c *Composite) GetFloat64Tile(ref osgrid.GridRef) (Float64Tile, error) {
+	return c.getTile(ref)
+}
+
+func (c *Composite) Precision() osgrid.Distance {
+	return c.precision
+}
+
+// Stats returns the combined stats of all of the sources
+func (c *Composite) Stats() Stats {
+	stats := newStats()
+	for _, s := range c.sources {
+		stats.add(s.Stats())
+	}
+
+	return stats
+}
@@ -0,0 +1,138 @@
+package osdata
+
+import (
+	"errors"
+	"math"
+	"testing"
+
+	"github.com/usedbytes/osgrid"
+)
+
+func newTestComposite(t *testing.T, opts ...CompositeOpt) *Composite {
+	// High resolution, only West of 20 km, and no-data South of 1 km
+	hi := &TestCoastDatabase{
+		TestFloat64Database: TestFloat64Database{
+			precision: 10 * osgrid.Metre,
+			f: func(e, n float64) float64 {
+				if n < 1000 {
+					return NoData
+				}
+				return 100
+			},
+		},
+		coast: 20 * osgrid.Kilometre,
+	}
+
+	// Low resolution, only West of 40 km, and no-data South of 500 m
+	lo := &TestCoastDatabase{
+		TestFloat64Database: TestFloat64Database{
+			precision: 50 * osgrid.Metre,
+			f: func(e, n float64) float64 {
+				if n < 500 {
+					return NoData
+				}
+				return e
+			},
+		},
+		coast: 40 * osgrid.Kilometre,
+	}
+
+	c, err := NewComposite([]Float64Database{hi, lo}, opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return c
+}
+
+func TestComposite(t *testing.T) {
+	c := newTestComposite(t)
+
+	if c.Precision() != 10*osgrid.Metre {
+		t.Errorf("expected precision 10, got %d", c.Precision())
+	}
+
+	testCases := []struct {
+		name        string
+		east, north osgrid.Distance
+		exp         float64
+	}{
+		{"high resolution", 5000, 5000, 100},
+		{"no-data falls through", 5025, 700, 5025},
+		{"missing tile falls through", 30025, 5000, 30025},
+		{"no-data in all", 5000, 200, NoData},
+	}
+
+	for _, tc := range testCases {
+		ref, _ := osgrid.Origin().Add(tc.east, tc.north)
+
+		v, err := c.GetFloat64(ref)
+		if err != nil {
+			t.Fatalf("%s: %v", tc.name, err)
+		}
+
+		if IsNoData(tc.exp) {
+			if !IsNoData(v) {
+				t.Errorf("%s: expected no-data, got %v", tc.name, v)
+			}
+		} else if math.Abs(v-tc.exp) > 1e-9 {
+			t.Errorf("%s: expected %v, got %v", tc.name, tc.exp, v)
+		}
+	}
+
+	sea, _ := osgrid.Origin().Add(45*osgrid.Kilometre, 5*osgrid.Kilometre)
+	_, err := c.GetFloat64(sea)
+	if !errors.Is(err, ErrTileNotFound) {
+		t.Errorf("expected ErrTileNotFound, got %v", err)
+	}
+
+	_, err = c.GetFloat64Tile(sea)
+	if !errors.Is(err, ErrTileNotFound) {
+		t.Errorf("expected ErrTileNotFound, got %v", err)
+	}
+}
+
+func TestCompositeBlend(t *testing.T) {
+	c := newTestComposite(t, CompositeBlendOpt(500*osgrid.Metre))
+
+	testCases := []struct {
+		name        string
+		east, north osgrid.Distance
+		exp         float64
+	}{
+		{"far from edge", 5000, 5000, 100},
+		// The first no-data point to the South is at 990, 260 m away
+		{"near edge", 5000, 1250, 0.52*100 + 0.48*5000},
+		{"outside", 5000, 700, 5000},
+	}
+
+	for _, tc := range testCases {
+		ref, _ := osgrid.Origin().Add(tc.east, tc.north)
+
+		v, err := c.GetFloat64(ref)
+		if err != nil {
+			t.Fatalf("%s: %v", tc.name, err)
+		}
+
+		if math.Abs(v-tc.exp) > 1e-9 {
+			t.Errorf("%s: expected %v, got %v", tc.name, tc.exp, v)
+		}
+	}
+}
+
+func TestCompositeOpts(t *testing.T) {
+	c := newTestComposite(t, CompositePrecisionOpt(50*osgrid.Metre))
+	if c.Precision() != 50*osgrid.Metre {
+		t.Errorf("expected precision 50, got %d", c.Precision())
+	}
+
+	_, err := NewComposite(nil)
+	if err == nil {
+		t.Errorf("expected error with no sources")
+	}
+
+	_, err = NewComposite([]Float64Database{&TestFloat64Database{precision: 3}})
+	if err == nil {
+		t.Errorf("expected error with tile size not a multiple of precision")
+	}
+}
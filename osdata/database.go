@@ -16,17 +16,21 @@ type Tile interface {
 }
 
 type Database interface {
+	StatsProvider
 	GetTile(osgrid.GridRef) (Tile, error)
 	Precision() osgrid.Distance
 }
 
 type Float64Tile interface {
 	Tile
+	// Returns NoData for points without a value
 	GetFloat64(osgrid.GridRef) (float64, error)
 }
 
 type Float64Database interface {
 	Database
+	// Returns NoData for points without a value, and an error wrapping
+	// ErrTileNotFound if there's no tile for the point
 	GetFloat64(osgrid.GridRef) (float64, error)
 	GetFloat64Tile(osgrid.GridRef) (Float64Tile, error)
 }
@@ -49,3 +53,54 @@ type ImageDatabase interface {
 	Database
 	GetImageTile(osgrid.GridRef) (ImageTile, error)
 }
+
+// DatabaseConfig holds the options common to all database implementations
+type DatabaseConfig struct {
+	Cache *Cache
+	// Memory-map tiles, for databases and platforms which support it
+	Mmap bool
+}
+
+type DatabaseOpt func(*DatabaseConfig)
+
+// Use the given cache for tiles. The cache may be shared between multiple
+// databases.
+func DatabaseCacheOpt(c *Cache) DatabaseOpt {
+	return func(cfg *DatabaseConfig) {
+		cfg.Cache = c
+	}
+}
+
+// Use a new, private, cache with a budget of size bytes
+func DatabaseCacheSizeOpt(size int64) DatabaseOpt {
+	return func(cfg *DatabaseConfig) {
+		cfg.Cache = NewCache(size)
+	}
+}
+
+// Memory-map tiles instead of reading them, where supported. This makes
+// loading a tile almost free, at the cost of reading from disk (or the page
+// cache) on access.
+func DatabaseMmapOpt() DatabaseOpt {
+	return func(cfg *DatabaseConfig) {
+		cfg.Mmap = true
+	}
+}
+
+// NewDatabaseConfig applies opts to a default configuration. The returned
+// config always has its own namespace in Cache, so it's safe for a database to
+// use the cache directly.
+func NewDatabaseConfig(opts ...DatabaseOpt) DatabaseConfig {
+	var cfg DatabaseConfig
+
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+
+	if cfg.Cache == nil {
+		cfg.Cache = NewCache(DefaultCacheSize)
+	}
+	cfg.Cache = cfg.Cache.Share()
+
+	return cfg
+}
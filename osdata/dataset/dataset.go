@@ -0,0 +1,250 @@
+// Package dataset writes elevation (or other) data as a directory of tiles,
+// laid out like OS OpenData products: <path>/data/<square>/<tile>.
+//
+// Tiles can be written as ESRI ASCII Grid or GeoTIFF, and the dataset can be
+// reopened with ascgrid.OpenDatabase or geotiff.OpenDatabase respectively.
+// ASCII Grid datasets of 10 km or 5 km tiles are named like Terrain 50 and
+// Terrain 5, so can also be opened with terrain50.OpenDatabase or
+// terrain5.OpenDatabase.
+package dataset
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/usedbytes/osgrid"
+	"github.com/usedbytes/osgrid/osdata"
+	"github.com/usedbytes/osgrid/osdata/ascgrid"
+	"github.com/usedbytes/osgrid/osdata/geotiff"
+	"github.com/usedbytes/osgrid/osdata/terrain5"
+)
+
+type Format int
+
+const (
+	// ESRI ASCII Grid, '.asc'
+	FormatASC Format = iota
+	// Single-band float32 GeoTIFF, '.tif'
+	FormatGeoTIFF
+)
+
+func (f Format) String() string {
+	switch f {
+	case FormatASC:
+		return "asc"
+	case FormatGeoTIFF:
+		return "geotiff"
+	}
+
+	return fmt.Sprintf("Format(%d)", int(f))
+}
+
+// ParseFormat parses the String() representation of a Format
+func ParseFormat(s string) (Format, error) {
+	for _, f := range []Format{FormatASC, FormatGeoTIFF} {
+		if s == f.String() {
+			return f, nil
+		}
+	}
+
+	return FormatASC, fmt.Errorf("unknown format '%s'", s)
+}
+
+// Ext returns the file extension for the format, including the '.'
+func (f Format) Ext() string {
+	switch f {
+	case FormatASC:
+		return ".asc"
+	case FormatGeoTIFF:
+		return ".tif"
+	}
+
+	return ""
+}
+
+// WriteTileFile writes t to path in format f
+func WriteTileFile(path string, t osdata.Float64Tile, f Format) error {
+	switch f {
+	case FormatASC:
+		return ascgrid.WriteFile(path, t)
+	case FormatGeoTIFF:
+		return geotiff.WriteFile(path, t)
+	}
+
+	return fmt.Errorf("unknown format %v", f)
+}
+
+// Writer writes tiles of a fixed size into a dataset directory
+type Writer struct {
+	path     string
+	tileSize osgrid.Distance
+	format   Format
+}
+
+// NewWriter writes a dataset to path, which is created if it doesn't exist.
+// tileSize must divide 100 km, so that tiles don't cross grid squares.
+func NewWriter(path string, tileSize osgrid.Distance, format Format) (*Writer, error) {
+	if tileSize <= 0 || (100*osgrid.Kilometre)%tileSize != 0 {
+		return nil, fmt.Errorf("tile size (%d) must divide 100 km", tileSize)
+	}
+
+	if format.Ext() == "" {
+		return nil, fmt.Errorf("unknown format %v", format)
+	}
+
+	err := os.MkdirAll(filepath.Join(path, "data"), 0755)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Writer{
+		path:     path,
+		tileSize: tileSize,
+		format:   format,
+	}, nil
+}
+
+// TileName returns the name of the tile of size tileSize containing ref, e.g.
+// "sh65" for a 10 km tile, or "sh6054" for a 1 km tile. 5 km tiles are named
+// by their quadrant of the 10 km square, as in Terrain 5, e.g. "sh65sw".
+func TileName(ref osgrid.GridRef, tileSize osgrid.Distance) string {
+	if tileSize == terrain5.TileSize {
+		return strings.ToLower(terrain5.TileName(ref))
+	}
+
+	ref = ref.Align(tileSize)
+
+	// Enough digits to distinguish tiles, as in the OS products' names
+	digits := 5
+	for unit := osgrid.Distance(10); digits > 1 && tileSize%unit == 0; unit *= 10 {
+		digits--
+	}
+
+	div := osgrid.Distance(1)
+	for i := digits; i < 5; i++ {
+		div *= 10
+	}
+
+	return fmt.Sprintf("%s%0*d%0*d", strings.ToLower(ref.Tile()),
+		digits, ref.TileEasting()/div, digits, ref.TileNorthing()/div)
+}
+
+// TilePath returns the path of the tile containing ref
+func (w *Writer) TilePath(ref osgrid.GridRef) string {
+	square := strings.ToLower(ref.Tile())
+	return filepath.Join(w.path, "data", square, TileName(ref, w.tileSize)+w.format.Ext())
+}
+
+// WriteTile writes t, which must be one of the writer's tiles
+func (w *Writer) WriteTile(t osdata.Float64Tile) error {
+	bottomLeft := t.BottomLeft()
+	if bottomLeft.Align(w.tileSize) != bottomLeft || t.Width() != w.tileSize || t.Height() != w.tileSize {
+		return fmt.Errorf("tile %s (%dx%d) isn't a %d m dataset tile", t, t.Width(), t.Height(), w.tileSize)
+	}
+
+	path := w.TilePath(bottomLeft)
+
+	err := os.MkdirAll(filepath.Dir(path), 0755)
+	if err != nil {
+		return err
+	}
+
+	return WriteTileFile(path, t, w.format)
+}
+
+// Sample db to make the tile at bottomLeft, with no-data outside of the
+// area. Returns nil if db has no data for any of the tile.
+func (w *Writer) makeTile(db osdata.Float64Database, bottomLeft osgrid.GridRef,
+	minE, minN, maxE, maxN osgrid.Distance) (*osdata.GridTile, error) {
+
+	precision := db.Precision()
+	n := int(w.tileSize / precision)
+
+	data := make([][]float64, n)
+
+	found := false
+
+	for y := range data {
+		data[y] = make([]float64, n)
+
+		for x := range data[y] {
+			data[y][x] = osdata.NoData
+
+			ref, err := bottomLeft.Add(osgrid.Distance(x)*precision, osgrid.Distance(y)*precision)
+			if err != nil {
+				return nil, err
+			}
+
+			e, nn := ref.AbsEasting(), ref.AbsNorthing()
+			if e < minE || nn < minN || e >= maxE || nn >= maxN {
+				continue
+			}
+
+			v, err := db.GetFloat64(ref)
+			if errors.Is(err, osdata.ErrTileNotFound) {
+				continue
+			} else if err != nil {
+				return nil, err
+			}
+
+			found = true
+			data[y][x] = v
+		}
+	}
+
+	if !found {
+		return nil, nil
+	}
+
+	return osdata.NewGridTile(bottomLeft, precision, data), nil
+}
+
+// WriteArea writes all of the tiles covering the area of db with its
+// bottom-left corner at bottomLeft, and the given width and height. Points
+// outside the area, or where db doesn't have a tile, are no-data, and tiles
+// with no data at all are skipped. The tiles have db's precision, which must
+// divide the tile size.
+//
+// Returns the number of tiles written.
+func (w *Writer) WriteArea(db osdata.Float64Database, bottomLeft osgrid.GridRef,
+	width, height osgrid.Distance) (int, error) {
+
+	if w.tileSize%db.Precision() != 0 {
+		return 0, fmt.Errorf("tile size (%d) must be a multiple of precision (%d)", w.tileSize, db.Precision())
+	}
+
+	minE, minN := bottomLeft.AbsEasting(), bottomLeft.AbsNorthing()
+	maxE, maxN := minE+width, minN+height
+
+	written := 0
+
+	for n := (minN / w.tileSize) * w.tileSize; n < maxN; n += w.tileSize {
+		for e := (minE / w.tileSize) * w.tileSize; e < maxE; e += w.tileSize {
+			ref, err := osgrid.Origin().Add(e, n)
+			if err != nil {
+				return written, err
+			}
+
+			t, err := w.makeTile(db, ref, minE, minN, maxE, maxN)
+			if err != nil {
+				return written, fmt.Errorf("%s: %w", ref, err)
+			}
+
+			if t == nil {
+				continue
+			}
+
+			err = w.WriteTile(t)
+			if err != nil {
+				return written, fmt.Errorf("%s: %w", ref, err)
+			}
+
+			written++
+		}
+	}
+
+	return written, nil
+}
@@ -0,0 +1,218 @@
+package dataset
+
+import (
+	"errors"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/usedbytes/osgrid"
+	"github.com/usedbytes/osgrid/osdata"
+	"github.com/usedbytes/osgrid/osdata/ascgrid"
+	"github.com/usedbytes/osgrid/osdata/geotiff"
+	"github.com/usedbytes/osgrid/osdata/osdatatest"
+	"github.com/usedbytes/osgrid/osdata/terrain5"
+	"github.com/usedbytes/osgrid/osdata/terrain50"
+)
+
+func TestTileName(t *testing.T) {
+	ref, _ := osgrid.ParseGridRef("SH 60986 54375")
+
+	for _, tc := range []struct {
+		tileSize osgrid.Distance
+		exp      string
+	}{
+		{10 * osgrid.Kilometre, "sh65"},
+		{5 * osgrid.Kilometre, "sh65sw"},
+		{1 * osgrid.Kilometre, "sh6054"},
+		{100 * osgrid.Metre, "sh609543"},
+	} {
+		if name := TileName(ref, tc.tileSize); name != tc.exp {
+			t.Errorf("%d: expected %s, got %s", tc.tileSize, tc.exp, name)
+		}
+	}
+}
+
+func TestWriteArea(t *testing.T) {
+	src := osdatatest.NewFuncDatabase(osdatatest.Plane(100, 0.01, 0.02), 50*osgrid.Metre, 10*osgrid.Kilometre)
+
+	for _, tc := range []struct {
+		format Format
+		open   func(string, ...osdata.DatabaseOpt) (osdata.Float64Database, error)
+	}{
+		{FormatASC, ascgrid.OpenDatabase},
+		{FormatGeoTIFF, geotiff.OpenDatabase},
+	} {
+		t.Run(tc.format.String(), func(t *testing.T) {
+			dir, err := ioutil.TempDir("", "dataset")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.RemoveAll(dir)
+
+			w, err := NewWriter(dir, 5*osgrid.Kilometre, tc.format)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			bottomLeft, _ := osgrid.ParseGridRef("SH 60000 54000")
+			n, err := w.WriteArea(src, bottomLeft, 7*osgrid.Kilometre, 3*osgrid.Kilometre)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if n != 4 {
+				t.Errorf("expected 4 tiles, got %d", n)
+			}
+
+			_, err = os.Stat(filepath.Join(dir, "data", "sh", "sh65ne"+tc.format.Ext()))
+			if err != nil {
+				t.Error(err)
+			}
+
+			db, err := tc.open(dir)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if db.Precision() != 50*osgrid.Metre {
+				t.Errorf("expected precision 50, got %d", db.Precision())
+			}
+
+			for _, s := range []string{"SH 60000 54000", "SH 66950 56950", "SH 63210 55555"} {
+				ref, _ := osgrid.ParseGridRef(s)
+
+				v, err := db.GetFloat64(ref)
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				exp, _ := src.GetFloat64(ref)
+				if math.Abs(v-exp) > 1e-3 {
+					t.Errorf("%s: expected %v, got %v", s, exp, v)
+				}
+			}
+
+			// In a tile, but outside the area
+			for _, s := range []string{"SH 67000 55000", "SH 62000 53950", "SH 69950 59950"} {
+				ref, _ := osgrid.ParseGridRef(s)
+
+				v, err := db.GetFloat64(ref)
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				if !osdata.IsNoData(v) {
+					t.Errorf("%s: expected NoData, got %v", s, v)
+				}
+			}
+
+			ref, _ := osgrid.ParseGridRef("SH 70000 54000")
+			_, err = db.GetFloat64(ref)
+			if !errors.Is(err, osdata.ErrTileNotFound) {
+				t.Errorf("expected ErrTileNotFound, got %v", err)
+			}
+		})
+	}
+}
+
+// Datasets of 10 km and 5 km ASCII Grid tiles can be opened as Terrain 50
+// and Terrain 5
+func TestTerrainRoundTrip(t *testing.T) {
+	for _, tc := range []struct {
+		name      string
+		tileSize  osgrid.Distance
+		precision osgrid.Distance
+		// Terrain 50 needs TQ 28 to exist
+		area string
+		open func(string) (osdata.Float64Database, error)
+	}{
+		{"terrain50", 10 * osgrid.Kilometre, 50 * osgrid.Metre, "TQ 28000 80000",
+			func(path string) (osdata.Float64Database, error) {
+				return terrain50.OpenDatabase(path, 10*osgrid.Kilometre)
+			},
+		},
+		{"terrain5", terrain5.TileSize, 5 * osgrid.Metre, "SH 60000 54000",
+			func(path string) (osdata.Float64Database, error) {
+				return terrain5.OpenDatabase(path)
+			},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			src := osdatatest.NewFuncDatabase(osdatatest.Plane(100, 0.01, 0.02), tc.precision, tc.tileSize)
+
+			dir, err := ioutil.TempDir("", "dataset")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.RemoveAll(dir)
+
+			w, err := NewWriter(dir, tc.tileSize, FormatASC)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			bottomLeft, _ := osgrid.ParseGridRef(tc.area)
+			_, err = w.WriteArea(src, bottomLeft, 1*osgrid.Kilometre, 1*osgrid.Kilometre)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			db, err := tc.open(dir)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if db.Precision() != tc.precision {
+				t.Errorf("expected precision %d, got %d", tc.precision, db.Precision())
+			}
+
+			for _, off := range []osgrid.Distance{0, 500, 950} {
+				ref, _ := bottomLeft.Add(off, off)
+
+				v, err := db.GetFloat64(ref)
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				exp, _ := src.GetFloat64(ref)
+				if math.Abs(v-exp) > 1e-3 {
+					t.Errorf("%s: expected %v, got %v", ref, exp, v)
+				}
+			}
+		})
+	}
+}
+
+func TestWriteTile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "dataset")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	w, err := NewWriter(dir, 5*osgrid.Kilometre, FormatASC)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Tiles must match the dataset's tile size
+	src := osdatatest.NewFuncDatabase(osdatatest.Plane(0, 0, 0), 50*osgrid.Metre, 10*osgrid.Kilometre)
+	ref, _ := osgrid.ParseGridRef("SH 60000 50000")
+	tile, err := src.GetFloat64Tile(ref)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = w.WriteTile(tile)
+	if err == nil {
+		t.Error("expected error writing a 10 km tile to a 5 km dataset")
+	}
+
+	_, err = NewWriter(dir, 3*osgrid.Kilometre, FormatASC)
+	if err == nil {
+		t.Error("expected error for tile size which doesn't divide 100 km")
+	}
+}
@@ -0,0 +1,290 @@
+package osdata
+
+import (
+	"errors"
+	"fmt"
+	"math"
+
+	"github.com/usedbytes/osgrid"
+)
+
+var mustBeDerivedDatabase Float64Database = &Derived{}
+
+// TerrainLayer is a property of the terrain which can be derived from
+// elevation data
+type TerrainLayer int
+
+const (
+	// Steepness, in degrees from horizontal
+	LayerSlopeDegrees TerrainLayer = iota
+	// Steepness, as a percentage (100 * rise / run)
+	LayerSlopePercent
+	// Direction the slope faces (downhill), in degrees clockwise from
+	// grid North. Flat areas have no aspect, so are NoData.
+	LayerAspect
+	// Curvature across the slope, per metre. Positive is convex (e.g.
+	// ridges), negative is concave (e.g. valleys)
+	LayerPlanCurvature
+	// Curvature along the slope, per metre. Positive is concave (the
+	// slope is slowing down), negative is convex (speeding up)
+	LayerProfileCurvature
+)
+
+var terrainLayers = []TerrainLayer{
+	LayerSlopeDegrees, LayerSlopePercent, LayerAspect,
+	LayerPlanCurvature, LayerProfileCurvature,
+}
+
+func (l TerrainLayer) String() string {
+	switch l {
+	case LayerSlopeDegrees:
+		return "slope"
+	case LayerSlopePercent:
+		return "slope-percent"
+	case LayerAspect:
+		return "aspect"
+	case LayerPlanCurvature:
+		return "plan-curvature"
+	case LayerProfileCurvature:
+		return "profile-curvature"
+	}
+
+	return fmt.Sprintf("TerrainLayer(%d)", int(l))
+}
+
+func ParseTerrainLayer(s string) (TerrainLayer, error) {
+	for _, l := range terrainLayers {
+		if s == l.String() {
+			return l, nil
+		}
+	}
+
+	return LayerSlopeDegrees, fmt.Errorf("unknown terrain layer: %s", s)
+}
+
+// Derived computes a TerrainLayer from an elevation database. It has the
+// same tiles and precision as the elevation database, and each point is
+// computed from the 3x3 window of posts around it, using the
+// Zevenbergen & Thorne method.
+//
+// Posts outside a tile are fetched from the neighbouring tiles, so there are
+// no seams. Where neighbouring posts are missing (at the edge of the data, or
+// next to no-data), they are extrapolated from the opposite side of the window.
+type Derived struct {
+	db    Float64Database
+	layer TerrainLayer
+	cache *Cache
+}
+
+// NewDerived computes layer from the elevation data in db
+func NewDerived(db Float64Database, layer TerrainLayer, opts ...DatabaseOpt) (*Derived, error) {
+	valid := false
+	for _, l := range terrainLayers {
+		valid = valid || l == layer
+	}
+
+	if !valid {
+		return nil, fmt.Errorf("unknown terrain layer: %v", layer)
+	}
+
+	return &Derived{
+		db:    db,
+		layer: layer,
+		cache: NewDatabaseConfig(opts...).Cache,
+	}, nil
+}
+
+// window holds the 3x3 posts around a point, window[0][0] being the
+// South-West corner
+type window [3][3]float64
+
+// Fill in missing (NoData) posts, by extrapolating. Returns false if the
+// centre is missing.
+func (w *window) fill() bool {
+	if IsNoData(w[1][1]) {
+		return false
+	}
+
+	c := w[1][1]
+
+	// Edges, by reflecting the opposite edge through the centre
+	for _, e := range [][2][2]int{
+		{{1, 0}, {1, 2}}, {{1, 2}, {1, 0}},
+		{{0, 1}, {2, 1}}, {{2, 1}, {0, 1}},
+	} {
+		y, x := e[0][0], e[0][1]
+		oy, ox := e[1][0], e[1][1]
+
+		if !IsNoData(w[y][x]) {
+			continue
+		}
+
+		if IsNoData(w[oy][ox]) {
+			w[y][x] = c
+		} else {
+			w[y][x] = 2*c - w[oy][ox]
+		}
+	}
+
+	// Corners, assuming they're on the plane through the adjacent edges
+	for _, y := range []int{0, 2} {
+		for _, x := range []int{0, 2} {
+			if IsNoData(w[y][x]) {
+				w[y][x] = w[y][1] + w[1][x] - c
+			}
+		}
+	}
+
+	return true
+}
+
+func (l TerrainLayer) compute(w *window, spacing float64) float64 {
+	L := spacing
+
+	// Zevenbergen & Thorne (1987) coefficients. The textbook numbering of
+	// the posts is 1-3 for the North row, so note the flipped rows.
+	D := ((w[1][0]+w[1][2])/2 - w[1][1]) / (L * L)
+	E := ((w[2][1]+w[0][1])/2 - w[1][1]) / (L * L)
+	F := (-w[2][0] + w[2][2] + w[0][0] - w[0][2]) / (4 * L * L)
+	G := (w[1][2] - w[1][0]) / (2 * L)
+	H := (w[2][1] - w[0][1]) / (2 * L)
+
+	gradient := math.Hypot(G, H)
+
+	switch l {
+	case LayerSlopeDegrees:
+		return math.Atan(gradient) * 180 / math.Pi
+	case LayerSlopePercent:
+		return gradient * 100
+	case LayerAspect:
+		if gradient == 0 {
+			return NoData
+		}
+
+		// Downhill is (-G, -H)
+		aspect := math.Atan2(-G, -H) * 180 / math.Pi
+		if aspect < 0 {
+			aspect += 360
+		}
+		return aspect
+	// The signs are the opposite of Zevenbergen & Thorne's, to match the
+	// descriptions of the layers.
+	case LayerPlanCurvature:
+		if gradient == 0 {
+			return 0
+		}
+		return -2 * (D*H*H + E*G*G - F*G*H) / (G*G + H*H)
+	case LayerProfileCurvature:
+		if gradient == 0 {
+			return 0
+		}
+		return 2 * (D*G*G + E*H*H + F*G*H) / (G*G + H*H)
+	}
+
+	return NoData
+}
+
+// Get a post, from tile if it's inside it, otherwise from the database.
+// Missing tiles give NoData.
+func (d *Derived) getPost(tile Float64Tile, ref osgrid.GridRef, east, north osgrid.Distance) (float64, error) {
+	post, err := ref.Add(east, north)
+	if err != nil {
+		// Off the edge of the grid
+		return NoData, nil
+	}
+
+	e := post.AbsEasting() - tile.BottomLeft().AbsEasting()
+	n := post.AbsNorthing() - tile.BottomLeft().AbsNorthing()
+	if e >= 0 && n >= 0 && e < tile.Width() && n < tile.Height() {
+		return tile.GetFloat64(post)
+	}
+
+	v, err := d.db.GetFloat64(post)
+	if errors.Is(err, ErrTileNotFound) {
+		return NoData, nil
+	}
+
+	return v, err
+}
+
+func (d *Derived) generateTile(src Float64Tile) (*GridTile, error) {
+	precision := src.Precision()
+	ncols := int(src.Width() / precision)
+	nrows := int(src.Height() / precision)
+
+	bottomLeft := src.BottomLeft()
+	data := make([][]float64, nrows)
+
+	for y := range data {
+		data[y] = make([]float64, ncols)
+
+		for x := range data[y] {
+			ref, err := bottomLeft.Add(osgrid.Distance(x)*precision, osgrid.Distance(y)*precision)
+			if err != nil {
+				return nil, err
+			}
+
+			var w window
+			for wy := 0; wy < 3; wy++ {
+				for wx := 0; wx < 3; wx++ {
+					w[wy][wx], err = d.getPost(src, ref,
+						osgrid.Distance(wx-1)*precision, osgrid.Distance(wy-1)*precision)
+					if err != nil {
+						return nil, err
+					}
+				}
+			}
+
+			if !w.fill() {
+				data[y][x] = NoData
+				continue
+			}
+
+			data[y][x] = d.layer.compute(&w, float64(precision))
+		}
+	}
+
+	return NewGridTile(bottomLeft, precision, data), nil
+}
+
+func (d *Derived) getTile(ref osgrid.GridRef) (*GridTile, error) {
+	src, err := d.db.GetFloat64Tile(ref)
+	if err != nil {
+		return nil, err
+	}
+
+	tile, err := d.cache.Load(src.BottomLeft(), func() (Tile, error) {
+		return d.generateTile(src)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return tile.(*GridTile), nil
+}
+
+func (d *Derived) GetTile(ref osgrid.GridRef) (Tile, error) {
+	return d.getTile(ref)
+}
+
+func (d *Derived) GetFloat64Tile(ref osgrid.GridRef) (Float64Tile, error) {
+	return d.getTile(ref)
+}
+
+func (d *Derived) GetFloat64(ref osgrid.GridRef) (float64, error) {
+	tile, err := d.getTile(ref)
+	if err != nil {
+		return NoData, err
+	}
+
+	return tile.GetFloat64(ref)
+}
+
+func (d *Derived) Precision() osgrid.Distance {
+	return d.db.Precision()
+}
+
+// Stats returns the stats of the derived tile cache
+func (d *Derived) Stats() Stats {
+	return d.cache.Stats()
+}
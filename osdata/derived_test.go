@@ -0,0 +1,118 @@
+package osdata
+
+import (
+	"math"
+	"testing"
+
+	"github.com/usedbytes/osgrid"
+)
+
+func testDerived(t *testing.T, db Float64Database, layer TerrainLayer, east, north osgrid.Distance, exp float64) {
+	t.Helper()
+
+	d, err := NewDerived(db, layer)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ref, _ := osgrid.Origin().Add(east, north)
+
+	v, err := d.GetFloat64(ref)
+	if err != nil {
+		t.Fatalf("%s %s: %v", layer, ref, err)
+	}
+
+	if IsNoData(exp) {
+		if !IsNoData(v) {
+			t.Errorf("%s %s: expected no-data, got %v", layer, ref, v)
+		}
+	} else if math.Abs(v-exp) > 1e-9 {
+		t.Errorf("%s %s: expected %v, got %v", layer, ref, exp, v)
+	}
+}
+
+func TestDerivedPlane(t *testing.T) {
+	// Rising 1 m in 10 towards the East
+	db := &TestCoastDatabase{
+		TestFloat64Database: TestFloat64Database{
+			precision: 50 * osgrid.Metre,
+			f:         func(e, n float64) float64 { return e / 10 },
+		},
+		coast: 100 * osgrid.Kilometre,
+	}
+
+	testDerived(t, db, LayerSlopeDegrees, 5000, 5000, math.Atan(0.1)*180/math.Pi)
+	testDerived(t, db, LayerSlopePercent, 5000, 5000, 10)
+	// Facing downhill, West
+	testDerived(t, db, LayerAspect, 5000, 5000, 270)
+	testDerived(t, db, LayerPlanCurvature, 5000, 5000, 0)
+	testDerived(t, db, LayerProfileCurvature, 5000, 5000, 0)
+
+	flat := &TestCoastDatabase{
+		TestFloat64Database: TestFloat64Database{
+			precision: 50 * osgrid.Metre,
+			f:         func(e, n float64) float64 { return 100 },
+		},
+		coast: 100 * osgrid.Kilometre,
+	}
+
+	testDerived(t, flat, LayerSlopeDegrees, 5000, 5000, 0)
+	testDerived(t, flat, LayerAspect, 5000, 5000, NoData)
+}
+
+func TestDerivedEdges(t *testing.T) {
+	// Rising 1 m per metre towards the North, with 10 km tiles up to 20 km
+	db := newTestCoastDatabase()
+
+	for _, east := range []osgrid.Distance{
+		// Inside a tile
+		5000,
+		// Either side of a tile boundary
+		9950, 10000,
+		// Next to a missing tile
+		19950,
+	} {
+		testDerived(t, db, LayerSlopeDegrees, east, 5000, 45)
+		// Facing South
+		testDerived(t, db, LayerAspect, east, 5000, 180)
+	}
+}
+
+func TestDerivedCurvature(t *testing.T) {
+	// A bowl, with its lowest point at 5 km, 5 km
+	db := &TestCoastDatabase{
+		TestFloat64Database: TestFloat64Database{
+			precision: 50 * osgrid.Metre,
+			f: func(e, n float64) float64 {
+				return ((e-5000)*(e-5000) + (n-5000)*(n-5000)) / 1000
+			},
+		},
+		coast: 100 * osgrid.Kilometre,
+	}
+
+	// d2z/dx2 is 2/1000. The sides of a bowl are concave in profile, and
+	// the contours curve around the low point, so concave in plan too.
+	testDerived(t, db, LayerProfileCurvature, 6000, 5000, 0.002)
+	testDerived(t, db, LayerPlanCurvature, 6000, 5000, -0.002)
+	testDerived(t, db, LayerProfileCurvature, 5000, 4000, 0.002)
+	testDerived(t, db, LayerPlanCurvature, 5000, 4000, -0.002)
+}
+
+func TestParseTerrainLayer(t *testing.T) {
+	for _, l := range terrainLayers {
+		parsed, err := ParseTerrainLayer(l.String())
+		if err != nil || parsed != l {
+			t.Errorf("%s: got %v, %v", l, parsed, err)
+		}
+	}
+
+	_, err := ParseTerrainLayer("roughness")
+	if err == nil {
+		t.Errorf("expected error")
+	}
+
+	_, err = NewDerived(newTestCoastDatabase(), TerrainLayer(100))
+	if err == nil {
+		t.Errorf("expected error")
+	}
+}
@@ -0,0 +1,389 @@
+package geotiff
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+
+	"github.com/google/tiff"
+	"golang.org/x/image/tiff/lzw"
+
+	"github.com/usedbytes/osgrid/osdata"
+)
+
+// Baseline and extension TIFF tags needed to decode the samples
+const (
+	imageWidthTag          uint16 = 256
+	imageLengthTag         uint16 = 257
+	bitsPerSampleTag       uint16 = 258
+	compressionTag         uint16 = 259
+	stripOffsetsTag        uint16 = 273
+	samplesPerPixelTag     uint16 = 277
+	rowsPerStripTag        uint16 = 278
+	stripByteCountsTag     uint16 = 279
+	planarConfigurationTag uint16 = 284
+	predictorTag           uint16 = 317
+	tileWidthTag           uint16 = 322
+	tileLengthTag          uint16 = 323
+	tileOffsetsTag         uint16 = 324
+	tileByteCountsTag      uint16 = 325
+	sampleFormatTag        uint16 = 339
+
+	GDALNoDataTag uint16 = 42113
+)
+
+const (
+	compressionNone       = 1
+	compressionLZW        = 5
+	compressionDeflate    = 8
+	compressionDeflateOld = 32946
+
+	predictorNone          = 1
+	predictorHorizontal    = 2
+	predictorFloatingPoint = 3
+
+	sampleFormatUint  = 1
+	sampleFormatInt   = 2
+	sampleFormatFloat = 3
+)
+
+func fieldUints(ifd tiff.IFD, tag uint16) ([]uint64, error) {
+	if !ifd.HasField(tag) {
+		return nil, fmt.Errorf("missing tag %d", tag)
+	}
+
+	f := ifd.GetField(tag)
+	size := f.Type().Size()
+	b := f.Value().Bytes()
+	order := f.Value().Order()
+
+	vals := make([]uint64, f.Count())
+	for i := range vals {
+		switch size {
+		case 1:
+			vals[i] = uint64(b[i])
+		case 2:
+			vals[i] = uint64(order.Uint16(b[i*2:]))
+		case 4:
+			vals[i] = uint64(order.Uint32(b[i*4:]))
+		case 8:
+			vals[i] = order.Uint64(b[i*8:])
+		default:
+			return nil, fmt.Errorf("tag %d: unexpected field size %d", tag, size)
+		}
+	}
+
+	return vals, nil
+}
+
+func fieldUint(ifd tiff.IFD, tag uint16, def uint64) (uint64, error) {
+	if !ifd.HasField(tag) {
+		return def, nil
+	}
+
+	vals, err := fieldUints(ifd, tag)
+	if err != nil {
+		return 0, err
+	}
+
+	if len(vals) == 0 {
+		return 0, fmt.Errorf("tag %d: empty", tag)
+	}
+
+	// Multi-valued tags like BitsPerSample have one entry per sample, but
+	// we only support a single sample anyway.
+	return vals[0], nil
+}
+
+type layout struct {
+	width, height  int
+	bytesPerSample int
+	sampleFormat   uint64
+	compression    uint64
+	predictor      uint64
+
+	// Strips are treated as tiles which are the full width of the image
+	chunkWidth, chunkHeight int
+	offsets, byteCounts     []uint64
+}
+
+func readLayout(ifd tiff.IFD) (*layout, error) {
+	var l layout
+
+	width, err := fieldUint(ifd, imageWidthTag, 0)
+	if err != nil {
+		return nil, err
+	}
+	height, err := fieldUint(ifd, imageLengthTag, 0)
+	if err != nil {
+		return nil, err
+	}
+	l.width, l.height = int(width), int(height)
+
+	if l.width == 0 || l.height == 0 {
+		return nil, fmt.Errorf("missing image dimensions")
+	}
+
+	samples, err := fieldUint(ifd, samplesPerPixelTag, 1)
+	if err != nil {
+		return nil, err
+	}
+	if samples != 1 {
+		return nil, fmt.Errorf("only single-band images are supported, got %d samples per pixel", samples)
+	}
+
+	bits, err := fieldUint(ifd, bitsPerSampleTag, 1)
+	if err != nil {
+		return nil, err
+	}
+
+	l.sampleFormat, err = fieldUint(ifd, sampleFormatTag, sampleFormatUint)
+	if err != nil {
+		return nil, err
+	}
+
+	switch {
+	case l.sampleFormat == sampleFormatFloat && (bits == 32 || bits == 64):
+	case (l.sampleFormat == sampleFormatUint || l.sampleFormat == sampleFormatInt) &&
+		(bits == 8 || bits == 16 || bits == 32):
+	default:
+		return nil, fmt.Errorf("unsupported sample format %d with %d bits", l.sampleFormat, bits)
+	}
+	l.bytesPerSample = int(bits / 8)
+
+	l.compression, err = fieldUint(ifd, compressionTag, compressionNone)
+	if err != nil {
+		return nil, err
+	}
+
+	l.predictor, err = fieldUint(ifd, predictorTag, predictorNone)
+	if err != nil {
+		return nil, err
+	}
+
+	if ifd.HasField(tileOffsetsTag) {
+		tw, err := fieldUint(ifd, tileWidthTag, 0)
+		if err != nil {
+			return nil, err
+		}
+		th, err := fieldUint(ifd, tileLengthTag, 0)
+		if err != nil {
+			return nil, err
+		}
+		l.chunkWidth, l.chunkHeight = int(tw), int(th)
+
+		l.offsets, err = fieldUints(ifd, tileOffsetsTag)
+		if err != nil {
+			return nil, err
+		}
+		l.byteCounts, err = fieldUints(ifd, tileByteCountsTag)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		rows, err := fieldUint(ifd, rowsPerStripTag, height)
+		if err != nil {
+			return nil, err
+		}
+		l.chunkWidth, l.chunkHeight = l.width, int(rows)
+		if l.chunkHeight > l.height {
+			l.chunkHeight = l.height
+		}
+
+		l.offsets, err = fieldUints(ifd, stripOffsetsTag)
+		if err != nil {
+			return nil, err
+		}
+		l.byteCounts, err = fieldUints(ifd, stripByteCountsTag)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if l.chunkWidth == 0 || l.chunkHeight == 0 {
+		return nil, fmt.Errorf("invalid strip/tile size")
+	}
+
+	across := (l.width + l.chunkWidth - 1) / l.chunkWidth
+	down := (l.height + l.chunkHeight - 1) / l.chunkHeight
+	if len(l.offsets) != across*down || len(l.byteCounts) != len(l.offsets) {
+		return nil, fmt.Errorf("unexpected number of strips/tiles")
+	}
+
+	return &l, nil
+}
+
+func (l *layout) decompress(r io.ReaderAt, chunk int) ([]byte, error) {
+	raw := make([]byte, l.byteCounts[chunk])
+	_, err := r.ReadAt(raw, int64(l.offsets[chunk]))
+	if err != nil {
+		return nil, err
+	}
+
+	var data []byte
+	switch l.compression {
+	case compressionNone:
+		data = raw
+	case compressionLZW:
+		zr := lzw.NewReader(bytes.NewReader(raw), lzw.MSB, 8)
+		defer zr.Close()
+		data, err = ioutil.ReadAll(zr)
+	case compressionDeflate, compressionDeflateOld:
+		var zr io.ReadCloser
+		zr, err = zlib.NewReader(bytes.NewReader(raw))
+		if err != nil {
+			return nil, err
+		}
+		defer zr.Close()
+		data, err = ioutil.ReadAll(zr)
+	default:
+		return nil, fmt.Errorf("unsupported compression %d", l.compression)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	need := l.chunkWidth * l.chunkHeight * l.bytesPerSample
+	if len(data) < need {
+		// The last strip is allowed to be short
+		padded := make([]byte, need)
+		copy(padded, data)
+		data = padded
+	}
+
+	return data, nil
+}
+
+// Undo the predictor, in-place. Afterwards, the samples are always in order,
+// but for predictorFloatingPoint they're big-endian.
+func (l *layout) unpredict(data []byte, order binary.ByteOrder) error {
+	rowBytes := l.chunkWidth * l.bytesPerSample
+
+	switch l.predictor {
+	case predictorNone:
+		return nil
+	case predictorHorizontal:
+		for y := 0; y < l.chunkHeight; y++ {
+			row := data[y*rowBytes : (y+1)*rowBytes]
+			switch l.bytesPerSample {
+			case 1:
+				for x := 1; x < len(row); x++ {
+					row[x] += row[x-1]
+				}
+			case 2:
+				for x := 2; x < len(row); x += 2 {
+					order.PutUint16(row[x:], order.Uint16(row[x:])+order.Uint16(row[x-2:]))
+				}
+			case 4:
+				for x := 4; x < len(row); x += 4 {
+					order.PutUint32(row[x:], order.Uint32(row[x:])+order.Uint32(row[x-4:]))
+				}
+			default:
+				return fmt.Errorf("unsupported predictor for %d byte samples", l.bytesPerSample)
+			}
+		}
+	case predictorFloatingPoint:
+		tmp := make([]byte, rowBytes)
+		for y := 0; y < l.chunkHeight; y++ {
+			row := data[y*rowBytes : (y+1)*rowBytes]
+			for x := 1; x < len(row); x++ {
+				row[x] += row[x-1]
+			}
+
+			// Bytes are grouped by significance, most significant first
+			for x := 0; x < l.chunkWidth; x++ {
+				for b := 0; b < l.bytesPerSample; b++ {
+					tmp[x*l.bytesPerSample+b] = row[b*l.chunkWidth+x]
+				}
+			}
+			copy(row, tmp)
+		}
+	default:
+		return fmt.Errorf("unsupported predictor %d", l.predictor)
+	}
+
+	return nil
+}
+
+func (l *layout) sample(b []byte, order binary.ByteOrder) float64 {
+	if l.predictor == predictorFloatingPoint {
+		order = binary.BigEndian
+	}
+
+	switch l.sampleFormat {
+	case sampleFormatFloat:
+		if l.bytesPerSample == 4 {
+			return float64(math.Float32frombits(order.Uint32(b)))
+		}
+		return math.Float64frombits(order.Uint64(b))
+	case sampleFormatInt:
+		switch l.bytesPerSample {
+		case 1:
+			return float64(int8(b[0]))
+		case 2:
+			return float64(int16(order.Uint16(b)))
+		default:
+			return float64(int32(order.Uint32(b)))
+		}
+	default:
+		switch l.bytesPerSample {
+		case 1:
+			return float64(b[0])
+		case 2:
+			return float64(order.Uint16(b))
+		default:
+			return float64(order.Uint32(b))
+		}
+	}
+}
+
+// decodeSamples reads all of the samples from the image in ifd, returning them
+// with data[0] being the bottom (Southern-most) row. Samples equal to the
+// NoData value in info are set to osdata.NoData.
+func decodeSamples(r io.ReaderAt, ifd tiff.IFD, order binary.ByteOrder, info Info) ([][]float32, error) {
+	l, err := readLayout(ifd)
+	if err != nil {
+		return nil, err
+	}
+
+	data := make([][]float32, l.height)
+	backing := make([]float32, l.width*l.height)
+	for i := range data {
+		data[i] = backing[i*l.width : (i+1)*l.width]
+	}
+
+	across := (l.width + l.chunkWidth - 1) / l.chunkWidth
+	for chunk := range l.offsets {
+		buf, err := l.decompress(r, chunk)
+		if err != nil {
+			return nil, fmt.Errorf("strip/tile %d: %w", chunk, err)
+		}
+
+		err = l.unpredict(buf, order)
+		if err != nil {
+			return nil, err
+		}
+
+		x0 := (chunk % across) * l.chunkWidth
+		y0 := (chunk / across) * l.chunkHeight
+
+		for y := 0; y < l.chunkHeight && y0+y < l.height; y++ {
+			// The image is stored from the top
+			row := data[l.height-(y0+y)-1]
+			for x := 0; x < l.chunkWidth && x0+x < l.width; x++ {
+				idx := (y*l.chunkWidth + x) * l.bytesPerSample
+				v := l.sample(buf[idx:], order)
+				if info.isNoData(v) {
+					v = osdata.NoData
+				}
+				row[x0+x] = float32(v)
+			}
+		}
+	}
+
+	return data, nil
+}
@@ -0,0 +1,226 @@
+package geotiff
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"sort"
+	"strconv"
+
+	"github.com/usedbytes/osgrid"
+	"github.com/usedbytes/osgrid/osdata"
+	"github.com/usedbytes/osgrid/osdata/raster"
+)
+
+const (
+	photometricInterpretationTag uint16 = 262
+
+	typeASCII  = 2
+	typeShort  = 3
+	typeLong   = 4
+	typeDouble = 12
+
+	photometricBlackIsZero = 1
+	planarConfigContig     = 1
+)
+
+// NoDataValue is written in place of osdata.NoData
+const NoDataValue float64 = -9999
+
+type ifdEntry struct {
+	tag   uint16
+	typ   uint16
+	count uint32
+	data  []byte
+}
+
+func shortsEntry(tag uint16, vs ...uint16) ifdEntry {
+	b := make([]byte, 2*len(vs))
+	for i, v := range vs {
+		binary.LittleEndian.PutUint16(b[i*2:], v)
+	}
+	return ifdEntry{tag, typeShort, uint32(len(vs)), b}
+}
+
+func longEntry(tag uint16, v uint32) ifdEntry {
+	b := make([]byte, 4)
+	binary.LittleEndian.PutUint32(b, v)
+	return ifdEntry{tag, typeLong, 1, b}
+}
+
+func doublesEntry(tag uint16, vs ...float64) ifdEntry {
+	b := make([]byte, 8*len(vs))
+	for i, v := range vs {
+		binary.LittleEndian.PutUint64(b[i*8:], math.Float64bits(v))
+	}
+	return ifdEntry{tag, typeDouble, uint32(len(vs)), b}
+}
+
+func asciiEntry(tag uint16, s string) ifdEntry {
+	return ifdEntry{tag, typeASCII, uint32(len(s) + 1), append([]byte(s), 0)}
+}
+
+// Write a little-endian TIFF with a single IFD and a single strip
+func writeTIFF(w io.Writer, entries []ifdEntry, strip []byte) error {
+	entries = append(entries,
+		longEntry(stripOffsetsTag, 0),
+		longEntry(stripByteCountsTag, uint32(len(strip))),
+	)
+	sort.Slice(entries, func(i, j int) bool { return entries[i].tag < entries[j].tag })
+
+	// Header, then IFD, then out-of-line values, then the strip. Everything
+	// is kept word-aligned.
+	offset := uint32(8 + 2 + 12*len(entries) + 4)
+	offsets := make([]uint32, len(entries))
+	for i, e := range entries {
+		if len(e.data) > 4 {
+			offsets[i] = offset
+			offset += uint32(len(e.data) + len(e.data)%2)
+		}
+	}
+
+	for _, e := range entries {
+		if e.tag == stripOffsetsTag {
+			binary.LittleEndian.PutUint32(e.data, offset)
+		}
+	}
+
+	buf := &bytes.Buffer{}
+	buf.Write([]byte("II"))
+	binary.Write(buf, binary.LittleEndian, uint16(42))
+	binary.Write(buf, binary.LittleEndian, uint32(8))
+
+	binary.Write(buf, binary.LittleEndian, uint16(len(entries)))
+	for i, e := range entries {
+		binary.Write(buf, binary.LittleEndian, e.tag)
+		binary.Write(buf, binary.LittleEndian, e.typ)
+		binary.Write(buf, binary.LittleEndian, e.count)
+		if len(e.data) > 4 {
+			binary.Write(buf, binary.LittleEndian, offsets[i])
+		} else {
+			val := make([]byte, 4)
+			copy(val, e.data)
+			buf.Write(val)
+		}
+	}
+	// No more IFDs
+	binary.Write(buf, binary.LittleEndian, uint32(0))
+
+	for _, e := range entries {
+		if len(e.data) > 4 {
+			buf.Write(e.data)
+			if len(e.data)%2 != 0 {
+				buf.WriteByte(0)
+			}
+		}
+	}
+
+	_, err := buf.WriteTo(w)
+	if err != nil {
+		return err
+	}
+
+	_, err = w.Write(strip)
+	return err
+}
+
+// Write writes t as a single-band GeoTIFF of Deflate-compressed 32-bit float
+// samples, tagged as British National Grid (EPSG:27700). No-data values are
+// written as NoDataValue, and recorded in the GDAL_NODATA tag.
+func Write(w io.Writer, t osdata.Float64Tile) error {
+	precision := t.Precision()
+	if precision <= 0 || t.Width()%precision != 0 || t.Height()%precision != 0 {
+		return fmt.Errorf("tile size must be a multiple of its precision")
+	}
+
+	ncols := int(t.Width() / precision)
+	nrows := int(t.Height() / precision)
+	bottomLeft := t.BottomLeft()
+
+	strip := &bytes.Buffer{}
+	zw := zlib.NewWriter(strip)
+
+	row := make([]byte, ncols*4)
+	// Image rows are North to South
+	for y := nrows - 1; y >= 0; y-- {
+		for x := 0; x < ncols; x++ {
+			ref, err := bottomLeft.Add(osgrid.Distance(x)*precision, osgrid.Distance(y)*precision)
+			if err != nil {
+				return err
+			}
+
+			v, err := t.GetFloat64(ref)
+			if err != nil {
+				return err
+			}
+
+			if osdata.IsNoData(v) {
+				v = NoDataValue
+			}
+
+			binary.LittleEndian.PutUint32(row[x*4:], math.Float32bits(float32(v)))
+		}
+
+		_, err := zw.Write(row)
+		if err != nil {
+			return err
+		}
+	}
+
+	err := zw.Close()
+	if err != nil {
+		return err
+	}
+
+	// Tie the top-left corner
+	top := float64(bottomLeft.AbsNorthing() + t.Height())
+
+	entries := []ifdEntry{
+		longEntry(imageWidthTag, uint32(ncols)),
+		longEntry(imageLengthTag, uint32(nrows)),
+		shortsEntry(bitsPerSampleTag, 32),
+		shortsEntry(compressionTag, compressionDeflate),
+		shortsEntry(photometricInterpretationTag, photometricBlackIsZero),
+		shortsEntry(samplesPerPixelTag, 1),
+		longEntry(rowsPerStripTag, uint32(nrows)),
+		shortsEntry(planarConfigurationTag, planarConfigContig),
+		shortsEntry(sampleFormatTag, sampleFormatFloat),
+		doublesEntry(raster.ModelPixelScaleTag, float64(precision), float64(precision), 0),
+		doublesEntry(raster.ModelTiepointTag, 0, 0, 0, float64(bottomLeft.AbsEasting()), top, 0),
+		shortsEntry(geoKeyDirectoryTag,
+			1, 1, 0, 3,
+			gtModelTypeGeoKey, 0, 1, modelTypeProjected,
+			gtRasterTypeGeoKey, 0, 1, rasterPixelIsArea,
+			projectedCSTypeGeoKey, 0, 1, epsgBritishNationalGrid,
+		),
+		asciiEntry(GDALNoDataTag, strconv.FormatFloat(NoDataValue, 'f', -1, 64)),
+	}
+
+	return writeTIFF(w, entries, strip.Bytes())
+}
+
+// WriteFile writes t as a GeoTIFF to path. The file is written under a
+// temporary name and then renamed, so readers never see a partial tile.
+func WriteFile(path string, t osdata.Float64Tile) error {
+	tmp := path + ".tmp"
+
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+
+	err = Write(f, t)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+
+	return os.Rename(tmp, path)
+}
@@ -0,0 +1,452 @@
+// Package geotiff reads single-band GeoTIFF elevation models (DEMs), such as
+// LiDAR composites or Copernicus tiles, as an osdata.Float64Database.
+package geotiff
+
+import (
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/google/tiff"
+
+	"github.com/usedbytes/osgrid"
+	"github.com/usedbytes/osgrid/osdata"
+	"github.com/usedbytes/osgrid/osdata/ascgrid"
+	"github.com/usedbytes/osgrid/osdata/raster"
+)
+
+var mustBeFloat64Database osdata.Float64Database = &Database{}
+var mustBePartialCoverage osdata.PartialCoverage = &Database{}
+
+// Info describes the position and size of a GeoTIFF, without reading its
+// samples
+type Info struct {
+	BottomLeft    osgrid.GridRef
+	Width, Height osgrid.Distance
+	Precision     osgrid.Distance
+
+	// Whether NoDataValue is valid
+	HasNoData   bool
+	NoDataValue float64
+}
+
+func (info Info) isNoData(v float64) bool {
+	if !info.HasNoData {
+		return false
+	}
+
+	return v == info.NoDataValue || (math.IsNaN(info.NoDataValue) && math.IsNaN(v))
+}
+
+// GeoKeys which describe the coordinate system
+const (
+	geoKeyDirectoryTag uint16 = 34735
+
+	gtModelTypeGeoKey     = 1024
+	gtRasterTypeGeoKey    = 1025
+	projectedCSTypeGeoKey = 3072
+
+	modelTypeProjected      = 1
+	rasterPixelIsArea       = 1
+	rasterPixelIsPoint      = 2
+	epsgBritishNationalGrid = 27700
+)
+
+// Check the coordinate system is British National Grid, and return whether
+// the tie point is the centre of a pixel (PixelIsPoint), rather than its
+// corner. Files without GeoKeys are assumed to be British National Grid.
+func readGeoKeys(ifd tiff.IFD) (bool, error) {
+	if !ifd.HasField(geoKeyDirectoryTag) {
+		return false, nil
+	}
+
+	keys, err := fieldUints(ifd, geoKeyDirectoryTag)
+	if err != nil {
+		return false, err
+	}
+
+	// Header, then 4 values per key
+	if len(keys) < 4 || len(keys) < 4+4*int(keys[3]) {
+		return false, fmt.Errorf("GeoKey directory is truncated")
+	}
+
+	pixelIsPoint := false
+	for i := 0; i < int(keys[3]); i++ {
+		key := keys[4+4*i : 8+4*i]
+		if key[1] != 0 {
+			// Stored in another tag, which none of the keys we
+			// need are
+			continue
+		}
+
+		switch value := key[3]; key[0] {
+		case gtModelTypeGeoKey:
+			if value != modelTypeProjected {
+				return false, fmt.Errorf("coordinate system isn't British National Grid (EPSG:27700): model type %d", value)
+			}
+		case gtRasterTypeGeoKey:
+			pixelIsPoint = value == rasterPixelIsPoint
+		case projectedCSTypeGeoKey:
+			if value != epsgBritishNationalGrid {
+				return false, fmt.Errorf("coordinate system isn't British National Grid (EPSG:27700): EPSG:%d", value)
+			}
+		}
+	}
+
+	return pixelIsPoint, nil
+}
+
+func wholeMetres(v float64) (osgrid.Distance, bool) {
+	r := math.Round(v)
+	if math.Abs(v-r) > 1e-6 {
+		return 0, false
+	}
+
+	return osgrid.Distance(r), true
+}
+
+// The model position of the tie point. raster.TiePointTag truncates it to
+// whole metres, which would hide an origin which isn't.
+func tiePointPosition(ifd tiff.IFD) (float64, float64) {
+	v := ifd.GetField(raster.ModelTiepointTag).Value()
+	b, order := v.Bytes(), v.Order()
+
+	return math.Float64frombits(order.Uint64(b[3*8:])), math.Float64frombits(order.Uint64(b[4*8:]))
+}
+
+func readInfo(t tiff.TIFF) (tiff.IFD, Info, error) {
+	ifds := t.IFDs()
+	if len(ifds) < 1 {
+		return nil, Info{}, fmt.Errorf("no images")
+	}
+
+	// Any further IFDs are overviews, which we don't need
+	ifd := ifds[0]
+
+	// This checks there's a single tie point at pixel 0,0
+	scaleTag, _, err := raster.DecodeGeoTags(ifd)
+	if err != nil {
+		return nil, Info{}, err
+	}
+
+	if scaleTag.ScaleX != scaleTag.ScaleY {
+		return nil, Info{}, fmt.Errorf("pixels must be square")
+	}
+
+	precision := osgrid.Distance(scaleTag.ScaleX)
+	if float64(precision) != scaleTag.ScaleX || precision <= 0 {
+		return nil, Info{}, fmt.Errorf("pixel size must be a whole number of metres, got %v", scaleTag.ScaleX)
+	}
+
+	width, err := fieldUint(ifd, imageWidthTag, 0)
+	if err != nil {
+		return nil, Info{}, err
+	}
+	height, err := fieldUint(ifd, imageLengthTag, 0)
+	if err != nil {
+		return nil, Info{}, err
+	}
+
+	info := Info{
+		Width:     osgrid.Distance(width) * precision,
+		Height:    osgrid.Distance(height) * precision,
+		Precision: precision,
+	}
+
+	pixelIsPoint, err := readGeoKeys(ifd)
+	if err != nil {
+		return nil, Info{}, err
+	}
+
+	// Tie point position is the top-left corner of the image, or the centre
+	// of the top-left pixel
+	left, top := tiePointPosition(ifd)
+	if pixelIsPoint {
+		left -= scaleTag.ScaleX / 2
+		top += scaleTag.ScaleY / 2
+	}
+
+	x, okx := wholeMetres(left)
+	y, oky := wholeMetres(top)
+	if !okx || !oky {
+		return nil, Info{}, fmt.Errorf("top-left corner must be a whole number of metres, got %v,%v", left, top)
+	}
+
+	// Subtract height to get the bottom-left
+	info.BottomLeft, err = osgrid.Origin().Add(x, y-info.Height)
+	if err != nil {
+		return nil, Info{}, err
+	}
+
+	if ifd.HasField(GDALNoDataTag) {
+		str := strings.Trim(string(ifd.GetField(GDALNoDataTag).Value().Bytes()), "\x00 ")
+		info.NoDataValue, err = strconv.ParseFloat(str, 64)
+		if err != nil {
+			return nil, Info{}, fmt.Errorf("invalid GDAL_NODATA '%s'", str)
+		}
+		info.HasNoData = true
+	}
+
+	return ifd, info, nil
+}
+
+func ReadInfo(r tiff.ReadAtReadSeeker) (Info, error) {
+	t, err := tiff.Parse(r, nil, nil)
+	if err != nil {
+		return Info{}, fmt.Errorf("tiff.Parse: %v", err)
+	}
+
+	_, info, err := readInfo(t)
+
+	return info, err
+}
+
+// ReadTile reads a single-band GeoTIFF with 8, 16 or 32-bit integer or 32 or
+// 64-bit floating point samples. Samples equal to the GDAL_NODATA value are
+// set to osdata.NoData.
+func ReadTile(r tiff.ReadAtReadSeeker) (*ascgrid.Tile, error) {
+	t, err := tiff.Parse(r, nil, nil)
+	if err != nil {
+		return nil, fmt.Errorf("tiff.Parse: %v", err)
+	}
+
+	ifd, info, err := readInfo(t)
+	if err != nil {
+		return nil, err
+	}
+
+	order := ifd.GetField(imageWidthTag).Value().Order()
+	data, err := decodeSamples(r, ifd, order, info)
+	if err != nil {
+		return nil, err
+	}
+
+	return ascgrid.NewTile(&ascgrid.Grid{
+		Header: ascgrid.Header{
+			NCols:     len(data[0]),
+			NRows:     len(data),
+			XLLCorner: float64(info.BottomLeft.AbsEasting()),
+			YLLCorner: float64(info.BottomLeft.AbsNorthing()),
+			CellSize:  float64(info.Precision),
+		},
+		Data: data,
+	})
+}
+
+func OpenTile(path string) (*ascgrid.Tile, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	return ReadTile(f)
+}
+
+func openInfo(path string) (Info, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return Info{}, err
+	}
+	defer f.Close()
+
+	return ReadInfo(f)
+}
+
+type tileEntry struct {
+	path string
+	info Info
+}
+
+func (e *tileEntry) rect() osgrid.Rect {
+	return osgrid.Rect{BottomLeft: e.info.BottomLeft, Width: e.info.Width, Height: e.info.Height}
+}
+
+func (e *tileEntry) contains(ref osgrid.GridRef) bool {
+	east := ref.AbsEasting() - e.info.BottomLeft.AbsEasting()
+	north := ref.AbsNorthing() - e.info.BottomLeft.AbsNorthing()
+
+	return east >= 0 && north >= 0 && east < e.info.Width && north < e.info.Height
+}
+
+// Database is a set of GeoTIFF tiles with the same precision. Unlike most
+// other databases, the tiles can be any size, and needn't be on a regular
+// grid - for example it can be a single large GeoTIFF.
+type Database struct {
+	precision osgrid.Distance
+	tiles     []tileEntry
+	cache     *osdata.Cache
+
+	// The tiles overlapping each square of indexSize, keyed by its
+	// bottom-left corner. indexSize is no bigger than the smallest tile, so
+	// there are only a few tiles in each.
+	indexSize osgrid.Distance
+	index     map[osgrid.GridRef][]*tileEntry
+}
+
+func isTIFF(path string) bool {
+	ext := strings.ToLower(filepath.Ext(path))
+	return ext == ".tif" || ext == ".tiff"
+}
+
+// OpenDatabase indexes all of the GeoTIFFs under path (recursively), or path
+// itself if it's a file. Only the headers are read until tiles are requested.
+func OpenDatabase(path string, opts ...osdata.DatabaseOpt) (osdata.Float64Database, error) {
+	cfg := osdata.NewDatabaseConfig(opts...)
+
+	d := &Database{
+		cache: cfg.Cache,
+	}
+
+	err := filepath.Walk(path, func(p string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if fi.IsDir() || !isTIFF(p) {
+			return nil
+		}
+
+		info, err := openInfo(p)
+		if err != nil {
+			return fmt.Errorf("%s: %w", p, err)
+		}
+
+		if d.precision == 0 {
+			d.precision = info.Precision
+		} else if d.precision != info.Precision {
+			return fmt.Errorf("%s: precision %v doesn't match the rest of the dataset (%v)",
+				p, info.Precision, d.precision)
+		}
+
+		d.tiles = append(d.tiles, tileEntry{path: p, info: info})
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if len(d.tiles) == 0 {
+		return nil, fmt.Errorf("no GeoTIFF tiles found in %s", path)
+	}
+
+	d.buildIndex()
+
+	return d, nil
+}
+
+func (d *Database) buildIndex() {
+	smallest := d.tiles[0].info.Width
+	for _, e := range d.tiles {
+		if e.info.Width < smallest {
+			smallest = e.info.Width
+		}
+		if e.info.Height < smallest {
+			smallest = e.info.Height
+		}
+	}
+
+	// Squares must divide the 100 km grid squares, so that they line up
+	// with GridRef.Align()
+	d.indexSize = smallest
+	for 100*osgrid.Kilometre%d.indexSize != 0 {
+		d.indexSize--
+	}
+
+	d.index = make(map[osgrid.GridRef][]*tileEntry)
+	for i := range d.tiles {
+		e := &d.tiles[i]
+		bl := e.info.BottomLeft.Align(d.indexSize)
+
+		for north := osgrid.Distance(0); bl.AbsNorthing()+north < e.info.BottomLeft.AbsNorthing()+e.info.Height; north += d.indexSize {
+			for east := osgrid.Distance(0); bl.AbsEasting()+east < e.info.BottomLeft.AbsEasting()+e.info.Width; east += d.indexSize {
+				square, err := bl.Add(east, north)
+				if err != nil {
+					// Off the edge of the grid
+					continue
+				}
+
+				d.index[square] = append(d.index[square], e)
+			}
+		}
+	}
+}
+
+func (d *Database) getTile(ref osgrid.GridRef) (*ascgrid.Tile, error) {
+	var entry *tileEntry
+	for _, e := range d.index[ref.Align(d.indexSize)] {
+		if e.contains(ref) {
+			entry = e
+			break
+		}
+	}
+
+	if entry == nil {
+		return nil, fmt.Errorf("Tile for %s %w", ref, osdata.ErrTileNotFound)
+	}
+
+	// Files needn't be on a regular grid, so several could share a corner.
+	// Cache them by path.
+	tile, err := d.cache.LoadItem(entry.path, func() (interface{}, error) {
+		return OpenTile(entry.path)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return tile.(*ascgrid.Tile), nil
+}
+
+// HasData returns true if any of the tiles overlap r. As the tiles needn't be
+// on a regular grid, osdata.FillMissingTiles uses this to tell squares which
+// are partly covered from ones which are missing entirely.
+func (d *Database) HasData(r osgrid.Rect) bool {
+	bl := r.BottomLeft.Align(d.indexSize)
+
+	for north := osgrid.Distance(0); bl.AbsNorthing()+north < r.BottomLeft.AbsNorthing()+r.Height; north += d.indexSize {
+		for east := osgrid.Distance(0); bl.AbsEasting()+east < r.BottomLeft.AbsEasting()+r.Width; east += d.indexSize {
+			square, err := bl.Add(east, north)
+			if err != nil {
+				// Off the edge of the grid
+				continue
+			}
+
+			for _, e := range d.index[square] {
+				if e.rect().Intersects(r) {
+					return true
+				}
+			}
+		}
+	}
+
+	return false
+}
+
+func (d *Database) GetTile(ref osgrid.GridRef) (osdata.Tile, error) {
+	return d.getTile(ref)
+}
+
+func (d *Database) GetFloat64Tile(ref osgrid.GridRef) (osdata.Float64Tile, error) {
+	return d.getTile(ref)
+}
+
+func (d *Database) GetFloat64(ref osgrid.GridRef) (float64, error) {
+	tile, err := d.getTile(ref)
+	if err != nil {
+		return osdata.NoData, err
+	}
+
+	return tile.GetFloat64(ref)
+}
+
+func (d *Database) Precision() osgrid.Distance {
+	return d.precision
+}
+
+func (d *Database) Stats() osdata.Stats {
+	return d.cache.Stats()
+}
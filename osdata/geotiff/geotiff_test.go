@@ -0,0 +1,400 @@
+package geotiff
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"errors"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/usedbytes/osgrid"
+	"github.com/usedbytes/osgrid/osdata"
+)
+
+func shortEntry(tag uint16, v uint16) ifdEntry {
+	return shortsEntry(tag, v)
+}
+
+func encodeTIFF(entries []ifdEntry, strip []byte) []byte {
+	buf := &bytes.Buffer{}
+	writeTIFF(buf, entries, strip)
+	return buf.Bytes()
+}
+
+func geoEntries(width, height int, bits, format uint16, x, y, scale float64) []ifdEntry {
+	return []ifdEntry{
+		shortEntry(imageWidthTag, uint16(width)),
+		shortEntry(imageLengthTag, uint16(height)),
+		shortEntry(bitsPerSampleTag, bits),
+		shortEntry(samplesPerPixelTag, 1),
+		shortEntry(rowsPerStripTag, uint16(height)),
+		shortEntry(sampleFormatTag, format),
+		doublesEntry(33550, scale, scale, 0),
+		doublesEntry(33922, 0, 0, 0, x, y, 0),
+	}
+}
+
+func geoKeysEntry(modelType, rasterType, projectedCSType uint16) ifdEntry {
+	return shortsEntry(geoKeyDirectoryTag,
+		1, 1, 0, 3,
+		gtModelTypeGeoKey, 0, 1, modelType,
+		gtRasterTypeGeoKey, 0, 1, rasterType,
+		projectedCSTypeGeoKey, 0, 1, projectedCSType,
+	)
+}
+
+func nodataEntry(v string) ifdEntry {
+	return ifdEntry{GDALNoDataTag, typeASCII, uint32(len(v) + 1), append([]byte(v), 0)}
+}
+
+func deflate(b []byte) []byte {
+	buf := &bytes.Buffer{}
+	zw := zlib.NewWriter(buf)
+	zw.Write(b)
+	zw.Close()
+	return buf.Bytes()
+}
+
+// 4x3 pixels, values are (row * 10) + col, top row first
+var testValues = [][]float64{
+	{0, 1, 2, 3},
+	{10, 11, 12, 13},
+	{20, 21, -9999, 23},
+}
+
+func checkTile(t *testing.T, data []byte) {
+	tile, err := ReadTile(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Top-left at SH 60000 54015, so the bottom-left is 3 pixels South
+	sh6054, _ := osgrid.ParseGridRef("SH 60000 54000")
+	if tile.BottomLeft() != sh6054 {
+		t.Errorf("bottomLeft: expected %s, got %s", sh6054, tile.BottomLeft())
+	}
+
+	if tile.Width() != 20 || tile.Height() != 15 || tile.Precision() != 5 {
+		t.Errorf("unexpected dimensions %v x %v @ %v", tile.Width(), tile.Height(), tile.Precision())
+	}
+
+	for row, vals := range testValues {
+		for col, exp := range vals {
+			ref, _ := sh6054.Add(osgrid.Distance(col*5), osgrid.Distance((2-row)*5))
+			v, err := tile.GetFloat64(ref)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if exp == -9999 {
+				if !osdata.IsNoData(v) {
+					t.Errorf("(%d, %d): expected NoData, got %v", col, row, v)
+				}
+			} else if v != exp {
+				t.Errorf("(%d, %d): expected %v, got %v", col, row, exp, v)
+			}
+		}
+	}
+}
+
+func TestReadFloat32(t *testing.T) {
+	strip := &bytes.Buffer{}
+	for _, row := range testValues {
+		for _, v := range row {
+			binary.Write(strip, binary.LittleEndian, float32(v))
+		}
+	}
+
+	entries := append(geoEntries(4, 3, 32, sampleFormatFloat, 260000, 354015, 5), nodataEntry("-9999"))
+	checkTile(t, encodeTIFF(entries, strip.Bytes()))
+}
+
+func TestReadInt16DeflatePredictor(t *testing.T) {
+	strip := &bytes.Buffer{}
+	for _, row := range testValues {
+		prev := int16(0)
+		for _, v := range row {
+			binary.Write(strip, binary.LittleEndian, int16(v)-prev)
+			prev = int16(v)
+		}
+	}
+
+	entries := append(geoEntries(4, 3, 16, sampleFormatInt, 260000, 354015, 5),
+		nodataEntry("-9999"),
+		shortEntry(compressionTag, compressionDeflate),
+		shortEntry(predictorTag, predictorHorizontal),
+	)
+	checkTile(t, encodeTIFF(entries, deflate(strip.Bytes())))
+}
+
+func TestReadFloat32FloatingPointPredictor(t *testing.T) {
+	strip := []byte{}
+	for _, row := range testValues {
+		// Split into byte planes, most significant first
+		planes := make([]byte, len(row)*4)
+		for x, v := range row {
+			var b [4]byte
+			binary.BigEndian.PutUint32(b[:], math.Float32bits(float32(v)))
+			for i := range b {
+				planes[i*len(row)+x] = b[i]
+			}
+		}
+
+		// Then difference
+		for i := len(planes) - 1; i > 0; i-- {
+			planes[i] -= planes[i-1]
+		}
+		strip = append(strip, planes...)
+	}
+
+	entries := append(geoEntries(4, 3, 32, sampleFormatFloat, 260000, 354015, 5),
+		nodataEntry("-9999"),
+		shortEntry(compressionTag, compressionDeflate),
+		shortEntry(predictorTag, predictorFloatingPoint),
+	)
+	checkTile(t, encodeTIFF(entries, deflate(strip)))
+}
+
+func TestReadPixelIsPoint(t *testing.T) {
+	strip := &bytes.Buffer{}
+	for _, row := range testValues {
+		for _, v := range row {
+			binary.Write(strip, binary.LittleEndian, float32(v))
+		}
+	}
+
+	// The tie point is the centre of the top-left pixel
+	entries := append(geoEntries(4, 3, 32, sampleFormatFloat, 260002.5, 354012.5, 5),
+		nodataEntry("-9999"),
+		geoKeysEntry(modelTypeProjected, rasterPixelIsPoint, epsgBritishNationalGrid),
+	)
+	checkTile(t, encodeTIFF(entries, strip.Bytes()))
+}
+
+func TestReadUnsupported(t *testing.T) {
+	entries := append(geoEntries(4, 3, 32, sampleFormatFloat, 260000, 354015, 5),
+		shortEntry(compressionTag, 7))
+	if _, err := ReadTile(bytes.NewReader(encodeTIFF(entries, make([]byte, 48)))); err == nil {
+		t.Error("expected error for JPEG compression")
+	}
+
+	entries = geoEntries(4, 3, 32, sampleFormatFloat, 260000, 354015, 2.5)
+	if _, err := ReadTile(bytes.NewReader(encodeTIFF(entries, make([]byte, 48)))); err == nil {
+		t.Error("expected error for fractional pixel size")
+	}
+
+	for name, entries := range map[string][]ifdEntry{
+		"fractional origin": geoEntries(4, 3, 32, sampleFormatFloat, 260000.5, 354015, 5),
+		"fractional PixelIsPoint origin": append(geoEntries(4, 3, 32, sampleFormatFloat, 260000, 354015, 5),
+			geoKeysEntry(modelTypeProjected, rasterPixelIsPoint, epsgBritishNationalGrid)),
+		"UTM": append(geoEntries(4, 3, 32, sampleFormatFloat, 260000, 354015, 5),
+			geoKeysEntry(modelTypeProjected, rasterPixelIsArea, 32630)),
+		"geographic": append(geoEntries(4, 3, 32, sampleFormatFloat, 260000, 354015, 5),
+			geoKeysEntry(2, rasterPixelIsArea, 0)),
+	} {
+		if _, err := ReadTile(bytes.NewReader(encodeTIFF(entries, make([]byte, 48)))); err == nil {
+			t.Errorf("%s: expected error", name)
+		}
+	}
+}
+
+func TestDatabase(t *testing.T) {
+	dir, err := ioutil.TempDir("", "geotiff")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// A single strip of uint16, 100 m wide and 10 m tall
+	strip := &bytes.Buffer{}
+	for y := 0; y < 2; y++ {
+		for x := 0; x < 20; x++ {
+			binary.Write(strip, binary.LittleEndian, uint16(x))
+		}
+	}
+
+	entries := geoEntries(20, 2, 16, sampleFormatUint, 260000, 354010, 5)
+	err = ioutil.WriteFile(filepath.Join(dir, "dem.tif"), encodeTIFF(entries, strip.Bytes()), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// And a smaller one, North-East of it
+	strip = &bytes.Buffer{}
+	binary.Write(strip, binary.LittleEndian, []uint16{7, 7, 7, 7})
+
+	entries = geoEntries(2, 2, 16, sampleFormatUint, 260100, 354020, 5)
+	err = ioutil.WriteFile(filepath.Join(dir, "corner.tif"), encodeTIFF(entries, strip.Bytes()), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	db, err := OpenDatabase(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if db.Precision() != 5 {
+		t.Errorf("expected precision 5, got %v", db.Precision())
+	}
+
+	ref, _ := osgrid.ParseGridRef("SH 60052 54003")
+	v, err := db.GetFloat64(ref)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if v != 10 {
+		t.Errorf("expected 10, got %v", v)
+	}
+
+	ref, _ = osgrid.ParseGridRef("SH 60109 54019")
+	v, err = db.GetFloat64(ref)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if v != 7 {
+		t.Errorf("expected 7, got %v", v)
+	}
+
+	ref, _ = osgrid.ParseGridRef("SH 60100 54000")
+	_, err = db.GetFloat64(ref)
+	if !errors.Is(err, osdata.ErrTileNotFound) {
+		t.Errorf("expected ErrTileNotFound, got %v", err)
+	}
+}
+
+func TestSharedCorner(t *testing.T) {
+	dir, err := ioutil.TempDir("", "geotiff")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// 100 m x 10 m, and 10 m x 20 m, both with their corner at SH 60000 54000
+	strip := &bytes.Buffer{}
+	for i := 0; i < 20*2; i++ {
+		binary.Write(strip, binary.LittleEndian, uint16(1))
+	}
+
+	entries := geoEntries(20, 2, 16, sampleFormatUint, 260000, 354010, 5)
+	err = ioutil.WriteFile(filepath.Join(dir, "wide.tif"), encodeTIFF(entries, strip.Bytes()), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	strip = &bytes.Buffer{}
+	for i := 0; i < 2*4; i++ {
+		binary.Write(strip, binary.LittleEndian, uint16(2))
+	}
+
+	entries = geoEntries(2, 4, 16, sampleFormatUint, 260000, 354020, 5)
+	err = ioutil.WriteFile(filepath.Join(dir, "tall.tif"), encodeTIFF(entries, strip.Bytes()), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	db, err := OpenDatabase(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		ref string
+		exp float64
+	}{
+		{"SH 60052 54003", 1},
+		{"SH 60005 54015", 2},
+		{"SH 60095 54005", 1},
+	} {
+		ref, _ := osgrid.ParseGridRef(tc.ref)
+		v, err := db.GetFloat64(ref)
+		if err != nil || v != tc.exp {
+			t.Errorf("%s: expected %v, got %v, %v", tc.ref, tc.exp, v, err)
+		}
+	}
+}
+
+func TestFillPartialSquare(t *testing.T) {
+	dir, err := ioutil.TempDir("", "geotiff")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// 100 m x 10 m in the South-West corner of SH 60 54
+	strip := &bytes.Buffer{}
+	for y := 0; y < 2; y++ {
+		for x := 0; x < 20; x++ {
+			binary.Write(strip, binary.LittleEndian, uint16(x))
+		}
+	}
+
+	entries := geoEntries(20, 2, 16, sampleFormatUint, 260000, 354010, 5)
+	err = ioutil.WriteFile(filepath.Join(dir, "dem.tif"), encodeTIFF(entries, strip.Bytes()), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	db, err := OpenDatabase(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	filled := osdata.FillMissingTiles(db, osgrid.Kilometre, osdata.MissingTileFillConstant, -1)
+
+	// Missing, but in the same 1 km square as the data
+	for i := 0; i < 2; i++ {
+		ref, _ := osgrid.ParseGridRef("SH 60500 54500")
+		if v, err := filled.GetFloat64(ref); err != nil || v != -1 {
+			t.Errorf("expected -1, got %v, %v", v, err)
+		}
+
+		ref, _ = osgrid.ParseGridRef("SH 60052 54003")
+		if v, err := filled.GetFloat64(ref); err != nil || v != 10 {
+			t.Errorf("expected 10, got %v, %v", v, err)
+		}
+
+		tile, err := filled.GetFloat64Tile(ref)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if tile.Width() != 100 || tile.Height() != 10 {
+			t.Errorf("expected the GeoTIFF tile, got %v x %v", tile.Width(), tile.Height())
+		}
+	}
+
+	sh6154, _ := osgrid.ParseGridRef("SH 61000 54000")
+	if db.(*Database).HasData(osgrid.Rect{BottomLeft: sh6154, Width: osgrid.Kilometre, Height: osgrid.Kilometre}) {
+		t.Errorf("expected no data in SH 61 54")
+	}
+}
+
+func TestWrite(t *testing.T) {
+	strip := &bytes.Buffer{}
+	for _, row := range testValues {
+		for _, v := range row {
+			binary.Write(strip, binary.LittleEndian, float32(v))
+		}
+	}
+
+	entries := append(geoEntries(4, 3, 32, sampleFormatFloat, 260000, 354015, 5), nodataEntry("-9999"))
+	tile, err := ReadTile(bytes.NewReader(encodeTIFF(entries, strip.Bytes())))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	buf := &bytes.Buffer{}
+	err = Write(buf, tile)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	checkTile(t, buf.Bytes())
+}
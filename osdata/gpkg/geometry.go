@@ -0,0 +1,261 @@
+package gpkg
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+
+	"github.com/usedbytes/osgrid/osdata/vector"
+)
+
+const (
+	gpHeaderSize = 8
+
+	gpFlagEmpty    = 0x10
+	gpFlagExtended = 0x20
+)
+
+// Envelope size in bytes, by the envelope contents indicator
+var gpEnvelopeSizes = []int{0, 32, 48, 48, 64}
+
+// WKB geometry types
+const (
+	wkbPoint           = 1
+	wkbLineString      = 2
+	wkbPolygon         = 3
+	wkbMultiPoint      = 4
+	wkbMultiLineString = 5
+	wkbMultiPolygon    = 6
+)
+
+// Parse a GeoPackage binary geometry, which is a header followed by the
+// geometry in Well-Known Binary. Empty geometries are returned as nil.
+//
+// Points become vector.MultiPoint, line strings become vector.MultiLineString
+// and polygons become vector.Polygon. Multi-polygons are a single
+// vector.Polygon with all of the rings. Z and M values are ignored.
+func parseGeometry(b []byte) (vector.Geometry, error) {
+	if len(b) < gpHeaderSize || b[0] != 'G' || b[1] != 'P' {
+		return nil, fmt.Errorf("not a GeoPackage geometry")
+	}
+
+	flags := b[3]
+	if flags&gpFlagExtended != 0 {
+		return nil, fmt.Errorf("extended geometry types aren't supported")
+	}
+
+	if flags&gpFlagEmpty != 0 {
+		return nil, nil
+	}
+
+	envelope := int(flags>>1) & 0x7
+	if envelope >= len(gpEnvelopeSizes) {
+		return nil, fmt.Errorf("invalid envelope type %d", envelope)
+	}
+
+	offset := gpHeaderSize + gpEnvelopeSizes[envelope]
+	if len(b) < offset {
+		return nil, fmt.Errorf("geometry too short")
+	}
+
+	r := &wkbReader{b: b[offset:]}
+
+	return r.geometry()
+}
+
+type wkbReader struct {
+	b []byte
+}
+
+func (r *wkbReader) take(n int) ([]byte, error) {
+	if n < 0 || n > len(r.b) {
+		return nil, fmt.Errorf("WKB too short")
+	}
+
+	b := r.b[:n]
+	r.b = r.b[n:]
+
+	return b, nil
+}
+
+// Read a geometry's byte order and type, returning the type without its
+// dimensions, and the number of values per point
+func (r *wkbReader) header() (binary.ByteOrder, uint32, int, error) {
+	b, err := r.take(5)
+	if err != nil {
+		return nil, 0, 0, err
+	}
+
+	var order binary.ByteOrder = binary.BigEndian
+	if b[0] == 1 {
+		order = binary.LittleEndian
+	}
+
+	typ := order.Uint32(b[1:])
+	dims := 2
+
+	// Extended WKB flags, as written by PostGIS
+	if typ&0x80000000 != 0 {
+		dims++
+	}
+	if typ&0x40000000 != 0 {
+		dims++
+	}
+	typ &= 0x0fffffff
+
+	// ISO WKB: 1000 for Z, 2000 for M and 3000 for ZM
+	switch typ / 1000 {
+	case 1, 2:
+		dims++
+	case 3:
+		dims += 2
+	}
+
+	return order, typ % 1000, dims, nil
+}
+
+func (r *wkbReader) count(order binary.ByteOrder, size int) (int, error) {
+	b, err := r.take(4)
+	if err != nil {
+		return 0, err
+	}
+
+	n := int(order.Uint32(b))
+	if n*size > len(r.b) {
+		return 0, fmt.Errorf("WKB too short for %d items", n)
+	}
+
+	return n, nil
+}
+
+// Read a list of points. Empty points (NaN) are dropped.
+func (r *wkbReader) points(order binary.ByteOrder, dims, n int) ([]vector.Point, error) {
+	b, err := r.take(n * dims * 8)
+	if err != nil {
+		return nil, err
+	}
+
+	pts := make([]vector.Point, 0, n)
+	for i := 0; i < n; i++ {
+		p := b[i*dims*8:]
+		e := math.Float64frombits(order.Uint64(p[0:8]))
+		n := math.Float64frombits(order.Uint64(p[8:16]))
+		if math.IsNaN(e) || math.IsNaN(n) {
+			continue
+		}
+
+		pts = append(pts, vector.Point{E: e, N: n})
+	}
+
+	return pts, nil
+}
+
+func (r *wkbReader) lineString(order binary.ByteOrder, dims int) ([]vector.Point, error) {
+	n, err := r.count(order, dims*8)
+	if err != nil {
+		return nil, err
+	}
+
+	return r.points(order, dims, n)
+}
+
+func (r *wkbReader) polygon(order binary.ByteOrder, dims int) ([][]vector.Point, error) {
+	n, err := r.count(order, 4)
+	if err != nil {
+		return nil, err
+	}
+
+	rings := make([][]vector.Point, 0, n)
+	for i := 0; i < n; i++ {
+		ring, err := r.lineString(order, dims)
+		if err != nil {
+			return nil, err
+		}
+
+		rings = append(rings, ring)
+	}
+
+	return rings, nil
+}
+
+func (r *wkbReader) geometry() (vector.Geometry, error) {
+	order, typ, dims, err := r.header()
+	if err != nil {
+		return nil, err
+	}
+
+	switch typ {
+	case wkbPoint:
+		pts, err := r.points(order, dims, 1)
+		if err != nil {
+			return nil, err
+		}
+
+		return &vector.MultiPoint{Points: pts}, nil
+	case wkbLineString:
+		line, err := r.lineString(order, dims)
+		if err != nil {
+			return nil, err
+		}
+
+		return &vector.MultiLineString{Lines: [][]vector.Point{line}}, nil
+	case wkbPolygon:
+		rings, err := r.polygon(order, dims)
+		if err != nil {
+			return nil, err
+		}
+
+		return &vector.Polygon{Rings: rings}, nil
+	case wkbMultiPoint, wkbMultiLineString, wkbMultiPolygon:
+		return r.multi(order, typ)
+	}
+
+	return nil, fmt.Errorf("unsupported geometry type %d", typ)
+}
+
+// Read a multi-geometry, where each member is a complete WKB geometry of the
+// corresponding single type, and combine them
+func (r *wkbReader) multi(order binary.ByteOrder, typ uint32) (vector.Geometry, error) {
+	// Each member has at least a header
+	n, err := r.count(order, 5)
+	if err != nil {
+		return nil, err
+	}
+
+	var points []vector.Point
+	var lines [][]vector.Point
+
+	for i := 0; i < n; i++ {
+		g, err := r.geometry()
+		if err != nil {
+			return nil, err
+		}
+
+		switch m := g.(type) {
+		case *vector.MultiPoint:
+			if typ != wkbMultiPoint {
+				return nil, fmt.Errorf("point in geometry type %d", typ)
+			}
+			points = append(points, m.Points...)
+		case *vector.MultiLineString:
+			if typ != wkbMultiLineString {
+				return nil, fmt.Errorf("line string in geometry type %d", typ)
+			}
+			lines = append(lines, m.Lines...)
+		case *vector.Polygon:
+			if typ != wkbMultiPolygon {
+				return nil, fmt.Errorf("polygon in geometry type %d", typ)
+			}
+			lines = append(lines, m.Rings...)
+		}
+	}
+
+	switch typ {
+	case wkbMultiPoint:
+		return &vector.MultiPoint{Points: points}, nil
+	case wkbMultiLineString:
+		return &vector.MultiLineString{Lines: lines}, nil
+	}
+
+	return &vector.Polygon{Rings: lines}, nil
+}
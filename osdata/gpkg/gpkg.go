@@ -0,0 +1,173 @@
+// Package gpkg reads OGC GeoPackages, which newer OS OpenData products such
+// as OS Open Zoomstack, OS Open Rivers and OS Open Roads are distributed as.
+//
+// A GeoPackage is an SQLite database. Its vector layers are read as
+// vector.Features, using each layer's R-tree spatial index for searches, and
+// its tiled raster layers are provided as osdata.ImageDatabases.
+package gpkg
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/usedbytes/osgrid/osdata/internal/sqlite"
+	"github.com/usedbytes/osgrid/osdata/vector"
+)
+
+var ErrNotFound = errors.New("not found")
+
+// Values of Content.DataType
+const (
+	DataTypeFeatures = "features"
+	DataTypeTiles    = "tiles"
+)
+
+// Content describes one of the layers in a GeoPackage
+type Content struct {
+	TableName   string
+	DataType    string
+	Identifier  string
+	Description string
+	// Bounds of the layer's data, empty if they aren't given
+	Bounds vector.Bounds
+	SRSID  int
+}
+
+// GeoPackage is an open GeoPackage file
+type GeoPackage struct {
+	db   *sql.DB
+	path string
+}
+
+// Open opens the GeoPackage at path, read-only
+func Open(path string) (*GeoPackage, error) {
+	db, err := sqlite.OpenReadOnly(path)
+	if err != nil {
+		return nil, err
+	}
+
+	g := &GeoPackage{
+		db:   db,
+		path: path,
+	}
+
+	if _, err := g.Contents(); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("%s: not a GeoPackage: %w", path, err)
+	}
+
+	return g, nil
+}
+
+func (g *GeoPackage) Close() error {
+	return g.db.Close()
+}
+
+func (g *GeoPackage) String() string {
+	return g.path
+}
+
+// Quote an SQL identifier, such as a table name
+func quote(name string) string {
+	return `"` + strings.ReplaceAll(name, `"`, `""`) + `"`
+}
+
+func scanContent(rows *sql.Rows) (Content, error) {
+	var c Content
+	var identifier, description sql.NullString
+	var minX, minY, maxX, maxY sql.NullFloat64
+	var srsID sql.NullInt64
+
+	err := rows.Scan(&c.TableName, &c.DataType, &identifier, &description,
+		&minX, &minY, &maxX, &maxY, &srsID)
+	if err != nil {
+		return c, err
+	}
+
+	c.Identifier = identifier.String
+	c.Description = description.String
+	c.SRSID = int(srsID.Int64)
+
+	c.Bounds = vector.EmptyBounds()
+	if minX.Valid && minY.Valid && maxX.Valid && maxY.Valid {
+		c.Bounds = vector.Bounds{
+			Min: vector.Point{E: minX.Float64, N: minY.Float64},
+			Max: vector.Point{E: maxX.Float64, N: maxY.Float64},
+		}
+	}
+
+	return c, nil
+}
+
+const contentsQuery = `SELECT table_name, data_type, identifier, description,
+	min_x, min_y, max_x, max_y, srs_id FROM gpkg_contents`
+
+// Contents lists the layers in the GeoPackage, by table name
+func (g *GeoPackage) Contents() ([]Content, error) {
+	rows, err := g.db.Query(contentsQuery + ` ORDER BY table_name`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var contents []Content
+	for rows.Next() {
+		c, err := scanContent(rows)
+		if err != nil {
+			return nil, err
+		}
+
+		contents = append(contents, c)
+	}
+
+	return contents, rows.Err()
+}
+
+// Content describes the layer in table (case-insensitive)
+func (g *GeoPackage) Content(table string) (Content, error) {
+	rows, err := g.db.Query(contentsQuery+` WHERE table_name = ? COLLATE NOCASE`, table)
+	if err != nil {
+		return Content{}, err
+	}
+	defer rows.Close()
+
+	if !rows.Next() {
+		if err := rows.Err(); err != nil {
+			return Content{}, err
+		}
+
+		return Content{}, fmt.Errorf("layer '%s' %w", table, ErrNotFound)
+	}
+
+	return scanContent(rows)
+}
+
+// Check that a spatial reference system is British National Grid
+// (EPSG:27700)
+func (g *GeoPackage) checkSRS(srsID int) error {
+	var name, organization, definition sql.NullString
+	var orgID sql.NullInt64
+
+	err := g.db.QueryRow(`SELECT srs_name, organization, organization_coordsys_id, definition
+		FROM gpkg_spatial_ref_sys WHERE srs_id = ?`, srsID).Scan(&name, &organization, &orgID, &definition)
+	if errors.Is(err, sql.ErrNoRows) {
+		return fmt.Errorf("spatial reference system %d %w", srsID, ErrNotFound)
+	} else if err != nil {
+		return err
+	}
+
+	if strings.EqualFold(organization.String, "EPSG") && orgID.Int64 == 27700 {
+		return nil
+	}
+
+	// Different tools write quite different WKT, so just look for the name
+	def := strings.NewReplacer(" ", "", "_", "").Replace(strings.ToLower(definition.String))
+	if strings.Contains(def, "britishnationalgrid") {
+		return nil
+	}
+
+	return fmt.Errorf("spatial reference system %d (%s) is not British National Grid (EPSG:27700)",
+		srsID, name.String)
+}
@@ -0,0 +1,513 @@
+package gpkg
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/binary"
+	"errors"
+	"image"
+	"image/color"
+	"image/png"
+	"math"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/usedbytes/osgrid"
+	"github.com/usedbytes/osgrid/osdata"
+	"github.com/usedbytes/osgrid/osdata/vector"
+	"github.com/usedbytes/osgrid/osdata/vector/vectortest"
+	"github.com/usedbytes/osgrid/osgridtest"
+)
+
+// Encode WKB (little-endian), with ISO Z coordinates if z is set
+type wkbWriter struct {
+	bytes.Buffer
+	z bool
+}
+
+func (w *wkbWriter) header(typ uint32) {
+	if w.z {
+		typ += 1000
+	}
+
+	w.WriteByte(1)
+	binary.Write(w, binary.LittleEndian, typ)
+}
+
+func (w *wkbWriter) points(pts []vector.Point) {
+	for _, p := range pts {
+		binary.Write(w, binary.LittleEndian, p.E)
+		binary.Write(w, binary.LittleEndian, p.N)
+		if w.z {
+			binary.Write(w, binary.LittleEndian, 123.0)
+		}
+	}
+}
+
+func (w *wkbWriter) count(n int) {
+	binary.Write(w, binary.LittleEndian, uint32(n))
+}
+
+func (w *wkbWriter) lineString(pts []vector.Point) {
+	w.header(wkbLineString)
+	w.count(len(pts))
+	w.points(pts)
+}
+
+func (w *wkbWriter) polygon(rings [][]vector.Point) {
+	w.header(wkbPolygon)
+	w.count(len(rings))
+	for _, r := range rings {
+		w.count(len(r))
+		w.points(r)
+	}
+}
+
+// Wrap WKB in a GeoPackage header, with an XY envelope
+func gpkgGeometry(wkb []byte, bounds vector.Bounds) []byte {
+	buf := &bytes.Buffer{}
+	buf.Write([]byte{'G', 'P', 0, 1 | 1<<1})
+	binary.Write(buf, binary.LittleEndian, int32(27700))
+	binary.Write(buf, binary.LittleEndian, []float64{bounds.Min.E, bounds.Max.E, bounds.Min.N, bounds.Max.N})
+	buf.Write(wkb)
+
+	return buf.Bytes()
+}
+
+const schema = `
+CREATE TABLE gpkg_spatial_ref_sys (srs_name TEXT NOT NULL, srs_id INTEGER PRIMARY KEY,
+	organization TEXT NOT NULL, organization_coordsys_id INTEGER NOT NULL,
+	definition TEXT NOT NULL, description TEXT);
+INSERT INTO gpkg_spatial_ref_sys VALUES
+	('OSGB36 / British National Grid', 27700, 'EPSG', 27700, 'PROJCS["OSGB 1936 / British National Grid"]', NULL),
+	('Custom BNG', 100000, 'NONE', 100000, 'PROJCS["British_National_Grid",GEOGCS["GCS_OSGB_1936"]]', NULL),
+	('WGS 84', 4326, 'EPSG', 4326, 'GEOGCS["WGS 84"]', NULL);
+CREATE TABLE gpkg_contents (table_name TEXT NOT NULL PRIMARY KEY, data_type TEXT NOT NULL,
+	identifier TEXT UNIQUE, description TEXT DEFAULT '', last_change DATETIME,
+	min_x DOUBLE, min_y DOUBLE, max_x DOUBLE, max_y DOUBLE, srs_id INTEGER);
+CREATE TABLE gpkg_geometry_columns (table_name TEXT NOT NULL, column_name TEXT NOT NULL,
+	geometry_type_name TEXT NOT NULL, srs_id INTEGER NOT NULL, z TINYINT NOT NULL, m TINYINT NOT NULL);
+CREATE TABLE gpkg_tile_matrix_set (table_name TEXT NOT NULL PRIMARY KEY, srs_id INTEGER NOT NULL,
+	min_x DOUBLE NOT NULL, min_y DOUBLE NOT NULL, max_x DOUBLE NOT NULL, max_y DOUBLE NOT NULL);
+CREATE TABLE gpkg_tile_matrix (table_name TEXT NOT NULL, zoom_level INTEGER NOT NULL,
+	matrix_width INTEGER NOT NULL, matrix_height INTEGER NOT NULL, tile_width INTEGER NOT NULL,
+	tile_height INTEGER NOT NULL, pixel_x_size DOUBLE NOT NULL, pixel_y_size DOUBLE NOT NULL);
+
+INSERT INTO gpkg_contents VALUES
+	('roads', 'features', 'Roads', 'Road links', NULL, 500000, 200000, 520000, 220000, 27700),
+	('rivers', 'features', 'Rivers', NULL, NULL, NULL, NULL, NULL, NULL, 100000),
+	('wgs', 'features', 'WGS', NULL, NULL, NULL, NULL, NULL, NULL, 4326),
+	('raster', 'tiles', 'Raster', NULL, NULL, 500000, 200000, 500200, 200200, 27700);
+INSERT INTO gpkg_geometry_columns VALUES
+	('roads', 'geom', 'GEOMETRY', 27700, 0, 0),
+	('rivers', 'shape', 'MULTILINESTRING', 100000, 1, 0),
+	('wgs', 'geom', 'POINT', 4326, 0, 0);
+
+CREATE TABLE roads (fid INTEGER PRIMARY KEY AUTOINCREMENT, geom BLOB, name TEXT, class TEXT, length REAL);
+CREATE VIRTUAL TABLE rtree_roads_geom USING rtree(id, minx, maxx, miny, maxy);
+CREATE TABLE rivers (ogc_fid INTEGER PRIMARY KEY, shape BLOB, name TEXT);
+CREATE TABLE wgs (fid INTEGER PRIMARY KEY, geom BLOB);
+
+INSERT INTO gpkg_tile_matrix_set VALUES ('raster', 27700, 500000, 200000, 500200, 200200);
+INSERT INTO gpkg_tile_matrix VALUES
+	('raster', 0, 1, 1, 100, 100, 2, 2),
+	('raster', 1, 2, 2, 100, 100, 1, 1);
+CREATE TABLE raster (id INTEGER PRIMARY KEY AUTOINCREMENT, zoom_level INTEGER NOT NULL,
+	tile_column INTEGER NOT NULL, tile_row INTEGER NOT NULL, tile_data BLOB NOT NULL);
+`
+
+// Colours of the zoom level 1 tiles, by row and column. The bottom-right
+// tile is missing.
+var tileColours = [2][2]color.RGBA{
+	{{255, 0, 0, 255}, {0, 255, 0, 255}},
+	{{0, 0, 255, 255}, {}},
+}
+
+func writeTestGeoPackage(t *testing.T) string {
+	path := filepath.Join(t.TempDir(), "test.gpkg")
+
+	db, err := sql.Open("sqlite", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	exec := func(query string, args ...interface{}) {
+		if _, err := db.Exec(query, args...); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	exec(schema)
+
+	roads := []struct {
+		name, class string
+		wkb         func(w *wkbWriter)
+	}{
+		{"A1", "A Road", func(w *wkbWriter) {
+			w.lineString([]vector.Point{{E: 500000, N: 200000}, {E: 505000, N: 205000}})
+		}},
+		{"B2", "", func(w *wkbWriter) {
+			w.lineString([]vector.Point{{E: 515000, N: 215000}, {E: 520000, N: 220000}})
+		}},
+		{"Roundabout", "", func(w *wkbWriter) {
+			// Multi-polygon, with a hole in the first polygon
+			w.header(wkbMultiPolygon)
+			w.count(2)
+			w.polygon([][]vector.Point{vectortest.Square(510000, 210000, 100), vectortest.Square(510025, 210025, 50)})
+			w.polygon([][]vector.Point{vectortest.Square(510200, 210000, 10)})
+		}},
+		{"Junction", "", func(w *wkbWriter) {
+			w.z = true
+			w.header(wkbPoint)
+			w.points([]vector.Point{{E: 512345.5, N: 212345.5}})
+		}},
+	}
+
+	for _, r := range roads {
+		w := &wkbWriter{}
+		r.wkb(w)
+
+		geom, err := parseGeometry(gpkgGeometry(w.Bytes(), vector.EmptyBounds()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		b := geom.Bounds()
+
+		var class interface{}
+		if r.class != "" {
+			class = r.class
+		}
+
+		res, err := db.Exec(`INSERT INTO roads (geom, name, class, length) VALUES (?, ?, ?, ?)`,
+			gpkgGeometry(w.Bytes(), b), r.name, class, 1.5)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		id, _ := res.LastInsertId()
+		exec(`INSERT INTO rtree_roads_geom VALUES (?, ?, ?, ?, ?)`, id, b.Min.E, b.Max.E, b.Min.N, b.Max.N)
+	}
+
+	// An empty geometry, which isn't in the index
+	exec(`INSERT INTO roads (geom, name) VALUES (?, 'Nowhere')`,
+		[]byte{'G', 'P', 0, 1 | gpFlagEmpty, 0, 0, 0, 0})
+
+	w := &wkbWriter{z: true}
+	w.header(wkbMultiLineString)
+	w.count(2)
+	w.lineString([]vector.Point{{E: 501000, N: 201000}, {E: 501000, N: 202000}})
+	w.lineString([]vector.Point{{E: 501000, N: 202000}, {E: 502000, N: 202000}})
+	exec(`INSERT INTO rivers VALUES (7, ?, 'River Test')`, gpkgGeometry(w.Bytes(), vector.EmptyBounds()))
+	exec(`INSERT INTO rivers VALUES (8, NULL, 'Lost River')`)
+
+	for row := range tileColours {
+		for col, c := range tileColours[row] {
+			if c.A == 0 {
+				continue
+			}
+
+			img := image.NewRGBA(image.Rect(0, 0, 100, 100))
+			for i := 0; i < len(img.Pix); i += 4 {
+				copy(img.Pix[i:], []uint8{c.R, c.G, c.B, c.A})
+			}
+
+			buf := &bytes.Buffer{}
+			if err := png.Encode(buf, img); err != nil {
+				t.Fatal(err)
+			}
+
+			exec(`INSERT INTO raster (zoom_level, tile_column, tile_row, tile_data) VALUES (1, ?, ?, ?)`,
+				col, row, buf.Bytes())
+		}
+	}
+
+	return path
+}
+
+func openTestGeoPackage(t *testing.T) *GeoPackage {
+	g, err := Open(writeTestGeoPackage(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { g.Close() })
+
+	return g
+}
+
+func featureNames(fs []*vector.Feature) []string {
+	var names []string
+	for _, f := range fs {
+		names = append(names, f.StringAttr("name"))
+	}
+	return names
+}
+
+func TestContents(t *testing.T) {
+	g := openTestGeoPackage(t)
+
+	contents, err := g.Contents()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var tables []string
+	for _, c := range contents {
+		tables = append(tables, c.TableName)
+	}
+
+	if !reflect.DeepEqual(tables, []string{"raster", "rivers", "roads", "wgs"}) {
+		t.Errorf("unexpected tables %v", tables)
+	}
+
+	c, err := g.Content("ROADS")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	exp := Content{
+		TableName:   "roads",
+		DataType:    DataTypeFeatures,
+		Identifier:  "Roads",
+		Description: "Road links",
+		Bounds:      vector.Bounds{Min: vector.Point{E: 500000, N: 200000}, Max: vector.Point{E: 520000, N: 220000}},
+		SRSID:       27700,
+	}
+	if c != exp {
+		t.Errorf("expected %v, got %v", exp, c)
+	}
+
+	if c, err := g.Content("rivers"); err != nil || !c.Bounds.Empty() {
+		t.Errorf("expected empty bounds, got %v (%v)", c.Bounds, err)
+	}
+
+	if _, err := g.Content("railways"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("expected ErrNotFound, got %v", err)
+	}
+}
+
+func TestOpenErrors(t *testing.T) {
+	dir := t.TempDir()
+
+	if _, err := Open(filepath.Join(dir, "missing.gpkg")); err == nil {
+		t.Errorf("expected error for missing file")
+	}
+
+	path := filepath.Join(dir, "empty.sqlite")
+	db, err := sql.Open("sqlite", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Exec(`CREATE TABLE things (id INTEGER)`); err != nil {
+		t.Fatal(err)
+	}
+	db.Close()
+
+	if _, err := Open(path); err == nil {
+		t.Errorf("expected error for non-GeoPackage")
+	}
+
+	g := openTestGeoPackage(t)
+
+	if _, err := g.Layer("wgs"); err == nil {
+		t.Errorf("expected error for WGS84 layer")
+	}
+
+	if _, err := g.Layer("raster"); err == nil {
+		t.Errorf("expected error for tiles as features")
+	}
+
+	if _, err := g.TileDatabase("roads"); err == nil {
+		t.Errorf("expected error for features as tiles")
+	}
+
+	if _, err := g.TileDatabase("raster", ZoomLevelOpt(5)); !errors.Is(err, ErrNotFound) {
+		t.Errorf("expected ErrNotFound for missing zoom level, got %v", err)
+	}
+}
+
+func TestLayer(t *testing.T) {
+	g := openTestGeoPackage(t)
+
+	l, err := g.Layer("roads")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if l.GeometryColumn != "geom" || l.rtree != "rtree_roads_geom" {
+		t.Errorf("unexpected layer %+v", l)
+	}
+
+	all, err := l.ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if names := featureNames(all); !reflect.DeepEqual(names, []string{"A1", "B2", "Roundabout", "Junction", "Nowhere"}) {
+		t.Fatalf("unexpected features %v", names)
+	}
+
+	a1 := all[0]
+	expAttrs := map[string]interface{}{"name": "A1", "class": "A Road", "length": 1.5}
+	if a1.ID != 1 || !reflect.DeepEqual(a1.Attributes, expAttrs) {
+		t.Errorf("unexpected feature %d %v", a1.ID, a1.Attributes)
+	}
+
+	if l, ok := a1.Geometry.(*vector.MultiLineString); !ok || l.Length() != math.Hypot(5000, 5000) {
+		t.Errorf("unexpected geometry %#v", a1.Geometry)
+	}
+
+	roundabout, ok := all[2].Geometry.(*vector.Polygon)
+	if !ok || len(roundabout.Rings) != 3 {
+		t.Fatalf("unexpected geometry %#v", all[2].Geometry)
+	}
+
+	for _, tc := range []struct {
+		p      vector.Point
+		inside bool
+	}{
+		{vector.Point{E: 510010, N: 210010}, true},
+		{vector.Point{E: 510050, N: 210050}, false},
+		{vector.Point{E: 510205, N: 210005}, true},
+	} {
+		if roundabout.Contains(tc.p) != tc.inside {
+			t.Errorf("Contains(%v): expected %v", tc.p, tc.inside)
+		}
+	}
+
+	junction, ok := all[3].Geometry.(*vector.MultiPoint)
+	if !ok || !reflect.DeepEqual(junction.Points, []vector.Point{{E: 512345.5, N: 212345.5}}) {
+		t.Errorf("unexpected geometry %#v", all[3].Geometry)
+	}
+
+	if all[4].Geometry != nil {
+		t.Errorf("expected nil geometry, got %#v", all[4].Geometry)
+	}
+
+	for _, tc := range []struct {
+		r   osgrid.Rect
+		exp []string
+	}{
+		{osgrid.Rect{BottomLeft: osgridtest.MustRef(t, 504000, 204000), Width: 1000, Height: 1000}, []string{"A1"}},
+		{osgrid.Rect{BottomLeft: osgridtest.MustRef(t, 510000, 210000), Width: 5000, Height: 5000}, []string{"B2", "Roundabout", "Junction"}},
+		{osgrid.Rect{BottomLeft: osgridtest.MustRef(t, 510150, 210150), Width: 10, Height: 10}, nil},
+		{osgrid.Rect{BottomLeft: osgridtest.MustRef(t, 600000, 200000), Width: 1000, Height: 1000}, nil},
+	} {
+		found, err := l.Search(tc.r)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if names := featureNames(found); !reflect.DeepEqual(names, tc.exp) {
+			t.Errorf("Search(%v): expected %v, got %v", tc.r, tc.exp, names)
+		}
+	}
+}
+
+func TestLayerWithoutIndex(t *testing.T) {
+	g := openTestGeoPackage(t)
+
+	// In a custom spatial reference system, which is described as British
+	// National Grid
+	l, err := g.Layer("Rivers")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if l.rtree != "" || l.fidColumn != "ogc_fid" {
+		t.Errorf("unexpected layer %+v", l)
+	}
+
+	found, err := l.Search(osgrid.Rect{BottomLeft: osgridtest.MustRef(t, 501500, 201500), Width: 1000, Height: 1000})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(found) != 1 || found[0].ID != 7 || found[0].StringAttr("name") != "River Test" {
+		t.Fatalf("unexpected features %v", found)
+	}
+
+	if m, ok := found[0].Geometry.(*vector.MultiLineString); !ok || len(m.Lines) != 2 || m.Length() != 2000 {
+		t.Errorf("unexpected geometry %#v", found[0].Geometry)
+	}
+
+	if _, ok := found[0].Attributes["ogc_fid"]; ok {
+		t.Errorf("primary key shouldn't be an attribute")
+	}
+}
+
+func TestParseGeometryErrors(t *testing.T) {
+	w := &wkbWriter{}
+	w.lineString([]vector.Point{{E: 1, N: 2}, {E: 3, N: 4}})
+	valid := gpkgGeometry(w.Bytes(), vector.EmptyBounds())
+
+	for _, b := range [][]byte{
+		nil,
+		[]byte("not a geometry"),
+		valid[:len(valid)-4],
+		append(valid[:8:8], 1, 7, 0, 0, 0),
+	} {
+		if _, err := parseGeometry(b); err == nil {
+			t.Errorf("expected error for %v", b)
+		}
+	}
+}
+
+func TestTiles(t *testing.T) {
+	g := openTestGeoPackage(t)
+
+	db, err := g.TileDatabase("raster", TileSizeOpt(100))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if db.ZoomLevel() != 1 || db.Precision() != 1 || db.PixelPrecision() != 1 {
+		t.Errorf("unexpected zoom %d precision %d, %d", db.ZoomLevel(), db.Precision(), db.PixelPrecision())
+	}
+
+	// Tile row 0 is at the top (North)
+	for _, tc := range []struct {
+		e, n osgrid.Distance
+		c    color.RGBA
+	}{
+		{500000, 200100, tileColours[0][0]},
+		{500100, 200100, tileColours[0][1]},
+		{500000, 200000, tileColours[1][0]},
+	} {
+		ref := osgridtest.MustRef(t, tc.e, tc.n)
+		tile, err := db.GetImageTile(ref)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if tile.BottomLeft() != ref {
+			t.Errorf("expected tile at %v, got %v", ref, tile.BottomLeft())
+		}
+
+		img := tile.GetImage()
+		if img.Bounds().Dx() != 100 || img.Bounds().Dy() != 100 {
+			t.Errorf("unexpected image size %v", img.Bounds())
+		}
+
+		if c := color.RGBAModel.Convert(img.At(50, 50)); c != tc.c {
+			t.Errorf("tile %v: expected %v, got %v", ref, tc.c, c)
+		}
+	}
+
+	for _, ref := range []osgrid.GridRef{osgridtest.MustRef(t, 500100, 200000), osgridtest.MustRef(t, 500300, 200000)} {
+		if _, err := db.GetImageTile(ref); !errors.Is(err, osdata.ErrTileNotFound) {
+			t.Errorf("expected ErrTileNotFound for %v, got %v", ref, err)
+		}
+	}
+
+	// 2 m per pixel
+	db, err = g.TileDatabase("raster", ZoomLevelOpt(0))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if db.Precision() != 2 || db.PixelPrecision() != 1 {
+		t.Errorf("unexpected precision %d, %d", db.Precision(), db.PixelPrecision())
+	}
+}
@@ -0,0 +1,210 @@
+package gpkg
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"github.com/usedbytes/osgrid"
+	"github.com/usedbytes/osgrid/osdata/vector"
+)
+
+// Layer is a vector (features) layer
+type Layer struct {
+	Content
+	GeometryColumn string
+
+	gpkg *GeoPackage
+	// The integer primary key, which becomes the features' IDs
+	fidColumn string
+	// R-tree index table, or empty if there isn't one
+	rtree string
+}
+
+// Layer opens the vector layer in table (case-insensitive). The layer must
+// be in British National Grid (EPSG:27700).
+func (g *GeoPackage) Layer(table string) (*Layer, error) {
+	c, err := g.Content(table)
+	if err != nil {
+		return nil, err
+	}
+
+	if c.DataType != DataTypeFeatures {
+		return nil, fmt.Errorf("layer '%s' is %s, not %s", c.TableName, c.DataType, DataTypeFeatures)
+	}
+
+	l := &Layer{
+		Content: c,
+		gpkg:    g,
+	}
+
+	var srsID int
+	err = g.db.QueryRow(`SELECT column_name, srs_id FROM gpkg_geometry_columns
+		WHERE table_name = ?`, c.TableName).Scan(&l.GeometryColumn, &srsID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, fmt.Errorf("geometry column for '%s' %w", c.TableName, ErrNotFound)
+	} else if err != nil {
+		return nil, err
+	}
+
+	if err := g.checkSRS(srsID); err != nil {
+		return nil, fmt.Errorf("layer '%s': %w", c.TableName, err)
+	}
+
+	if l.fidColumn, err = g.primaryKey(c.TableName); err != nil {
+		return nil, err
+	}
+
+	// Written by the "gpkg_rtree_index" extension
+	rtree := "rtree_" + c.TableName + "_" + l.GeometryColumn
+	err = g.db.QueryRow(`SELECT name FROM sqlite_master WHERE type = 'table' AND name = ?`,
+		rtree).Scan(&l.rtree)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return nil, err
+	}
+
+	return l, nil
+}
+
+// Find the integer primary key of table, or fall back to the rowid
+func (g *GeoPackage) primaryKey(table string) (string, error) {
+	rows, err := g.db.Query(`SELECT name, pk FROM pragma_table_info(?)`, table)
+	if err != nil {
+		return "", err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var name string
+		var pk int
+		if err := rows.Scan(&name, &pk); err != nil {
+			return "", err
+		}
+
+		if pk == 1 {
+			return name, nil
+		}
+	}
+
+	return "rowid", rows.Err()
+}
+
+// Run a query returning the primary key and all of the columns of the
+// layer's table, and make features from the rows. If b isn't nil, only
+// features with geometry intersecting it are returned.
+func (l *Layer) query(b *vector.Bounds, query string, args ...interface{}) ([]*vector.Feature, error) {
+	rows, err := l.gpkg.db.Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("layer '%s': %w", l.TableName, err)
+	}
+	defer rows.Close()
+
+	columns, err := rows.Columns()
+	if err != nil {
+		return nil, err
+	}
+
+	var features []*vector.Feature
+	for rows.Next() {
+		values := make([]interface{}, len(columns))
+		ptrs := make([]interface{}, len(columns))
+		for i := range values {
+			ptrs[i] = &values[i]
+		}
+
+		if err := rows.Scan(ptrs...); err != nil {
+			return nil, err
+		}
+
+		f, err := l.feature(columns, values)
+		if err != nil {
+			return nil, err
+		}
+
+		if b != nil && (f.Geometry == nil || !f.Geometry.Bounds().Intersects(*b)) {
+			continue
+		}
+
+		features = append(features, f)
+	}
+
+	return features, rows.Err()
+}
+
+// The first column is the primary key
+func (l *Layer) feature(columns []string, values []interface{}) (*vector.Feature, error) {
+	fid, ok := values[0].(int64)
+	if !ok {
+		return nil, fmt.Errorf("layer '%s': non-integer %s %v", l.TableName, l.fidColumn, values[0])
+	}
+
+	f := &vector.Feature{
+		ID:         fid,
+		Attributes: make(map[string]interface{}, len(columns)-1),
+	}
+
+	for i := 1; i < len(columns); i++ {
+		name, v := columns[i], values[i]
+
+		if name == l.GeometryColumn {
+			if v == nil {
+				continue
+			}
+
+			data, ok := v.([]byte)
+			if !ok {
+				return nil, fmt.Errorf("layer '%s' feature %d: geometry isn't a blob", l.TableName, fid)
+			}
+
+			g, err := parseGeometry(data)
+			if err != nil {
+				return nil, fmt.Errorf("layer '%s' feature %d: %w", l.TableName, fid, err)
+			}
+
+			f.Geometry = g
+			continue
+		}
+
+		if v != nil && name != l.fidColumn {
+			f.Attributes[name] = v
+		}
+	}
+
+	return f, nil
+}
+
+func (l *Layer) selectFrom() string {
+	return fmt.Sprintf(`SELECT t.%s, t.* FROM %s AS t`, quote(l.fidColumn), quote(l.TableName))
+}
+
+// ReadAll reads all of the features in the layer, in ID order. Features
+// with no geometry have a nil Geometry.
+func (l *Layer) ReadAll() ([]*vector.Feature, error) {
+	return l.query(nil, l.selectFrom()+` ORDER BY 1`)
+}
+
+// Search returns the features whose bounding boxes intersect r, in ID order
+//
+// The layer's R-tree index is used to find them, if it has one.
+func (l *Layer) Search(r osgrid.Rect) ([]*vector.Feature, error) {
+	return l.SearchBounds(vector.BoundsFromRect(r))
+}
+
+// SearchBounds is Search, with vector.Bounds
+func (l *Layer) SearchBounds(b vector.Bounds) ([]*vector.Feature, error) {
+	if b.Empty() {
+		return nil, nil
+	}
+
+	if l.rtree == "" {
+		return l.query(&b, l.selectFrom()+` ORDER BY 1`)
+	}
+
+	// The R-tree stores 32-bit floats, rounded outwards, so it can return
+	// a few extra features which query() filters out
+	query := l.selectFrom() + fmt.Sprintf(` JOIN %s AS r ON t.%s = r.id
+		WHERE r.minx <= ? AND r.maxx >= ? AND r.miny <= ? AND r.maxy >= ?
+		ORDER BY 1`, quote(l.rtree), quote(l.fidColumn))
+
+	return l.query(&b, query, b.Max.E, b.Min.E, b.Max.N, b.Min.N)
+}
@@ -0,0 +1,377 @@
+package gpkg
+
+import (
+	"bytes"
+	"database/sql"
+	"errors"
+	"fmt"
+	"image"
+	"image/draw"
+	"math"
+
+	// Image formats which tiles can be in
+	_ "image/jpeg"
+	_ "image/png"
+
+	"github.com/usedbytes/osgrid"
+	"github.com/usedbytes/osgrid/osdata"
+)
+
+var mustBeImageDatabase osdata.ImageDatabase = &TileDatabase{}
+
+// TileMatrix is one zoom level of a tiled raster layer. Tile (0, 0) is at
+// the top-left of the layer's tile matrix set.
+type TileMatrix struct {
+	ZoomLevel    int
+	MatrixWidth  int
+	MatrixHeight int
+	TileWidth    int
+	TileHeight   int
+	// Metres per pixel
+	PixelXSize float64
+	PixelYSize float64
+}
+
+// TileDatabase provides a tiled raster layer, at a single zoom level
+type TileDatabase struct {
+	gpkg  *GeoPackage
+	table string
+	// Top-left corner of the tile matrix set
+	left, top float64
+	zoom      int
+	matrix    TileMatrix
+
+	tileSize       osgrid.Distance
+	precision      osgrid.Distance
+	pixelPrecision int
+	cache          *osdata.Cache
+
+	// Decoded GeoPackage tiles, by row and column, so that neighbouring
+	// database tiles don't have to read them again. A namespace of cache.
+	sources *osdata.Cache
+}
+
+// A decoded GeoPackage tile. img is nil for missing tiles.
+type sourceTile struct {
+	img *image.RGBA
+}
+
+func (t *sourceTile) MemorySize() int {
+	if t.img == nil {
+		// Charged something, so missing tiles can't build up without
+		// limit
+		return 64
+	}
+
+	return len(t.img.Pix)
+}
+
+type TileOpt func(*TileDatabase)
+
+// Use the tile matrix at zoom, instead of the most detailed one
+func ZoomLevelOpt(zoom int) TileOpt {
+	return func(d *TileDatabase) {
+		d.zoom = zoom
+	}
+}
+
+// Size of the database's tiles, default 1 km. Must divide 100 km.
+func TileSizeOpt(size osgrid.Distance) TileOpt {
+	return func(d *TileDatabase) {
+		d.tileSize = size
+	}
+}
+
+// Precision of the database's tiles, and pixels per precision. By default the
+// precision is the largest divisor of the tile size which isn't larger than a
+// GeoPackage pixel, with as many pixels as gets closest to the GeoPackage
+// resolution.
+func PrecisionOpt(precision osgrid.Distance, pixelPrecision int) TileOpt {
+	return func(d *TileDatabase) {
+		d.precision = precision
+		d.pixelPrecision = pixelPrecision
+	}
+}
+
+// Options for the database's tile cache
+func DatabaseOpts(opts ...osdata.DatabaseOpt) TileOpt {
+	return func(d *TileDatabase) {
+		d.cache = osdata.NewDatabaseConfig(opts...).Cache
+	}
+}
+
+// TileMatrices lists the zoom levels of the tiled raster layer in table,
+// from least to most detailed
+func (g *GeoPackage) TileMatrices(table string) ([]TileMatrix, error) {
+	rows, err := g.db.Query(`SELECT zoom_level, matrix_width, matrix_height,
+		tile_width, tile_height, pixel_x_size, pixel_y_size
+		FROM gpkg_tile_matrix WHERE table_name = ? ORDER BY zoom_level`, table)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var matrices []TileMatrix
+	for rows.Next() {
+		var m TileMatrix
+		err := rows.Scan(&m.ZoomLevel, &m.MatrixWidth, &m.MatrixHeight,
+			&m.TileWidth, &m.TileHeight, &m.PixelXSize, &m.PixelYSize)
+		if err != nil {
+			return nil, err
+		}
+
+		matrices = append(matrices, m)
+	}
+
+	return matrices, rows.Err()
+}
+
+// Pick a precision and number of pixels per precision close to resolution
+func choosePrecision(tileSize osgrid.Distance, resolution float64) (osgrid.Distance, int) {
+	precision := osgrid.Distance(1)
+	for p := osgrid.Distance(math.Floor(resolution + 1e-6)); p > 1; p-- {
+		if tileSize%p == 0 {
+			precision = p
+			break
+		}
+	}
+
+	pixelPrecision := int(math.Round(float64(precision) / resolution))
+	if pixelPrecision < 1 {
+		pixelPrecision = 1
+	}
+
+	return precision, pixelPrecision
+}
+
+// TileDatabase opens the tiled raster layer in table (case-insensitive). The
+// layer must be in British National Grid (EPSG:27700).
+func (g *GeoPackage) TileDatabase(table string, opts ...TileOpt) (*TileDatabase, error) {
+	c, err := g.Content(table)
+	if err != nil {
+		return nil, err
+	}
+
+	if c.DataType != DataTypeTiles {
+		return nil, fmt.Errorf("layer '%s' is %s, not %s", c.TableName, c.DataType, DataTypeTiles)
+	}
+
+	d := &TileDatabase{
+		gpkg:     g,
+		table:    c.TableName,
+		zoom:     -1,
+		tileSize: 1 * osgrid.Kilometre,
+	}
+
+	for _, opt := range opts {
+		opt(d)
+	}
+
+	if d.cache == nil {
+		d.cache = osdata.NewDatabaseConfig().Cache
+	}
+	d.sources = d.cache.Share()
+
+	var srsID int
+	err = g.db.QueryRow(`SELECT srs_id, min_x, max_y FROM gpkg_tile_matrix_set
+		WHERE table_name = ?`, d.table).Scan(&srsID, &d.left, &d.top)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, fmt.Errorf("tile matrix set for '%s' %w", d.table, ErrNotFound)
+	} else if err != nil {
+		return nil, err
+	}
+
+	if err := g.checkSRS(srsID); err != nil {
+		return nil, fmt.Errorf("layer '%s': %w", d.table, err)
+	}
+
+	matrices, err := g.TileMatrices(d.table)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(matrices) == 0 {
+		return nil, fmt.Errorf("layer '%s' has no tile matrices", d.table)
+	}
+
+	if d.zoom < 0 {
+		d.matrix = matrices[len(matrices)-1]
+	} else {
+		found := false
+		for _, m := range matrices {
+			if m.ZoomLevel == d.zoom {
+				d.matrix = m
+				found = true
+				break
+			}
+		}
+
+		if !found {
+			return nil, fmt.Errorf("zoom level %d in '%s' %w", d.zoom, d.table, ErrNotFound)
+		}
+	}
+	d.zoom = d.matrix.ZoomLevel
+
+	if d.tileSize <= 0 || (100*osgrid.Kilometre)%d.tileSize != 0 {
+		return nil, fmt.Errorf("tile size (%d) must divide 100 km", d.tileSize)
+	}
+
+	if d.precision == 0 {
+		d.precision, d.pixelPrecision = choosePrecision(d.tileSize,
+			math.Min(d.matrix.PixelXSize, d.matrix.PixelYSize))
+	}
+
+	if d.precision <= 0 || d.pixelPrecision <= 0 || d.tileSize%d.precision != 0 {
+		return nil, fmt.Errorf("tile size (%d) must be a multiple of precision (%d)", d.tileSize, d.precision)
+	}
+
+	return d, nil
+}
+
+// Get a decoded GeoPackage tile, or nil if it doesn't exist
+func (d *TileDatabase) getSource(row, col int) (*image.RGBA, error) {
+	src, err := d.sources.LoadItem([2]int{row, col}, func() (interface{}, error) {
+		return d.loadSource(row, col)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return src.(*sourceTile).img, nil
+}
+
+func (d *TileDatabase) loadSource(row, col int) (*sourceTile, error) {
+	var data []byte
+	err := d.gpkg.db.QueryRow(fmt.Sprintf(`SELECT tile_data FROM %s
+		WHERE zoom_level = ? AND tile_row = ? AND tile_column = ?`, quote(d.table)),
+		d.zoom, row, col).Scan(&data)
+	if errors.Is(err, sql.ErrNoRows) {
+		return &sourceTile{}, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	decoded, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("tile %d,%d: image.Decode: %w", row, col, err)
+	}
+
+	img := image.NewRGBA(image.Rect(0, 0, d.matrix.TileWidth, d.matrix.TileHeight))
+	draw.Draw(img, img.Bounds(), decoded, decoded.Bounds().Min, draw.Src)
+
+	return &sourceTile{img: img}, nil
+}
+
+func (d *TileDatabase) generateTile(bottomLeft osgrid.GridRef) (*osdata.RGBATile, error) {
+	size := int(d.tileSize/d.precision) * d.pixelPrecision
+	img := image.NewRGBA(image.Rect(0, 0, size, size))
+
+	m := d.matrix
+
+	e0 := float64(bottomLeft.AbsEasting())
+	n0 := float64(bottomLeft.AbsNorthing())
+	// Metres per pixel
+	p := float64(d.precision) / float64(d.pixelPrecision)
+
+	// The source column and pixel for each output column are the same on
+	// every row. -1 marks columns outside the matrix.
+	pxs := make([]int, size)
+	for x := range pxs {
+		east := e0 + (float64(x)+0.5)*p
+		px := int(math.Floor((east - d.left) / m.PixelXSize))
+		if px < 0 || px/m.TileWidth >= m.MatrixWidth {
+			px = -1
+		}
+		pxs[x] = px
+	}
+
+	found := false
+
+	for y := 0; y < size; y++ {
+		// Sample at the centre of each pixel. Image rows go from North to
+		// South.
+		north := n0 + (float64(size-y)-0.5)*p
+		py := int(math.Floor((d.top - north) / m.PixelYSize))
+		row := py / m.TileHeight
+		if py < 0 || row >= m.MatrixHeight {
+			continue
+		}
+
+		// Only look up the source tile when the column changes
+		col := -1
+		var src *image.RGBA
+
+		for x, px := range pxs {
+			if px < 0 {
+				continue
+			}
+
+			if c := px / m.TileWidth; c != col {
+				var err error
+				src, err = d.getSource(row, c)
+				if err != nil {
+					return nil, err
+				}
+				col = c
+			}
+
+			if src == nil {
+				continue
+			}
+
+			found = true
+
+			si := src.PixOffset(px%m.TileWidth, py%m.TileHeight)
+			di := img.PixOffset(x, y)
+			copy(img.Pix[di:di+4], src.Pix[si:si+4])
+		}
+	}
+
+	if !found {
+		return nil, fmt.Errorf("Tile %s %w", bottomLeft, osdata.ErrTileNotFound)
+	}
+
+	return osdata.NewRGBATile(bottomLeft, d.precision, d.pixelPrecision, img), nil
+}
+
+func (d *TileDatabase) GetImageTile(ref osgrid.GridRef) (osdata.ImageTile, error) {
+	ref = ref.Align(d.tileSize)
+
+	tile, err := d.cache.Load(ref, func() (osdata.Tile, error) {
+		return d.generateTile(ref)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return tile.(*osdata.RGBATile), nil
+}
+
+func (d *TileDatabase) GetTile(ref osgrid.GridRef) (osdata.Tile, error) {
+	return d.GetImageTile(ref)
+}
+
+func (d *TileDatabase) Precision() osgrid.Distance {
+	return d.precision
+}
+
+// PixelPrecision returns the number of pixels per Precision() in the tiles
+func (d *TileDatabase) PixelPrecision() int {
+	return d.pixelPrecision
+}
+
+// ZoomLevel returns the zoom level the tiles are read from
+func (d *TileDatabase) ZoomLevel() int {
+	return d.zoom
+}
+
+// Stats returns the statistics for the cache of generated tiles. The decoded
+// GeoPackage tiles they're made from are counted separately, in SourceStats.
+func (d *TileDatabase) Stats() osdata.Stats {
+	return d.cache.Stats()
+}
+
+// SourceStats returns the statistics for the cache of decoded GeoPackage tiles
+func (d *TileDatabase) SourceStats() osdata.Stats {
+	return d.sources.Stats()
+}
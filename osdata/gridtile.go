@@ -0,0 +1,77 @@
+package osdata
+
+import (
+	"fmt"
+
+	"github.com/usedbytes/osgrid"
+)
+
+var mustBeGridTile Float64Tile = &GridTile{}
+
+// GridTile is a Float64Tile held in memory, for tiles which are computed or
+// generated rather than read from a file
+type GridTile struct {
+	bottomLeft    osgrid.GridRef
+	width, height osgrid.Distance
+	precision     osgrid.Distance
+	// data[0] is the Southern-most row
+	data [][]float64
+}
+
+// NewGridTile returns a tile of data, with a value every precision. data[0] is
+// the Southern-most row, and data[0][0] is at bottomLeft. All of the rows must
+// be the same length, which along with the number of rows gives the size of
+// the tile. data isn't copied.
+func NewGridTile(bottomLeft osgrid.GridRef, precision osgrid.Distance, data [][]float64) *GridTile {
+	t := &GridTile{
+		bottomLeft: bottomLeft,
+		height:     osgrid.Distance(len(data)) * precision,
+		precision:  precision,
+		data:       data,
+	}
+
+	if len(data) > 0 {
+		t.width = osgrid.Distance(len(data[0])) * precision
+	}
+
+	return t
+}
+
+func (t *GridTile) Width() osgrid.Distance {
+	return t.width
+}
+
+func (t *GridTile) Height() osgrid.Distance {
+	return t.height
+}
+
+func (t *GridTile) Precision() osgrid.Distance {
+	return t.precision
+}
+
+func (t *GridTile) BottomLeft() osgrid.GridRef {
+	return t.bottomLeft
+}
+
+func (t *GridTile) String() string {
+	return t.bottomLeft.String()
+}
+
+func (t *GridTile) MemorySize() int {
+	if len(t.data) == 0 {
+		return 0
+	}
+
+	return len(t.data) * len(t.data[0]) * 8
+}
+
+func (t *GridTile) GetFloat64(ref osgrid.GridRef) (float64, error) {
+	east := ref.AbsEasting() - t.bottomLeft.AbsEasting()
+	north := ref.AbsNorthing() - t.bottomLeft.AbsNorthing()
+
+	if east < 0 || north < 0 || east >= t.width || north >= t.height {
+		return NoData, fmt.Errorf("Coordinate outside tile")
+	}
+
+	return t.data[int(north/t.precision)][int(east/t.precision)], nil
+}
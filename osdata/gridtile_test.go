@@ -0,0 +1,54 @@
+package osdata
+
+import (
+	"image"
+	"testing"
+
+	"github.com/usedbytes/osgrid"
+)
+
+func TestGridTile(t *testing.T) {
+	bottomLeft, _ := osgrid.Origin().Add(1000, 2000)
+
+	// 3 posts wide, 2 high
+	tile := NewGridTile(bottomLeft, 10*osgrid.Metre, [][]float64{
+		{1, 2, 3},
+		{4, 5, 6},
+	})
+
+	if tile.Width() != 30 || tile.Height() != 20 || tile.MemorySize() != 6*8 {
+		t.Errorf("unexpected size %d x %d, %d bytes", tile.Width(), tile.Height(), tile.MemorySize())
+	}
+
+	ref, _ := bottomLeft.Add(25, 15)
+	if v, err := tile.GetFloat64(ref); err != nil || v != 6 {
+		t.Errorf("expected 6, got %v, %v", v, err)
+	}
+
+	ref, _ = bottomLeft.Add(5, 20)
+	if _, err := tile.GetFloat64(ref); err == nil {
+		t.Errorf("expected error outside tile")
+	}
+}
+
+func TestRGBATile(t *testing.T) {
+	bottomLeft, _ := osgrid.Origin().Add(1000, 2000)
+
+	// 2 pixels per 10 m cell, 3 cells wide and 2 high
+	tile := NewRGBATile(bottomLeft, 10*osgrid.Metre, 2, image.NewRGBA(image.Rect(0, 0, 6, 4)))
+
+	if tile.Width() != 30 || tile.Height() != 20 || tile.MemorySize() != 6*4*4 {
+		t.Errorf("unexpected size %d x %d, %d bytes", tile.Width(), tile.Height(), tile.MemorySize())
+	}
+
+	// The bottom edge of the top-right cell
+	ref, _ := bottomLeft.Add(25, 15)
+	if x, y, err := tile.GetPixelCoord(ref); err != nil || x != 4 || y != 2 {
+		t.Errorf("expected 4, 2, got %d, %d, %v", x, y, err)
+	}
+
+	ref, _ = bottomLeft.Add(30, 0)
+	if _, _, err := tile.GetPixelCoord(ref); err == nil {
+		t.Errorf("expected error outside tile")
+	}
+}
@@ -0,0 +1,305 @@
+package httptile
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/usedbytes/osgrid/osdata"
+)
+
+// Client fetches tiles over HTTP, optionally keeping a copy of each tile on
+// disk. It can be shared between databases.
+type Client struct {
+	http     *http.Client
+	retries  int
+	backoff  time.Duration
+	cacheDir string
+
+	missingTTL time.Duration
+	now        func() time.Time
+
+	lock sync.Mutex
+	// URLs which returned 404, and when they can be requested again
+	missing map[string]time.Time
+}
+
+// Most URLs to remember as missing. Beyond this, the ones which have expired
+// are forgotten, and then arbitrary ones.
+const maxMissing = 100000
+
+type ClientOpt func(*Client)
+
+// Timeout for each request, default 30 seconds
+func ClientTimeoutOpt(timeout time.Duration) ClientOpt {
+	return func(c *Client) {
+		c.http.Timeout = timeout
+	}
+}
+
+// Number of times to retry after a failed request (a network error, or a 5xx
+// or 429 response), and the delay before the first retry, which doubles after
+// each attempt. Default 3 retries, starting at 500 ms.
+func ClientRetriesOpt(retries int, backoff time.Duration) ClientOpt {
+	return func(c *Client) {
+		c.retries = retries
+		c.backoff = backoff
+	}
+}
+
+// Keep a copy of each tile in dir, and revalidate it with its ETag instead of
+// downloading it again. If the server can't be reached, the copy in dir is
+// used as-is.
+func ClientCacheDirOpt(dir string) ClientOpt {
+	return func(c *Client) {
+		c.cacheDir = dir
+	}
+}
+
+// How long to remember that a tile doesn't exist (the server returned 404),
+// before requesting it again. Default 10 minutes, 0 disables remembering.
+func ClientMissingTTLOpt(ttl time.Duration) ClientOpt {
+	return func(c *Client) {
+		c.missingTTL = ttl
+	}
+}
+
+// Use transport for requests, instead of http.DefaultTransport, e.g. to add
+// authentication
+func ClientTransportOpt(transport http.RoundTripper) ClientOpt {
+	return func(c *Client) {
+		c.http.Transport = transport
+	}
+}
+
+func NewClient(opts ...ClientOpt) *Client {
+	c := &Client{
+		http: &http.Client{
+			Timeout: 30 * time.Second,
+		},
+		retries:    3,
+		backoff:    500 * time.Millisecond,
+		missingTTL: 10 * time.Minute,
+		now:        time.Now,
+		missing:    make(map[string]time.Time),
+	}
+
+	for _, opt := range opts {
+		opt(c)
+	}
+
+	return c
+}
+
+// retryableError is a failure which might succeed if the request is repeated
+type retryableError struct {
+	err error
+}
+
+func (e *retryableError) Error() string {
+	return e.err.Error()
+}
+
+func (e *retryableError) Unwrap() error {
+	return e.err
+}
+
+// Paths of the cached body and ETag for url
+func (c *Client) cachePaths(url string) (string, string) {
+	sum := sha256.Sum256([]byte(url))
+	name := hex.EncodeToString(sum[:])
+
+	// Spread the files over subdirectories, so none get too big
+	base := filepath.Join(c.cacheDir, name[:2], name)
+
+	return base, base + ".etag"
+}
+
+func writeFileAtomic(path string, data []byte) error {
+	tmp := path + ".tmp"
+
+	err := ioutil.WriteFile(tmp, data, 0644)
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+
+	return os.Rename(tmp, path)
+}
+
+func (c *Client) store(url string, body []byte, etag string) error {
+	bodyPath, etagPath := c.cachePaths(url)
+
+	err := os.MkdirAll(filepath.Dir(bodyPath), 0755)
+	if err != nil {
+		return err
+	}
+
+	err = writeFileAtomic(bodyPath, body)
+	if err != nil {
+		return err
+	}
+
+	if etag == "" {
+		os.Remove(etagPath)
+		return nil
+	}
+
+	return writeFileAtomic(etagPath, []byte(etag))
+}
+
+func (c *Client) isMissing(url string) bool {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	expiry, ok := c.missing[url]
+	if !ok {
+		return false
+	}
+
+	if c.now().Before(expiry) {
+		return true
+	}
+
+	delete(c.missing, url)
+	return false
+}
+
+func (c *Client) setMissing(url string) {
+	if c.missingTTL <= 0 {
+		return
+	}
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	now := c.now()
+
+	if len(c.missing) >= maxMissing {
+		for u, expiry := range c.missing {
+			if !now.Before(expiry) {
+				delete(c.missing, u)
+			}
+		}
+
+		for u := range c.missing {
+			if len(c.missing) < maxMissing {
+				break
+			}
+			delete(c.missing, u)
+		}
+	}
+
+	c.missing[url] = now.Add(c.missingTTL)
+}
+
+// Make a single request. Returns the body (nil for 304 Not Modified) and
+// the ETag.
+func (c *Client) get(url, etag string) ([]byte, string, error) {
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return nil, "", err
+	}
+
+	if etag != "" {
+		req.Header.Set("If-None-Match", etag)
+	}
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return nil, "", &retryableError{err}
+	}
+	defer resp.Body.Close()
+
+	switch {
+	case resp.StatusCode == http.StatusOK:
+		body, err := ioutil.ReadAll(resp.Body)
+		if err != nil {
+			return nil, "", &retryableError{err}
+		}
+		return body, resp.Header.Get("ETag"), nil
+	case resp.StatusCode == http.StatusNotModified:
+		return nil, etag, nil
+	case resp.StatusCode == http.StatusNotFound:
+		return nil, "", fmt.Errorf("%s %w", url, osdata.ErrTileNotFound)
+	case resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500:
+		return nil, "", &retryableError{fmt.Errorf("%s: %s", url, resp.Status)}
+	}
+
+	return nil, "", fmt.Errorf("%s: %s", url, resp.Status)
+}
+
+// Make a request, retrying on failure
+func (c *Client) getRetry(url, etag string) ([]byte, string, error) {
+	backoff := c.backoff
+
+	for attempt := 0; ; attempt++ {
+		body, newEtag, err := c.get(url, etag)
+
+		var retryable *retryableError
+		if err == nil || !errors.As(err, &retryable) || attempt >= c.retries {
+			return body, newEtag, err
+		}
+
+		time.Sleep(backoff)
+		backoff *= 2
+	}
+}
+
+// Fetch returns the body of url, from the disk cache if it's still valid.
+// If the server returns 404, the error wraps osdata.ErrTileNotFound, and url
+// isn't requested again until ClientMissingTTLOpt's time has passed.
+func (c *Client) Fetch(url string) ([]byte, error) {
+	if c.isMissing(url) {
+		return nil, fmt.Errorf("%s %w", url, osdata.ErrTileNotFound)
+	}
+
+	var cached []byte
+	var etag string
+	if c.cacheDir != "" {
+		bodyPath, etagPath := c.cachePaths(url)
+
+		var err error
+		cached, err = ioutil.ReadFile(bodyPath)
+		if err == nil {
+			b, _ := ioutil.ReadFile(etagPath)
+			etag = strings.TrimSpace(string(b))
+		} else {
+			cached = nil
+		}
+	}
+
+	body, newEtag, err := c.getRetry(url, etag)
+	if err != nil {
+		if errors.Is(err, osdata.ErrTileNotFound) {
+			c.setMissing(url)
+		} else if cached != nil {
+			// Better a stale tile than none
+			return cached, nil
+		}
+
+		return nil, err
+	}
+
+	if body == nil {
+		// Not modified
+		return cached, nil
+	}
+
+	if c.cacheDir != "" {
+		err = c.store(url, body, newEtag)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return body, nil
+}
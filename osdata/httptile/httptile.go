@@ -0,0 +1,261 @@
+// Package httptile fetches tiles from an HTTP server, so that a dataset can be
+// shared from one machine instead of copied to every machine which uses it.
+//
+// Tile URLs are made from a template, in which these placeholders are
+// replaced with the values for the tile's bottom-left corner:
+//
+//	{square}    Lower-case 100 km square, e.g. "sh"
+//	{SQUARE}    Upper-case 100 km square, e.g. "SH"
+//	{tile}      Lower-case tile name, as used by the dataset package, e.g. "sh65"
+//	{TILE}      Upper-case tile name, e.g. "SH65"
+//	{easting}   Easting from the grid origin, in metres
+//	{northing}  Northing from the grid origin, in metres
+//
+// For example, "http://tiles.local/terrain50/data/{square}/{tile}.asc" serves
+// a dataset written by the dataset package.
+package httptile
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/draw"
+	"path"
+	"strconv"
+	"strings"
+
+	// Image formats which tiles can be in
+	_ "image/jpeg"
+	_ "image/png"
+
+	_ "golang.org/x/image/tiff"
+
+	"github.com/usedbytes/osgrid"
+	"github.com/usedbytes/osgrid/osdata"
+	"github.com/usedbytes/osgrid/osdata/ascgrid"
+	"github.com/usedbytes/osgrid/osdata/bintile"
+	"github.com/usedbytes/osgrid/osdata/dataset"
+	"github.com/usedbytes/osgrid/osdata/geotiff"
+)
+
+var mustBeFloat64Database osdata.Float64Database = &Database{}
+var mustBeImageDatabase osdata.ImageDatabase = &ImageDatabase{}
+
+// Expand the URL template for the tile of size tileSize containing ref
+func expand(template string, ref osgrid.GridRef, tileSize osgrid.Distance) string {
+	ref = ref.Align(tileSize)
+	tile := dataset.TileName(ref, tileSize)
+
+	return strings.NewReplacer(
+		"{square}", strings.ToLower(ref.Tile()),
+		"{SQUARE}", strings.ToUpper(ref.Tile()),
+		"{tile}", tile,
+		"{TILE}", strings.ToUpper(tile),
+		"{easting}", strconv.Itoa(int(ref.AbsEasting())),
+		"{northing}", strconv.Itoa(int(ref.AbsNorthing())),
+	).Replace(template)
+}
+
+type float64Decoder func([]byte) (osdata.Float64Tile, error)
+
+// Pick a decoder from the extension in the template
+func decoderFor(template string) (float64Decoder, error) {
+	ext := strings.ToLower(path.Ext(strings.SplitN(template, "?", 2)[0]))
+
+	switch ext {
+	case ".asc":
+		return func(b []byte) (osdata.Float64Tile, error) {
+			return ascgrid.ReadTile(bytes.NewReader(b))
+		}, nil
+	case ".tif", ".tiff":
+		return func(b []byte) (osdata.Float64Tile, error) {
+			return geotiff.ReadTile(bytes.NewReader(b))
+		}, nil
+	case bintile.Ext:
+		return func(b []byte) (osdata.Float64Tile, error) {
+			return bintile.ReadTile(bytes.NewReader(b))
+		}, nil
+	}
+
+	return nil, fmt.Errorf("unsupported tile format '%s', expected .asc, .tif or %s", ext, bintile.Ext)
+}
+
+// Database is a Float64Database of tiles fetched over HTTP. The tile format
+// (ASCII Grid, GeoTIFF or bintile) is chosen from the extension in the URL
+// template.
+type Database struct {
+	client    *Client
+	template  string
+	tileSize  osgrid.Distance
+	precision osgrid.Distance
+	decode    float64Decoder
+	cache     *osdata.Cache
+}
+
+// NewDatabase fetches tiles of tileSize and precision from the URLs given by
+// template, using client.
+func NewDatabase(client *Client, template string, tileSize, precision osgrid.Distance,
+	opts ...osdata.DatabaseOpt) (*Database, error) {
+	cfg := osdata.NewDatabaseConfig(opts...)
+
+	decode, err := decoderFor(template)
+	if err != nil {
+		return nil, err
+	}
+
+	if precision <= 0 || tileSize%precision != 0 {
+		return nil, fmt.Errorf("tile size (%d) must be a multiple of precision (%d)", tileSize, precision)
+	}
+
+	return &Database{
+		client:    client,
+		template:  template,
+		tileSize:  tileSize,
+		precision: precision,
+		decode:    decode,
+		cache:     cfg.Cache,
+	}, nil
+}
+
+func (d *Database) loadTile(bottomLeft osgrid.GridRef) (osdata.Float64Tile, error) {
+	url := expand(d.template, bottomLeft, d.tileSize)
+
+	body, err := d.client.Fetch(url)
+	if err != nil {
+		return nil, err
+	}
+
+	tile, err := d.decode(body)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", url, err)
+	}
+
+	if tile.BottomLeft() != bottomLeft || tile.Width() != d.tileSize ||
+		tile.Height() != d.tileSize || tile.Precision() != d.precision {
+		return nil, fmt.Errorf("%s: expected tile %s %dx%d @ %d, got %s %dx%d @ %d", url,
+			bottomLeft, d.tileSize, d.tileSize, d.precision,
+			tile.BottomLeft(), tile.Width(), tile.Height(), tile.Precision())
+	}
+
+	return tile, nil
+}
+
+func (d *Database) getTile(ref osgrid.GridRef) (osdata.Float64Tile, error) {
+	ref = ref.Align(d.tileSize)
+
+	tile, err := d.cache.Load(ref, func() (osdata.Tile, error) {
+		return d.loadTile(ref)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return tile.(osdata.Float64Tile), nil
+}
+
+func (d *Database) GetTile(ref osgrid.GridRef) (osdata.Tile, error) {
+	return d.getTile(ref)
+}
+
+func (d *Database) GetFloat64Tile(ref osgrid.GridRef) (osdata.Float64Tile, error) {
+	return d.getTile(ref)
+}
+
+func (d *Database) GetFloat64(ref osgrid.GridRef) (float64, error) {
+	tile, err := d.getTile(ref)
+	if err != nil {
+		return osdata.NoData, err
+	}
+
+	return tile.GetFloat64(ref)
+}
+
+func (d *Database) Precision() osgrid.Distance {
+	return d.precision
+}
+
+func (d *Database) Stats() osdata.Stats {
+	return d.cache.Stats()
+}
+
+// ImageDatabase is an ImageDatabase of square PNG, JPEG or TIFF images
+// fetched over HTTP. The images don't need to be georeferenced, as their
+// position is known from the URL.
+type ImageDatabase struct {
+	client    *Client
+	template  string
+	tileSize  osgrid.Distance
+	precision osgrid.Distance
+	cache     *osdata.Cache
+}
+
+// NewImageDatabase fetches images covering tileSize from the URLs given by
+// template, using client. The images can be any size which is a multiple of
+// tileSize / precision, with PixelPrecision() set accordingly.
+func NewImageDatabase(client *Client, template string, tileSize, precision osgrid.Distance,
+	opts ...osdata.DatabaseOpt) (*ImageDatabase, error) {
+	cfg := osdata.NewDatabaseConfig(opts...)
+
+	if precision <= 0 || tileSize%precision != 0 {
+		return nil, fmt.Errorf("tile size (%d) must be a multiple of precision (%d)", tileSize, precision)
+	}
+
+	return &ImageDatabase{
+		client:    client,
+		template:  template,
+		tileSize:  tileSize,
+		precision: precision,
+		cache:     cfg.Cache,
+	}, nil
+}
+
+func (d *ImageDatabase) loadTile(bottomLeft osgrid.GridRef) (*osdata.RGBATile, error) {
+	url := expand(d.template, bottomLeft, d.tileSize)
+
+	body, err := d.client.Fetch(url)
+	if err != nil {
+		return nil, err
+	}
+
+	img, _, err := image.Decode(bytes.NewReader(body))
+	if err != nil {
+		return nil, fmt.Errorf("%s: image.Decode: %w", url, err)
+	}
+
+	cells := int(d.tileSize / d.precision)
+	bounds := img.Bounds()
+	if bounds.Dx() != bounds.Dy() || bounds.Dx() < cells || bounds.Dx()%cells != 0 {
+		return nil, fmt.Errorf("%s: image size %dx%d must be square, and a multiple of %d",
+			url, bounds.Dx(), bounds.Dy(), cells)
+	}
+
+	rgba := image.NewRGBA(image.Rect(0, 0, bounds.Dx(), bounds.Dy()))
+	draw.Draw(rgba, rgba.Bounds(), img, bounds.Min, draw.Src)
+
+	return osdata.NewRGBATile(bottomLeft, d.precision, bounds.Dx()/cells, rgba), nil
+}
+
+func (d *ImageDatabase) GetImageTile(ref osgrid.GridRef) (osdata.ImageTile, error) {
+	ref = ref.Align(d.tileSize)
+
+	tile, err := d.cache.Load(ref, func() (osdata.Tile, error) {
+		return d.loadTile(ref)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return tile.(*osdata.RGBATile), nil
+}
+
+func (d *ImageDatabase) GetTile(ref osgrid.GridRef) (osdata.Tile, error) {
+	return d.GetImageTile(ref)
+}
+
+func (d *ImageDatabase) Precision() osgrid.Distance {
+	return d.precision
+}
+
+func (d *ImageDatabase) Stats() osdata.Stats {
+	return d.cache.Stats()
+}
@@ -0,0 +1,382 @@
+package httptile
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/usedbytes/osgrid"
+	"github.com/usedbytes/osgrid/osdata"
+	"github.com/usedbytes/osgrid/osdata/ascgrid"
+	"github.com/usedbytes/osgrid/osdata/osdatatest"
+)
+
+// testServer is a stand-in tile server, serving files from memory
+type testServer struct {
+	*httptest.Server
+
+	lock  sync.Mutex
+	files map[string][]byte
+	// Number of requests to fail with 503 before succeeding
+	failures int
+	// Delay before responding
+	delay time.Duration
+
+	requests    map[string]int
+	notModified int
+}
+
+func newTestServer() *testServer {
+	s := &testServer{
+		files:    make(map[string][]byte),
+		requests: make(map[string]int),
+	}
+
+	s.Server = httptest.NewServer(http.HandlerFunc(s.serve))
+
+	return s
+}
+
+func (s *testServer) serve(w http.ResponseWriter, r *http.Request) {
+	s.lock.Lock()
+	s.requests[r.URL.Path]++
+	delay := s.delay
+	fail := s.failures > 0
+	if fail {
+		s.failures--
+	}
+	body, ok := s.files[r.URL.Path]
+	s.lock.Unlock()
+
+	time.Sleep(delay)
+
+	if fail {
+		http.Error(w, "unavailable", http.StatusServiceUnavailable)
+		return
+	}
+
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+
+	etag := fmt.Sprintf(`"%d"`, len(body))
+	if r.Header.Get("If-None-Match") == etag {
+		s.lock.Lock()
+		s.notModified++
+		s.lock.Unlock()
+
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	w.Header().Set("ETag", etag)
+	w.Write(body)
+}
+
+func (s *testServer) requestCount(path string) int {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	return s.requests[path]
+}
+
+const testTemplate = "/data/{square}/{tile}.asc"
+
+// Serve 1 km tiles of a plane, for SH 60 54 and SH 61 54
+func newElevationServer(t *testing.T) *testServer {
+	s := newTestServer()
+
+	src := osdatatest.NewFuncDatabase(osdatatest.Plane(100, 0.01, 0), 50*osgrid.Metre, 1*osgrid.Kilometre)
+
+	for _, name := range []string{"SH 60000 54000", "SH 61000 54000"} {
+		ref, _ := osgrid.ParseGridRef(name)
+
+		tile, err := src.GetFloat64Tile(ref)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		buf := &bytes.Buffer{}
+		err = ascgrid.Write(buf, tile)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		s.files[expand(testTemplate, ref, 1*osgrid.Kilometre)] = buf.Bytes()
+	}
+
+	return s
+}
+
+func TestExpand(t *testing.T) {
+	ref, _ := osgrid.ParseGridRef("SH 60986 54375")
+
+	url := expand("http://x/{SQUARE}/{square}/{TILE}/{tile}/{easting}_{northing}.asc", ref, 10*osgrid.Kilometre)
+	exp := "http://x/SH/sh/SH65/sh65/260000_350000.asc"
+	if url != exp {
+		t.Errorf("expected %s, got %s", exp, url)
+	}
+}
+
+func TestDatabase(t *testing.T) {
+	s := newElevationServer(t)
+	defer s.Close()
+
+	client := NewClient(ClientRetriesOpt(0, 0))
+	db, err := NewDatabase(client, s.URL+testTemplate, 1*osgrid.Kilometre, 50*osgrid.Metre)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ref, _ := osgrid.ParseGridRef("SH 61234 54321")
+	v, err := db.GetFloat64(ref)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Sampled at SH 61200 54300
+	if exp := 100 + 0.01*261200; v != exp {
+		t.Errorf("expected %v, got %v", exp, v)
+	}
+
+	// Missing tiles are only requested once
+	missing, _ := osgrid.ParseGridRef("SH 62000 54000")
+	for i := 0; i < 2; i++ {
+		_, err = db.GetFloat64(missing)
+		if !errors.Is(err, osdata.ErrTileNotFound) {
+			t.Errorf("expected ErrTileNotFound, got %v", err)
+		}
+	}
+
+	if n := s.requestCount("/data/sh/sh6254.asc"); n != 1 {
+		t.Errorf("expected 1 request for the missing tile, got %d", n)
+	}
+
+	// Tiles which don't match the database are an error
+	db, err = NewDatabase(client, s.URL+testTemplate, 1*osgrid.Kilometre, 10*osgrid.Metre)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = db.GetFloat64(ref)
+	if err == nil || errors.Is(err, osdata.ErrTileNotFound) {
+		t.Errorf("expected precision mismatch error, got %v", err)
+	}
+
+	_, err = NewDatabase(client, s.URL+"/{tile}.png", 1*osgrid.Kilometre, 50*osgrid.Metre)
+	if err == nil {
+		t.Errorf("expected error for unsupported format")
+	}
+}
+
+func TestCacheDir(t *testing.T) {
+	s := newElevationServer(t)
+
+	dir, err := ioutil.TempDir("", "httptile")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	ref, _ := osgrid.ParseGridRef("SH 60500 54500")
+
+	// Each database has its own memory cache, so has to go to the client
+	get := func() (float64, error) {
+		client := NewClient(ClientCacheDirOpt(dir), ClientRetriesOpt(0, 0))
+		db, err := NewDatabase(client, s.URL+testTemplate, 1*osgrid.Kilometre, 50*osgrid.Metre)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		return db.GetFloat64(ref)
+	}
+
+	exp := 100 + 0.01*260500
+
+	for i := 0; i < 2; i++ {
+		v, err := get()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if v != exp {
+			t.Errorf("expected %v, got %v", exp, v)
+		}
+	}
+
+	// The second fetch should have been revalidated
+	if s.notModified != 1 {
+		t.Errorf("expected 1 Not Modified response, got %d", s.notModified)
+	}
+
+	// With the server gone, the cached copy is used
+	s.Close()
+
+	v, err := get()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if v != exp {
+		t.Errorf("expected %v, got %v", exp, v)
+	}
+}
+
+func TestMissingTTL(t *testing.T) {
+	s := newTestServer()
+	defer s.Close()
+
+	now := time.Now()
+	client := NewClient(ClientRetriesOpt(0, 0), ClientMissingTTLOpt(time.Minute))
+	client.now = func() time.Time { return now }
+
+	url := s.URL + "/tile.asc"
+
+	_, err := client.Fetch(url)
+	if !errors.Is(err, osdata.ErrTileNotFound) {
+		t.Fatalf("expected ErrTileNotFound, got %v", err)
+	}
+
+	// The tile appears, but it's remembered as missing until the TTL has
+	// passed
+	s.lock.Lock()
+	s.files["/tile.asc"] = []byte("tile")
+	s.lock.Unlock()
+
+	_, err = client.Fetch(url)
+	if !errors.Is(err, osdata.ErrTileNotFound) {
+		t.Errorf("expected ErrTileNotFound, got %v", err)
+	}
+
+	if n := s.requestCount("/tile.asc"); n != 1 {
+		t.Errorf("expected 1 request, got %d", n)
+	}
+
+	now = now.Add(2 * time.Minute)
+
+	body, err := client.Fetch(url)
+	if err != nil || string(body) != "tile" {
+		t.Errorf("expected the tile, got %q, %v", body, err)
+	}
+
+	if len(client.missing) != 0 {
+		t.Errorf("expected the expired URL to be forgotten, got %v", client.missing)
+	}
+
+	// Without a TTL, nothing is remembered
+	client = NewClient(ClientRetriesOpt(0, 0), ClientMissingTTLOpt(0))
+	for i := 0; i < 2; i++ {
+		client.Fetch(s.URL + "/other.asc")
+	}
+
+	if n := s.requestCount("/other.asc"); n != 2 {
+		t.Errorf("expected 2 requests, got %d", n)
+	}
+}
+
+func TestRetries(t *testing.T) {
+	s := newElevationServer(t)
+	defer s.Close()
+
+	ref, _ := osgrid.ParseGridRef("SH 60500 54500")
+
+	s.failures = 2
+
+	client := NewClient(ClientRetriesOpt(1, time.Millisecond))
+	db, _ := NewDatabase(client, s.URL+testTemplate, 1*osgrid.Kilometre, 50*osgrid.Metre)
+	_, err := db.GetFloat64(ref)
+	if err == nil {
+		t.Errorf("expected error after 2 failures with 1 retry")
+	}
+
+	s.failures = 2
+
+	client = NewClient(ClientRetriesOpt(2, time.Millisecond))
+	db, _ = NewDatabase(client, s.URL+testTemplate, 1*osgrid.Kilometre, 50*osgrid.Metre)
+	_, err = db.GetFloat64(ref)
+	if err != nil {
+		t.Errorf("expected success after 2 failures with 2 retries, got %v", err)
+	}
+}
+
+func TestTimeout(t *testing.T) {
+	s := newElevationServer(t)
+	defer s.Close()
+
+	s.delay = 200 * time.Millisecond
+
+	ref, _ := osgrid.ParseGridRef("SH 60500 54500")
+
+	client := NewClient(ClientTimeoutOpt(20*time.Millisecond), ClientRetriesOpt(0, 0))
+	db, _ := NewDatabase(client, s.URL+testTemplate, 1*osgrid.Kilometre, 50*osgrid.Metre)
+
+	_, err := db.GetFloat64(ref)
+	if err == nil || errors.Is(err, osdata.ErrTileNotFound) {
+		t.Errorf("expected timeout error, got %v", err)
+	}
+}
+
+func TestImageDatabase(t *testing.T) {
+	s := newTestServer()
+	defer s.Close()
+
+	// 1 km at 100 m precision, with 2 pixels per cell. The top-left
+	// cell is red.
+	img := image.NewRGBA(image.Rect(0, 0, 20, 20))
+	for _, p := range []image.Point{{0, 0}, {1, 0}, {0, 1}, {1, 1}} {
+		img.Set(p.X, p.Y, color.RGBA{0xff, 0, 0, 0xff})
+	}
+
+	buf := &bytes.Buffer{}
+	png.Encode(buf, img)
+	s.files["/sh/260000_354000.png"] = buf.Bytes()
+
+	client := NewClient(ClientRetriesOpt(0, 0))
+	db, err := NewImageDatabase(client, s.URL+"/{square}/{easting}_{northing}.png",
+		1*osgrid.Kilometre, 100*osgrid.Metre)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ref, _ := osgrid.ParseGridRef("SH 60000 54900")
+	tile, err := db.GetImageTile(ref)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if tile.PixelPrecision() != 2 {
+		t.Errorf("expected pixel precision 2, got %d", tile.PixelPrecision())
+	}
+
+	// The pixel coordinate is the bottom-left corner of the cell
+	x, y, err := tile.GetPixelCoord(ref)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if x != 0 || y != 2 {
+		t.Errorf("expected (0, 2), got (%d, %d)", x, y)
+	}
+
+	c := color.RGBAModel.Convert(tile.GetImage().At(x, y-1))
+	if c != (color.RGBA{0xff, 0, 0, 0xff}) {
+		t.Errorf("expected red, got %v", c)
+	}
+
+	ref, _ = osgrid.ParseGridRef("SH 61000 54000")
+	_, err = db.GetImageTile(ref)
+	if !errors.Is(err, osdata.ErrTileNotFound) {
+		t.Errorf("expected ErrTileNotFound, got %v", err)
+	}
+}
@@ -0,0 +1,86 @@
+package osdata
+
+import (
+	"fmt"
+	"image"
+
+	"github.com/usedbytes/osgrid"
+)
+
+var mustBeRGBATile ImageTile = &RGBATile{}
+
+// RGBATile is an ImageTile held in memory, for images which are fetched,
+// generated or rendered rather than read from a file. It's also an
+// image.Image, and can be drawn on.
+type RGBATile struct {
+	*image.RGBA
+
+	bottomLeft     osgrid.GridRef
+	width, height  osgrid.Distance
+	precision      osgrid.Distance
+	pixelPrecision int
+}
+
+// NewRGBATile returns a tile of img, with bottomLeft at the bottom-left
+// corner of the image and pixelPrecision pixels per precision. The image's
+// dimensions must be multiples of pixelPrecision, and give the size of the
+// tile.
+func NewRGBATile(bottomLeft osgrid.GridRef, precision osgrid.Distance, pixelPrecision int, img *image.RGBA) *RGBATile {
+	return &RGBATile{
+		RGBA:           img,
+		bottomLeft:     bottomLeft,
+		width:          osgrid.Distance(img.Rect.Dx()/pixelPrecision) * precision,
+		height:         osgrid.Distance(img.Rect.Dy()/pixelPrecision) * precision,
+		precision:      precision,
+		pixelPrecision: pixelPrecision,
+	}
+}
+
+func (t *RGBATile) String() string {
+	return t.bottomLeft.String()
+}
+
+func (t *RGBATile) BottomLeft() osgrid.GridRef {
+	return t.bottomLeft
+}
+
+func (t *RGBATile) Precision() osgrid.Distance {
+	return t.precision
+}
+
+func (t *RGBATile) PixelPrecision() int {
+	return t.pixelPrecision
+}
+
+func (t *RGBATile) Width() osgrid.Distance {
+	return t.width
+}
+
+func (t *RGBATile) Height() osgrid.Distance {
+	return t.height
+}
+
+func (t *RGBATile) GetImage() image.Image {
+	return t.RGBA
+}
+
+func (t *RGBATile) MemorySize() int {
+	return len(t.Pix)
+}
+
+// GetPixelCoord returns the position of the bottom-left corner of the cell
+// containing ref. y is counted from the top of the image, as in the raster
+// package.
+func (t *RGBATile) GetPixelCoord(ref osgrid.GridRef) (int, int, error) {
+	east := ref.AbsEasting() - t.bottomLeft.AbsEasting()
+	north := ref.AbsNorthing() - t.bottomLeft.AbsNorthing()
+
+	if east < 0 || north < 0 || east >= t.width || north >= t.height {
+		return -1, -1, fmt.Errorf("Coordinate outside tile")
+	}
+
+	x := int(east/t.precision) * t.pixelPrecision
+	y := int(north/t.precision) * t.pixelPrecision
+
+	return t.Rect.Min.X + x, t.Rect.Max.Y - y, nil
+}
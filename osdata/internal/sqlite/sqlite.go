@@ -0,0 +1,36 @@
+// Package sqlite opens the SQLite databases which some OS OpenData products
+// are distributed in, such as GeoPackages and MBTiles files.
+//
+// It uses a pure-Go SQLite driver, so cgo isn't needed.
+package sqlite
+
+import (
+	"database/sql"
+	"net/url"
+	"os"
+	"path/filepath"
+
+	_ "modernc.org/sqlite"
+)
+
+// OpenReadOnly opens the SQLite database at path, read-only. Unlike SQLite
+// itself, it's an error for the file not to exist.
+func OpenReadOnly(path string) (*sql.DB, error) {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return nil, err
+	}
+
+	// SQLite would happily create an empty database
+	if _, err := os.Stat(abs); err != nil {
+		return nil, err
+	}
+
+	dsn := &url.URL{
+		Scheme:   "file",
+		Path:     filepath.ToSlash(abs),
+		RawQuery: "mode=ro",
+	}
+
+	return sql.Open("sqlite", dsn.String())
+}
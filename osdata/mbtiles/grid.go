@@ -0,0 +1,139 @@
+package mbtiles
+
+import (
+	"math"
+
+	"github.com/usedbytes/osgrid/osdata/vector"
+)
+
+// TileGrid describes where the tiles of a tile set are on the ground.
+// Columns are counted from the West, and rows from the North (as in XYZ
+// tile URLs).
+type TileGrid interface {
+	// TileRange returns the columns and rows (inclusive) of the tiles at
+	// zoom which cover b. If there aren't any, maxCol < minCol.
+	TileRange(zoom int, b vector.Bounds) (minCol, minRow, maxCol, maxRow int)
+	// ToGrid converts a position in the tile at zoom, col, row to grid
+	// coordinates. fx and fy are fractions of the tile's width and height
+	// from its top-left corner.
+	ToGrid(zoom, col, row int, fx, fy float64) vector.Point
+	// Rows returns the number of rows of tiles at zoom
+	Rows(zoom int) int
+}
+
+type webMercator struct{}
+
+// WebMercator is the standard global tile grid (EPSG:3857), which is the
+// default for MBTiles
+var WebMercator TileGrid = webMercator{}
+
+// Number of samples along each edge of an area, when finding the Web
+// Mercator tiles which cover it. National Grid edges aren't straight in Web
+// Mercator, but they're close.
+const edgeSamples = 8
+
+func (webMercator) Rows(zoom int) int {
+	return 1 << uint(zoom)
+}
+
+func (w webMercator) TileRange(zoom int, b vector.Bounds) (int, int, int, int) {
+	if b.Empty() {
+		return 0, 0, -1, -1
+	}
+
+	n := float64(w.Rows(zoom))
+	minX, minY := math.Inf(1), math.Inf(1)
+	maxX, maxY := math.Inf(-1), math.Inf(-1)
+
+	for i := 0; i <= edgeSamples; i++ {
+		f := float64(i) / edgeSamples
+		e := b.Min.E + f*(b.Max.E-b.Min.E)
+		no := b.Min.N + f*(b.Max.N-b.Min.N)
+
+		for _, p := range []vector.Point{
+			{E: e, N: b.Min.N}, {E: e, N: b.Max.N},
+			{E: b.Min.E, N: no}, {E: b.Max.E, N: no},
+		} {
+			lat, lon := gridToWGS84(p)
+			x := (lon + 180) / 360 * n
+			y := (1 - math.Asinh(math.Tan(radians(lat)))/math.Pi) / 2 * n
+
+			minX, maxX = math.Min(minX, x), math.Max(maxX, x)
+			minY, maxY = math.Min(minY, y), math.Max(maxY, y)
+		}
+	}
+
+	clamp := func(v float64) int {
+		return int(math.Max(0, math.Min(n-1, math.Floor(v))))
+	}
+
+	return clamp(minX), clamp(minY), clamp(maxX), clamp(maxY)
+}
+
+func (w webMercator) ToGrid(zoom, col, row int, fx, fy float64) vector.Point {
+	n := float64(w.Rows(zoom))
+	lon := (float64(col)+fx)/n*360 - 180
+	lat := degrees(math.Atan(math.Sinh(math.Pi * (1 - 2*(float64(row)+fy)/n))))
+
+	return wgs84ToGrid(lat, lon)
+}
+
+// FixedGrid is a tile grid which is aligned with the National Grid, with
+// the tiles at each zoom level half the size of the previous level's
+type FixedGrid struct {
+	// Top-left corner of tile (0, 0), in grid coordinates
+	Left, Top float64
+	// Size of the tiles at zoom level 0, in metres
+	TileSize float64
+	// Number of columns and rows of tiles at zoom level 0
+	Width, Height int
+}
+
+// BritishNationalGrid is the EPSG:27700 tile grid used by OS Open Zoomstack
+// and the OS Maps and Vector Tile APIs: 256 pixel tiles of 896 m per pixel
+// at zoom level 0.
+var BritishNationalGrid TileGrid = &FixedGrid{
+	Left:     -238375.0,
+	Top:      1376256.0,
+	TileSize: 896.0 * 256,
+	Width:    5,
+	Height:   6,
+}
+
+func (g *FixedGrid) span(zoom int) float64 {
+	return g.TileSize / float64(uint(1)<<uint(zoom))
+}
+
+func (g *FixedGrid) Rows(zoom int) int {
+	return g.Height << uint(zoom)
+}
+
+func (g *FixedGrid) TileRange(zoom int, b vector.Bounds) (int, int, int, int) {
+	if b.Empty() {
+		return 0, 0, -1, -1
+	}
+
+	span := g.span(zoom)
+	cols := g.Width << uint(zoom)
+	rows := g.Rows(zoom)
+
+	minCol := int(math.Max(0, math.Floor((b.Min.E-g.Left)/span)))
+	maxCol := int(math.Min(float64(cols-1), math.Floor((b.Max.E-g.Left)/span)))
+	minRow := int(math.Max(0, math.Floor((g.Top-b.Max.N)/span)))
+	maxRow := int(math.Min(float64(rows-1), math.Floor((g.Top-b.Min.N)/span)))
+
+	if maxCol < minCol || maxRow < minRow {
+		return 0, 0, -1, -1
+	}
+
+	return minCol, minRow, maxCol, maxRow
+}
+
+func (g *FixedGrid) ToGrid(zoom, col, row int, fx, fy float64) vector.Point {
+	span := g.span(zoom)
+
+	return vector.Point{
+		E: g.Left + (float64(col)+fx)*span,
+		N: g.Top - (float64(row)+fy)*span,
+	}
+}
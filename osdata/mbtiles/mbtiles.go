@@ -0,0 +1,343 @@
+// Package mbtiles reads MBTiles files of Mapbox Vector Tiles, such as OS
+// Open Zoomstack, and returns the features in an area with their geometry in
+// grid coordinates.
+//
+// An MBTiles file is an SQLite database of tiles, which is read with a
+// pure-Go SQLite driver, so cgo isn't needed.
+package mbtiles
+
+import (
+	"bytes"
+	"compress/gzip"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"strings"
+
+	"github.com/usedbytes/osgrid"
+	"github.com/usedbytes/osgrid/osdata"
+	"github.com/usedbytes/osgrid/osdata/internal/sqlite"
+	"github.com/usedbytes/osgrid/osdata/mvt"
+	"github.com/usedbytes/osgrid/osdata/vector"
+)
+
+type tileKey struct {
+	zoom, col, row int
+}
+
+// The features of a tile in grid coordinates, by layer name. layers is nil
+// for missing tiles.
+type tileFeatures struct {
+	layers    map[string][]*vector.Feature
+	numPoints int
+}
+
+func (tf *tileFeatures) MemorySize() int {
+	// Missing tiles are charged something too, so they can't build up
+	// without limit
+	size := 64 + tf.numPoints*16
+	for _, features := range tf.layers {
+		size += len(features) * 64
+	}
+
+	return size
+}
+
+func numPoints(g vector.Geometry) int {
+	n := 0
+	switch g := g.(type) {
+	case *vector.MultiPoint:
+		n = len(g.Points)
+	case *vector.MultiLineString:
+		for _, l := range g.Lines {
+			n += len(l)
+		}
+	case *vector.Polygon:
+		for _, r := range g.Rings {
+			n += len(r)
+		}
+	}
+
+	return n
+}
+
+// MBTiles is an open MBTiles file of vector tiles
+type MBTiles struct {
+	db       *sql.DB
+	path     string
+	grid     TileGrid
+	metadata map[string]string
+
+	// Decoded tiles, so that overlapping searches don't have to decode
+	// them again
+	tiles *osdata.Cache
+}
+
+type Opt func(*MBTiles)
+
+// Options for the cache of decoded tiles
+func DatabaseOpts(opts ...osdata.DatabaseOpt) Opt {
+	return func(m *MBTiles) {
+		m.tiles = osdata.NewDatabaseConfig(opts...).Cache
+	}
+}
+
+// Use grid to place the tiles, instead of working it out from the metadata
+func TileGridOpt(grid TileGrid) Opt {
+	return func(m *MBTiles) {
+		m.grid = grid
+	}
+}
+
+// Open opens the MBTiles file at path, read-only.
+//
+// Tiles are assumed to be in the standard Web Mercator grid, unless the
+// metadata has a "crs" or "srs" of EPSG:27700, in which case they're in the
+// OS BritishNationalGrid tile grid.
+func Open(path string, opts ...Opt) (*MBTiles, error) {
+	db, err := sqlite.OpenReadOnly(path)
+	if err != nil {
+		return nil, err
+	}
+
+	m := &MBTiles{
+		db:       db,
+		path:     path,
+		metadata: make(map[string]string),
+	}
+
+	for _, opt := range opts {
+		opt(m)
+	}
+
+	if m.tiles == nil {
+		m.tiles = osdata.NewDatabaseConfig().Cache
+	}
+
+	if err := m.readMetadata(); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("%s: not an MBTiles file: %w", path, err)
+	}
+
+	if f := m.metadata["format"]; f != "" && f != "pbf" {
+		db.Close()
+		return nil, fmt.Errorf("%s: tiles are %s, not vector tiles (pbf)", path, f)
+	}
+
+	if m.grid == nil {
+		m.grid = WebMercator
+		for _, key := range []string{"crs", "srs"} {
+			if strings.Contains(m.metadata[key], "27700") {
+				m.grid = BritishNationalGrid
+			}
+		}
+	}
+
+	return m, nil
+}
+
+func (m *MBTiles) readMetadata() error {
+	rows, err := m.db.Query(`SELECT name, value FROM metadata`)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var name string
+		var value sql.NullString
+		if err := rows.Scan(&name, &value); err != nil {
+			return err
+		}
+
+		m.metadata[name] = value.String
+	}
+
+	return rows.Err()
+}
+
+func (m *MBTiles) Close() error {
+	return m.db.Close()
+}
+
+func (m *MBTiles) String() string {
+	return m.path
+}
+
+// Stats returns the stats of the decoded tile cache
+func (m *MBTiles) Stats() osdata.Stats {
+	return m.tiles.Stats()
+}
+
+// Metadata returns the values in the file's metadata table, such as "name",
+// "minzoom" and "maxzoom"
+func (m *MBTiles) Metadata() map[string]string {
+	md := make(map[string]string, len(m.metadata))
+	for k, v := range m.metadata {
+		md[k] = v
+	}
+
+	return md
+}
+
+// Grid returns the tile grid which the tiles are in
+func (m *MBTiles) Grid() TileGrid {
+	return m.grid
+}
+
+// Zooms returns the lowest and highest zoom levels with any tiles
+func (m *MBTiles) Zooms() (int, int, error) {
+	var min, max sql.NullInt64
+	err := m.db.QueryRow(`SELECT MIN(zoom_level), MAX(zoom_level) FROM tiles`).Scan(&min, &max)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	if !min.Valid {
+		return 0, 0, fmt.Errorf("%s has no tiles", m.path)
+	}
+
+	return int(min.Int64), int(max.Int64), nil
+}
+
+// Tile decodes the tile at zoom, col, row. Rows are counted from the North
+// (as in XYZ tile URLs), even though MBTiles stores them from the South.
+// Missing tiles are an error wrapping osdata.ErrTileNotFound.
+func (m *MBTiles) Tile(zoom, col, row int) (*mvt.Tile, error) {
+	tmsRow := m.grid.Rows(zoom) - 1 - row
+
+	var data []byte
+	err := m.db.QueryRow(`SELECT tile_data FROM tiles
+		WHERE zoom_level = ? AND tile_column = ? AND tile_row = ?`, zoom, col, tmsRow).Scan(&data)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, fmt.Errorf("Tile %d/%d/%d %w", zoom, col, row, osdata.ErrTileNotFound)
+	} else if err != nil {
+		return nil, err
+	}
+
+	// Vector tiles are usually gzipped
+	if len(data) >= 2 && data[0] == 0x1f && data[1] == 0x8b {
+		zr, err := gzip.NewReader(bytes.NewReader(data))
+		if err != nil {
+			return nil, fmt.Errorf("tile %d/%d/%d: %w", zoom, col, row, err)
+		}
+
+		data, err = ioutil.ReadAll(zr)
+		if err != nil {
+			return nil, fmt.Errorf("tile %d/%d/%d: %w", zoom, col, row, err)
+		}
+	}
+
+	t, err := mvt.Decode(data)
+	if err != nil {
+		return nil, fmt.Errorf("tile %d/%d/%d: %w", zoom, col, row, err)
+	}
+
+	return t, nil
+}
+
+// Get the features of a tile in grid coordinates
+func (m *MBTiles) tileFeatures(key tileKey) (*tileFeatures, error) {
+	tf, err := m.tiles.LoadItem(key, func() (interface{}, error) {
+		return m.loadTileFeatures(key)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return tf.(*tileFeatures), nil
+}
+
+func (m *MBTiles) loadTileFeatures(key tileKey) (*tileFeatures, error) {
+	tf := &tileFeatures{}
+
+	t, err := m.Tile(key.zoom, key.col, key.row)
+	if errors.Is(err, osdata.ErrTileNotFound) {
+		return tf, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	tf.layers = make(map[string][]*vector.Feature, len(t.Layers))
+	for _, l := range t.Layers {
+		extent := float64(l.Extent)
+		transform := func(x, y float64) vector.Point {
+			return m.grid.ToGrid(key.zoom, key.col, key.row, x/extent, y/extent)
+		}
+
+		features := make([]*vector.Feature, 0, len(l.Features))
+		for _, f := range l.Features {
+			g := f.ToVector(transform)
+			tf.numPoints += numPoints(g)
+
+			features = append(features, &vector.Feature{
+				ID:         int64(f.ID),
+				Geometry:   g,
+				Attributes: f.Attributes,
+			})
+		}
+
+		tf.layers[l.Name] = features
+	}
+
+	return tf, nil
+}
+
+// Search returns the features in layer whose bounding boxes intersect r,
+// from the tiles at zoom. Features which cross the edges of tiles are split
+// into pieces by the tiles, so they're returned once for each tile they're
+// in.
+func (m *MBTiles) Search(r osgrid.Rect, zoom int, layer string) ([]*vector.Feature, error) {
+	return m.SearchBounds(vector.BoundsFromRect(r), zoom, layer)
+}
+
+// SearchBounds is Search, with vector.Bounds
+func (m *MBTiles) SearchBounds(b vector.Bounds, zoom int, layer string) ([]*vector.Feature, error) {
+	minCol, minRow, maxCol, maxRow := m.grid.TileRange(zoom, b)
+
+	var found []*vector.Feature
+	for row := minRow; row <= maxRow; row++ {
+		for col := minCol; col <= maxCol; col++ {
+			tf, err := m.tileFeatures(tileKey{zoom, col, row})
+			if err != nil {
+				return nil, err
+			}
+
+			for _, f := range tf.layers[layer] {
+				if f.Geometry != nil && f.Geometry.Bounds().Intersects(b) {
+					found = append(found, f)
+				}
+			}
+		}
+	}
+
+	return found, nil
+}
+
+// Layer is one of the layers in an MBTiles file, at a single zoom level
+type Layer struct {
+	Name string
+	Zoom int
+
+	m *MBTiles
+}
+
+// Layer returns the layer called name, using the tiles at zoom
+func (m *MBTiles) Layer(name string, zoom int) *Layer {
+	return &Layer{
+		Name: name,
+		Zoom: zoom,
+		m:    m,
+	}
+}
+
+// Search returns the features whose bounding boxes intersect r
+func (l *Layer) Search(r osgrid.Rect) ([]*vector.Feature, error) {
+	return l.m.Search(r, l.Zoom, l.Name)
+}
+
+// SearchBounds is Search, with vector.Bounds
+func (l *Layer) SearchBounds(b vector.Bounds) ([]*vector.Feature, error) {
+	return l.m.SearchBounds(b, l.Zoom, l.Name)
+}
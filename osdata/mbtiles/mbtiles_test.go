@@ -0,0 +1,282 @@
+package mbtiles
+
+import (
+	"bytes"
+	"compress/gzip"
+	"database/sql"
+	"errors"
+	"math"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/usedbytes/osgrid"
+	"github.com/usedbytes/osgrid/osdata"
+	"github.com/usedbytes/osgrid/osdata/mvt/mvttest"
+	"github.com/usedbytes/osgrid/osdata/vector"
+	"github.com/usedbytes/osgrid/osgridtest"
+)
+
+func near(p, q vector.Point, tolerance float64) bool {
+	return math.Hypot(p.E-q.E, p.N-q.N) <= tolerance
+}
+
+func TestProjection(t *testing.T) {
+	// Worked example from "A guide to coordinate systems in Great Britain"
+	lat := radians(52 + 39.0/60 + 27.2531/3600)
+	lon := radians(1 + 43.0/60 + 4.5177/3600)
+	exp := vector.Point{E: 651409.903, N: 313177.270}
+
+	if p := osgb36ToGrid(lat, lon); !near(p, exp, 0.001) {
+		t.Errorf("expected %v, got %v", exp, p)
+	}
+
+	lat2, lon2 := gridToOSGB36(exp)
+	if math.Abs(lat2-lat) > 1e-9 || math.Abs(lon2-lon) > 1e-9 {
+		t.Errorf("expected %v, %v, got %v, %v", lat, lon, lat2, lon2)
+	}
+
+	// The Airy transit circle at Greenwich is the OSGB36 prime meridian,
+	// but about 100 m East of the WGS84 one. These positions are only
+	// approximate, but getting the datum change wrong is out by hundreds of
+	// metres.
+	greenwich := osgb36ToGrid(radians(51+28.0/60+38.0/3600), 0)
+	if p := wgs84ToGrid(51.477811, -0.001475); !near(p, greenwich, 20) {
+		t.Errorf("expected %v, got %v", greenwich, p)
+	}
+
+	snowdon := vector.Point{E: 260986, N: 354375}
+	lat, lon = gridToWGS84(snowdon)
+	if p := wgs84ToGrid(lat, lon); !near(p, snowdon, 0.01) {
+		t.Errorf("round trip: expected %v, got %v", snowdon, p)
+	}
+}
+
+func TestGrids(t *testing.T) {
+	// Zoom 7 tiles are 1792 m
+	g := BritishNationalGrid
+	p := g.ToGrid(7, 133, 615, 0.5, 0.25)
+	if !near(p, vector.Point{E: -238375 + 133.5*1792, N: 1376256 - 615.25*1792}, 1e-6) {
+		t.Errorf("unexpected point %v", p)
+	}
+
+	b := vector.Bounds{Min: p, Max: vector.Point{E: p.E + 2000, N: p.N + 10}}
+	if c0, r0, c1, r1 := g.TileRange(7, b); c0 != 133 || c1 != 134 || r0 != 615 || r1 != 615 {
+		t.Errorf("unexpected range %d,%d - %d,%d", c0, r0, c1, r1)
+	}
+
+	if _, _, c1, _ := g.TileRange(7, vector.Bounds{Min: vector.Point{E: -1e7, N: 0}, Max: vector.Point{E: -1e6, N: 1}}); c1 >= 0 {
+		t.Errorf("expected no tiles off the grid")
+	}
+
+	// In Web Mercator, the tile containing Snowdon at zoom 14 is 8006, 5331
+	snowdon := vector.Bounds{Min: vector.Point{E: 260986, N: 354375}, Max: vector.Point{E: 260986, N: 354375}}
+	if c0, r0, c1, r1 := WebMercator.TileRange(14, snowdon); c0 != 8006 || r0 != 5331 || c1 != c0 || r1 != r0 {
+		t.Errorf("unexpected range %d,%d - %d,%d", c0, r0, c1, r1)
+	}
+
+	inside := WebMercator.ToGrid(14, 8006, 5331, 0.01, 0.01)
+	if c0, r0, _, _ := WebMercator.TileRange(14, vector.Bounds{Min: inside, Max: inside}); c0 != 8006 || r0 != 5331 {
+		t.Errorf("point isn't in its own tile: %d, %d", c0, r0)
+	}
+}
+
+// A tile with a "roads" layer, with an extent of 4096, containing a single
+// line from (x0, y0) to (x1, y1), named name
+func encodeTile(id uint64, name string, x0, y0, x1, y1 int32) []byte {
+	var geom []byte
+	geom = mvttest.AppendVarint(geom, 1|1<<3)
+	geom = mvttest.AppendVarint(geom, uint64(mvttest.Zigzag(x0)))
+	geom = mvttest.AppendVarint(geom, uint64(mvttest.Zigzag(y0)))
+	geom = mvttest.AppendVarint(geom, 2|1<<3)
+	geom = mvttest.AppendVarint(geom, uint64(mvttest.Zigzag(x1-x0)))
+	geom = mvttest.AppendVarint(geom, uint64(mvttest.Zigzag(y1-y0)))
+
+	var f []byte
+	f = mvttest.AppendVarint(f, 1<<3)
+	f = mvttest.AppendVarint(f, id)
+	f = mvttest.AppendBytes(f, 2, []byte{0, 0})
+	f = mvttest.AppendVarint(f, 3<<3)
+	f = mvttest.AppendVarint(f, 2)
+	f = mvttest.AppendBytes(f, 4, geom)
+
+	var l []byte
+	l = mvttest.AppendBytes(l, 1, []byte("roads"))
+	l = mvttest.AppendBytes(l, 2, f)
+	l = mvttest.AppendBytes(l, 3, []byte("name"))
+	l = mvttest.AppendBytes(l, 4, mvttest.AppendBytes(nil, 1, []byte(name)))
+
+	return mvttest.AppendBytes(nil, 3, l)
+}
+
+func gzipped(t *testing.T, data []byte) []byte {
+	buf := &bytes.Buffer{}
+	zw := gzip.NewWriter(buf)
+	if _, err := zw.Write(data); err != nil {
+		t.Fatal(err)
+	}
+	zw.Close()
+
+	return buf.Bytes()
+}
+
+func writeTestMBTiles(t *testing.T, metadata map[string]string, tiles map[tileKey][]byte) string {
+	path := filepath.Join(t.TempDir(), "test.mbtiles")
+
+	db, err := sql.Open("sqlite", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	_, err = db.Exec(`CREATE TABLE metadata (name TEXT, value TEXT);
+		CREATE TABLE tiles (zoom_level INTEGER, tile_column INTEGER, tile_row INTEGER, tile_data BLOB);`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for k, v := range metadata {
+		if _, err := db.Exec(`INSERT INTO metadata VALUES (?, ?)`, k, v); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for k, data := range tiles {
+		if _, err := db.Exec(`INSERT INTO tiles VALUES (?, ?, ?, ?)`, k.zoom, k.col, k.row, data); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	return path
+}
+
+func TestSearch(t *testing.T) {
+	// Zoom 7, 1792 m tiles. Rows are stored from the South: there are 768
+	// rows, so XYZ row 615 is TMS row 152.
+	x0 := -238375.0 + 133*1792
+	y0 := 1376256.0 - 615*1792
+
+	path := writeTestMBTiles(t, map[string]string{
+		"name":   "Test",
+		"format": "pbf",
+		"crs":    "EPSG:27700",
+	}, map[tileKey][]byte{
+		// A road across the top half of the tile, from West to East
+		{7, 133, 152}: gzipped(t, encodeTile(1, "A1", 0, 1024, 4096, 1024)),
+		// And continuing into the next tile, uncompressed
+		{7, 134, 152}: encodeTile(1, "A1", 0, 1024, 2048, 1024),
+		// A road in the tile below
+		{7, 133, 151}: encodeTile(2, "B2", 2048, 0, 2048, 4096),
+	})
+
+	m, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer m.Close()
+
+	if m.Grid() != BritishNationalGrid || m.Metadata()["name"] != "Test" {
+		t.Errorf("unexpected grid or metadata %v", m.Metadata())
+	}
+
+	if min, max, err := m.Zooms(); err != nil || min != 7 || max != 7 {
+		t.Errorf("unexpected zooms %d, %d, %v", min, max, err)
+	}
+
+	// The road is 448 m South of the top of the tiles
+	n := y0 - 448
+	r := osgrid.Rect{
+		BottomLeft: osgridtest.MustRef(t, osgrid.Distance(x0+100), osgrid.Distance(n-100)),
+		Width:      2000,
+		Height:     200,
+	}
+
+	found, err := m.Layer("roads", 7).Search(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(found) != 2 {
+		t.Fatalf("expected 2 pieces of road, got %d", len(found))
+	}
+
+	for i, f := range found {
+		if f.ID != 1 || f.StringAttr("name") != "A1" {
+			t.Errorf("unexpected feature %d: %d %v", i, f.ID, f.Attributes)
+		}
+	}
+
+	line := found[1].Geometry.(*vector.MultiLineString).Lines[0]
+	exp := []vector.Point{{E: x0 + 1792, N: n}, {E: x0 + 1792 + 896, N: n}}
+	if !reflect.DeepEqual(line, exp) {
+		t.Errorf("expected %v, got %v", exp, line)
+	}
+
+	// Just the B2, which runs North-South down the middle of the tile below
+	r = osgrid.Rect{BottomLeft: osgridtest.MustRef(t, osgrid.Distance(x0+800), osgrid.Distance(y0-3000)), Width: 200, Height: 200}
+	found, err = m.Search(r, 7, "roads")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(found) != 1 || found[0].StringAttr("name") != "B2" {
+		t.Errorf("unexpected features %v", found)
+	}
+
+	if found, err := m.Search(r, 7, "rivers"); err != nil || len(found) != 0 {
+		t.Errorf("expected no rivers, got %v, %v", found, err)
+	}
+
+	// Which came from the cached tile
+	if st := m.Stats(); st.Hits != 1 || st.Misses != 3 {
+		t.Errorf("expected 1 hit and 3 misses, got %+v", st)
+	}
+
+	if _, err := m.Tile(7, 0, 0); !errors.Is(err, osdata.ErrTileNotFound) {
+		t.Errorf("expected ErrTileNotFound, got %v", err)
+	}
+}
+
+func TestWebMercator(t *testing.T) {
+	path := writeTestMBTiles(t, map[string]string{"format": "pbf"}, map[tileKey][]byte{
+		{14, 8006, (1 << 14) - 1 - 5331}: encodeTile(1, "Summit path", 0, 0, 4096, 4096),
+	})
+
+	m, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer m.Close()
+
+	snowdon, _ := osgrid.ParseGridRef("SH 60986 54375")
+	found, err := m.Search(osgrid.Rect{BottomLeft: snowdon, Width: 1, Height: 1}, 14, "roads")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(found) != 1 {
+		t.Fatalf("expected 1 feature, got %d", len(found))
+	}
+
+	line := found[0].Geometry.(*vector.MultiLineString).Lines[0]
+	if !near(line[0], WebMercator.ToGrid(14, 8006, 5331, 0, 0), 1e-6) ||
+		!near(line[1], WebMercator.ToGrid(14, 8007, 5332, 0, 0), 1e-6) {
+		t.Errorf("unexpected line %v", line)
+	}
+
+	// Tiles at zoom 14 are about 1.5 km across here
+	if d := line[1].E - line[0].E; d < 1400 || d > 1600 {
+		t.Errorf("unexpected tile width %v", d)
+	}
+}
+
+func TestOpenErrors(t *testing.T) {
+	if _, err := Open(filepath.Join(t.TempDir(), "missing.mbtiles")); err == nil {
+		t.Errorf("expected error for missing file")
+	}
+
+	path := writeTestMBTiles(t, map[string]string{"format": "png"}, nil)
+	if _, err := Open(path); err == nil {
+		t.Errorf("expected error for raster tiles")
+	}
+}
@@ -0,0 +1,196 @@
+package mbtiles
+
+import (
+	"math"
+
+	"github.com/usedbytes/osgrid/osdata/vector"
+)
+
+// Conversion between WGS84 latitude and longitude and the National Grid,
+// following "A guide to coordinate systems in Great Britain" (Ordnance
+// Survey). The datum change uses a Helmert transformation, which is accurate
+// to a few metres: plenty for map tiles, but not for surveying.
+
+type ellipsoid struct {
+	a, b float64
+}
+
+var (
+	airy1830 = ellipsoid{6377563.396, 6356256.909}
+	wgs84    = ellipsoid{6378137.000, 6356752.3141}
+)
+
+func (e ellipsoid) e2() float64 {
+	return (e.a*e.a - e.b*e.b) / (e.a * e.a)
+}
+
+// National Grid projection constants
+const (
+	gridF0 = 0.9996012717
+	gridE0 = 400000.0
+	gridN0 = -100000.0
+)
+
+var (
+	gridLat0 = radians(49)
+	gridLon0 = radians(-2)
+)
+
+type helmert struct {
+	tx, ty, tz float64
+	// Parts per million
+	s float64
+	// Arc-seconds
+	rx, ry, rz float64
+}
+
+var wgs84ToOSGB36 = helmert{-446.448, 125.157, -542.060, 20.4894, -0.1502, -0.2470, -0.8421}
+
+func radians(deg float64) float64 {
+	return deg * math.Pi / 180
+}
+
+func degrees(rad float64) float64 {
+	return rad * 180 / math.Pi
+}
+
+func (h helmert) inverse() helmert {
+	return helmert{-h.tx, -h.ty, -h.tz, -h.s, -h.rx, -h.ry, -h.rz}
+}
+
+func (h helmert) apply(x, y, z float64) (float64, float64, float64) {
+	s := 1 + h.s*1e-6
+	rx := radians(h.rx / 3600)
+	ry := radians(h.ry / 3600)
+	rz := radians(h.rz / 3600)
+
+	return h.tx + s*x - rz*y + ry*z,
+		h.ty + rz*x + s*y - rx*z,
+		h.tz - ry*x + rx*y + s*z
+}
+
+// Latitude and longitude (radians) to cartesian coordinates, at height 0
+func (e ellipsoid) toCartesian(lat, lon float64) (float64, float64, float64) {
+	e2 := e.e2()
+	sinLat := math.Sin(lat)
+	nu := e.a / math.Sqrt(1-e2*sinLat*sinLat)
+
+	return nu * math.Cos(lat) * math.Cos(lon),
+		nu * math.Cos(lat) * math.Sin(lon),
+		(1 - e2) * nu * sinLat
+}
+
+func (e ellipsoid) fromCartesian(x, y, z float64) (float64, float64) {
+	e2 := e.e2()
+	p := math.Hypot(x, y)
+
+	lat := math.Atan2(z, p*(1-e2))
+	for i := 0; i < 10; i++ {
+		sinLat := math.Sin(lat)
+		nu := e.a / math.Sqrt(1-e2*sinLat*sinLat)
+		lat = math.Atan2(z+e2*nu*sinLat, p)
+	}
+
+	return lat, math.Atan2(y, x)
+}
+
+// Meridional arc
+func gridM(lat float64) float64 {
+	a, b := airy1830.a, airy1830.b
+	n := (a - b) / (a + b)
+	n2, n3 := n*n, n*n*n
+	dLat, sLat := lat-gridLat0, lat+gridLat0
+
+	return b * gridF0 * ((1+n+5.0/4*n2+5.0/4*n3)*dLat -
+		(3*n+3*n2+21.0/8*n3)*math.Sin(dLat)*math.Cos(sLat) +
+		(15.0/8*n2+15.0/8*n3)*math.Sin(2*dLat)*math.Cos(2*sLat) -
+		35.0/24*n3*math.Sin(3*dLat)*math.Cos(3*sLat))
+}
+
+// Radii of curvature, and eta squared
+func gridRadii(lat float64) (nu, rho, eta2 float64) {
+	a, e2 := airy1830.a, airy1830.e2()
+	s := 1 - e2*math.Sin(lat)*math.Sin(lat)
+
+	nu = a * gridF0 / math.Sqrt(s)
+	rho = a * gridF0 * (1 - e2) / math.Pow(s, 1.5)
+
+	return nu, rho, nu/rho - 1
+}
+
+// Project OSGB36 latitude and longitude (radians) to the National Grid
+func osgb36ToGrid(lat, lon float64) vector.Point {
+	nu, rho, eta2 := gridRadii(lat)
+	sin, cos, tan := math.Sin(lat), math.Cos(lat), math.Tan(lat)
+	cos3, cos5 := cos*cos*cos, cos*cos*cos*cos*cos
+	tan2, tan4 := tan*tan, tan*tan*tan*tan
+
+	I := gridM(lat) + gridN0
+	II := nu / 2 * sin * cos
+	III := nu / 24 * sin * cos3 * (5 - tan2 + 9*eta2)
+	IIIA := nu / 720 * sin * cos5 * (61 - 58*tan2 + tan4)
+	IV := nu * cos
+	V := nu / 6 * cos3 * (nu/rho - tan2)
+	VI := nu / 120 * cos5 * (5 - 18*tan2 + tan4 + 14*eta2 - 58*tan2*eta2)
+
+	dl := lon - gridLon0
+	dl2 := dl * dl
+
+	return vector.Point{
+		E: gridE0 + IV*dl + V*dl2*dl + VI*dl2*dl2*dl,
+		N: I + II*dl2 + III*dl2*dl2 + IIIA*dl2*dl2*dl2,
+	}
+}
+
+// Inverse of osgb36ToGrid
+func gridToOSGB36(p vector.Point) (float64, float64) {
+	a := airy1830.a
+
+	lat := (p.N-gridN0)/(a*gridF0) + gridLat0
+	for i := 0; i < 20; i++ {
+		d := p.N - gridN0 - gridM(lat)
+		if math.Abs(d) < 1e-5 {
+			break
+		}
+		lat += d / (a * gridF0)
+	}
+
+	nu, rho, eta2 := gridRadii(lat)
+	tan, sec := math.Tan(lat), 1/math.Cos(lat)
+	tan2, tan4, tan6 := tan*tan, tan*tan*tan*tan, tan*tan*tan*tan*tan*tan
+	nu3, nu5, nu7 := nu*nu*nu, nu*nu*nu*nu*nu, nu*nu*nu*nu*nu*nu*nu
+
+	VII := tan / (2 * rho * nu)
+	VIII := tan / (24 * rho * nu3) * (5 + 3*tan2 + eta2 - 9*tan2*eta2)
+	IX := tan / (720 * rho * nu5) * (61 + 90*tan2 + 45*tan4)
+	X := sec / nu
+	XI := sec / (6 * nu3) * (nu/rho + 2*tan2)
+	XII := sec / (120 * nu5) * (5 + 28*tan2 + 24*tan4)
+	XIIA := sec / (5040 * nu7) * (61 + 662*tan2 + 1320*tan4 + 720*tan6)
+
+	de := p.E - gridE0
+	de2 := de * de
+
+	return lat - VII*de2 + VIII*de2*de2 - IX*de2*de2*de2,
+		gridLon0 + X*de - XI*de2*de + XII*de2*de2*de - XIIA*de2*de2*de2*de
+}
+
+// wgs84ToGrid converts WGS84 latitude and longitude (degrees) to National
+// Grid coordinates
+func wgs84ToGrid(lat, lon float64) vector.Point {
+	x, y, z := wgs84.toCartesian(radians(lat), radians(lon))
+	x, y, z = wgs84ToOSGB36.apply(x, y, z)
+	lat, lon = airy1830.fromCartesian(x, y, z)
+
+	return osgb36ToGrid(lat, lon)
+}
+
+// gridToWGS84 is the inverse of wgs84ToGrid
+func gridToWGS84(p vector.Point) (float64, float64) {
+	lat, lon := gridToOSGB36(p)
+	x, y, z := airy1830.toCartesian(lat, lon)
+	x, y, z = wgs84ToOSGB36.inverse().apply(x, y, z)
+	lat, lon = wgs84.fromCartesian(x, y, z)
+
+	return degrees(lat), degrees(lon)
+}
@@ -0,0 +1,535 @@
+// Package mvt decodes Mapbox Vector Tiles, which OS Open Zoomstack and the
+// OS Vector Tile API are distributed as.
+//
+// A tile is a set of named layers, each of which has features whose
+// geometry is in tile coordinates: integers from 0 to the layer's extent,
+// with y pointing down. Use Feature.ToVector() to convert the geometry to
+// grid coordinates.
+package mvt
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+
+	"github.com/usedbytes/osgrid/osdata/vector"
+)
+
+// The extent of layers which don't say what theirs is
+const DefaultExtent = 4096
+
+// GeomType is the type of a feature's geometry
+type GeomType int
+
+const (
+	GeomUnknown    GeomType = 0
+	GeomPoint      GeomType = 1
+	GeomLineString GeomType = 2
+	GeomPolygon    GeomType = 3
+)
+
+func (t GeomType) String() string {
+	switch t {
+	case GeomPoint:
+		return "Point"
+	case GeomLineString:
+		return "LineString"
+	case GeomPolygon:
+		return "Polygon"
+	}
+
+	return "Unknown"
+}
+
+// Point is a position in tile coordinates
+type Point struct {
+	X, Y int32
+}
+
+// Feature is a feature in a layer
+type Feature struct {
+	// 0 if the feature has no ID
+	ID   uint64
+	Type GeomType
+	// Values are string, float64, int64, uint64 (only if too large for an
+	// int64) or bool
+	Attributes map[string]interface{}
+	// The points (for GeomPoint), lines (GeomLineString) or rings
+	// (GeomPolygon). Rings aren't explicitly closed.
+	Geometry [][]Point
+}
+
+// Layer is one of the layers in a tile
+type Layer struct {
+	Name     string
+	Version  int
+	Extent   int
+	Features []*Feature
+}
+
+// Tile is a decoded vector tile
+type Tile struct {
+	Layers []*Layer
+}
+
+// Layer returns the layer called name, or nil if there isn't one
+func (t *Tile) Layer(name string) *Layer {
+	for _, l := range t.Layers {
+		if l.Name == name {
+			return l
+		}
+	}
+
+	return nil
+}
+
+// ToVector converts the feature's geometry to a vector.Geometry, using
+// transform to convert tile coordinates to grid coordinates. Points become
+// vector.MultiPoint, lines become vector.MultiLineString and polygons
+// become vector.Polygon. Features with unknown geometry return nil.
+func (f *Feature) ToVector(transform func(x, y float64) vector.Point) vector.Geometry {
+	parts := make([][]vector.Point, len(f.Geometry))
+	for i, part := range f.Geometry {
+		parts[i] = make([]vector.Point, len(part))
+		for j, p := range part {
+			parts[i][j] = transform(float64(p.X), float64(p.Y))
+		}
+	}
+
+	switch f.Type {
+	case GeomPoint:
+		var pts []vector.Point
+		for _, part := range parts {
+			pts = append(pts, part...)
+		}
+
+		return &vector.MultiPoint{Points: pts}
+	case GeomLineString:
+		return &vector.MultiLineString{Lines: parts}
+	case GeomPolygon:
+		return &vector.Polygon{Rings: parts}
+	}
+
+	return nil
+}
+
+// Protocol buffer field numbers
+const (
+	tileLayers = 3
+
+	layerVersion  = 15
+	layerName     = 1
+	layerFeatures = 2
+	layerKeys     = 3
+	layerValues   = 4
+	layerExtent   = 5
+
+	featureID       = 1
+	featureTags     = 2
+	featureType     = 3
+	featureGeometry = 4
+
+	valueString = 1
+	valueFloat  = 2
+	valueDouble = 3
+	valueInt    = 4
+	valueUint   = 5
+	valueSint   = 6
+	valueBool   = 7
+)
+
+// Protocol buffer wire types
+const (
+	wireVarint  = 0
+	wireFixed64 = 1
+	wireBytes   = 2
+	wireFixed32 = 5
+)
+
+// Geometry commands
+const (
+	cmdMoveTo    = 1
+	cmdLineTo    = 2
+	cmdClosePath = 7
+)
+
+var errTruncated = errors.New("truncated")
+
+// A minimal protocol buffer reader
+type pbReader struct {
+	b []byte
+}
+
+func (r *pbReader) done() bool {
+	return len(r.b) == 0
+}
+
+func (r *pbReader) varint() (uint64, error) {
+	v, n := binary.Uvarint(r.b)
+	if n <= 0 {
+		return 0, errTruncated
+	}
+	r.b = r.b[n:]
+
+	return v, nil
+}
+
+// Read a field's number and wire type
+func (r *pbReader) key() (int, int, error) {
+	k, err := r.varint()
+	if err != nil {
+		return 0, 0, err
+	}
+
+	return int(k >> 3), int(k & 0x7), nil
+}
+
+func (r *pbReader) take(n uint64) ([]byte, error) {
+	if n > uint64(len(r.b)) {
+		return nil, errTruncated
+	}
+
+	b := r.b[:n]
+	r.b = r.b[n:]
+
+	return b, nil
+}
+
+func (r *pbReader) bytes() ([]byte, error) {
+	n, err := r.varint()
+	if err != nil {
+		return nil, err
+	}
+
+	return r.take(n)
+}
+
+func (r *pbReader) skip(wireType int) error {
+	var err error
+	switch wireType {
+	case wireVarint:
+		_, err = r.varint()
+	case wireFixed64:
+		_, err = r.take(8)
+	case wireBytes:
+		_, err = r.bytes()
+	case wireFixed32:
+		_, err = r.take(4)
+	default:
+		err = fmt.Errorf("unsupported wire type %d", wireType)
+	}
+
+	return err
+}
+
+// Read a repeated uint32, which may or may not be packed
+func (r *pbReader) uint32s(wireType int, vs []uint32) ([]uint32, error) {
+	if wireType == wireVarint {
+		v, err := r.varint()
+		return append(vs, uint32(v)), err
+	}
+
+	if wireType != wireBytes {
+		return nil, fmt.Errorf("unexpected wire type %d", wireType)
+	}
+
+	b, err := r.bytes()
+	if err != nil {
+		return nil, err
+	}
+
+	packed := &pbReader{b}
+	for !packed.done() {
+		v, err := packed.varint()
+		if err != nil {
+			return nil, err
+		}
+
+		vs = append(vs, uint32(v))
+	}
+
+	return vs, nil
+}
+
+// Decode decodes an uncompressed vector tile
+func Decode(data []byte) (*Tile, error) {
+	t := &Tile{}
+	r := &pbReader{data}
+
+	for !r.done() {
+		field, wireType, err := r.key()
+		if err != nil {
+			return nil, err
+		}
+
+		if field != tileLayers || wireType != wireBytes {
+			if err := r.skip(wireType); err != nil {
+				return nil, err
+			}
+			continue
+		}
+
+		b, err := r.bytes()
+		if err != nil {
+			return nil, err
+		}
+
+		l, err := decodeLayer(b)
+		if err != nil {
+			return nil, fmt.Errorf("layer %d: %w", len(t.Layers), err)
+		}
+
+		t.Layers = append(t.Layers, l)
+	}
+
+	return t, nil
+}
+
+func decodeLayer(b []byte) (*Layer, error) {
+	l := &Layer{
+		Version: 1,
+		Extent:  DefaultExtent,
+	}
+
+	var keys []string
+	var values []interface{}
+	// Features can come before the keys and values they refer to
+	var features [][]byte
+
+	r := &pbReader{b}
+	for !r.done() {
+		field, wireType, err := r.key()
+		if err != nil {
+			return nil, err
+		}
+
+		switch {
+		case field == layerName && wireType == wireBytes:
+			s, err := r.bytes()
+			if err != nil {
+				return nil, err
+			}
+			l.Name = string(s)
+		case field == layerFeatures && wireType == wireBytes:
+			f, err := r.bytes()
+			if err != nil {
+				return nil, err
+			}
+			features = append(features, f)
+		case field == layerKeys && wireType == wireBytes:
+			s, err := r.bytes()
+			if err != nil {
+				return nil, err
+			}
+			keys = append(keys, string(s))
+		case field == layerValues && wireType == wireBytes:
+			vb, err := r.bytes()
+			if err != nil {
+				return nil, err
+			}
+
+			v, err := decodeValue(vb)
+			if err != nil {
+				return nil, fmt.Errorf("value %d: %w", len(values), err)
+			}
+			values = append(values, v)
+		case field == layerExtent && wireType == wireVarint:
+			v, err := r.varint()
+			if err != nil {
+				return nil, err
+			}
+			l.Extent = int(v)
+		case field == layerVersion && wireType == wireVarint:
+			v, err := r.varint()
+			if err != nil {
+				return nil, err
+			}
+			l.Version = int(v)
+		default:
+			if err := r.skip(wireType); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	if l.Extent <= 0 {
+		return nil, fmt.Errorf("'%s' has invalid extent %d", l.Name, l.Extent)
+	}
+
+	for i, fb := range features {
+		f, err := decodeFeature(fb, keys, values)
+		if err != nil {
+			return nil, fmt.Errorf("'%s' feature %d: %w", l.Name, i, err)
+		}
+
+		l.Features = append(l.Features, f)
+	}
+
+	return l, nil
+}
+
+func decodeValue(b []byte) (interface{}, error) {
+	var v interface{}
+
+	r := &pbReader{b}
+	for !r.done() {
+		field, wireType, err := r.key()
+		if err != nil {
+			return nil, err
+		}
+
+		switch {
+		case field == valueString && wireType == wireBytes:
+			s, err := r.bytes()
+			if err != nil {
+				return nil, err
+			}
+			v = string(s)
+		case field == valueFloat && wireType == wireFixed32:
+			b, err := r.take(4)
+			if err != nil {
+				return nil, err
+			}
+			v = float64(math.Float32frombits(binary.LittleEndian.Uint32(b)))
+		case field == valueDouble && wireType == wireFixed64:
+			b, err := r.take(8)
+			if err != nil {
+				return nil, err
+			}
+			v = math.Float64frombits(binary.LittleEndian.Uint64(b))
+		case (field == valueInt || field == valueUint || field == valueSint || field == valueBool) &&
+			wireType == wireVarint:
+			u, err := r.varint()
+			if err != nil {
+				return nil, err
+			}
+
+			switch field {
+			case valueInt:
+				v = int64(u)
+			case valueUint:
+				if u > math.MaxInt64 {
+					v = u
+				} else {
+					v = int64(u)
+				}
+			case valueSint:
+				v = unzigzag(u)
+			case valueBool:
+				v = u != 0
+			}
+		default:
+			if err := r.skip(wireType); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	return v, nil
+}
+
+func unzigzag(u uint64) int64 {
+	return int64(u>>1) ^ -int64(u&1)
+}
+
+func decodeFeature(b []byte, keys []string, values []interface{}) (*Feature, error) {
+	f := &Feature{}
+
+	var tags, geometry []uint32
+
+	r := &pbReader{b}
+	for !r.done() {
+		field, wireType, err := r.key()
+		if err != nil {
+			return nil, err
+		}
+
+		switch {
+		case field == featureID && wireType == wireVarint:
+			f.ID, err = r.varint()
+		case field == featureType && wireType == wireVarint:
+			var v uint64
+			v, err = r.varint()
+			f.Type = GeomType(v)
+		case field == featureTags:
+			tags, err = r.uint32s(wireType, tags)
+		case field == featureGeometry:
+			geometry, err = r.uint32s(wireType, geometry)
+		default:
+			err = r.skip(wireType)
+		}
+
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if len(tags)%2 != 0 {
+		return nil, fmt.Errorf("odd number of tags")
+	}
+
+	f.Attributes = make(map[string]interface{}, len(tags)/2)
+	for i := 0; i < len(tags); i += 2 {
+		k, v := int(tags[i]), int(tags[i+1])
+		if k >= len(keys) || v >= len(values) {
+			return nil, fmt.Errorf("tag %d out of range", i/2)
+		}
+
+		if values[v] != nil {
+			f.Attributes[keys[k]] = values[v]
+		}
+	}
+
+	var err error
+	f.Geometry, err = decodeGeometry(geometry)
+	if err != nil {
+		return nil, err
+	}
+
+	return f, nil
+}
+
+// Decode geometry commands. Each MoveTo starts a new part.
+func decodeGeometry(cmds []uint32) ([][]Point, error) {
+	var parts [][]Point
+	var x, y int32
+
+	for i := 0; i < len(cmds); {
+		id := cmds[i] & 0x7
+		count := int(cmds[i] >> 3)
+		i++
+
+		switch id {
+		case cmdMoveTo, cmdLineTo:
+			if len(cmds)-i < count*2 {
+				return nil, fmt.Errorf("geometry: %w", errTruncated)
+			}
+
+			if id == cmdLineTo && len(parts) == 0 {
+				return nil, fmt.Errorf("geometry: LineTo before MoveTo")
+			}
+
+			for j := 0; j < count; j++ {
+				x += int32(unzigzag(uint64(cmds[i])))
+				y += int32(unzigzag(uint64(cmds[i+1])))
+				i += 2
+
+				if id == cmdMoveTo {
+					parts = append(parts, []Point{{x, y}})
+				} else {
+					parts[len(parts)-1] = append(parts[len(parts)-1], Point{x, y})
+				}
+			}
+		case cmdClosePath:
+			// Rings are implicitly closed
+			if len(parts) == 0 {
+				return nil, fmt.Errorf("geometry: ClosePath before MoveTo")
+			}
+		default:
+			return nil, fmt.Errorf("geometry: unknown command %d", id)
+		}
+	}
+
+	return parts, nil
+}
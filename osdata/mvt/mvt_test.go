@@ -0,0 +1,196 @@
+package mvt
+
+import (
+	"encoding/binary"
+	"math"
+	"reflect"
+	"testing"
+
+	"github.com/usedbytes/osgrid/osdata/mvt/mvttest"
+	"github.com/usedbytes/osgrid/osdata/vector"
+)
+
+// A minimal protocol buffer writer
+type pbWriter struct {
+	b []byte
+}
+
+func (w *pbWriter) varint(field int, v uint64) {
+	w.b = mvttest.AppendVarint(w.b, uint64(field<<3|wireVarint))
+	w.b = mvttest.AppendVarint(w.b, v)
+}
+
+func (w *pbWriter) bytes(field int, b []byte) {
+	w.b = mvttest.AppendBytes(w.b, field, b)
+}
+
+func (w *pbWriter) packed(field int, vs []uint32) {
+	var b []byte
+	for _, v := range vs {
+		b = mvttest.AppendVarint(b, uint64(v))
+	}
+	w.bytes(field, b)
+}
+
+func cmd(id, count int) uint32 {
+	return uint32(id&0x7 | count<<3)
+}
+
+func TestDecode(t *testing.T) {
+	values := &pbWriter{}
+
+	layer := &pbWriter{}
+	layer.varint(layerVersion, 2)
+	layer.bytes(layerName, []byte("roads"))
+	layer.varint(layerExtent, 512)
+
+	// A line, before the keys and values
+	f := &pbWriter{}
+	f.varint(featureID, 42)
+	f.packed(featureTags, []uint32{0, 0, 1, 1})
+	f.varint(featureType, uint64(GeomLineString))
+	f.packed(featureGeometry, []uint32{
+		cmd(cmdMoveTo, 1), mvttest.Zigzag(10), mvttest.Zigzag(20),
+		cmd(cmdLineTo, 2), mvttest.Zigzag(5), mvttest.Zigzag(0), mvttest.Zigzag(0), mvttest.Zigzag(-10),
+		cmd(cmdMoveTo, 1), mvttest.Zigzag(100), mvttest.Zigzag(100),
+		cmd(cmdLineTo, 1), mvttest.Zigzag(1), mvttest.Zigzag(1),
+	})
+	layer.bytes(layerFeatures, f.b)
+
+	// A polygon with a hole, and unpacked tags
+	f = &pbWriter{}
+	f.varint(featureTags, 2)
+	f.varint(featureTags, 2)
+	f.varint(featureType, uint64(GeomPolygon))
+	f.packed(featureGeometry, []uint32{
+		cmd(cmdMoveTo, 1), mvttest.Zigzag(0), mvttest.Zigzag(0),
+		cmd(cmdLineTo, 3), mvttest.Zigzag(10), mvttest.Zigzag(0), mvttest.Zigzag(0), mvttest.Zigzag(10), mvttest.Zigzag(-10), mvttest.Zigzag(0),
+		cmd(cmdClosePath, 1),
+		cmd(cmdMoveTo, 1), mvttest.Zigzag(2), mvttest.Zigzag(-8),
+		cmd(cmdLineTo, 3), mvttest.Zigzag(0), mvttest.Zigzag(5), mvttest.Zigzag(5), mvttest.Zigzag(0), mvttest.Zigzag(0), mvttest.Zigzag(-5),
+		cmd(cmdClosePath, 1),
+	})
+	// An unknown field, which is ignored
+	f.varint(99, 1)
+	layer.bytes(layerFeatures, f.b)
+
+	layer.bytes(layerKeys, []byte("name"))
+	layer.bytes(layerKeys, []byte("lanes"))
+	layer.bytes(layerKeys, []byte("height"))
+
+	values.bytes(valueString, []byte("A1"))
+	layer.bytes(layerValues, values.b)
+	values = &pbWriter{}
+	values.varint(valueSint, uint64(mvttest.Zigzag(-2)))
+	layer.bytes(layerValues, values.b)
+	values = &pbWriter{}
+	values.b = append(values.b, valueDouble<<3|wireFixed64)
+	values.b = append(values.b, make([]byte, 8)...)
+	binary.LittleEndian.PutUint64(values.b[len(values.b)-8:], math.Float64bits(12.5))
+	layer.bytes(layerValues, values.b)
+
+	// A points layer, with the default extent
+	points := &pbWriter{}
+	points.bytes(layerName, []byte("names"))
+	f = &pbWriter{}
+	f.varint(featureType, uint64(GeomPoint))
+	f.packed(featureGeometry, []uint32{cmd(cmdMoveTo, 2), mvttest.Zigzag(1), mvttest.Zigzag(2), mvttest.Zigzag(3), mvttest.Zigzag(4)})
+	points.bytes(layerFeatures, f.b)
+
+	tile := &pbWriter{}
+	tile.bytes(tileLayers, layer.b)
+	tile.bytes(tileLayers, points.b)
+
+	decoded, err := Decode(tile.b)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(decoded.Layers) != 2 || decoded.Layer("buildings") != nil {
+		t.Fatalf("unexpected layers %v", decoded.Layers)
+	}
+
+	roads := decoded.Layer("roads")
+	if roads.Version != 2 || roads.Extent != 512 || len(roads.Features) != 2 {
+		t.Fatalf("unexpected layer %+v", roads)
+	}
+
+	line := roads.Features[0]
+	if line.ID != 42 || line.Type != GeomLineString ||
+		!reflect.DeepEqual(line.Attributes, map[string]interface{}{"name": "A1", "lanes": int64(-2)}) {
+		t.Errorf("unexpected feature %+v", line)
+	}
+
+	expLines := [][]Point{{{10, 20}, {15, 20}, {15, 10}}, {{115, 110}, {116, 111}}}
+	if !reflect.DeepEqual(line.Geometry, expLines) {
+		t.Errorf("expected %v, got %v", expLines, line.Geometry)
+	}
+
+	poly := roads.Features[1]
+	if !reflect.DeepEqual(poly.Attributes, map[string]interface{}{"height": 12.5}) {
+		t.Errorf("unexpected attributes %v", poly.Attributes)
+	}
+
+	// Tile coordinates to metres, with y flipped
+	g := poly.ToVector(func(x, y float64) vector.Point {
+		return vector.Point{E: 1000 + x, N: 2000 - y}
+	})
+
+	p, ok := g.(*vector.Polygon)
+	if !ok || len(p.Rings) != 2 {
+		t.Fatalf("unexpected geometry %#v", g)
+	}
+
+	if !p.Contains(vector.Point{E: 1001, N: 1999}) || p.Contains(vector.Point{E: 1003, N: 1995}) {
+		t.Errorf("unexpected polygon %v", p.Rings)
+	}
+
+	names := decoded.Layer("names")
+	if names.Extent != DefaultExtent || names.Version != 1 {
+		t.Errorf("unexpected layer %+v", names)
+	}
+
+	mp, ok := names.Features[0].ToVector(func(x, y float64) vector.Point {
+		return vector.Point{E: x, N: y}
+	}).(*vector.MultiPoint)
+	if !ok || !reflect.DeepEqual(mp.Points, []vector.Point{{E: 1, N: 2}, {E: 4, N: 6}}) {
+		t.Errorf("unexpected points %#v", mp)
+	}
+}
+
+func TestDecodeErrors(t *testing.T) {
+	feature := func(geometry []uint32, tags []uint32) []byte {
+		f := &pbWriter{}
+		f.packed(featureTags, tags)
+		f.varint(featureType, uint64(GeomLineString))
+		f.packed(featureGeometry, geometry)
+
+		layer := &pbWriter{}
+		layer.bytes(layerName, []byte("bad"))
+		layer.bytes(layerFeatures, f.b)
+
+		tile := &pbWriter{}
+		tile.bytes(tileLayers, layer.b)
+
+		return tile.b
+	}
+
+	valid := feature([]uint32{cmd(cmdMoveTo, 1), 0, 0}, nil)
+	if _, err := Decode(valid); err != nil {
+		t.Fatal(err)
+	}
+
+	for name, b := range map[string][]byte{
+		"truncated":     valid[:len(valid)-1],
+		"LineTo first":  feature([]uint32{cmd(cmdLineTo, 1), 0, 0}, nil),
+		"short params":  feature([]uint32{cmd(cmdMoveTo, 2), 0, 0}, nil),
+		"unknown cmd":   feature([]uint32{cmd(3, 1)}, nil),
+		"odd tags":      feature(nil, []uint32{0}),
+		"tag range":     feature(nil, []uint32{0, 0}),
+		"bad wire type": {tileLayers<<3 | 3},
+	} {
+		if _, err := Decode(b); err == nil {
+			t.Errorf("%s: expected error", name)
+		}
+	}
+}
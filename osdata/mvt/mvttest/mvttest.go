@@ -0,0 +1,26 @@
+// Package mvttest has helpers for encoding Mapbox Vector Tiles, for testing
+// the packages which read them
+package mvttest
+
+import (
+	"encoding/binary"
+)
+
+// AppendVarint appends v to b, as a protocol buffer varint
+func AppendVarint(b []byte, v uint64) []byte {
+	buf := make([]byte, binary.MaxVarintLen64)
+	return append(b, buf[:binary.PutUvarint(buf, v)]...)
+}
+
+// AppendBytes appends a length-delimited protocol buffer field to b
+func AppendBytes(b []byte, field int, data []byte) []byte {
+	b = AppendVarint(b, uint64(field<<3|2))
+	b = AppendVarint(b, uint64(len(data)))
+	return append(b, data...)
+}
+
+// Zigzag encodes v as an unsigned integer, as in vector tile geometry
+// parameters and sint values
+func Zigzag(v int32) uint32 {
+	return uint32((v << 1) ^ (v >> 31))
+}
@@ -0,0 +1,277 @@
+package osdata
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sync"
+
+	"github.com/usedbytes/osgrid"
+)
+
+// NoData is returned by Float64Tile and Float64Database for points which have
+// no value. It is a NaN, so must be tested for with IsNoData()
+var NoData = math.NaN()
+
+func IsNoData(v float64) bool {
+	return math.IsNaN(v)
+}
+
+// ErrTileNotFound is wrapped by errors returned by databases which don't
+// have a tile for the requested location
+var ErrTileNotFound = errors.New("not found")
+
+type MissingTilePolicy int
+
+const (
+	// Return an error wrapping ErrTileNotFound
+	MissingTileError MissingTilePolicy = iota
+	// Return a constant value for every point in a missing tile
+	MissingTileFillConstant
+	// Return the value of the nearest point in any available tile
+	MissingTileFillNearest
+)
+
+// How many tiles away from a missing tile to look for data for
+// MissingTileFillNearest
+const maxFillNearestTiles = 3
+
+// PartialCoverage is implemented by databases whose tiles needn't line up
+// with a regular grid, so a tile missing for one point doesn't mean that the
+// whole grid square around it is missing.
+type PartialCoverage interface {
+	// HasData returns true if there's data for any part of r
+	HasData(r osgrid.Rect) bool
+}
+
+type fillDatabase struct {
+	Float64Database
+	tileSize osgrid.Distance
+	policy   MissingTilePolicy
+	value    float64
+
+	sync.Mutex
+	// true for squares which are missing entirely, false for ones which
+	// are only partly covered, so db still has to be asked
+	missing map[osgrid.GridRef]bool
+}
+
+type fillTile struct {
+	db         *fillDatabase
+	bottomLeft osgrid.GridRef
+}
+
+func (t *fillTile) Width() osgrid.Distance {
+	return t.db.tileSize
+}
+
+func (t *fillTile) Height() osgrid.Distance {
+	return t.db.tileSize
+}
+
+func (t *fillTile) Precision() osgrid.Distance {
+	return t.db.Precision()
+}
+
+func (t *fillTile) BottomLeft() osgrid.GridRef {
+	return t.bottomLeft
+}
+
+func (t *fillTile) String() string {
+	return t.bottomLeft.String()
+}
+
+func (t *fillTile) GetFloat64(ref osgrid.GridRef) (float64, error) {
+	if ref.Align(t.db.tileSize) != t.bottomLeft {
+		return NoData, fmt.Errorf("Coordinate outside tile")
+	}
+
+	return t.db.fill(ref)
+}
+
+// FillMissingTiles wraps db, applying policy to queries which fall in tiles
+// that db doesn't have. tileSize must match the tiles in db, unless db
+// implements PartialCoverage. value is only used for MissingTileFillConstant,
+// and may be NoData.
+//
+// Missing tiles are remembered, so db is only asked for each one once. If db
+// implements PartialCoverage, squares of tileSize with data in any part of
+// them are never remembered as missing.
+func FillMissingTiles(db Float64Database, tileSize osgrid.Distance,
+	policy MissingTilePolicy, value float64) Float64Database {
+
+	return &fillDatabase{
+		Float64Database: db,
+		tileSize:        tileSize,
+		policy:          policy,
+		value:           value,
+		missing:         make(map[osgrid.GridRef]bool),
+	}
+}
+
+func (d *fillDatabase) isMissing(ref osgrid.GridRef) bool {
+	d.Lock()
+	defer d.Unlock()
+
+	return d.missing[ref.Align(d.tileSize)]
+}
+
+func (d *fillDatabase) setMissing(ref osgrid.GridRef) {
+	square := ref.Align(d.tileSize)
+
+	d.Lock()
+	_, known := d.missing[square]
+	d.Unlock()
+
+	if known {
+		return
+	}
+
+	missing := true
+	if pc, ok := d.Float64Database.(PartialCoverage); ok {
+		missing = !pc.HasData(osgrid.Rect{BottomLeft: square, Width: d.tileSize, Height: d.tileSize})
+	}
+
+	d.Lock()
+	defer d.Unlock()
+
+	d.missing[square] = missing
+}
+
+func (d *fillDatabase) getFloat64(ref osgrid.GridRef) (float64, bool, error) {
+	if d.isMissing(ref) {
+		return NoData, false, nil
+	}
+
+	v, err := d.Float64Database.GetFloat64(ref)
+	if errors.Is(err, ErrTileNotFound) {
+		d.setMissing(ref)
+		return NoData, false, nil
+	}
+
+	return v, true, err
+}
+
+func clamp(v, min, max osgrid.Distance) osgrid.Distance {
+	if v < min {
+		return min
+	} else if v > max {
+		return max
+	}
+
+	return v
+}
+
+func (d *fillDatabase) nearest(ref osgrid.GridRef) (float64, error) {
+	tile := ref.Align(d.tileSize)
+	last := d.tileSize - d.Precision()
+
+	// Offset of ref within its tile
+	offEast := ref.TileEasting() - tile.TileEasting()
+	offNorth := ref.TileNorthing() - tile.TileNorthing()
+
+	found := false
+	best := math.MaxFloat64
+	value := NoData
+
+	// Search in rings of tiles around the missing one. Within each tile the
+	// candidate is the point on the tile closest to ref
+	for ring := 1; ring <= maxFillNearestTiles; ring++ {
+		for y := -ring; y <= ring; y++ {
+			for x := -ring; x <= ring; x++ {
+				if x != -ring && x != ring && y != -ring && y != ring {
+					continue
+				}
+
+				dx := offEast - osgrid.Distance(x)*d.tileSize
+				dy := offNorth - osgrid.Distance(y)*d.tileSize
+
+				east := clamp(dx, 0, last)
+				north := clamp(dy, 0, last)
+
+				candidate, err := tile.Add(osgrid.Distance(x)*d.tileSize+east,
+					osgrid.Distance(y)*d.tileSize+north)
+				if err != nil {
+					// Off the edge of the grid
+					continue
+				}
+
+				v, ok, err := d.getFloat64(candidate)
+				if err != nil {
+					return NoData, err
+				}
+				if !ok || IsNoData(v) {
+					continue
+				}
+
+				dist := math.Hypot(float64(dx-east), float64(dy-north))
+				if dist < best {
+					found = true
+					best = dist
+					value = v
+				}
+			}
+		}
+
+		// Every point in the next ring is at least ring tiles away, but
+		// a straight-line neighbour there can still be closer than a
+		// diagonal one in this ring
+		if found && float64(ring)*float64(d.tileSize) >= best {
+			return value, nil
+		}
+	}
+
+	if found {
+		return value, nil
+	}
+
+	return NoData, fmt.Errorf("Tile %s %w, and no data within %d tiles",
+		tile, ErrTileNotFound, maxFillNearestTiles)
+}
+
+func (d *fillDatabase) fill(ref osgrid.GridRef) (float64, error) {
+	v, ok, err := d.getFloat64(ref)
+	if ok || err != nil {
+		return v, err
+	}
+
+	switch d.policy {
+	case MissingTileFillConstant:
+		return d.value, nil
+	case MissingTileFillNearest:
+		return d.nearest(ref)
+	}
+
+	return NoData, fmt.Errorf("Tile %s %w", ref.Align(d.tileSize), ErrTileNotFound)
+}
+
+func (d *fillDatabase) GetFloat64(ref osgrid.GridRef) (float64, error) {
+	return d.fill(ref)
+}
+
+func (d *fillDatabase) getTile(ref osgrid.GridRef) (Float64Tile, error) {
+	if !d.isMissing(ref) {
+		tile, err := d.Float64Database.GetFloat64Tile(ref)
+		if !errors.Is(err, ErrTileNotFound) {
+			return tile, err
+		}
+		d.setMissing(ref)
+	}
+
+	if d.policy == MissingTileError {
+		return nil, fmt.Errorf("Tile %s %w", ref.Align(d.tileSize), ErrTileNotFound)
+	}
+
+	return &fillTile{
+		db:         d,
+		bottomLeft: ref.Align(d.tileSize),
+	}, nil
+}
+
+func (d *fillDatabase) GetTile(ref osgrid.GridRef) (Tile, error) {
+	return d.getTile(ref)
+}
+
+func (d *fillDatabase) GetFloat64Tile(ref osgrid.GridRef) (Float64Tile, error) {
+	return d.getTile(ref)
+}
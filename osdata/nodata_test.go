@@ -0,0 +1,205 @@
+package osdata
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/usedbytes/osgrid"
+)
+
+// Database with 10 km tiles, which only has tiles West of easting 'coast'
+type TestCoastDatabase struct {
+	TestFloat64Database
+	coast   osgrid.Distance
+	queries int
+	// If set, only these tiles exist
+	tiles map[osgrid.GridRef]bool
+}
+
+func (db *TestCoastDatabase) missing(ref osgrid.GridRef) bool {
+	tile := ref.Align(10 * osgrid.Kilometre)
+	if db.tiles != nil {
+		return !db.tiles[tile]
+	}
+
+	return tile.AbsEasting() >= db.coast
+}
+
+func (db *TestCoastDatabase) GetFloat64(ref osgrid.GridRef) (float64, error) {
+	db.queries++
+
+	if db.missing(ref) {
+		return NoData, fmt.Errorf("Tile %s %w", ref.Align(10*osgrid.Kilometre), ErrTileNotFound)
+	}
+
+	return db.TestFloat64Database.GetFloat64(ref)
+}
+
+func (db *TestCoastDatabase) GetFloat64Tile(ref osgrid.GridRef) (Float64Tile, error) {
+	if db.missing(ref) {
+		return nil, fmt.Errorf("Tile %s %w", ref.Align(10*osgrid.Kilometre), ErrTileNotFound)
+	}
+
+	return &TestCoastTile{
+		db:         db,
+		bottomLeft: ref.Align(10 * osgrid.Kilometre),
+	}, nil
+}
+
+// 10 km tile, which just gets its values from the database
+type TestCoastTile struct {
+	db         *TestCoastDatabase
+	bottomLeft osgrid.GridRef
+}
+
+func (t *TestCoastTile) Width() osgrid.Distance {
+	return 10 * osgrid.Kilometre
+}
+
+func (t *TestCoastTile) Height() osgrid.Distance {
+	return 10 * osgrid.Kilometre
+}
+
+func (t *TestCoastTile) Precision() osgrid.Distance {
+	return t.db.Precision()
+}
+
+func (t *TestCoastTile) BottomLeft() osgrid.GridRef {
+	return t.bottomLeft
+}
+
+func (t *TestCoastTile) String() string {
+	return t.bottomLeft.String()
+}
+
+func (t *TestCoastTile) GetFloat64(ref osgrid.GridRef) (float64, error) {
+	if ref.Align(10*osgrid.Kilometre) != t.bottomLeft {
+		return NoData, fmt.Errorf("Coordinate outside tile")
+	}
+
+	return t.db.GetFloat64(ref)
+}
+
+func newTestCoastDatabase() *TestCoastDatabase {
+	return &TestCoastDatabase{
+		TestFloat64Database: TestFloat64Database{
+			precision: 50 * osgrid.Metre,
+			f:         func(e, n float64) float64 { return n },
+		},
+		coast: 20 * osgrid.Kilometre,
+	}
+}
+
+func TestFillMissingTilesError(t *testing.T) {
+	db := FillMissingTiles(newTestCoastDatabase(), 10*osgrid.Kilometre, MissingTileError, 0)
+
+	sea, _ := osgrid.Origin().Add(25*osgrid.Kilometre, 5*osgrid.Kilometre)
+	_, err := db.GetFloat64(sea)
+	if !errors.Is(err, ErrTileNotFound) {
+		t.Fatalf("expected ErrTileNotFound, got %v", err)
+	}
+
+	_, err = db.GetFloat64Tile(sea)
+	if !errors.Is(err, ErrTileNotFound) {
+		t.Fatalf("expected ErrTileNotFound, got %v", err)
+	}
+}
+
+func TestFillMissingTilesConstant(t *testing.T) {
+	coast := newTestCoastDatabase()
+	db := FillMissingTiles(coast, 10*osgrid.Kilometre, MissingTileFillConstant, -1)
+
+	sea, _ := osgrid.Origin().Add(25*osgrid.Kilometre, 5*osgrid.Kilometre)
+	for i := 0; i < 3; i++ {
+		v, err := db.GetFloat64(sea)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if v != -1 {
+			t.Fatalf("expected -1, got %v", v)
+		}
+	}
+
+	if coast.queries != 1 {
+		t.Errorf("missing tile should only be queried once, got %d", coast.queries)
+	}
+
+	land, _ := osgrid.Origin().Add(5*osgrid.Kilometre, 5*osgrid.Kilometre)
+	v, err := db.GetFloat64(land)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if v != 5000 {
+		t.Fatalf("expected 5000, got %v", v)
+	}
+
+	tile, err := db.GetFloat64Tile(sea)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if tile.BottomLeft() != sea.Align(10*osgrid.Kilometre) {
+		t.Errorf("unexpected tile %v", tile)
+	}
+
+	v, err = tile.GetFloat64(sea)
+	if err != nil || v != -1 {
+		t.Errorf("expected -1 from fill tile, got %v, %v", v, err)
+	}
+}
+
+func TestFillMissingTilesNoData(t *testing.T) {
+	db := FillMissingTiles(newTestCoastDatabase(), 10*osgrid.Kilometre, MissingTileFillConstant, NoData)
+
+	sea, _ := osgrid.Origin().Add(25*osgrid.Kilometre, 5*osgrid.Kilometre)
+	v, err := db.GetFloat64(sea)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !IsNoData(v) {
+		t.Fatalf("expected NoData, got %v", v)
+	}
+}
+
+func TestFillMissingTilesNearest(t *testing.T) {
+	db := FillMissingTiles(newTestCoastDatabase(), 10*osgrid.Kilometre, MissingTileFillNearest, 0)
+
+	// The closest point is due West, on the last column of the 10-20 km tile
+	sea, _ := osgrid.Origin().Add(25*osgrid.Kilometre, 5*osgrid.Kilometre+120)
+	v, err := db.GetFloat64(sea)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if v != 5100 {
+		t.Fatalf("expected 5100, got %v", v)
+	}
+
+	// The nearest tile to a point near the East edge of its tile is two
+	// tiles East, rather than the diagonal neighbour to the North-West
+	islands := newTestCoastDatabase()
+	diagonal, _ := osgrid.Origin().Add(10*osgrid.Kilometre, 30*osgrid.Kilometre)
+	east, _ := osgrid.Origin().Add(40*osgrid.Kilometre, 20*osgrid.Kilometre)
+	islands.tiles = map[osgrid.GridRef]bool{diagonal: true, east: true}
+
+	ref, _ := osgrid.Origin().Add(29900, 25*osgrid.Kilometre)
+	v, err = FillMissingTiles(islands, 10*osgrid.Kilometre, MissingTileFillNearest, 0).GetFloat64(ref)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if v != 25000 {
+		t.Fatalf("expected 25000, got %v", v)
+	}
+
+	// Too far from any data
+	farSea, _ := osgrid.Origin().Add(60*osgrid.Kilometre, 5*osgrid.Kilometre)
+	_, err = db.GetFloat64(farSea)
+	if !errors.Is(err, ErrTileNotFound) {
+		t.Fatalf("expected ErrTileNotFound, got %v", err)
+	}
+}
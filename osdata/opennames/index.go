@@ -0,0 +1,105 @@
+package opennames
+
+import (
+	"sort"
+	"strings"
+	"unicode/utf8"
+)
+
+// nameIndex finds entries by their normalised names, so that Lookup and
+// Search don't have to look at every feature
+type nameIndex struct {
+	entries map[string][]int32
+	// Every name, sorted, for prefix searches
+	names []string
+
+	// The names containing each word
+	words map[string][]string
+	// Every word, sorted, for prefix searches
+	sortedWords []string
+
+	// The names of each length (in runes), for spelling differences
+	lengths map[int][]string
+}
+
+func newNameIndex() nameIndex {
+	return nameIndex{
+		entries: make(map[string][]int32),
+		words:   make(map[string][]string),
+		lengths: make(map[int][]string),
+	}
+}
+
+func (ni *nameIndex) add(name string, idx int32) {
+	if name == "" {
+		return
+	}
+
+	if _, ok := ni.entries[name]; !ok {
+		ni.names = append(ni.names, name)
+
+		for _, w := range strings.Fields(name) {
+			if _, ok := ni.words[w]; !ok {
+				ni.sortedWords = append(ni.sortedWords, w)
+			}
+			ni.words[w] = append(ni.words[w], name)
+		}
+
+		l := utf8.RuneCountInString(name)
+		ni.lengths[l] = append(ni.lengths[l], name)
+	}
+
+	ni.entries[name] = append(ni.entries[name], idx)
+}
+
+// sort must be called after adding all of the names, before searching
+func (ni *nameIndex) sort() {
+	sort.Strings(ni.names)
+	sort.Strings(ni.sortedWords)
+}
+
+// withPrefix returns the part of sorted which starts with prefix
+func withPrefix(sorted []string, prefix string) []string {
+	start := sort.SearchStrings(sorted, prefix)
+
+	end := start
+	for end < len(sorted) && strings.HasPrefix(sorted[end], prefix) {
+		end++
+	}
+
+	return sorted[start:end]
+}
+
+// prefixed returns the names starting with prefix
+func (ni *nameIndex) prefixed(prefix string) []string {
+	return withPrefix(ni.names, prefix)
+}
+
+// withWords returns the names which have a word starting with each of
+// prefixes
+func (ni *nameIndex) withWords(prefixes []string) []string {
+	var found map[string]bool
+
+	for i, p := range prefixes {
+		next := make(map[string]bool)
+		for _, w := range withPrefix(ni.sortedWords, p) {
+			for _, name := range ni.words[w] {
+				if i == 0 || found[name] {
+					next[name] = true
+				}
+			}
+		}
+
+		found = next
+		if len(found) == 0 {
+			return nil
+		}
+	}
+
+	names := make([]string, 0, len(found))
+	for name := range found {
+		names = append(names, name)
+	}
+
+	return names
+}
@@ -0,0 +1,533 @@
+// Package opennames is a gazetteer, using the OS Open Names dataset to find
+// places by name, and names by place.
+//
+// The dataset is distributed as CSV files (without header rows) under a
+// 'DATA' directory, in a zip. Either the zip, or the directory it's extracted
+// to, can be opened.
+package opennames
+
+import (
+	"archive/zip"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/usedbytes/osgrid"
+)
+
+var ErrNotFound = errors.New("not found")
+
+// Column indices in the CSV files
+const (
+	colID               = 0
+	colName1            = 2
+	colName2            = 4
+	colType             = 6
+	colLocalType        = 7
+	colGeometryX        = 8
+	colGeometryY        = 9
+	colMBRXMin          = 12
+	colMBRYMin          = 13
+	colMBRXMax          = 14
+	colMBRYMax          = 15
+	colPostcodeDistrict = 16
+	colPopulatedPlace   = 18
+	colDistrictBorough  = 21
+	colCountyUnitary    = 24
+	colRegion           = 27
+	colCountry          = 29
+
+	numCols = 34
+)
+
+// Size of the cells in the spatial index
+const indexCellSize = 10 * osgrid.Kilometre
+
+// Feature is a named place
+type Feature struct {
+	ID string
+	// The name, and an alternative (e.g. Welsh or Gaelic) name if there is one
+	Name, AltName string
+	// Broad type, e.g. "populatedPlace", "landform" or "hydrography"
+	Type string
+	// Specific type, e.g. "Town", "Hill Or Mountain" or "Valley"
+	LocalType string
+
+	// Representative point, e.g. the summit of a hill or the centre of a
+	// town
+	GridRef osgrid.GridRef
+	// Bounding box of the feature. Zero size for point features.
+	Bounds osgrid.Rect
+
+	// Where the feature is, for telling features with the same name apart.
+	// Any of these may be empty.
+	PostcodeDistrict string
+	PopulatedPlace   string
+	DistrictBorough  string
+	CountyUnitary    string
+	Region           string
+	Country          string
+}
+
+func (f *Feature) String() string {
+	context := f.PopulatedPlace
+	if context == "" || context == f.Name {
+		context = f.CountyUnitary
+	}
+
+	if context == "" {
+		return fmt.Sprintf("%s (%s)", f.Name, f.LocalType)
+	}
+
+	return fmt.Sprintf("%s, %s (%s)", f.Name, context, f.LocalType)
+}
+
+func (f *Feature) area() int64 {
+	return int64(f.Bounds.Width) * int64(f.Bounds.Height)
+}
+
+// Gazetteer holds an index of all of the features in the dataset in memory
+type Gazetteer struct {
+	entries []Feature
+	cells   map[[2]int32][]int32
+	names   nameIndex
+
+	// Share the many repeated strings (types, counties, etc.)
+	strings map[string]string
+}
+
+type Opt func(*openConfig)
+
+type openConfig struct {
+	types map[string]bool
+}
+
+// Only index features with the given Types (e.g. "populatedPlace"), to save
+// memory. Without this, everything is indexed, which includes more than a
+// million postcodes and roads.
+func TypesOpt(types ...string) Opt {
+	return func(cfg *openConfig) {
+		cfg.types = make(map[string]bool)
+		for _, t := range types {
+			cfg.types[t] = true
+		}
+	}
+}
+
+// Open indexes the Open Names CSV files in path, which can be the
+// distribution zip, or a directory containing the CSV files (e.g. the
+// extracted 'DATA' directory).
+func Open(path string, opts ...Opt) (*Gazetteer, error) {
+	var cfg openConfig
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+
+	g := &Gazetteer{
+		cells:   make(map[[2]int32][]int32),
+		names:   newNameIndex(),
+		strings: make(map[string]string),
+	}
+
+	fi, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+
+	if fi.IsDir() {
+		err = filepath.Walk(path, func(p string, fi os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+
+			if fi.IsDir() || !isDataFile(p) {
+				return nil
+			}
+
+			f, err := os.Open(p)
+			if err != nil {
+				return err
+			}
+			defer f.Close()
+
+			return g.read(f, p, &cfg)
+		})
+	} else {
+		err = g.readZip(path, &cfg)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if len(g.entries) == 0 {
+		return nil, fmt.Errorf("no features found in %s", path)
+	}
+
+	g.names.sort()
+
+	return g, nil
+}
+
+func isDataFile(path string) bool {
+	return strings.ToLower(filepath.Ext(path)) == ".csv" &&
+		!strings.Contains(strings.ToLower(filepath.Base(path)), "header")
+}
+
+func (g *Gazetteer) readZip(path string, cfg *openConfig) error {
+	zr, err := zip.OpenReader(path)
+	if err != nil {
+		return err
+	}
+	defer zr.Close()
+
+	for _, f := range zr.File {
+		if !isDataFile(f.Name) {
+			continue
+		}
+
+		r, err := f.Open()
+		if err != nil {
+			return err
+		}
+
+		err = g.read(r, f.Name, cfg)
+		r.Close()
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (g *Gazetteer) intern(s string) string {
+	if v, ok := g.strings[s]; ok {
+		return v
+	}
+
+	// Copy, so that the rest of the CSV record can be freed
+	s = string([]byte(s))
+	g.strings[s] = s
+
+	return s
+}
+
+func parseDistance(s string) (osgrid.Distance, error) {
+	v, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return 0, err
+	}
+
+	return osgrid.Distance(math.Round(v)), nil
+}
+
+func (g *Gazetteer) read(r io.Reader, name string, cfg *openConfig) error {
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = numCols
+
+	for line := 1; ; line++ {
+		record, err := cr.Read()
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+
+		// Tolerate a header row
+		if record[colID] == "ID" {
+			continue
+		}
+
+		if cfg.types != nil && !cfg.types[record[colType]] {
+			continue
+		}
+
+		f, err := g.parseFeature(record)
+		if err != nil {
+			return fmt.Errorf("%s:%d: %w", name, line, err)
+		}
+
+		g.add(f)
+	}
+}
+
+func (g *Gazetteer) parseFeature(record []string) (Feature, error) {
+	f := Feature{
+		ID:               string([]byte(record[colID])),
+		Name:             string([]byte(record[colName1])),
+		AltName:          string([]byte(record[colName2])),
+		Type:             g.intern(record[colType]),
+		LocalType:        g.intern(record[colLocalType]),
+		PostcodeDistrict: g.intern(record[colPostcodeDistrict]),
+		PopulatedPlace:   g.intern(record[colPopulatedPlace]),
+		DistrictBorough:  g.intern(record[colDistrictBorough]),
+		CountyUnitary:    g.intern(record[colCountyUnitary]),
+		Region:           g.intern(record[colRegion]),
+		Country:          g.intern(record[colCountry]),
+	}
+
+	x, err := parseDistance(record[colGeometryX])
+	if err != nil {
+		return Feature{}, fmt.Errorf("invalid GEOMETRY_X: %w", err)
+	}
+
+	y, err := parseDistance(record[colGeometryY])
+	if err != nil {
+		return Feature{}, fmt.Errorf("invalid GEOMETRY_Y: %w", err)
+	}
+
+	f.GridRef, err = osgrid.Origin().Add(x, y)
+	if err != nil {
+		return Feature{}, err
+	}
+
+	f.Bounds = osgrid.Rect{BottomLeft: f.GridRef}
+
+	if record[colMBRXMin] != "" {
+		var mbr [4]osgrid.Distance
+		for i := range mbr {
+			mbr[i], err = parseDistance(record[colMBRXMin+i])
+			if err != nil {
+				return Feature{}, fmt.Errorf("invalid MBR: %w", err)
+			}
+		}
+
+		min, err := osgrid.Origin().Add(mbr[0], mbr[1])
+		if err != nil {
+			return Feature{}, err
+		}
+
+		max, err := osgrid.Origin().Add(mbr[2], mbr[3])
+		if err != nil {
+			return Feature{}, err
+		}
+
+		f.Bounds = osgrid.NewRect(min, max)
+	}
+
+	return f, nil
+}
+
+func cellFor(ref osgrid.GridRef) [2]int32 {
+	return [2]int32{int32(ref.AbsEasting() / indexCellSize), int32(ref.AbsNorthing() / indexCellSize)}
+}
+
+func (g *Gazetteer) add(f Feature) {
+	idx := int32(len(g.entries))
+	g.entries = append(g.entries, f)
+
+	name := normalise(f.Name)
+	g.names.add(name, idx)
+	if alt := normalise(f.AltName); alt != name {
+		g.names.add(alt, idx)
+	}
+
+	cell := cellFor(f.GridRef)
+	g.cells[cell] = append(g.cells[cell], idx)
+}
+
+// Len returns the number of features in the gazetteer
+func (g *Gazetteer) Len() int {
+	return len(g.entries)
+}
+
+// Lookup finds a feature whose name matches name exactly (ignoring case
+// and punctuation), with one of the given types (see Search). If there are
+// several, the largest is returned, so "Newport" is the city rather than one
+// of the villages.
+func (g *Gazetteer) Lookup(name string, types ...string) (*Feature, error) {
+	key := normalise(name)
+	filter := newTypeFilter(types)
+
+	var best *Feature
+	for _, idx := range g.names.entries[key] {
+		f := &g.entries[idx]
+		if filter.matches(f) && (best == nil || f.area() > best.area()) {
+			best = f
+		}
+	}
+
+	if best == nil {
+		return nil, fmt.Errorf("'%s' %w", name, ErrNotFound)
+	}
+
+	return best, nil
+}
+
+// Nearest finds the feature, with one of the given types (see Search), whose
+// GridRef is nearest to ref. It also returns the distance to it.
+func (g *Gazetteer) Nearest(ref osgrid.GridRef, types ...string) (*Feature, osgrid.Distance, error) {
+	filter := newTypeFilter(types)
+
+	centre := cellFor(ref)
+	e0, n0 := float64(ref.AbsEasting()), float64(ref.AbsNorthing())
+
+	var best *Feature
+	bestDist := math.Inf(1)
+
+	// Search rings of cells around ref, until the ring can't contain
+	// anything closer. Great Britain is about 130 cells tall.
+	for r := int32(0); r < 150; r++ {
+		if float64(r-1)*float64(indexCellSize) > bestDist {
+			break
+		}
+
+		for y := centre[1] - r; y <= centre[1]+r; y++ {
+			for x := centre[0] - r; x <= centre[0]+r; x++ {
+				// Only the edge of the ring
+				if y != centre[1]-r && y != centre[1]+r && x != centre[0]-r && x != centre[0]+r {
+					continue
+				}
+
+				for _, idx := range g.cells[[2]int32{x, y}] {
+					f := &g.entries[idx]
+					if !filter.matches(f) {
+						continue
+					}
+
+					d := math.Hypot(float64(f.GridRef.AbsEasting())-e0, float64(f.GridRef.AbsNorthing())-n0)
+					if d < bestDist {
+						best, bestDist = f, d
+					}
+				}
+			}
+		}
+	}
+
+	if best == nil {
+		return nil, 0, fmt.Errorf("feature near %s %w", ref, ErrNotFound)
+	}
+
+	return best, osgrid.Distance(math.Round(bestDist)), nil
+}
+
+// Match is a search result
+type Match struct {
+	Feature *Feature
+	// Lower is better. 0 is an exact match.
+	Score int
+}
+
+type searchConfig struct {
+	limit int
+	types []string
+}
+
+type SearchOpt func(*searchConfig)
+
+// Return at most n results, default 10
+func SearchLimitOpt(n int) SearchOpt {
+	return func(cfg *searchConfig) {
+		cfg.limit = n
+	}
+}
+
+// Only return features with one of types. Types can be a Type or LocalType
+// from the dataset (case insensitive, e.g. "hydrography" or "hill or
+// mountain"), or one of the shorthands: hill, mountain, valley, city, town,
+// village, hamlet, place, water, road, landform.
+func SearchTypesOpt(types ...string) SearchOpt {
+	return func(cfg *searchConfig) {
+		cfg.types = types
+	}
+}
+
+// Search finds features whose names are like query. Exact matches are best,
+// followed by names starting with the query, names containing all of the
+// query's words (or the start of them), and then names with small spelling
+// differences.
+func (g *Gazetteer) Search(query string, opts ...SearchOpt) []Match {
+	cfg := searchConfig{
+		limit: 10,
+	}
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+
+	q := newQuery(query)
+	filter := newTypeFilter(cfg.types)
+
+	var matches []Match
+	// Index in matches of each entry found, and the names already scored
+	found := make(map[int32]int)
+	scored := make(map[string]bool)
+
+	addMatches := func(names []string) {
+		for _, name := range names {
+			if scored[name] {
+				continue
+			}
+			scored[name] = true
+
+			score, ok := q.score(name)
+			if !ok {
+				continue
+			}
+
+			for _, idx := range g.names.entries[name] {
+				f := &g.entries[idx]
+				if !filter.matches(f) {
+					continue
+				}
+
+				// Features can match by both of their names
+				if i, ok := found[idx]; ok {
+					if score < matches[i].Score {
+						matches[i].Score = score
+					}
+					continue
+				}
+
+				found[idx] = len(matches)
+				matches = append(matches, Match{Feature: f, Score: score})
+			}
+		}
+	}
+
+	if q.key != "" {
+		addMatches(g.names.prefixed(q.key))
+		addMatches(g.names.withWords(q.words))
+	}
+
+	// Spelling differences always score worse than the other matches, so
+	// they're only needed if there aren't enough of those. Only names of
+	// about the same length can be close enough.
+	if q.maxDistance > 0 && (cfg.limit <= 0 || len(matches) < cfg.limit) {
+		n := len(q.runes)
+		for l := n - q.maxDistance; l <= n+q.maxDistance; l++ {
+			addMatches(g.names.lengths[l])
+		}
+	}
+
+	// The matches were found in map order, so break every tie to keep the
+	// results the same from run to run
+	sort.SliceStable(matches, func(i, j int) bool {
+		a, b := matches[i], matches[j]
+		if a.Score != b.Score {
+			return a.Score < b.Score
+		}
+
+		if a.Feature.area() != b.Feature.area() {
+			return a.Feature.area() > b.Feature.area()
+		}
+
+		if a.Feature.Name != b.Feature.Name {
+			return a.Feature.Name < b.Feature.Name
+		}
+
+		return a.Feature.ID < b.Feature.ID
+	})
+
+	if cfg.limit > 0 && len(matches) > cfg.limit {
+		matches = matches[:cfg.limit]
+	}
+
+	return matches
+}
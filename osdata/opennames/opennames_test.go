@@ -0,0 +1,283 @@
+package opennames
+
+import (
+	"archive/zip"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/usedbytes/osgrid"
+)
+
+// Make a CSV line, in the Open Names column order
+func testRecord(id, name1, name2, typ, localType, x, y, mbr, county string) string {
+	fields := make([]string, numCols)
+	fields[colID] = id
+	fields[colName1] = name1
+	fields[colName2] = name2
+	fields[colType] = typ
+	fields[colLocalType] = localType
+	fields[colGeometryX] = x
+	fields[colGeometryY] = y
+	if mbr != "" {
+		copy(fields[colMBRXMin:], strings.Split(mbr, " "))
+	}
+	fields[colCountyUnitary] = county
+
+	return strings.Join(fields, ",") + "\n"
+}
+
+var testData = map[string]string{
+	"DATA/SH65.csv": testRecord("osgb1", "Snowdon", "Yr Wyddfa", "landform", "Hill Or Mountain",
+		"260986", "354375", "", "Gwynedd") +
+		testRecord("osgb2", "Llanberis", "", "populatedPlace", "Village",
+			"257800", "360300", "257000 359500 258500 361000", "Gwynedd") +
+		testRecord("osgb3", "Llyn Llydaw", "", "hydrography", "Inland Water",
+			"263000", "354500", "262000 353800 263500 355000", "Gwynedd"),
+	"DATA/ST45.csv": testRecord("osgb4", "Cheddar Gorge", "", "landform", "Valley",
+		"347500", "154200", "346500 153800 348500 154800", "Somerset") +
+		testRecord("osgb5", "Cheddar", "", "populatedPlace", "Village",
+			"345800", "153500", "345000 152800 346600 154200", "Somerset") +
+		testRecord("osgb6", "Cheddar Reservoir", "", "hydrography", "Inland Water",
+			"344600", "155400", "343800 154800 345400 156000", "Somerset"),
+	"DATA/ST38.csv": testRecord("osgb7", "Newport", "Casnewydd", "populatedPlace", "City",
+		"331000", "188000", "326000 183000 336000 193000", "Newport") +
+		testRecord("osgb8", "Newport", "", "populatedPlace", "Hamlet",
+			"322000", "135000", "321800 134800 322200 135200", "Somerset"),
+	"DOC/OS_Open_Names_Header.csv": "ID,NAMES_URI,NAME1\n",
+}
+
+func writeTestDir(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "opennames")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for name, data := range testData {
+		path := filepath.Join(dir, name)
+		os.MkdirAll(filepath.Dir(path), 0755)
+
+		err = ioutil.WriteFile(path, []byte(data), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	return dir
+}
+
+func openTest(t *testing.T) *Gazetteer {
+	dir := writeTestDir(t)
+	defer os.RemoveAll(dir)
+
+	g, err := Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return g
+}
+
+func TestOpen(t *testing.T) {
+	g := openTest(t)
+
+	if g.Len() != 8 {
+		t.Errorf("expected 8 features, got %d", g.Len())
+	}
+
+	// From a zip too
+	dir, err := ioutil.TempDir("", "opennames")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "opname_csv_gb.zip")
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	zw := zip.NewWriter(f)
+	for name, data := range testData {
+		w, _ := zw.Create(name)
+		w.Write([]byte(data))
+	}
+	zw.Close()
+	f.Close()
+
+	g, err = Open(path, TypesOpt("populatedPlace"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if g.Len() != 4 {
+		t.Errorf("expected 4 populated places, got %d", g.Len())
+	}
+}
+
+func TestLookup(t *testing.T) {
+	g := openTest(t)
+
+	f, err := g.Lookup("cheddar gorge")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ref, _ := osgrid.ParseGridRef("ST 47500 54200")
+	if f.Name != "Cheddar Gorge" || f.GridRef != ref {
+		t.Errorf("unexpected feature %s at %s", f, f.GridRef)
+	}
+
+	bottomLeft, _ := osgrid.ParseGridRef("ST 46500 53800")
+	if f.Bounds != (osgrid.Rect{BottomLeft: bottomLeft, Width: 2000, Height: 1000}) {
+		t.Errorf("unexpected bounds %s", f.Bounds)
+	}
+
+	// Alternative names, and the largest of several
+	f, err = g.Lookup("Casnewydd")
+	if err != nil || f.ID != "osgb7" {
+		t.Errorf("expected Newport city, got %v, %v", f, err)
+	}
+
+	f, err = g.Lookup("Newport")
+	if err != nil || f.ID != "osgb7" {
+		t.Errorf("expected Newport city, got %v, %v", f, err)
+	}
+
+	f, err = g.Lookup("Newport", "hamlet")
+	if err != nil || f.ID != "osgb8" {
+		t.Errorf("expected Newport hamlet, got %v, %v", f, err)
+	}
+
+	// Point features have zero size bounds
+	f, err = g.Lookup("Snowdon")
+	if err != nil || f.Bounds.BottomLeft != f.GridRef || f.Bounds.Width != 0 {
+		t.Errorf("unexpected Snowdon %v, %v", f, err)
+	}
+
+	_, err = g.Lookup("Cheddar Gorge", "water")
+	if !errors.Is(err, ErrNotFound) {
+		t.Errorf("expected ErrNotFound, got %v", err)
+	}
+}
+
+func matchIDs(matches []Match) []string {
+	var ids []string
+	for _, m := range matches {
+		ids = append(ids, m.Feature.ID)
+	}
+
+	return ids
+}
+
+func TestSearch(t *testing.T) {
+	g := openTest(t)
+
+	for _, tc := range []struct {
+		query string
+		opts  []SearchOpt
+		exp   []string
+	}{
+		// Exact, then prefixes by size
+		{"cheddar", nil, []string{"osgb5", "osgb4", "osgb6"}},
+		{"cheddar", []SearchOpt{SearchLimitOpt(2)}, []string{"osgb5", "osgb4"}},
+		{"cheddar", []SearchOpt{SearchTypesOpt("water")}, []string{"osgb6"}},
+		{"gorge chedd", nil, []string{"osgb4"}},
+		// Misspelt
+		{"Chedar Gorge", nil, []string{"osgb4"}},
+		{"snowden", nil, []string{"osgb1"}},
+		{"yr wyddfa", []SearchOpt{SearchTypesOpt("hill")}, []string{"osgb1"}},
+		{"Llyn", []SearchOpt{SearchTypesOpt("Inland Water")}, []string{"osgb3"}},
+		{"nowhere", nil, nil},
+	} {
+		ids := matchIDs(g.Search(tc.query, tc.opts...))
+		if strings.Join(ids, ",") != strings.Join(tc.exp, ",") {
+			t.Errorf("%s: expected %v, got %v", tc.query, tc.exp, ids)
+		}
+	}
+}
+
+func TestSearchTies(t *testing.T) {
+	g := &Gazetteer{
+		cells: make(map[[2]int32][]int32),
+		names: newNameIndex(),
+	}
+
+	// Point features with the same name all tie, except on their IDs
+	ref, _ := osgrid.ParseGridRef("SH 60000 54000")
+	for _, id := range []string{"osgb3", "osgb1", "osgb4", "osgb2"} {
+		g.add(Feature{ID: id, Name: "Mill", GridRef: ref, Bounds: osgrid.Rect{BottomLeft: ref}})
+	}
+	g.names.sort()
+
+	for i := 0; i < 10; i++ {
+		ids := strings.Join(matchIDs(g.Search("mill")), ",")
+		if ids != "osgb1,osgb2,osgb3,osgb4" {
+			t.Fatalf("expected matches in ID order, got %s", ids)
+		}
+	}
+}
+
+func TestNameIndex(t *testing.T) {
+	ni := newNameIndex()
+	for i, name := range []string{"cheddar gorge", "cheddar", "cheddar reservoir", "gorge", "chew magna"} {
+		ni.add(name, int32(i))
+	}
+	// A second feature with the same name
+	ni.add("cheddar", 5)
+	ni.sort()
+
+	if got := strings.Join(ni.prefixed("chedd"), ","); got != "cheddar,cheddar gorge,cheddar reservoir" {
+		t.Errorf("unexpected prefixed names %s", got)
+	}
+
+	if got := ni.withWords([]string{"gor", "ch"}); len(got) != 1 || got[0] != "cheddar gorge" {
+		t.Errorf("unexpected names with words %v", got)
+	}
+
+	if got := ni.withWords([]string{"gorge", "magna"}); len(got) != 0 {
+		t.Errorf("expected no names with words, got %v", got)
+	}
+
+	if got := ni.entries["cheddar"]; len(got) != 2 || got[0] != 1 || got[1] != 5 {
+		t.Errorf("unexpected entries %v", got)
+	}
+}
+
+func TestNearest(t *testing.T) {
+	g := openTest(t)
+
+	for _, tc := range []struct {
+		ref   string
+		types []string
+		exp   string
+		dist  osgrid.Distance
+	}{
+		{"SH 60986 54375", nil, "osgb1", 0},
+		{"SH 60000 54375", []string{"place"}, "osgb2", 6320},
+		{"ST 46000 54000", nil, "osgb5", 539},
+		// A long way from anything
+		{"TQ 30000 80000", []string{"hill"}, "osgb1", 320586},
+	} {
+		ref, _ := osgrid.ParseGridRef(tc.ref)
+
+		f, dist, err := g.Nearest(ref, tc.types...)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if f.ID != tc.exp || dist != tc.dist {
+			t.Errorf("%s: expected %s at %d, got %s at %d", tc.ref, tc.exp, tc.dist, f.ID, dist)
+		}
+	}
+
+	ref, _ := osgrid.ParseGridRef("SH 60000 54000")
+	_, _, err := g.Nearest(ref, "road")
+	if !errors.Is(err, ErrNotFound) {
+		t.Errorf("expected ErrNotFound, got %v", err)
+	}
+}
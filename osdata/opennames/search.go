@@ -0,0 +1,189 @@
+package opennames
+
+import (
+	"strings"
+	"unicode"
+)
+
+// Shorthands for type filters
+var typeAliases = map[string][]string{
+	"hill":     {"hill or mountain"},
+	"mountain": {"hill or mountain"},
+	"valley":   {"valley"},
+	"city":     {"city"},
+	"town":     {"town"},
+	"village":  {"village"},
+	"hamlet":   {"hamlet"},
+	"place":    {"populatedplace"},
+	"water":    {"hydrography"},
+	"road":     {"transportnetwork"},
+	"landform": {"landform"},
+}
+
+// typeFilter matches features by Type or LocalType
+type typeFilter map[string]bool
+
+func newTypeFilter(types []string) typeFilter {
+	if len(types) == 0 {
+		return nil
+	}
+
+	filter := make(typeFilter)
+	for _, t := range types {
+		t = strings.ToLower(t)
+
+		if aliases, ok := typeAliases[t]; ok {
+			for _, a := range aliases {
+				filter[a] = true
+			}
+		} else {
+			filter[t] = true
+		}
+	}
+
+	return filter
+}
+
+func (tf typeFilter) matches(f *Feature) bool {
+	if tf == nil {
+		return true
+	}
+
+	return tf[strings.ToLower(f.Type)] || tf[strings.ToLower(f.LocalType)]
+}
+
+// normalise lower-cases s, and replaces punctuation with spaces, so that
+// "St. Mary's" becomes "st mary s"
+func normalise(s string) string {
+	var b strings.Builder
+
+	space := true
+	for _, r := range s {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			b.WriteRune(unicode.ToLower(r))
+			space = false
+		} else if !space {
+			b.WriteByte(' ')
+			space = true
+		}
+	}
+
+	return strings.TrimSpace(b.String())
+}
+
+type query struct {
+	key   string
+	words []string
+	runes []rune
+	// Most spelling differences to allow
+	maxDistance int
+}
+
+func newQuery(s string) *query {
+	q := &query{
+		key: normalise(s),
+	}
+
+	q.words = strings.Fields(q.key)
+	q.runes = []rune(q.key)
+	q.maxDistance = len(q.runes) / 4
+
+	return q
+}
+
+// Score a normalised name against the query. Lower is better.
+func (q *query) score(name string) (int, bool) {
+	if q.key == "" {
+		return 0, false
+	}
+
+	if name == q.key {
+		return 0, true
+	}
+
+	if strings.HasPrefix(name, q.key) {
+		return 1, true
+	}
+
+	if q.wordsMatch(name) {
+		return 2, true
+	}
+
+	if q.maxDistance == 0 {
+		return 0, false
+	}
+
+	d := distance(q.runes, []rune(name), q.maxDistance)
+	if d <= q.maxDistance {
+		return 2 + d, true
+	}
+
+	return 0, false
+}
+
+// Every word in the query is the start of a word in name
+func (q *query) wordsMatch(name string) bool {
+	words := strings.Fields(name)
+
+	for _, qw := range q.words {
+		found := false
+		for _, w := range words {
+			if strings.HasPrefix(w, qw) {
+				found = true
+				break
+			}
+		}
+
+		if !found {
+			return false
+		}
+	}
+
+	return len(q.words) > 0
+}
+
+// distance returns the Levenshtein distance between a and b, or max+1 if it's
+// larger than max
+func distance(a, b []rune, max int) int {
+	if len(a)-len(b) > max || len(b)-len(a) > max {
+		return max + 1
+	}
+
+	prev := make([]int, len(b)+1)
+	cur := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+
+	for i := 1; i <= len(a); i++ {
+		cur[0] = i
+		rowMin := cur[0]
+
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+
+			cur[j] = prev[j-1] + cost
+			if v := prev[j] + 1; v < cur[j] {
+				cur[j] = v
+			}
+			if v := cur[j-1] + 1; v < cur[j] {
+				cur[j] = v
+			}
+
+			if cur[j] < rowMin {
+				rowMin = cur[j]
+			}
+		}
+
+		if rowMin > max {
+			return max + 1
+		}
+
+		prev, cur = cur, prev
+	}
+
+	return prev[len(b)]
+}
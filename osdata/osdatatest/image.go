@@ -0,0 +1,133 @@
+package osdatatest
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+
+	"github.com/usedbytes/osgrid"
+	"github.com/usedbytes/osgrid/osdata"
+)
+
+var mustBeImageDatabase osdata.ImageDatabase = &ImageDatabase{}
+
+// ColorFunc returns the colour at an absolute position, in metres East and
+// North of the grid origin
+type ColorFunc func(east, north float64) color.Color
+
+// ImageDatabase generates its tiles from a function, keeping them in a cache
+type ImageDatabase struct {
+	precision      osgrid.Distance
+	pixelPrecision int
+	tileSize       osgrid.Distance
+	f              ColorFunc
+	// Returns false for tiles which don't exist
+	hasTile func(bottomLeft osgrid.GridRef) bool
+
+	cache *osdata.Cache
+}
+
+// NewImageFuncDatabase returns a database which has an image everywhere,
+// coloured by f. There are pixelPrecision pixels per precision, and f is
+// sampled at the bottom-left corner of each pixel.
+func NewImageFuncDatabase(f ColorFunc, precision osgrid.Distance, pixelPrecision int,
+	tileSize osgrid.Distance, opts ...osdata.DatabaseOpt) *ImageDatabase {
+	cfg := osdata.NewDatabaseConfig(opts...)
+
+	return &ImageDatabase{
+		precision:      precision,
+		pixelPrecision: pixelPrecision,
+		tileSize:       tileSize,
+		f:              f,
+		hasTile:        func(osgrid.GridRef) bool { return true },
+		cache:          cfg.Cache,
+	}
+}
+
+// NewImageDatabase returns a database containing img, with the bottom-left
+// corner of img at bottomLeft, and each pixel covering precision metres.
+// Tiles outside img don't exist, and pixels outside img in tiles which do are
+// transparent.
+func NewImageDatabase(img image.Image, bottomLeft osgrid.GridRef, precision osgrid.Distance,
+	tileSize osgrid.Distance, opts ...osdata.DatabaseOpt) *ImageDatabase {
+	bounds := img.Bounds()
+	e0 := float64(bottomLeft.AbsEasting())
+	n0 := float64(bottomLeft.AbsNorthing())
+	p := float64(precision)
+
+	db := NewImageFuncDatabase(func(east, north float64) color.Color {
+		x := int((east - e0) / p)
+		y := int((north - n0) / p)
+
+		if east < e0 || north < n0 || x >= bounds.Dx() || y >= bounds.Dy() {
+			return color.Transparent
+		}
+
+		return img.At(bounds.Min.X+x, bounds.Max.Y-1-y)
+	}, precision, 1, tileSize, opts...)
+
+	minE := bottomLeft.AbsEasting()
+	minN := bottomLeft.AbsNorthing()
+	maxE := minE + osgrid.Distance(bounds.Dx())*precision
+	maxN := minN + osgrid.Distance(bounds.Dy())*precision
+
+	db.hasTile = func(tile osgrid.GridRef) bool {
+		e, n := tile.AbsEasting(), tile.AbsNorthing()
+
+		return e < maxE && n < maxN && e+tileSize > minE && n+tileSize > minN
+	}
+
+	return db
+}
+
+func (db *ImageDatabase) generateTile(bottomLeft osgrid.GridRef) *osdata.RGBATile {
+	size := int(db.tileSize/db.precision) * db.pixelPrecision
+	img := image.NewRGBA(image.Rect(0, 0, size, size))
+
+	e0 := float64(bottomLeft.AbsEasting())
+	n0 := float64(bottomLeft.AbsNorthing())
+	// Metres per pixel
+	p := float64(db.precision) / float64(db.pixelPrecision)
+
+	for y := 0; y < size; y++ {
+		for x := 0; x < size; x++ {
+			// Image rows go from North to South
+			img.Set(x, y, db.f(e0+float64(x)*p, n0+float64(size-1-y)*p))
+		}
+	}
+
+	return osdata.NewRGBATile(bottomLeft, db.precision, db.pixelPrecision, img)
+}
+
+func (db *ImageDatabase) GetImageTile(ref osgrid.GridRef) (osdata.ImageTile, error) {
+	ref = ref.Align(db.tileSize)
+
+	tile, err := db.cache.Load(ref, func() (osdata.Tile, error) {
+		if !db.hasTile(ref) {
+			return nil, fmt.Errorf("Tile %s %w", ref, osdata.ErrTileNotFound)
+		}
+
+		return db.generateTile(ref), nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return tile.(*osdata.RGBATile), nil
+}
+
+func (db *ImageDatabase) GetTile(ref osgrid.GridRef) (osdata.Tile, error) {
+	return db.GetImageTile(ref)
+}
+
+func (db *ImageDatabase) Precision() osgrid.Distance {
+	return db.precision
+}
+
+func (db *ImageDatabase) TileSize() osgrid.Distance {
+	return db.tileSize
+}
+
+func (db *ImageDatabase) Stats() osdata.Stats {
+	return db.cache.Stats()
+}
@@ -0,0 +1,150 @@
+// Package osdatatest provides in-memory and synthetic databases, for testing
+// and demonstrating code which uses osdata without needing any OS data.
+//
+// Unlike hand-rolled test databases, these provide proper tiles, so they can
+// be used with everything which accepts an osdata.Float64Database or
+// osdata.ImageDatabase.
+package osdatatest
+
+import (
+	"fmt"
+
+	"github.com/usedbytes/osgrid"
+	"github.com/usedbytes/osgrid/osdata"
+)
+
+var mustBeFloat64Database osdata.Float64Database = &Float64Database{}
+
+// Float64Func returns the value at an absolute position, in metres East and
+// North of the grid origin (osgrid.Origin()). It can return osdata.NoData.
+type Float64Func func(east, north float64) float64
+
+// Float64Database generates its tiles from a function, keeping them in a
+// cache.
+type Float64Database struct {
+	precision osgrid.Distance
+	tileSize  osgrid.Distance
+	f         Float64Func
+	// Returns false for tiles which don't exist
+	hasTile func(bottomLeft osgrid.GridRef) bool
+
+	cache *osdata.Cache
+}
+
+// NewFuncDatabase returns a database which has a value everywhere, given by f.
+// f is sampled at the bottom-left corner of each cell.
+func NewFuncDatabase(f Float64Func, precision, tileSize osgrid.Distance, opts ...osdata.DatabaseOpt) *Float64Database {
+	cfg := osdata.NewDatabaseConfig(opts...)
+
+	return &Float64Database{
+		precision: precision,
+		tileSize:  tileSize,
+		f:         f,
+		hasTile:   func(osgrid.GridRef) bool { return true },
+		cache:     cfg.Cache,
+	}
+}
+
+// NewSliceDatabase returns a database containing data, with data[0] being
+// the Southern-most row and data[0][0] being at bottomLeft. Tiles outside the
+// data don't exist, and cells outside the data in tiles which do are
+// osdata.NoData.
+func NewSliceDatabase(data [][]float64, bottomLeft osgrid.GridRef, precision, tileSize osgrid.Distance, opts ...osdata.DatabaseOpt) *Float64Database {
+	e0 := float64(bottomLeft.AbsEasting())
+	n0 := float64(bottomLeft.AbsNorthing())
+	p := float64(precision)
+
+	rows := len(data)
+	cols := 0
+	if rows > 0 {
+		cols = len(data[0])
+	}
+
+	db := NewFuncDatabase(func(east, north float64) float64 {
+		x := int((east - e0) / p)
+		y := int((north - n0) / p)
+
+		if east < e0 || north < n0 || y >= rows || x >= len(data[y]) {
+			return osdata.NoData
+		}
+
+		return data[y][x]
+	}, precision, tileSize, opts...)
+
+	minE := bottomLeft.AbsEasting()
+	minN := bottomLeft.AbsNorthing()
+	maxE := minE + osgrid.Distance(cols)*precision
+	maxN := minN + osgrid.Distance(rows)*precision
+
+	db.hasTile = func(tile osgrid.GridRef) bool {
+		e, n := tile.AbsEasting(), tile.AbsNorthing()
+
+		return e < maxE && n < maxN && e+tileSize > minE && n+tileSize > minN
+	}
+
+	return db
+}
+
+func (db *Float64Database) generateTile(bottomLeft osgrid.GridRef) *osdata.GridTile {
+	n := int(db.tileSize / db.precision)
+
+	e0 := float64(bottomLeft.AbsEasting())
+	n0 := float64(bottomLeft.AbsNorthing())
+	p := float64(db.precision)
+
+	data := make([][]float64, n)
+	for y := range data {
+		data[y] = make([]float64, n)
+		for x := range data[y] {
+			data[y][x] = db.f(e0+float64(x)*p, n0+float64(y)*p)
+		}
+	}
+
+	return osdata.NewGridTile(bottomLeft, db.precision, data)
+}
+
+func (db *Float64Database) getTile(ref osgrid.GridRef) (*osdata.GridTile, error) {
+	ref = ref.Align(db.tileSize)
+
+	tile, err := db.cache.Load(ref, func() (osdata.Tile, error) {
+		if !db.hasTile(ref) {
+			return nil, fmt.Errorf("Tile %s %w", ref, osdata.ErrTileNotFound)
+		}
+
+		return db.generateTile(ref), nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return tile.(*osdata.GridTile), nil
+}
+
+func (db *Float64Database) GetTile(ref osgrid.GridRef) (osdata.Tile, error) {
+	return db.getTile(ref)
+}
+
+func (db *Float64Database) GetFloat64Tile(ref osgrid.GridRef) (osdata.Float64Tile, error) {
+	return db.getTile(ref)
+}
+
+func (db *Float64Database) GetFloat64(ref osgrid.GridRef) (float64, error) {
+	tile, err := db.getTile(ref)
+	if err != nil {
+		return osdata.NoData, err
+	}
+
+	return tile.GetFloat64(ref)
+}
+
+func (db *Float64Database) Precision() osgrid.Distance {
+	return db.precision
+}
+
+func (db *Float64Database) TileSize() osgrid.Distance {
+	return db.tileSize
+}
+
+func (db *Float64Database) Stats() osdata.Stats {
+	return db.cache.Stats()
+}
@@ -0,0 +1,203 @@
+package osdatatest
+
+import (
+	"errors"
+	"image"
+	"image/color"
+	"math"
+	"testing"
+
+	"github.com/usedbytes/osgrid"
+	"github.com/usedbytes/osgrid/osdata"
+)
+
+func TestFuncDatabase(t *testing.T) {
+	db := NewFuncDatabase(Plane(10, 1, 2), 50*osgrid.Metre, 1*osgrid.Kilometre)
+
+	ref, _ := osgrid.ParseGridRef("SV 01234 05678")
+	v, err := db.GetFloat64(ref)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Sampled at the bottom-left of the cell: SV 01200 05650
+	exp := 10 + 1200.0 + 2*5650.0
+	if v != exp {
+		t.Errorf("expected %v, got %v", exp, v)
+	}
+
+	tile, err := db.GetFloat64Tile(ref)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if tile.BottomLeft() != ref.Align(1*osgrid.Kilometre) || tile.Width() != 1*osgrid.Kilometre ||
+		tile.Precision() != 50*osgrid.Metre {
+		t.Errorf("unexpected tile %s %dx%d @ %d", tile, tile.Width(), tile.Height(), tile.Precision())
+	}
+
+	stats := db.Stats()
+	if stats.Misses != 1 || stats.Hits != 1 {
+		t.Errorf("expected 1 miss and 1 hit, got %+v", stats)
+	}
+}
+
+func TestSliceDatabase(t *testing.T) {
+	data := [][]float64{
+		{1, 2, 3},
+		{4, 5, 6},
+	}
+
+	bottomLeft, _ := osgrid.ParseGridRef("SV 00100 00100")
+	db := NewSliceDatabase(data, bottomLeft, 10*osgrid.Metre, 1*osgrid.Kilometre)
+
+	for _, tc := range []struct {
+		east, north osgrid.Distance
+		exp         float64
+	}{
+		{0, 0, 1},
+		{29, 0, 3},
+		{10, 10, 5},
+		{20, 19, 6},
+	} {
+		ref, _ := bottomLeft.Add(tc.east, tc.north)
+		v, err := db.GetFloat64(ref)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if v != tc.exp {
+			t.Errorf("%s: expected %v, got %v", ref, tc.exp, v)
+		}
+	}
+
+	// In the tile, but outside the data
+	for _, ref := range []string{"SV 00130 00100", "SV 00100 00120", "SV 00099 00100", "SV 00000 00000"} {
+		r, _ := osgrid.ParseGridRef(ref)
+		v, err := db.GetFloat64(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if !osdata.IsNoData(v) {
+			t.Errorf("%s: expected NoData, got %v", ref, v)
+		}
+	}
+
+	// Outside the tile
+	ref, _ := osgrid.ParseGridRef("SV 01000 00100")
+	_, err := db.GetFloat64(ref)
+	if !errors.Is(err, osdata.ErrTileNotFound) {
+		t.Errorf("expected ErrTileNotFound, got %v", err)
+	}
+}
+
+func TestImageDatabase(t *testing.T) {
+	img := image.NewRGBA(image.Rect(0, 0, 2, 2))
+	img.Set(0, 0, color.RGBA{0xff, 0, 0, 0xff})
+	img.Set(1, 0, color.RGBA{0, 0xff, 0, 0xff})
+	img.Set(0, 1, color.RGBA{0, 0, 0xff, 0xff})
+	img.Set(1, 1, color.RGBA{0xff, 0xff, 0xff, 0xff})
+
+	bottomLeft, _ := osgrid.ParseGridRef("SV 00100 00100")
+	db := NewImageDatabase(img, bottomLeft, 10*osgrid.Metre, 1*osgrid.Kilometre)
+
+	for _, tc := range []struct {
+		east, north osgrid.Distance
+		exp         color.Color
+	}{
+		{0, 0, color.RGBA{0, 0, 0xff, 0xff}},
+		{10, 0, color.RGBA{0xff, 0xff, 0xff, 0xff}},
+		{0, 10, color.RGBA{0xff, 0, 0, 0xff}},
+		{10, 10, color.RGBA{0, 0xff, 0, 0xff}},
+		{20, 10, color.RGBA{}},
+	} {
+		ref, _ := bottomLeft.Add(tc.east, tc.north)
+
+		tile, err := db.GetImageTile(ref)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// The pixel coordinate is the bottom-left corner of the cell
+		x, y, err := tile.GetPixelCoord(ref)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		c := tile.GetImage().At(x, y-1)
+		if color.RGBAModel.Convert(c) != tc.exp {
+			t.Errorf("%s: expected %v, got %v", ref, tc.exp, c)
+		}
+	}
+
+	ref, _ := osgrid.ParseGridRef("SV 01000 00100")
+	_, err := db.GetImageTile(ref)
+	if !errors.Is(err, osdata.ErrTileNotFound) {
+		t.Errorf("expected ErrTileNotFound, got %v", err)
+	}
+}
+
+func TestCone(t *testing.T) {
+	centre, _ := osgrid.ParseGridRef("SH 60000 54000")
+	f := Cone(centre, 1000, 500)
+
+	e, n := float64(centre.AbsEasting()), float64(centre.AbsNorthing())
+
+	for _, tc := range []struct {
+		de, dn, exp float64
+	}{
+		{0, 0, 1000},
+		{250, 0, 500},
+		{0, -400, 200},
+		{300, 400, 0},
+		{1000, 1000, 0},
+	} {
+		v := f(e+tc.de, n+tc.dn)
+		if math.Abs(v-tc.exp) > 1e-9 {
+			t.Errorf("(%v, %v): expected %v, got %v", tc.de, tc.dn, tc.exp, v)
+		}
+	}
+}
+
+func TestFractalNoise(t *testing.T) {
+	f := FractalNoise(42, 800, 10000, 5)
+	g := FractalNoise(42, 800, 10000, 5)
+	other := FractalNoise(43, 800, 10000, 5)
+
+	differs := false
+	min, max := math.Inf(1), math.Inf(-1)
+
+	for i := 0; i < 1000; i++ {
+		e, n := float64(i*137), float64(i*89)
+
+		v := f(e, n)
+		if v != g(e, n) {
+			t.Fatalf("(%v, %v): not deterministic", e, n)
+		}
+
+		if v != other(e, n) {
+			differs = true
+		}
+
+		if v < 0 || v > 800 {
+			t.Fatalf("(%v, %v): %v out of range", e, n, v)
+		}
+
+		// Should be continuous, so a small step is a small change
+		if d := math.Abs(f(e+1, n) - v); d > 5 {
+			t.Errorf("(%v, %v): changed by %v in 1 m", e, n, d)
+		}
+
+		min = math.Min(min, v)
+		max = math.Max(max, v)
+	}
+
+	if !differs {
+		t.Errorf("different seeds gave the same terrain")
+	}
+
+	if max-min < 200 {
+		t.Errorf("terrain is too flat: %v to %v", min, max)
+	}
+}
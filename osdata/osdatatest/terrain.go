@@ -0,0 +1,100 @@
+package osdatatest
+
+import (
+	"math"
+
+	"github.com/usedbytes/osgrid"
+)
+
+// Plane is a flat, possibly sloping, surface. base is the value at the grid
+// origin, and the slopes are in metres per metre.
+func Plane(base, slopeEast, slopeNorth float64) Float64Func {
+	return func(east, north float64) float64 {
+		return base + east*slopeEast + north*slopeNorth
+	}
+}
+
+// Cone is a cone with its peak of height at centre, falling linearly to zero
+// at radius, and flat beyond that.
+func Cone(centre osgrid.GridRef, height, radius float64) Float64Func {
+	ce := float64(centre.AbsEasting())
+	cn := float64(centre.AbsNorthing())
+
+	return func(east, north float64) float64 {
+		d := math.Hypot(east-ce, north-cn)
+		if d >= radius {
+			return 0
+		}
+
+		return height * (1 - d/radius)
+	}
+}
+
+// Sum adds together the values of fs, e.g. to put a Cone on a Plane
+func Sum(fs ...Float64Func) Float64Func {
+	return func(east, north float64) float64 {
+		v := 0.0
+		for _, f := range fs {
+			v += f(east, north)
+		}
+
+		return v
+	}
+}
+
+// Hash a lattice point to a value between 0 and 1
+func latticeValue(seed int64, x, y int64) float64 {
+	h := uint64(seed)*0x9e3779b97f4a7c15 ^ uint64(x)*0xbf58476d1ce4e5b9 ^ uint64(y)*0x94d049bb133111eb
+	h ^= h >> 31
+	h *= 0xd6e8feb86659fd93
+	h ^= h >> 32
+
+	return float64(h>>11) / float64(1<<53)
+}
+
+// Smoothly interpolated value noise, between 0 and 1
+func valueNoise(seed int64, x, y float64) float64 {
+	x0, y0 := math.Floor(x), math.Floor(y)
+	ix, iy := int64(x0), int64(y0)
+
+	smooth := func(t float64) float64 {
+		return t * t * (3 - 2*t)
+	}
+	tx, ty := smooth(x-x0), smooth(y-y0)
+
+	v00 := latticeValue(seed, ix, iy)
+	v10 := latticeValue(seed, ix+1, iy)
+	v01 := latticeValue(seed, ix, iy+1)
+	v11 := latticeValue(seed, ix+1, iy+1)
+
+	bottom := v00 + (v10-v00)*tx
+	top := v01 + (v11-v01)*tx
+
+	return bottom + (top-bottom)*ty
+}
+
+// FractalNoise is hilly terrain between 0 and amplitude, made of octaves
+// layers of value noise. The largest features are roughly wavelength metres
+// across, and each octave has half the wavelength and half the amplitude of
+// the previous one. The same seed always gives the same terrain.
+func FractalNoise(seed int64, amplitude, wavelength float64, octaves int) Float64Func {
+	// So that the result is normalised to 0..1
+	total := 0.0
+	for i := 0; i < octaves; i++ {
+		total += math.Pow(0.5, float64(i))
+	}
+
+	return func(east, north float64) float64 {
+		v := 0.0
+		scale := 1.0
+		freq := 1 / wavelength
+
+		for i := 0; i < octaves; i++ {
+			v += scale * valueNoise(seed+int64(i), east*freq, north*freq)
+			scale /= 2
+			freq *= 2
+		}
+
+		return amplitude * v / total
+	}
+}
@@ -0,0 +1,10 @@
+module github.com/usedbytes/osgrid/osdata/promstats
+
+go 1.14
+
+require (
+	github.com/prometheus/client_golang v1.12.2
+	github.com/usedbytes/osgrid v0.0.0
+)
+
+replace github.com/usedbytes/osgrid => ../..
@@ -0,0 +1,103 @@
+// Package promstats exports osdata cache statistics as Prometheus metrics.
+//
+// It's a separate module, so that only programs which use it depend on the
+// Prometheus client.
+package promstats
+
+import (
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/usedbytes/osgrid/osdata"
+)
+
+var mustBeCollector prometheus.Collector = &Collector{}
+
+// Collector is a prometheus.Collector which reports the Stats of a set of
+// databases, labelled by name.
+type Collector struct {
+	sync.Mutex
+	sources map[string]osdata.StatsProvider
+
+	hits          *prometheus.Desc
+	misses        *prometheus.Desc
+	evictions     *prometheus.Desc
+	allocations   *prometheus.Desc
+	bytesResident *prometheus.Desc
+	loadLatency   *prometheus.Desc
+}
+
+// NewCollector creates a Collector with all metric names prefixed with
+// namespace. Use Add() to register databases with it.
+func NewCollector(namespace string) *Collector {
+	labels := []string{"database"}
+	desc := func(name, help string) *prometheus.Desc {
+		return prometheus.NewDesc(prometheus.BuildFQName(namespace, "tile_cache", name), help, labels, nil)
+	}
+
+	return &Collector{
+		sources:       make(map[string]osdata.StatsProvider),
+		hits:          desc("hits_total", "Number of tile cache hits"),
+		misses:        desc("misses_total", "Number of tile cache misses"),
+		evictions:     desc("evictions_total", "Number of tiles evicted from the cache"),
+		allocations:   desc("allocations_total", "Number of tiles allocated in the cache"),
+		bytesResident: desc("resident_bytes", "Bytes of tile data currently held in the cache"),
+		loadLatency:   desc("load_duration_seconds", "Time taken to load tiles on cache misses"),
+	}
+}
+
+// Add registers p with the collector, under the label database=name.
+// Adding another provider with the same name replaces the previous one.
+func (c *Collector) Add(name string, p osdata.StatsProvider) {
+	c.Lock()
+	defer c.Unlock()
+
+	c.sources[name] = p
+}
+
+func (c *Collector) Remove(name string) {
+	c.Lock()
+	defer c.Unlock()
+
+	delete(c.sources, name)
+}
+
+func (c *Collector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.hits
+	ch <- c.misses
+	ch <- c.evictions
+	ch <- c.allocations
+	ch <- c.bytesResident
+	ch <- c.loadLatency
+}
+
+func histogramBuckets(h osdata.Histogram) map[float64]uint64 {
+	buckets := make(map[float64]uint64, len(h.Bounds))
+
+	// Prometheus buckets are cumulative
+	cumulative := uint64(0)
+	for i, bound := range h.Bounds {
+		cumulative += h.Counts[i]
+		buckets[bound.Seconds()] = cumulative
+	}
+
+	return buckets
+}
+
+func (c *Collector) Collect(ch chan<- prometheus.Metric) {
+	c.Lock()
+	defer c.Unlock()
+
+	for name, p := range c.sources {
+		st := p.Stats()
+
+		ch <- prometheus.MustNewConstMetric(c.hits, prometheus.CounterValue, float64(st.Hits), name)
+		ch <- prometheus.MustNewConstMetric(c.misses, prometheus.CounterValue, float64(st.Misses), name)
+		ch <- prometheus.MustNewConstMetric(c.evictions, prometheus.CounterValue, float64(st.Evictions), name)
+		ch <- prometheus.MustNewConstMetric(c.allocations, prometheus.CounterValue, float64(st.Allocations), name)
+		ch <- prometheus.MustNewConstMetric(c.bytesResident, prometheus.GaugeValue, float64(st.BytesResident), name)
+		ch <- prometheus.MustNewConstHistogram(c.loadLatency, st.LoadLatency.Count,
+			st.LoadLatency.Sum.Seconds(), histogramBuckets(st.LoadLatency), name)
+	}
+}
@@ -0,0 +1,91 @@
+package promstats
+
+import (
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/usedbytes/osgrid/osdata"
+)
+
+type testProvider struct {
+	stats osdata.Stats
+}
+
+func (p *testProvider) Stats() osdata.Stats {
+	return p.stats
+}
+
+func TestCollector(t *testing.T) {
+	counts := make([]uint64, len(osdata.LatencyBuckets)+1)
+	counts[0] = 2
+	counts[3] = 1
+
+	p := &testProvider{
+		stats: osdata.Stats{
+			Hits:          10,
+			Misses:        3,
+			Allocations:   3,
+			Evictions:     1,
+			BytesResident: 2048,
+			LoadLatency: osdata.Histogram{
+				Bounds: osdata.LatencyBuckets,
+				Counts: counts,
+				Count:  3,
+				Sum:    12 * time.Millisecond,
+			},
+		},
+	}
+
+	c := NewCollector("osgrid")
+	c.Add("terrain50", p)
+
+	reg := prometheus.NewRegistry()
+	reg.MustRegister(c)
+
+	families, err := reg.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	found := make(map[string]bool)
+	for _, f := range families {
+		found[f.GetName()] = true
+
+		if len(f.GetMetric()) != 1 {
+			t.Fatalf("%s: expected 1 metric, got %d", f.GetName(), len(f.GetMetric()))
+		}
+		m := f.GetMetric()[0]
+
+		if m.GetLabel()[0].GetValue() != "terrain50" {
+			t.Errorf("%s: unexpected label %v", f.GetName(), m.GetLabel()[0].GetValue())
+		}
+
+		switch f.GetName() {
+		case "osgrid_tile_cache_hits_total":
+			if m.GetCounter().GetValue() != 10 {
+				t.Errorf("hits: expected 10, got %v", m.GetCounter().GetValue())
+			}
+		case "osgrid_tile_cache_resident_bytes":
+			if m.GetGauge().GetValue() != 2048 {
+				t.Errorf("resident: expected 2048, got %v", m.GetGauge().GetValue())
+			}
+		case "osgrid_tile_cache_load_duration_seconds":
+			h := m.GetHistogram()
+			if h.GetSampleCount() != 3 {
+				t.Errorf("latency count: expected 3, got %v", h.GetSampleCount())
+			}
+			if h.GetBucket()[0].GetCumulativeCount() != 2 {
+				t.Errorf("latency bucket 0: expected 2, got %v", h.GetBucket()[0].GetCumulativeCount())
+			}
+			if h.GetBucket()[3].GetCumulativeCount() != 3 {
+				t.Errorf("latency bucket 3: expected 3, got %v", h.GetBucket()[3].GetCumulativeCount())
+			}
+		}
+	}
+
+	if len(found) != 6 {
+		t.Errorf("expected 6 metric families, got %d", len(found))
+	}
+}
@@ -9,6 +9,7 @@ import (
 	"os"
 	"path/filepath"
 	"regexp"
+	"sync"
 
 	"github.com/google/tiff"
 	_ "golang.org/x/image/tiff"
@@ -66,6 +67,36 @@ func (t *Tile) GetImage() image.Image {
 	return t.image
 }
 
+func (t *Tile) MemorySize() int {
+	return imageMemorySize(t.image)
+}
+
+func imageMemorySize(img image.Image) int {
+	switch v := img.(type) {
+	case *image.RGBA:
+		return len(v.Pix)
+	case *image.NRGBA:
+		return len(v.Pix)
+	case *image.RGBA64:
+		return len(v.Pix)
+	case *image.NRGBA64:
+		return len(v.Pix)
+	case *image.Gray:
+		return len(v.Pix)
+	case *image.Gray16:
+		return len(v.Pix)
+	case *image.CMYK:
+		return len(v.Pix)
+	case *image.Paletted:
+		return len(v.Pix) + len(v.Palette)*4
+	case *image.YCbCr:
+		return len(v.Y) + len(v.Cb) + len(v.Cr)
+	}
+
+	// Assume 32-bit pixels for anything else
+	return img.Bounds().Dx() * img.Bounds().Dy() * 4
+}
+
 func (t *Tile) GetPixelCoord(ref osgrid.GridRef) (int, int, error) {
 	if ref.Align(t.width) != t.bottomLeft {
 		return -1, -1, fmt.Errorf("Coordinate outside tile")
@@ -82,24 +113,15 @@ func (t *Tile) GetPixelCoord(ref osgrid.GridRef) (int, int, error) {
 	return x, y, nil
 }
 
-type tileMapEntry struct {
-	path string
-	slot int
-}
-
-type tileCacheEntry struct {
-	timestamp int
-	tile      *Tile
-}
-
 type Database struct {
 	path      string
 	tileSize  osgrid.Distance
 	precision osgrid.Distance
 
-	tileMap   map[string]tileMapEntry
-	tileCache []tileCacheEntry
-	timestamp int
+	// Tiles are loaded concurrently, so paths is guarded by lock
+	lock  sync.Mutex
+	paths map[string]string
+	cache *osdata.Cache
 }
 
 const (
@@ -163,6 +185,40 @@ func (pt *PixelScaleTag) Decode(data []byte, order binary.ByteOrder) error {
 	return nil
 }
 
+// DecodeGeoTags decodes the pixel scale and tie point tags from ifd, which
+// must have exactly one tie point, at pixel 0,0
+func DecodeGeoTags(ifd tiff.IFD) (*PixelScaleTag, *TiePointTag, error) {
+	if !ifd.HasField(ModelPixelScaleTag) || !ifd.HasField(ModelTiepointTag) {
+		return nil, nil, fmt.Errorf("need pixel scale and tie points")
+	}
+
+	scaleField := ifd.GetField(ModelPixelScaleTag)
+	scaleVal := scaleField.Value()
+	scaleTag := &PixelScaleTag{}
+	err := scaleTag.Decode(scaleVal.Bytes(), scaleVal.Order())
+	if err != nil {
+		return nil, nil, err
+	}
+
+	tieField := ifd.GetField(ModelTiepointTag)
+	tieVal := tieField.Value()
+	tieTag := &TiePointTag{}
+	err = tieTag.Decode(tieVal.Bytes(), tieVal.Order())
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if len(tieTag.TiePoints) != 1 {
+		// Being lazy
+		return nil, nil, fmt.Errorf("can't handle more than one tie point")
+	} else if tieTag.TiePoints[0].PixX != 0 || tieTag.TiePoints[0].PixY != 0 {
+		// Being lazy
+		return nil, nil, fmt.Errorf("tie point must be at 0,0")
+	}
+
+	return scaleTag, tieTag, nil
+}
+
 func OpenTile(path string) (*Tile, error) {
 	fi, err := os.Stat(path)
 	if err != nil {
@@ -197,34 +253,11 @@ func OpenTile(path string) (*Tile, error) {
 		return nil, fmt.Errorf("can't handle more than one IFD")
 	}
 
-	if !ifds[0].HasField(ModelPixelScaleTag) || !ifds[0].HasField(ModelTiepointTag) {
-		return nil, fmt.Errorf("need pixel scale and tie points")
-	}
-
-	scaleField := ifds[0].GetField(ModelPixelScaleTag)
-	scaleVal := scaleField.Value()
-	scaleTag := &PixelScaleTag{}
-	err = scaleTag.Decode(scaleVal.Bytes(), scaleVal.Order())
-	if err != nil {
-		return nil, err
-	}
-
-	tieField := ifds[0].GetField(ModelTiepointTag)
-	tieVal := tieField.Value()
-	tieTag := &TiePointTag{}
-	err = tieTag.Decode(tieVal.Bytes(), tieVal.Order())
+	scaleTag, tieTag, err := DecodeGeoTags(ifds[0])
 	if err != nil {
 		return nil, err
 	}
 
-	if len(tieTag.TiePoints) != 1 {
-		// Being lazy
-		return nil, fmt.Errorf("can't handle more than one tie point")
-	} else if tieTag.TiePoints[0].PixX != 0 || tieTag.TiePoints[0].PixY != 0 {
-		// Being lazy
-		return nil, fmt.Errorf("tie point must be at 0,0")
-	}
-
 	// Technically I don't think we're guaranteed that the units are
 	// metres, but for OS data it should be
 	widthInMetres := float64(img.Bounds().Dx()) * scaleTag.ScaleX
@@ -300,49 +333,7 @@ func (d *Database) findTile(ref osgrid.GridRef) (string, error) {
 		}
 	}
 
-	return "", fmt.Errorf("Tile %s not found", ref)
-}
-
-func findOldest(cache []tileCacheEntry) int {
-	oldest := 0
-	idx := 0
-
-	for i, entry := range cache {
-		if entry.timestamp <= oldest {
-			oldest = entry.timestamp
-			idx = i
-		}
-	}
-
-	return idx
-}
-
-func (d *Database) hit(slot int) *Tile {
-	tile := d.tileCache[slot].tile
-
-	d.tileCache[slot].timestamp = d.timestamp
-	return tile
-}
-
-func (d *Database) readAllocate(path string) (*Tile, int, error) {
-	tile, err := OpenTile(path)
-	if err != nil {
-		return nil, -1, err
-	}
-
-	slot := findOldest(d.tileCache)
-
-	if d.tileCache[slot].tile != nil {
-		key := d.tileCache[slot].tile.String()
-		evict := d.tileMap[key]
-		evict.slot = -1
-		d.tileMap[key] = evict
-	}
-
-	d.tileCache[slot].timestamp = d.timestamp
-	d.tileCache[slot].tile = tile
-
-	return tile, slot, nil
+	return "", fmt.Errorf("Tile %s %w", ref, osdata.ErrTileNotFound)
 }
 
 func (d *Database) GetImageTile(ref osgrid.GridRef) (osdata.ImageTile, error) {
@@ -354,51 +345,50 @@ func (d *Database) GetTile(ref osgrid.GridRef) (osdata.Tile, error) {
 }
 
 func (d *Database) getTile(ref osgrid.GridRef) (*Tile, error) {
-	var tile *Tile
-	var path string
 	var err error
 
 	ref = ref.Align(d.tileSize)
-	key := ref.String()
-
-	d.timestamp++
-
-	slot := -1
-	entry, ok := d.tileMap[key]
-	if ok {
-		path = entry.path
-		if entry.slot >= 0 {
-			// Cache hit
-			slot = entry.slot
-			tile = d.hit(slot)
-		}
-	} else {
-		path, err = d.findTile(ref)
-		if err != nil {
-			return nil, err
-		}
-	}
 
-	if slot < 0 {
-		tile, slot, err = d.readAllocate(path)
-		if err != nil {
-			return nil, err
+	osdTile, err := d.cache.Load(ref, func() (osdata.Tile, error) {
+		key := ref.String()
+
+		d.lock.Lock()
+		path, ok := d.paths[key]
+		d.lock.Unlock()
+
+		if !ok {
+			path, err = d.findTile(ref)
+			if err != nil {
+				return nil, err
+			}
+
+			d.lock.Lock()
+			d.paths[key] = path
+			d.lock.Unlock()
 		}
-	}
 
-	d.tileMap[key] = tileMapEntry{
-		path: path,
-		slot: slot,
+		return OpenTile(path)
+	})
+	if err != nil {
+		return nil, err
 	}
 
-	return tile, nil
+	return osdTile.(*Tile), nil
+}
+
+func (d *Database) Stats() osdata.Stats {
+	return d.cache.Stats()
+}
+
+func (d *Database) DumpStats() string {
+	return "Cache stats: " + d.cache.DumpStats()
 }
 
 func (d *Database) Precision() osgrid.Distance {
 	return d.precision
 }
 
-func OpenDatabase(path string, tileSize osgrid.Distance) (osdata.ImageDatabase, error) {
+func OpenDatabase(path string, tileSize osgrid.Distance, opts ...osdata.DatabaseOpt) (osdata.ImageDatabase, error) {
 	datapath := filepath.Join(path, "data")
 
 	fi, err := os.Stat(datapath)
@@ -409,11 +399,13 @@ func OpenDatabase(path string, tileSize osgrid.Distance) (osdata.ImageDatabase,
 		return nil, fmt.Errorf("%s should be a directory", datapath)
 	}
 
+	cfg := osdata.NewDatabaseConfig(opts...)
+
 	d := &Database{
-		path:      datapath,
-		tileSize:  tileSize,
-		tileMap:   make(map[string]tileMapEntry),
-		tileCache: make([]tileCacheEntry, 1),
+		path:     datapath,
+		tileSize: tileSize,
+		paths:    make(map[string]string),
+		cache:    cfg.Cache,
 	}
 
 	// We assume that London is available in the data-set
@@ -0,0 +1,327 @@
+package osdata
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+
+	"github.com/usedbytes/osgrid"
+)
+
+var mustBeResampledDatabase Float64Database = &Resampler{}
+
+type AggregateMethod int
+
+const (
+	// Mean of the source posts
+	AggregateMean AggregateMethod = iota
+	// Lowest of the source posts
+	AggregateMin
+	// Highest of the source posts, e.g. to keep summits when downsampling
+	AggregateMax
+	// Median of the source posts
+	AggregateMedian
+)
+
+func (m AggregateMethod) String() string {
+	switch m {
+	case AggregateMean:
+		return "mean"
+	case AggregateMin:
+		return "min"
+	case AggregateMax:
+		return "max"
+	case AggregateMedian:
+		return "median"
+	}
+
+	return fmt.Sprintf("AggregateMethod(%d)", int(m))
+}
+
+func ParseAggregateMethod(s string) (AggregateMethod, error) {
+	for _, m := range []AggregateMethod{AggregateMean, AggregateMin, AggregateMax, AggregateMedian} {
+		if s == m.String() {
+			return m, nil
+		}
+	}
+
+	return AggregateMean, fmt.Errorf("unknown aggregate method: %s", s)
+}
+
+func (m AggregateMethod) aggregate(vals []float64) float64 {
+	if len(vals) == 0 {
+		return NoData
+	}
+
+	switch m {
+	case AggregateMin:
+		min := vals[0]
+		for _, v := range vals[1:] {
+			min = math.Min(min, v)
+		}
+		return min
+	case AggregateMax:
+		max := vals[0]
+		for _, v := range vals[1:] {
+			max = math.Max(max, v)
+		}
+		return max
+	case AggregateMedian:
+		sort.Float64s(vals)
+		mid := len(vals) / 2
+		if len(vals)%2 == 0 {
+			return (vals[mid-1] + vals[mid]) / 2
+		}
+		return vals[mid]
+	}
+
+	sum := 0.0
+	for _, v := range vals {
+		sum += v
+	}
+
+	return sum / float64(len(vals))
+}
+
+// Resampler presents a database at a different precision. When the new
+// precision is coarser, each post is the aggregate of all of the source posts
+// in the cell centred on it, so that features aren't aliased. When it's finer, posts are
+// interpolated from the source.
+//
+// Resampled tiles are generated on demand, and kept in a cache.
+type Resampler struct {
+	db        Float64Database
+	precision osgrid.Distance
+	tileSize  osgrid.Distance
+	aggregate AggregateMethod
+	method    SampleMethod
+	cache     *Cache
+}
+
+type ResampleOpt func(*Resampler)
+
+// How to combine source posts when downsampling, default AggregateMean
+func ResampleAggregateOpt(m AggregateMethod) ResampleOpt {
+	return func(r *Resampler) {
+		r.aggregate = m
+	}
+}
+
+// How to interpolate when upsampling, default SampleBilinear
+func ResampleSampleOpt(m SampleMethod) ResampleOpt {
+	return func(r *Resampler) {
+		r.method = m
+	}
+}
+
+// Size of the resampled tiles, which must be a multiple of the precision.
+// The default is the smallest multiple which is at least 10 km.
+func ResampleTileSizeOpt(size osgrid.Distance) ResampleOpt {
+	return func(r *Resampler) {
+		r.tileSize = size
+	}
+}
+
+// Options for the resampled tile cache
+func ResampleDatabaseOpts(opts ...DatabaseOpt) ResampleOpt {
+	return func(r *Resampler) {
+		r.cache = NewDatabaseConfig(opts...).Cache
+	}
+}
+
+// NewResampler presents db at precision. When downsampling, precision must be
+// a multiple of db.Precision().
+func NewResampler(db Float64Database, precision osgrid.Distance, opts ...ResampleOpt) (*Resampler, error) {
+	r := &Resampler{
+		db:        db,
+		precision: precision,
+		aggregate: AggregateMean,
+		method:    SampleBilinear,
+	}
+
+	for _, opt := range opts {
+		opt(r)
+	}
+
+	if r.cache == nil {
+		r.cache = NewDatabaseConfig().Cache
+	}
+
+	if precision <= 0 {
+		return nil, fmt.Errorf("invalid precision (%d)", precision)
+	}
+
+	if r.tileSize == 0 {
+		r.tileSize = precision * ((10*osgrid.Kilometre + precision - 1) / precision)
+	}
+
+	if r.tileSize%precision != 0 {
+		return nil, fmt.Errorf("tile size (%d) must be a multiple of precision (%d)", r.tileSize, precision)
+	}
+
+	if precision > db.Precision() && precision%db.Precision() != 0 {
+		return nil, fmt.Errorf("precision (%d) must be a multiple of the database's precision (%d)",
+			precision, db.Precision())
+	}
+
+	return r, nil
+}
+
+// Aggregate all of the source posts in the cell centred on ref, using vals as
+// scratch space. Returns false if none of the source tiles exist.
+//
+// When the cell is an even number of source posts wide, the posts on its edges
+// are shared with the neighbouring cells, so that it's still centred.
+func (r *Resampler) downsample(ref osgrid.GridRef, vals []float64) (float64, bool, error) {
+	src := r.db.Precision()
+	n := int(r.precision / src)
+
+	found := false
+
+	for y := -n / 2; y <= n/2; y++ {
+		for x := -n / 2; x <= n/2; x++ {
+			post, err := ref.Add(osgrid.Distance(x)*src, osgrid.Distance(y)*src)
+			if err != nil {
+				// Off the edge of the grid
+				continue
+			}
+
+			v, err := r.db.GetFloat64(post)
+			if errors.Is(err, ErrTileNotFound) {
+				continue
+			} else if err != nil {
+				return NoData, false, err
+			}
+
+			found = true
+			if !IsNoData(v) {
+				vals = append(vals, v)
+			}
+		}
+	}
+
+	return r.aggregate.aggregate(vals), found, nil
+}
+
+// Interpolate the value at ref. Returns false if the source tile doesn't
+// exist.
+func (r *Resampler) upsample(ref osgrid.GridRef) (float64, bool, error) {
+	v, err := Sample(r.db, ref, r.method)
+	if err == nil {
+		return v, true, nil
+	}
+
+	if !errors.Is(err, ErrTileNotFound) {
+		return NoData, false, err
+	}
+
+	// A neighbouring tile is missing, so fall back to the nearest post
+	v, err = r.db.GetFloat64(ref)
+	if errors.Is(err, ErrTileNotFound) {
+		return NoData, false, nil
+	}
+
+	return v, err == nil, err
+}
+
+func (r *Resampler) generateTile(bottomLeft osgrid.GridRef) (*GridTile, error) {
+	n := int(r.tileSize / r.precision)
+
+	data := make([][]float64, n)
+
+	found := false
+
+	// Reused for each cell when downsampling
+	var vals []float64
+	if r.precision > r.db.Precision() {
+		k := int(r.precision/r.db.Precision())/2*2 + 1
+		vals = make([]float64, 0, k*k)
+	}
+
+	for y := range data {
+		data[y] = make([]float64, n)
+
+		for x := range data[y] {
+			ref, err := bottomLeft.Add(osgrid.Distance(x)*r.precision, osgrid.Distance(y)*r.precision)
+			if err != nil {
+				return nil, err
+			}
+
+			var v float64
+			var ok bool
+			if r.precision > r.db.Precision() {
+				v, ok, err = r.downsample(ref, vals)
+			} else {
+				v, ok, err = r.upsample(ref)
+			}
+			if err != nil {
+				return nil, err
+			}
+
+			if !ok {
+				v = NoData
+			}
+
+			found = found || ok
+			data[y][x] = v
+		}
+	}
+
+	if !found {
+		return nil, fmt.Errorf("Tile %s %w", bottomLeft, ErrTileNotFound)
+	}
+
+	return NewGridTile(bottomLeft, r.precision, data), nil
+}
+
+func (r *Resampler) getTile(ref osgrid.GridRef) (*GridTile, error) {
+	ref = ref.Align(r.tileSize)
+
+	tile, err := r.cache.Load(ref, func() (Tile, error) {
+		return r.generateTile(ref)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return tile.(*GridTile), nil
+}
+
+func (r *Resampler) GetTile(ref osgrid.GridRef) (Tile, error) {
+	if r.precision == r.db.Precision() {
+		return r.db.GetTile(ref)
+	}
+
+	return r.getTile(ref)
+}
+
+func (r *Resampler) GetFloat64Tile(ref osgrid.GridRef) (Float64Tile, error) {
+	if r.precision == r.db.Precision() {
+		return r.db.GetFloat64Tile(ref)
+	}
+
+	return r.getTile(ref)
+}
+
+func (r *Resampler) GetFloat64(ref osgrid.GridRef) (float64, error) {
+	if r.precision == r.db.Precision() {
+		return r.db.GetFloat64(ref)
+	}
+
+	tile, err := r.getTile(ref)
+	if err != nil {
+		return NoData, err
+	}
+
+	return tile.GetFloat64(ref)
+}
+
+func (r *Resampler) Precision() osgrid.Distance {
+	return r.precision
+}
+
+// Stats returns the stats of the resampled tile cache
+func (r *Resampler) Stats() Stats {
+	return r.cache.Stats()
+}
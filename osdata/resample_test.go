@@ -0,0 +1,238 @@
+package osdata
+
+import (
+	"errors"
+	"math"
+	"testing"
+
+	"github.com/usedbytes/osgrid"
+)
+
+func TestResampleDownsample(t *testing.T) {
+	db := &TestFloat64Database{
+		precision: 10 * osgrid.Metre,
+		f: func(e, n float64) float64 {
+			// Ignored by all of the aggregates
+			if e == 1010 && n == 2000 {
+				return NoData
+			}
+			return e + n
+		},
+	}
+
+	ref, _ := osgrid.Origin().Add(1000, 2000)
+
+	testCases := []struct {
+		method AggregateMethod
+		exp    float64
+	}{
+		// 5x5 posts centred on the cell: e from 980 to 1020, n from 1980
+		// to 2020
+		{AggregateMean, (3000.0*25 - 3010.0) / 24},
+		{AggregateMin, 2960},
+		{AggregateMax, 3040},
+		{AggregateMedian, 3000},
+	}
+
+	for _, tc := range testCases {
+		r, err := NewResampler(db, 50*osgrid.Metre, ResampleAggregateOpt(tc.method),
+			ResampleTileSizeOpt(1*osgrid.Kilometre))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if r.Precision() != 50*osgrid.Metre {
+			t.Errorf("expected precision 50, got %d", r.Precision())
+		}
+
+		// Anywhere in the cell should give the same value
+		for _, off := range []osgrid.Distance{0, 25, 49} {
+			p, _ := ref.Add(off, off)
+
+			v, err := r.GetFloat64(p)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if math.Abs(v-tc.exp) > 1e-9 {
+				t.Errorf("%s +%d: expected %v, got %v", tc.method, off, tc.exp, v)
+			}
+		}
+	}
+}
+
+func TestResampleDownsampleCentred(t *testing.T) {
+	// A slope, with a peak at 1030, 2030
+	db := &TestFloat64Database{
+		precision: 10 * osgrid.Metre,
+		f: func(e, n float64) float64 {
+			if e == 1030 && n == 2030 {
+				return 10000
+			}
+			return e + 2*n
+		},
+	}
+
+	for _, precision := range []osgrid.Distance{50, 100} {
+		mean, err := NewResampler(db, precision, ResampleTileSizeOpt(1*osgrid.Kilometre))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// Away from the peak, the mean of the slope is its value at the post
+		for _, off := range [][2]osgrid.Distance{{200, 300}, {500, 500}} {
+			ref, _ := osgrid.Origin().Add(1000+off[0], 2000+off[1])
+
+			v, err := mean.GetFloat64(ref)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			exp := float64(ref.AbsEasting() + 2*ref.AbsNorthing())
+			if math.Abs(v-exp) > 1e-9 {
+				t.Errorf("%d %s: expected %v, got %v", precision, ref, exp, v)
+			}
+		}
+
+		max, err := NewResampler(db, precision, ResampleAggregateOpt(AggregateMax),
+			ResampleTileSizeOpt(1*osgrid.Kilometre))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// The peak is in the cell of the post nearest to it, 30 m from
+		// the posts at 1000, 2000
+		off := osgrid.Distance(0)
+		if 30 > precision/2 {
+			off = precision
+		}
+
+		for _, e := range []osgrid.Distance{off - precision, off, off + precision} {
+			ref, _ := osgrid.Origin().Add(1000+e, 2000+off)
+
+			v, err := max.GetFloat64(ref)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if (v == 10000) != (e == off) {
+				t.Errorf("%d %s: unexpected %v", precision, ref, v)
+			}
+		}
+	}
+}
+
+func TestResampleUpsample(t *testing.T) {
+	db := &TestFloat64Database{
+		precision: 50 * osgrid.Metre,
+		f:         func(e, n float64) float64 { return e + 2*n },
+	}
+
+	r, err := NewResampler(db, 10*osgrid.Metre, ResampleTileSizeOpt(1*osgrid.Kilometre))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, off := range [][2]osgrid.Distance{{0, 0}, {10, 0}, {20, 30}, {47, 13}} {
+		ref, _ := osgrid.Origin().Add(1000+off[0], 2000+off[1])
+
+		v, err := r.GetFloat64(ref)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// Bilinear is exact for a plane, at the 10 m posts
+		aligned := ref.Align(10 * osgrid.Metre)
+		exp := float64(aligned.AbsEasting() + 2*aligned.AbsNorthing())
+		if math.Abs(v-exp) > 1e-9 {
+			t.Errorf("%s: expected %v, got %v", ref, exp, v)
+		}
+	}
+}
+
+func TestResampleMissingTiles(t *testing.T) {
+	r, err := NewResampler(newTestCoastDatabase(), 100*osgrid.Metre)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	land, _ := osgrid.Origin().Add(15*osgrid.Kilometre, 5*osgrid.Kilometre)
+	_, err = r.GetFloat64(land)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Not the first tile off the coast, whose Western posts' cells reach
+	// onto the land
+	sea, _ := osgrid.Origin().Add(35*osgrid.Kilometre, 5*osgrid.Kilometre)
+	_, err = r.GetFloat64(sea)
+	if !errors.Is(err, ErrTileNotFound) {
+		t.Fatalf("expected ErrTileNotFound, got %v", err)
+	}
+
+	_, err = r.GetFloat64Tile(sea)
+	if !errors.Is(err, ErrTileNotFound) {
+		t.Fatalf("expected ErrTileNotFound, got %v", err)
+	}
+}
+
+func TestResampleInvalid(t *testing.T) {
+	db := &TestFloat64Database{precision: 50 * osgrid.Metre}
+
+	_, err := NewResampler(db, 75*osgrid.Metre)
+	if err == nil {
+		t.Errorf("expected error for precision which isn't a multiple")
+	}
+
+	_, err = NewResampler(db, 300*osgrid.Metre, ResampleTileSizeOpt(1*osgrid.Kilometre))
+	if err == nil {
+		t.Errorf("expected error for tile size which isn't a multiple")
+	}
+}
+
+func TestResampleDefaultTileSize(t *testing.T) {
+	db := &TestFloat64Database{
+		precision: 50 * osgrid.Metre,
+		f:         func(e, n float64) float64 { return e + n },
+	}
+
+	// 300 m doesn't divide 10 km, so the tiles are a little bigger
+	r, err := NewResampler(db, 300*osgrid.Metre)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ref, _ := osgrid.Origin().Add(30*osgrid.Kilometre, 60*osgrid.Kilometre)
+	tile, err := r.GetFloat64Tile(ref)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if tile.Width() != 10200 || tile.Precision() != 300 {
+		t.Errorf("expected 10200 m tile with 300 m precision, got %d, %d", tile.Width(), tile.Precision())
+	}
+
+	v, err := r.GetFloat64(ref)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	exp := float64(ref.AbsEasting() + ref.AbsNorthing())
+	if math.Abs(v-exp) > 1e-9 {
+		t.Errorf("expected %v, got %v", exp, v)
+	}
+}
+
+func TestParseAggregateMethod(t *testing.T) {
+	for _, m := range []AggregateMethod{AggregateMean, AggregateMin, AggregateMax, AggregateMedian} {
+		parsed, err := ParseAggregateMethod(m.String())
+		if err != nil || parsed != m {
+			t.Errorf("%s: got %v, %v", m, parsed, err)
+		}
+	}
+
+	_, err := ParseAggregateMethod("mode")
+	if err == nil {
+		t.Errorf("expected error")
+	}
+}
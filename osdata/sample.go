@@ -0,0 +1,157 @@
+package osdata
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/usedbytes/osgrid"
+)
+
+type SampleMethod int
+
+const (
+	// Value of the closest post
+	SampleNearest SampleMethod = iota
+	// Linear interpolation between the 4 surrounding posts
+	SampleBilinear
+	// Cubic (Catmull-Rom) interpolation of the 16 surrounding posts
+	SampleBicubic
+)
+
+func (m SampleMethod) String() string {
+	switch m {
+	case SampleNearest:
+		return "nearest"
+	case SampleBilinear:
+		return "bilinear"
+	case SampleBicubic:
+		return "bicubic"
+	}
+
+	return fmt.Sprintf("SampleMethod(%d)", int(m))
+}
+
+func ParseSampleMethod(s string) (SampleMethod, error) {
+	for _, m := range []SampleMethod{SampleNearest, SampleBilinear, SampleBicubic} {
+		if s == m.String() {
+			return m, nil
+		}
+	}
+
+	return SampleNearest, fmt.Errorf("unknown sample method: %s", s)
+}
+
+// Get the value of the post (east, north) posts away from ref. Returns false if
+// there's no value there: off the edge of the grid, in a missing tile, or
+// NoData.
+func getPost(db Float64Database, ref osgrid.GridRef, east, north int) (float64, bool, error) {
+	precision := db.Precision()
+
+	post, err := ref.Add(osgrid.Distance(east)*precision, osgrid.Distance(north)*precision)
+	if err != nil {
+		return NoData, false, nil
+	}
+
+	v, err := db.GetFloat64(post)
+	if errors.Is(err, ErrTileNotFound) {
+		return NoData, false, nil
+	} else if err != nil {
+		return NoData, false, err
+	}
+
+	return v, !IsNoData(v), nil
+}
+
+// Weights of the 4 posts around t for cubic (Catmull-Rom) interpolation
+func cubicWeights(t float64) []float64 {
+	t2, t3 := t*t, t*t*t
+
+	return []float64{
+		0.5 * (-t + 2*t2 - t3),
+		0.5 * (2 - 5*t2 + 3*t3),
+		0.5 * (t + 4*t2 - 3*t3),
+		0.5 * (-t2 + t3),
+	}
+}
+
+// Sum the posts around sw, weighted by wx[x]*wy[y] for the post x+offset
+// posts East and y+offset posts North of sw. Posts with no weight aren't
+// fetched, so exactly on a post only that post is needed. Returns false if
+// any of the others has no value.
+func interpolate(db Float64Database, sw osgrid.GridRef, offset int, wx, wy []float64) (float64, bool, error) {
+	var sum float64
+	for y := range wy {
+		if wy[y] == 0 {
+			continue
+		}
+
+		for x := range wx {
+			if wx[x] == 0 {
+				continue
+			}
+
+			v, ok, err := getPost(db, sw, x+offset, y+offset)
+			if err != nil || !ok {
+				return NoData, false, err
+			}
+
+			sum += wx[x] * wy[y] * v
+		}
+	}
+
+	return sum, true, nil
+}
+
+// Sample returns the value of db at ref, interpolated from the surrounding
+// posts using method. Neighbouring posts are fetched from the database, so
+// they may come from adjacent tiles.
+//
+// At the edge of the data, where some of the posts needed for bicubic
+// interpolation are missing, bilinear is used instead, and where those are
+// missing too, the nearest post.
+func Sample(db Float64Database, ref osgrid.GridRef, method SampleMethod) (float64, error) {
+	precision := db.Precision()
+	if precision <= 1 {
+		// Posts every metre, nothing to interpolate between
+		return db.GetFloat64(ref)
+	}
+
+	sw := ref.Align(precision)
+
+	// Fractional position between sw and the next post
+	fx := float64(ref.TileEasting()-sw.TileEasting()) / float64(precision)
+	fy := float64(ref.TileNorthing()-sw.TileNorthing()) / float64(precision)
+
+	switch method {
+	case SampleNearest:
+	case SampleBicubic:
+		v, ok, err := interpolate(db, sw, -1, cubicWeights(fx), cubicWeights(fy))
+		if err != nil || ok {
+			return v, err
+		}
+
+		fallthrough
+	case SampleBilinear:
+		v, ok, err := interpolate(db, sw, 0, []float64{1 - fx, fx}, []float64{1 - fy, fy})
+		if err != nil || ok {
+			return v, err
+		}
+	default:
+		return NoData, fmt.Errorf("unknown sample method: %v", method)
+	}
+
+	east, north := 0, 0
+	if fx >= 0.5 {
+		east = 1
+	}
+	if fy >= 0.5 {
+		north = 1
+	}
+
+	post, err := sw.Add(osgrid.Distance(east)*precision, osgrid.Distance(north)*precision)
+	if err != nil {
+		return NoData, err
+	}
+
+	return db.GetFloat64(post)
+}
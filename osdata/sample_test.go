@@ -0,0 +1,238 @@
+package osdata
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"testing"
+
+	"github.com/usedbytes/osgrid"
+)
+
+type TestFloat64Database struct {
+	precision osgrid.Distance
+	f         func(east, north float64) float64
+	// If set, returns true where the tiles are missing
+	missing func(east, north float64) bool
+	// Number of calls to GetFloat64
+	gets int
+}
+
+func (db *TestFloat64Database) Stats() Stats {
+	return Stats{}
+}
+
+func (db *TestFloat64Database) GetTile(osgrid.GridRef) (Tile, error) {
+	return nil, errors.New("GetTile not implemented")
+}
+
+func (db *TestFloat64Database) Precision() osgrid.Distance {
+	return db.precision
+}
+
+func (db *TestFloat64Database) GetFloat64(ref osgrid.GridRef) (float64, error) {
+	db.gets++
+
+	ref = ref.Align(db.precision)
+	if db.missing != nil && db.missing(float64(ref.AbsEasting()), float64(ref.AbsNorthing())) {
+		return NoData, fmt.Errorf("Tile %s %w", ref, ErrTileNotFound)
+	}
+
+	return db.f(float64(ref.AbsEasting()), float64(ref.AbsNorthing())), nil
+}
+
+func (db *TestFloat64Database) GetFloat64Tile(ref osgrid.GridRef) (Float64Tile, error) {
+	return nil, errors.New("GetFloat64Tile not implemented")
+}
+
+func TestSampleNearest(t *testing.T) {
+	db := &TestFloat64Database{
+		precision: 50 * osgrid.Metre,
+		f:         func(e, n float64) float64 { return e + 1000*n },
+	}
+
+	ref, _ := osgrid.ParseGridRef("SH 6000 5400")
+
+	tests := []struct {
+		east, north osgrid.Distance
+		expEast     osgrid.Distance
+		expNorth    osgrid.Distance
+	}{
+		{0, 0, 0, 0},
+		{24, 24, 0, 0},
+		{25, 0, 50, 0},
+		{10, 49, 0, 50},
+		{-1, -1, 0, 0},
+		{-30, -30, -50, -50},
+		{-26, 0, -50, 0},
+	}
+
+	for _, test := range tests {
+		point, _ := ref.Add(test.east, test.north)
+		expRef, _ := ref.Add(test.expEast, test.expNorth)
+		exp, _ := db.GetFloat64(expRef)
+
+		v, err := Sample(db, point, SampleNearest)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if v != exp {
+			t.Errorf("%v: expected %v got %v", point, exp, v)
+		}
+	}
+}
+
+func testSampleLinear(t *testing.T, method SampleMethod) {
+	db := &TestFloat64Database{
+		precision: 50 * osgrid.Metre,
+		f:         func(e, n float64) float64 { return 2*e + 3*n },
+	}
+
+	// Straddle the boundary between two 100 km squares
+	ref, _ := osgrid.ParseGridRef("SH 99900 54000")
+
+	for east := osgrid.Distance(0); east < 200; east += 7 {
+		for north := osgrid.Distance(0); north < 100; north += 11 {
+			point, _ := ref.Add(east, north)
+			exp := 2*float64(point.AbsEasting()) + 3*float64(point.AbsNorthing())
+
+			v, err := Sample(db, point, method)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if math.Abs(v-exp) > 1e-6 {
+				t.Fatalf("%v: expected %v got %v", point, exp, v)
+			}
+		}
+	}
+}
+
+func TestSampleBilinear(t *testing.T) {
+	testSampleLinear(t, SampleBilinear)
+}
+
+func TestSampleBicubic(t *testing.T) {
+	testSampleLinear(t, SampleBicubic)
+}
+
+func TestSampleBilinearMidpoint(t *testing.T) {
+	db := &TestFloat64Database{
+		precision: 10 * osgrid.Metre,
+		f:         func(e, n float64) float64 { return e * n },
+	}
+
+	ref, _ := osgrid.ParseGridRef("SV 00010 00010")
+	point, _ := ref.Add(5, 5)
+
+	v, err := Sample(db, point, SampleBilinear)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Average of 10*10, 20*10, 10*20 and 20*20
+	if v != 225 {
+		t.Errorf("expected 225 got %v", v)
+	}
+}
+
+func TestSampleFetches(t *testing.T) {
+	db := &TestFloat64Database{
+		precision: 50 * osgrid.Metre,
+		f:         func(e, n float64) float64 { return e + n },
+	}
+
+	ref, _ := osgrid.ParseGridRef("SH 6000 5400")
+	between, _ := ref.Add(0, 20)
+
+	// Posts with no weight aren't fetched
+	for _, tc := range []struct {
+		ref    osgrid.GridRef
+		method SampleMethod
+		gets   int
+	}{
+		{ref, SampleBilinear, 1},
+		{ref, SampleBicubic, 1},
+		{between, SampleBilinear, 2},
+		{between, SampleBicubic, 4},
+	} {
+		db.gets = 0
+		if _, err := Sample(db, tc.ref, tc.method); err != nil {
+			t.Fatal(err)
+		}
+
+		if db.gets != tc.gets {
+			t.Errorf("%v %v: expected %d posts fetched, got %d", tc.ref, tc.method, tc.gets, db.gets)
+		}
+	}
+}
+
+func TestSampleEdge(t *testing.T) {
+	// The data ends at SH 6000 5400
+	edge, _ := osgrid.ParseGridRef("SH 6000 5400")
+	db := &TestFloat64Database{
+		precision: 50 * osgrid.Metre,
+		f:         func(e, n float64) float64 { return 2*e + 3*n },
+		missing: func(e, n float64) bool {
+			return e > float64(edge.AbsEasting())
+		},
+	}
+
+	linear := func(ref osgrid.GridRef) float64 {
+		return 2*float64(ref.AbsEasting()) + 3*float64(ref.AbsNorthing())
+	}
+
+	for _, tc := range []struct {
+		east   osgrid.Distance
+		method SampleMethod
+		// Offset of the post expected, or the interpolated value if
+		// it's zero
+		post osgrid.Distance
+	}{
+		// Bicubic needs a post beyond the edge, so falls back to bilinear
+		{-20, SampleBicubic, 0},
+		{-20, SampleBilinear, 0},
+		// Exactly on the edge, nothing beyond it is needed
+		{0, SampleBicubic, 0},
+		{0, SampleBilinear, 0},
+		// Beyond the last post, the nearest is used
+		{10, SampleBicubic, -10},
+		{20, SampleBilinear, -20},
+	} {
+		point, _ := edge.Add(tc.east, 0)
+		exp := linear(point)
+		if tc.post != 0 {
+			post, _ := point.Add(tc.post, 0)
+			exp = linear(post)
+		}
+
+		v, err := Sample(db, point, tc.method)
+		if err != nil {
+			t.Fatalf("%v %v: %v", point, tc.method, err)
+		}
+
+		if math.Abs(v-exp) > 1e-6 {
+			t.Errorf("%v %v: expected %v got %v", point, tc.method, exp, v)
+		}
+	}
+
+	// The nearest post is beyond the edge
+	point, _ := edge.Add(30, 0)
+	if v, err := Sample(db, point, SampleBilinear); !errors.Is(err, ErrTileNotFound) {
+		t.Errorf("expected ErrTileNotFound, got %v, %v", v, err)
+	}
+}
+
+func TestParseSampleMethod(t *testing.T) {
+	for _, m := range []SampleMethod{SampleNearest, SampleBilinear, SampleBicubic} {
+		got, err := ParseSampleMethod(m.String())
+		if err != nil || got != m {
+			t.Errorf("%v: got %v, %v", m, got, err)
+		}
+	}
+
+	if _, err := ParseSampleMethod("sinc"); err == nil {
+		t.Error("expected error for unknown method")
+	}
+}
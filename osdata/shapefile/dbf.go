@@ -0,0 +1,178 @@
+package shapefile
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// Field describes one of the attributes in a shapefile's .dbf table
+type Field struct {
+	Name string
+	// dBASE type: 'C' (string), 'N' or 'F' (number), 'L' (logical) or 'D'
+	// (date)
+	Type     byte
+	Length   int
+	Decimals int
+}
+
+const (
+	dbfHeaderSize = 32
+	dbfFieldSize  = 32
+	dbfTerminator = 0x0d
+	dbfDeleted    = '*'
+)
+
+type dbfReader struct {
+	r          io.ReaderAt
+	fields     []Field
+	numRecords int
+	headerLen  int64
+	recordLen  int
+}
+
+func newDBFReader(r io.ReaderAt) (*dbfReader, error) {
+	d := &dbfReader{
+		r: r,
+	}
+
+	br := bufio.NewReader(io.NewSectionReader(r, 0, math.MaxInt64))
+
+	hdr := make([]byte, dbfHeaderSize)
+	if _, err := io.ReadFull(br, hdr); err != nil {
+		return nil, fmt.Errorf("reading dbf header: %w", err)
+	}
+
+	d.numRecords = int(binary.LittleEndian.Uint32(hdr[4:8]))
+	d.headerLen = int64(binary.LittleEndian.Uint16(hdr[8:10]))
+	d.recordLen = int(binary.LittleEndian.Uint16(hdr[10:12]))
+
+	for {
+		b, err := br.Peek(1)
+		if err != nil {
+			return nil, fmt.Errorf("reading dbf fields: %w", err)
+		}
+
+		if b[0] == dbfTerminator {
+			break
+		}
+
+		desc := make([]byte, dbfFieldSize)
+		if _, err := io.ReadFull(br, desc); err != nil {
+			return nil, fmt.Errorf("reading dbf fields: %w", err)
+		}
+
+		name := desc[:11]
+		if i := bytes.IndexByte(name, 0); i >= 0 {
+			name = name[:i]
+		}
+
+		d.fields = append(d.fields, Field{
+			Name:     string(name),
+			Type:     desc[11],
+			Length:   int(desc[16]),
+			Decimals: int(desc[17]),
+		})
+	}
+
+	total := 1
+	for _, f := range d.fields {
+		total += f.Length
+	}
+
+	if total != d.recordLen {
+		return nil, fmt.Errorf("dbf record length %d doesn't match fields (%d)", d.recordLen, total)
+	}
+
+	return d, nil
+}
+
+// Read record i (0-based). Deleted records are returned as nil.
+func (d *dbfReader) read(i int) (map[string]interface{}, error) {
+	if i < 0 || i >= d.numRecords {
+		return nil, fmt.Errorf("record %d out of range (%d records)", i, d.numRecords)
+	}
+
+	record := make([]byte, d.recordLen)
+	if _, err := d.r.ReadAt(record, d.headerLen+int64(i)*int64(d.recordLen)); err != nil {
+		return nil, err
+	}
+
+	if record[0] == dbfDeleted {
+		return nil, nil
+	}
+
+	attrs := make(map[string]interface{}, len(d.fields))
+
+	pos := 1
+	for _, f := range d.fields {
+		raw := record[pos : pos+f.Length]
+		pos += f.Length
+
+		v, err := f.parse(raw)
+		if err != nil {
+			return nil, fmt.Errorf("field %s: %w", f.Name, err)
+		}
+
+		if v != nil {
+			attrs[f.Name] = v
+		}
+	}
+
+	return attrs, nil
+}
+
+// Text is usually UTF-8, but older files are often Latin-1
+func decodeText(raw []byte) string {
+	if utf8.Valid(raw) {
+		return string(raw)
+	}
+
+	runes := make([]rune, len(raw))
+	for i, b := range raw {
+		runes[i] = rune(b)
+	}
+
+	return string(runes)
+}
+
+// Parse a value. Empty values are returned as nil.
+func (f Field) parse(raw []byte) (interface{}, error) {
+	s := strings.TrimSpace(decodeText(bytes.TrimRight(raw, "\x00")))
+
+	switch f.Type {
+	case 'N', 'F':
+		if s == "" || strings.Trim(s, "*") == "" {
+			return nil, nil
+		}
+
+		if f.Decimals == 0 {
+			if v, err := strconv.ParseInt(s, 10, 64); err == nil {
+				return v, nil
+			}
+		}
+
+		return strconv.ParseFloat(s, 64)
+	case 'L':
+		switch s {
+		case "Y", "y", "T", "t":
+			return true, nil
+		case "N", "n", "F", "f":
+			return false, nil
+		}
+
+		return nil, nil
+	}
+
+	if s == "" {
+		return nil, nil
+	}
+
+	return s, nil
+}
@@ -0,0 +1,570 @@
+// Package shapefile reads ESRI Shapefiles, which many of the OS OpenData
+// vector products are distributed as.
+//
+// A shapefile is a set of files with the same name: the geometry is in the
+// .shp file, the attributes are in the .dbf file, the .shx file is an index
+// of the records in the .shp file, and the .prj file describes the
+// coordinate system.
+package shapefile
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/usedbytes/osgrid"
+	"github.com/usedbytes/osgrid/osdata/vector"
+)
+
+// ShapeType is the type of geometry in a shapefile
+type ShapeType int
+
+const (
+	ShapeNull        ShapeType = 0
+	ShapePoint       ShapeType = 1
+	ShapePolyLine    ShapeType = 3
+	ShapePolygon     ShapeType = 5
+	ShapeMultiPoint  ShapeType = 8
+	ShapePointZ      ShapeType = 11
+	ShapePolyLineZ   ShapeType = 13
+	ShapePolygonZ    ShapeType = 15
+	ShapeMultiPointZ ShapeType = 18
+	ShapePointM      ShapeType = 21
+	ShapePolyLineM   ShapeType = 23
+	ShapePolygonM    ShapeType = 25
+	ShapeMultiPointM ShapeType = 28
+)
+
+func (t ShapeType) String() string {
+	switch t {
+	case ShapeNull:
+		return "Null"
+	case ShapePoint:
+		return "Point"
+	case ShapePolyLine:
+		return "PolyLine"
+	case ShapePolygon:
+		return "Polygon"
+	case ShapeMultiPoint:
+		return "MultiPoint"
+	case ShapePointZ:
+		return "PointZ"
+	case ShapePolyLineZ:
+		return "PolyLineZ"
+	case ShapePolygonZ:
+		return "PolygonZ"
+	case ShapeMultiPointZ:
+		return "MultiPointZ"
+	case ShapePointM:
+		return "PointM"
+	case ShapePolyLineM:
+		return "PolyLineM"
+	case ShapePolygonM:
+		return "PolygonM"
+	case ShapeMultiPointM:
+		return "MultiPointM"
+	}
+
+	return fmt.Sprintf("ShapeType(%d)", int(t))
+}
+
+const (
+	fileCode       = 9994
+	fileVersion    = 1000
+	headerSize     = 100
+	recordHdrSize  = 8
+	shxRecordSize  = 8
+	bytesPerWord   = 2
+	bytesPerPoint  = 16
+	bytesPerDouble = 8
+	// Shape type and bounding box, at the start of most records
+	boundsPrefixSize = 4 + 4*bytesPerDouble
+)
+
+// File is an open shapefile
+type File struct {
+	// Type of all of the (non-null) shapes in the file
+	ShapeType ShapeType
+	// Bounds of all of the shapes in the file
+	Bounds vector.Bounds
+	// Attributes, in the order they appear in the .dbf file
+	Fields []Field
+
+	shp, shx, dbf *os.File
+	dbfR          *dbfReader
+
+	// For reading sequentially with Next()
+	shpR *bufio.Reader
+	// 0-based index of the next record
+	record int
+}
+
+// Open opens a shapefile. path is the .shp file, or the name of the
+// shapefile without an extension.
+//
+// If there's a .prj file, it must be British National Grid (EPSG:27700). If
+// there's no .prj file, the coordinates are assumed to be British National
+// Grid. The .shx file is optional, but makes Search() much faster.
+func Open(path string) (*File, error) {
+	base := path
+	if strings.EqualFold(filepath.Ext(path), ".shp") {
+		base = strings.TrimSuffix(path, filepath.Ext(path))
+	}
+
+	if err := checkProjection(base); err != nil {
+		return nil, err
+	}
+
+	shp, err := openSidecar(base, ".shp")
+	if err != nil {
+		return nil, err
+	}
+
+	f := &File{
+		shp: shp,
+		shpR: bufio.NewReader(io.NewSectionReader(shp, headerSize,
+			math.MaxInt64-headerSize)),
+	}
+
+	if err := f.readHeader(); err != nil {
+		f.Close()
+		return nil, fmt.Errorf("%s: %w", shp.Name(), err)
+	}
+
+	f.dbf, err = openSidecar(base, ".dbf")
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	f.dbfR, err = newDBFReader(f.dbf)
+	if err != nil {
+		f.Close()
+		return nil, fmt.Errorf("%s: %w", f.dbf.Name(), err)
+	}
+	f.Fields = f.dbfR.fields
+
+	f.shx, err = openSidecar(base, ".shx")
+	if err != nil && !os.IsNotExist(err) {
+		f.Close()
+		return nil, err
+	}
+
+	return f, nil
+}
+
+// Open base+ext, allowing for the extension being upper case
+func openSidecar(base, ext string) (*os.File, error) {
+	f, err := os.Open(base + ext)
+	if os.IsNotExist(err) {
+		if upper, err := os.Open(base + strings.ToUpper(ext)); err == nil {
+			return upper, nil
+		}
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return f, nil
+}
+
+// Check that the .prj file, if there is one, is British National Grid
+func checkProjection(base string) error {
+	prj, err := openSidecar(base, ".prj")
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	defer prj.Close()
+
+	wkt, err := ioutil.ReadAll(prj)
+	if err != nil {
+		return err
+	}
+
+	if !isBritishNationalGrid(string(wkt)) {
+		return fmt.Errorf("%s: coordinate system isn't British National Grid (EPSG:27700): %s",
+			prj.Name(), strings.TrimSpace(string(wkt)))
+	}
+
+	return nil
+}
+
+// Check a WKT coordinate system description. Different tools write quite
+// different WKT for the same thing, so this just looks for the name or EPSG
+// code.
+func isBritishNationalGrid(wkt string) bool {
+	s := strings.ToLower(wkt)
+	s = strings.NewReplacer(" ", "", "_", "", "\"", "", "\n", "", "\r", "", "\t", "").Replace(s)
+
+	if !strings.HasPrefix(s, "projcs[") && !strings.HasPrefix(s, "projcrs[") {
+		return false
+	}
+
+	return strings.Contains(s, "britishnationalgrid") ||
+		strings.Contains(s, "authority[epsg,27700]") ||
+		strings.Contains(s, "id[epsg,27700]")
+}
+
+func (f *File) Close() error {
+	var err error
+	for _, file := range []*os.File{f.shp, f.shx, f.dbf} {
+		if file == nil {
+			continue
+		}
+
+		if e := file.Close(); e != nil && err == nil {
+			err = e
+		}
+	}
+
+	return err
+}
+
+func readBounds(b []byte) vector.Bounds {
+	return vector.Bounds{
+		Min: vector.Point{E: readDouble(b[0:]), N: readDouble(b[8:])},
+		Max: vector.Point{E: readDouble(b[16:]), N: readDouble(b[24:])},
+	}
+}
+
+func readDouble(b []byte) float64 {
+	return math.Float64frombits(binary.LittleEndian.Uint64(b))
+}
+
+func (f *File) readHeader() error {
+	hdr := make([]byte, headerSize)
+	if _, err := f.shp.ReadAt(hdr, 0); err != nil {
+		return fmt.Errorf("reading header: %w", err)
+	}
+
+	if code := binary.BigEndian.Uint32(hdr[0:4]); code != fileCode {
+		return fmt.Errorf("not a shapefile (file code %d)", code)
+	}
+
+	if version := binary.LittleEndian.Uint32(hdr[28:32]); version != fileVersion {
+		return fmt.Errorf("unsupported version %d", version)
+	}
+
+	f.ShapeType = ShapeType(binary.LittleEndian.Uint32(hdr[32:36]))
+	f.Bounds = readBounds(hdr[36:68])
+
+	return nil
+}
+
+// Len returns the number of records in the file, including deleted records
+func (f *File) Len() int {
+	return f.dbfR.numRecords
+}
+
+// Make a feature from record i's content. Returns nil for deleted records.
+func (f *File) feature(i int, content []byte) (*vector.Feature, error) {
+	attrs, err := f.dbfR.read(i)
+	if err != nil {
+		return nil, fmt.Errorf("record %d attributes: %w", i+1, err)
+	}
+
+	if attrs == nil {
+		return nil, nil
+	}
+
+	geom, err := parseShape(content)
+	if err != nil {
+		return nil, fmt.Errorf("record %d: %w", i+1, err)
+	}
+
+	return &vector.Feature{
+		ID:         int64(i + 1),
+		Geometry:   geom,
+		Attributes: attrs,
+	}, nil
+}
+
+// Read the next record's content from r
+func readRecord(r io.Reader) ([]byte, error) {
+	rec := make([]byte, recordHdrSize)
+	if _, err := io.ReadFull(r, rec); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+
+	content := make([]byte, int(binary.BigEndian.Uint32(rec[4:8]))*bytesPerWord)
+	if _, err := io.ReadFull(r, content); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+
+	return content, nil
+}
+
+// Next returns the next feature in the file, or io.EOF at the end. The
+// feature's ID is its (1-based) record number. Deleted records are skipped.
+func (f *File) Next() (*vector.Feature, error) {
+	for f.record < f.dbfR.numRecords {
+		i := f.record
+
+		content, err := readRecord(f.shpR)
+		if err != nil {
+			return nil, fmt.Errorf("record %d: %w", i+1, err)
+		}
+		f.record++
+
+		feat, err := f.feature(i, content)
+		if err != nil {
+			return nil, err
+		}
+
+		if feat != nil {
+			return feat, nil
+		}
+	}
+
+	return nil, io.EOF
+}
+
+// ReadAll reads all of the remaining features
+func (f *File) ReadAll() ([]*vector.Feature, error) {
+	var features []*vector.Feature
+	for {
+		feat, err := f.Next()
+		if err == io.EOF {
+			return features, nil
+		} else if err != nil {
+			return nil, err
+		}
+
+		features = append(features, feat)
+	}
+}
+
+// Get the bounds of a record from the start of its content
+func recordBounds(content []byte) (vector.Bounds, bool) {
+	if len(content) < 4 {
+		return vector.Bounds{}, false
+	}
+
+	switch ShapeType(binary.LittleEndian.Uint32(content)) {
+	case ShapeNull:
+		return vector.Bounds{}, false
+	case ShapePoint, ShapePointZ, ShapePointM:
+		if len(content) < 4+bytesPerPoint {
+			return vector.Bounds{}, false
+		}
+
+		p := vector.Point{E: readDouble(content[4:]), N: readDouble(content[12:])}
+		return vector.Bounds{Min: p, Max: p}, true
+	}
+
+	if len(content) < boundsPrefixSize {
+		return vector.Bounds{}, false
+	}
+
+	return readBounds(content[4:]), true
+}
+
+// Search returns the features whose bounding boxes intersect r, in record
+// order. It's independent of Next(), and doesn't change its position.
+//
+// The .shx file is used to skip over the records outside of r, if there is
+// one.
+func (f *File) Search(r osgrid.Rect) ([]*vector.Feature, error) {
+	return f.SearchBounds(vector.BoundsFromRect(r))
+}
+
+// SearchBounds is Search, with vector.Bounds
+func (f *File) SearchBounds(b vector.Bounds) ([]*vector.Feature, error) {
+	if !f.Bounds.Intersects(b) {
+		return nil, nil
+	}
+
+	if f.shx == nil {
+		return f.scan(b)
+	}
+
+	var features []*vector.Feature
+
+	shx := bufio.NewReader(io.NewSectionReader(f.shx, headerSize, math.MaxInt64-headerSize))
+	entry := make([]byte, shxRecordSize)
+	prefix := make([]byte, recordHdrSize+boundsPrefixSize)
+
+	for i := 0; i < f.dbfR.numRecords; i++ {
+		if _, err := io.ReadFull(shx, entry); err != nil {
+			return nil, fmt.Errorf("%s: record %d: %w", f.shx.Name(), i+1, err)
+		}
+
+		offset := int64(binary.BigEndian.Uint32(entry[0:4])) * bytesPerWord
+		length := int(binary.BigEndian.Uint32(entry[4:8])) * bytesPerWord
+
+		// Read just enough to get the bounds, which is all of a Point
+		n := recordHdrSize + length
+		if n > len(prefix) {
+			n = len(prefix)
+		}
+
+		if _, err := f.shp.ReadAt(prefix[:n], offset); err != nil {
+			return nil, fmt.Errorf("record %d: %w", i+1, err)
+		}
+
+		rb, ok := recordBounds(prefix[recordHdrSize:n])
+		if !ok || !rb.Intersects(b) {
+			continue
+		}
+
+		content := make([]byte, length)
+		if _, err := f.shp.ReadAt(content, offset+recordHdrSize); err != nil {
+			return nil, fmt.Errorf("record %d: %w", i+1, err)
+		}
+
+		feat, err := f.feature(i, content)
+		if err != nil {
+			return nil, err
+		}
+
+		if feat != nil {
+			features = append(features, feat)
+		}
+	}
+
+	return features, nil
+}
+
+// Search without an index, by reading every record
+func (f *File) scan(b vector.Bounds) ([]*vector.Feature, error) {
+	var features []*vector.Feature
+
+	r := bufio.NewReader(io.NewSectionReader(f.shp, headerSize, math.MaxInt64-headerSize))
+	for i := 0; i < f.dbfR.numRecords; i++ {
+		content, err := readRecord(r)
+		if err != nil {
+			return nil, fmt.Errorf("record %d: %w", i+1, err)
+		}
+
+		rb, ok := recordBounds(content)
+		if !ok || !rb.Intersects(b) {
+			continue
+		}
+
+		feat, err := f.feature(i, content)
+		if err != nil {
+			return nil, err
+		}
+
+		if feat != nil {
+			features = append(features, feat)
+		}
+	}
+
+	return features, nil
+}
+
+// Parse a record's content. Null shapes have a nil geometry. Z and M values
+// are ignored.
+func parseShape(b []byte) (vector.Geometry, error) {
+	if len(b) < 4 {
+		return nil, fmt.Errorf("short record")
+	}
+
+	t := ShapeType(binary.LittleEndian.Uint32(b))
+	b = b[4:]
+
+	// The Z and M values come after the X and Y values, so can be ignored
+	switch t {
+	case ShapeNull:
+		return nil, nil
+	case ShapePoint, ShapePointZ, ShapePointM:
+		if len(b) < bytesPerPoint {
+			return nil, fmt.Errorf("short record")
+		}
+
+		return &vector.MultiPoint{Points: readPoints(b, 1)}, nil
+	case ShapeMultiPoint, ShapeMultiPointZ, ShapeMultiPointM:
+		// Bounds, number of points
+		if len(b) < 36 {
+			return nil, fmt.Errorf("short record")
+		}
+
+		numPoints := int(binary.LittleEndian.Uint32(b[32:36]))
+		b = b[36:]
+
+		if len(b) < numPoints*bytesPerPoint {
+			return nil, fmt.Errorf("short record: %d points", numPoints)
+		}
+
+		return &vector.MultiPoint{Points: readPoints(b, numPoints)}, nil
+	case ShapePolyLine, ShapePolyLineZ, ShapePolyLineM:
+		parts, err := parseParts(b)
+		if err != nil {
+			return nil, err
+		}
+
+		return &vector.MultiLineString{Lines: parts}, nil
+	case ShapePolygon, ShapePolygonZ, ShapePolygonM:
+		parts, err := parseParts(b)
+		if err != nil {
+			return nil, err
+		}
+
+		return &vector.Polygon{Rings: parts}, nil
+	}
+
+	return nil, fmt.Errorf("unsupported shape type %v", t)
+}
+
+func readPoints(b []byte, n int) []vector.Point {
+	points := make([]vector.Point, n)
+	for i := range points {
+		points[i] = vector.Point{
+			E: readDouble(b[i*bytesPerPoint:]),
+			N: readDouble(b[i*bytesPerPoint+bytesPerDouble:]),
+		}
+	}
+
+	return points
+}
+
+// Parse the parts of a PolyLine or Polygon
+func parseParts(b []byte) ([][]vector.Point, error) {
+	// Bounds, number of parts, number of points
+	if len(b) < 40 {
+		return nil, fmt.Errorf("short record")
+	}
+
+	numParts := int(binary.LittleEndian.Uint32(b[32:36]))
+	numPoints := int(binary.LittleEndian.Uint32(b[36:40]))
+	b = b[40:]
+
+	if len(b) < numParts*4+numPoints*bytesPerPoint {
+		return nil, fmt.Errorf("short record: %d parts, %d points", numParts, numPoints)
+	}
+
+	starts := make([]int, numParts+1)
+	for i := 0; i < numParts; i++ {
+		starts[i] = int(binary.LittleEndian.Uint32(b[i*4:]))
+	}
+	starts[numParts] = numPoints
+
+	points := readPoints(b[numParts*4:], numPoints)
+
+	parts := make([][]vector.Point, numParts)
+	for i := range parts {
+		if starts[i] > starts[i+1] || starts[i+1] > numPoints {
+			return nil, fmt.Errorf("invalid part %d", i)
+		}
+
+		parts[i] = points[starts[i]:starts[i+1]:starts[i+1]]
+	}
+
+	return parts, nil
+}
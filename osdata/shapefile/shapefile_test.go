@@ -0,0 +1,573 @@
+package shapefile
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/usedbytes/osgrid"
+	"github.com/usedbytes/osgrid/osdata/vector"
+	"github.com/usedbytes/osgrid/osdata/vector/vectortest"
+	"github.com/usedbytes/osgrid/osgridtest"
+)
+
+type testShape struct {
+	shapeType ShapeType
+	parts     [][]vector.Point
+	deleted   bool
+	attrs     []string
+}
+
+func putDouble(b *bytes.Buffer, v float64) {
+	binary.Write(b, binary.LittleEndian, math.Float64bits(v))
+}
+
+func putBounds(b *bytes.Buffer, bounds vector.Bounds) {
+	putDouble(b, bounds.Min.E)
+	putDouble(b, bounds.Min.N)
+	putDouble(b, bounds.Max.E)
+	putDouble(b, bounds.Max.N)
+}
+
+func encodeShape(s testShape) []byte {
+	b := &bytes.Buffer{}
+	binary.Write(b, binary.LittleEndian, uint32(s.shapeType))
+
+	switch s.shapeType {
+	case ShapeNull:
+		return b.Bytes()
+	case ShapePoint:
+		pt := s.parts[0][0]
+		putDouble(b, pt.E)
+		putDouble(b, pt.N)
+		return b.Bytes()
+	}
+
+	bounds := vector.EmptyBounds()
+	numPoints := 0
+	for _, p := range s.parts {
+		for _, pt := range p {
+			bounds = bounds.Extend(pt)
+		}
+		numPoints += len(p)
+	}
+
+	putBounds(b, bounds)
+	if s.shapeType != ShapeMultiPoint {
+		binary.Write(b, binary.LittleEndian, uint32(len(s.parts)))
+	}
+	binary.Write(b, binary.LittleEndian, uint32(numPoints))
+
+	if s.shapeType != ShapeMultiPoint {
+		start := 0
+		for _, p := range s.parts {
+			binary.Write(b, binary.LittleEndian, uint32(start))
+			start += len(p)
+		}
+	}
+
+	for _, p := range s.parts {
+		for _, pt := range p {
+			putDouble(b, pt.E)
+			putDouble(b, pt.N)
+		}
+	}
+
+	if s.shapeType == ShapePolygonZ {
+		// Z range and values, then M range and values
+		for i := 0; i < 2*(2+numPoints); i++ {
+			putDouble(b, 0)
+		}
+	}
+
+	return b.Bytes()
+}
+
+func encodeHeader(b *bytes.Buffer, shapeType ShapeType, bounds vector.Bounds, length int) {
+	binary.Write(b, binary.BigEndian, uint32(fileCode))
+	b.Write(make([]byte, 20))
+	binary.Write(b, binary.BigEndian, uint32(length/bytesPerWord))
+	binary.Write(b, binary.LittleEndian, uint32(fileVersion))
+	binary.Write(b, binary.LittleEndian, uint32(shapeType))
+	putBounds(b, bounds)
+	b.Write(make([]byte, 32))
+}
+
+// Returns the .shp and .shx files
+func encodeSHP(shapeType ShapeType, shapes []testShape) ([]byte, []byte) {
+	var records, index bytes.Buffer
+	bounds := vector.EmptyBounds()
+
+	for i, s := range shapes {
+		content := encodeShape(s)
+		binary.Write(&index, binary.BigEndian, uint32((headerSize+records.Len())/bytesPerWord))
+		binary.Write(&index, binary.BigEndian, uint32(len(content)/bytesPerWord))
+
+		binary.Write(&records, binary.BigEndian, uint32(i+1))
+		binary.Write(&records, binary.BigEndian, uint32(len(content)/bytesPerWord))
+		records.Write(content)
+
+		for _, p := range s.parts {
+			for _, pt := range p {
+				bounds = bounds.Extend(pt)
+			}
+		}
+	}
+
+	shp := &bytes.Buffer{}
+	encodeHeader(shp, shapeType, bounds, headerSize+records.Len())
+	shp.Write(records.Bytes())
+
+	shx := &bytes.Buffer{}
+	encodeHeader(shx, shapeType, bounds, headerSize+index.Len())
+	shx.Write(index.Bytes())
+
+	return shp.Bytes(), shx.Bytes()
+}
+
+func encodeDBF(fields []Field, shapes []testShape) []byte {
+	recordLen := 1
+	for _, f := range fields {
+		recordLen += f.Length
+	}
+	headerLen := dbfHeaderSize + len(fields)*dbfFieldSize + 1
+
+	b := &bytes.Buffer{}
+	b.Write([]byte{3, 121, 1, 1})
+	binary.Write(b, binary.LittleEndian, uint32(len(shapes)))
+	binary.Write(b, binary.LittleEndian, uint16(headerLen))
+	binary.Write(b, binary.LittleEndian, uint16(recordLen))
+	b.Write(make([]byte, 20))
+
+	for _, f := range fields {
+		desc := make([]byte, dbfFieldSize)
+		copy(desc, f.Name)
+		desc[11] = f.Type
+		desc[16] = byte(f.Length)
+		desc[17] = byte(f.Decimals)
+		b.Write(desc)
+	}
+	b.WriteByte(dbfTerminator)
+
+	for _, s := range shapes {
+		if s.deleted {
+			b.WriteByte(dbfDeleted)
+		} else {
+			b.WriteByte(' ')
+		}
+
+		for i, f := range fields {
+			v := s.attrs[i]
+			if f.Type == 'N' || f.Type == 'F' {
+				b.WriteString(fmt.Sprintf("%*s", f.Length, v))
+			} else {
+				b.WriteString(fmt.Sprintf("%-*s", f.Length, v))
+			}
+		}
+	}
+	b.WriteByte(0x1a)
+
+	return b.Bytes()
+}
+
+const bngWKT = `PROJCS["British_National_Grid",GEOGCS["GCS_OSGB_1936",DATUM["D_OSGB_1936",` +
+	`SPHEROID["Airy_1830",6377563.396,299.3249646]],PRIMEM["Greenwich",0.0],UNIT["Degree",0.0174532925199433]],` +
+	`PROJECTION["Transverse_Mercator"],PARAMETER["False_Easting",400000.0],PARAMETER["False_Northing",-100000.0],` +
+	`PARAMETER["Central_Meridian",-2.0],PARAMETER["Scale_Factor",0.9996012717],` +
+	`PARAMETER["Latitude_Of_Origin",49.0],UNIT["Meter",1.0]]`
+
+func writeTestShapefile(t *testing.T, base string, shapeType ShapeType, fields []Field, shapes []testShape) {
+	shp, shx := encodeSHP(shapeType, shapes)
+	err := ioutil.WriteFile(base+".shp", shp, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = ioutil.WriteFile(base+".shx", shx, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = ioutil.WriteFile(base+".prj", []byte(bngWKT), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = ioutil.WriteFile(base+".dbf", encodeDBF(fields, shapes), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+var testFields = []Field{
+	{Name: "NAME", Type: 'C', Length: 20},
+	{Name: "HECTARES", Type: 'N', Length: 10, Decimals: 3},
+	{Name: "NUMBER", Type: 'N', Length: 6},
+	{Name: "ACTIVE", Type: 'L', Length: 1},
+}
+
+var testShapes = []testShape{
+	{
+		shapeType: ShapePolygon,
+		parts:     [][]vector.Point{vectortest.Square(1000, 2000, 100)},
+		attrs:     []string{"First", "1.000", "1", "T"},
+	},
+	{
+		shapeType: ShapePolygon,
+		deleted:   true,
+		parts:     [][]vector.Point{vectortest.Square(5000, 5000, 100)},
+		attrs:     []string{"Deleted", "1.000", "2", "F"},
+	},
+	{
+		shapeType: ShapeNull,
+		attrs:     []string{"Null", "", "3", "?"},
+	},
+	{
+		// A square with a hole, and a separate island
+		shapeType: ShapePolygon,
+		parts: [][]vector.Point{
+			vectortest.Square(0, 0, 300),
+			vectortest.Square(100, 100, 100),
+			vectortest.Square(1000, 0, 10),
+		},
+		attrs: []string{"Caf\xe9", "8.010", "4", "F"},
+	},
+}
+
+func TestRead(t *testing.T) {
+	dir, err := ioutil.TempDir("", "shapefile")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	base := filepath.Join(dir, "test_region")
+	writeTestShapefile(t, base, ShapePolygon, testFields, testShapes)
+
+	f, err := Open(base + ".shp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	if f.ShapeType != ShapePolygon {
+		t.Errorf("expected Polygon, got %v", f.ShapeType)
+	}
+
+	expBounds := vector.Bounds{Min: vector.Point{E: 0, N: 0}, Max: vector.Point{E: 5100, N: 5100}}
+	if f.Bounds != expBounds {
+		t.Errorf("expected bounds %v, got %v", expBounds, f.Bounds)
+	}
+
+	if !reflect.DeepEqual(f.Fields, testFields) {
+		t.Errorf("expected fields %v, got %v", testFields, f.Fields)
+	}
+
+	features, err := f.ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(features) != 3 {
+		t.Fatalf("expected 3 features, got %d", len(features))
+	}
+
+	for i, exp := range []struct {
+		id    int64
+		attrs map[string]interface{}
+		rings int
+	}{
+		{1, map[string]interface{}{"NAME": "First", "HECTARES": 1.0, "NUMBER": int64(1), "ACTIVE": true}, 1},
+		{3, map[string]interface{}{"NAME": "Null", "NUMBER": int64(3)}, 0},
+		{4, map[string]interface{}{"NAME": "Café", "HECTARES": 8.01, "NUMBER": int64(4), "ACTIVE": false}, 3},
+	} {
+		feat := features[i]
+		if feat.ID != exp.id {
+			t.Errorf("feature %d: expected ID %d, got %d", i, exp.id, feat.ID)
+		}
+
+		if !reflect.DeepEqual(feat.Attributes, exp.attrs) {
+			t.Errorf("feature %d: expected attributes %v, got %v", i, exp.attrs, feat.Attributes)
+		}
+
+		if exp.rings == 0 {
+			if feat.Geometry != nil {
+				t.Errorf("feature %d: expected nil geometry, got %v", i, feat.Geometry)
+			}
+			continue
+		}
+
+		poly, ok := feat.Geometry.(*vector.Polygon)
+		if !ok || len(poly.Rings) != exp.rings {
+			t.Errorf("feature %d: expected polygon with %d rings, got %v", i, exp.rings, feat.Geometry)
+		}
+	}
+
+	poly := features[2].Geometry.(*vector.Polygon)
+	for _, tc := range []struct {
+		p      vector.Point
+		inside bool
+	}{
+		{vector.Point{E: 50, N: 50}, true},
+		{vector.Point{E: 150, N: 150}, false},
+		{vector.Point{E: 250, N: 150}, true},
+		{vector.Point{E: 1005, N: 5}, true},
+		{vector.Point{E: 500, N: 5}, false},
+	} {
+		if poly.Contains(tc.p) != tc.inside {
+			t.Errorf("Contains(%v): expected %v", tc.p, tc.inside)
+		}
+	}
+
+	if _, err := f.Next(); err != io.EOF {
+		t.Errorf("expected EOF, got %v", err)
+	}
+}
+
+func TestReadZ(t *testing.T) {
+	dir, err := ioutil.TempDir("", "shapefile")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	base := filepath.Join(dir, "z")
+	shapes := []testShape{
+		{shapeType: ShapePolygonZ, parts: [][]vector.Point{vectortest.Square(0, 0, 10)}, attrs: []string{"A"}},
+		{shapeType: ShapePolygonZ, parts: [][]vector.Point{vectortest.Square(10, 0, 10)}, attrs: []string{"B"}},
+	}
+	writeTestShapefile(t, base, ShapePolygonZ, testFields[:1], shapes)
+
+	// Without the extension
+	f, err := Open(base)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	features, err := f.ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(features) != 2 || features[1].StringAttr("NAME") != "B" {
+		t.Fatalf("unexpected features %v", features)
+	}
+
+	exp := &vector.Polygon{Rings: [][]vector.Point{vectortest.Square(10, 0, 10)}}
+	if !reflect.DeepEqual(features[1].Geometry, exp) {
+		t.Errorf("expected %v, got %v", exp, features[1].Geometry)
+	}
+}
+
+func TestOpenErrors(t *testing.T) {
+	dir, err := ioutil.TempDir("", "shapefile")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	base := filepath.Join(dir, "bad")
+
+	if _, err := Open(base); err == nil {
+		t.Errorf("expected error for missing file")
+	}
+
+	ioutil.WriteFile(base+".shp", make([]byte, headerSize), 0644)
+	if _, err := Open(base); err == nil {
+		t.Errorf("expected error for bad file code")
+	}
+
+	// Missing .dbf
+	shp, _ := encodeSHP(ShapePolygon, nil)
+	ioutil.WriteFile(base+".shp", shp, 0644)
+	if _, err := Open(base); err == nil {
+		t.Errorf("expected error for missing dbf")
+	}
+}
+
+func pts(coords ...float64) []vector.Point {
+	var points []vector.Point
+	for i := 0; i < len(coords); i += 2 {
+		points = append(points, vector.Point{E: coords[i], N: coords[i+1]})
+	}
+
+	return points
+}
+
+func TestShapeTypes(t *testing.T) {
+	dir, err := ioutil.TempDir("", "shapefile")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	for _, tc := range []struct {
+		shapeType ShapeType
+		parts     [][]vector.Point
+		exp       vector.Geometry
+	}{
+		{ShapePoint, [][]vector.Point{pts(10.5, 20.25)},
+			&vector.MultiPoint{Points: pts(10.5, 20.25)}},
+		{ShapeMultiPoint, [][]vector.Point{pts(1, 2, 3, 4, 5, 6)},
+			&vector.MultiPoint{Points: pts(1, 2, 3, 4, 5, 6)}},
+		{ShapePolyLine, [][]vector.Point{pts(0, 0, 10, 10, 20, 0), pts(100, 100, 200, 200)},
+			&vector.MultiLineString{Lines: [][]vector.Point{pts(0, 0, 10, 10, 20, 0), pts(100, 100, 200, 200)}}},
+	} {
+		base := filepath.Join(dir, tc.shapeType.String())
+		shapes := []testShape{{shapeType: tc.shapeType, parts: tc.parts, attrs: []string{"A"}}}
+		writeTestShapefile(t, base, tc.shapeType, testFields[:1], shapes)
+
+		f, err := Open(base)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		features, err := f.ReadAll()
+		f.Close()
+		if err != nil {
+			t.Fatalf("%v: %v", tc.shapeType, err)
+		}
+
+		if f.ShapeType != tc.shapeType {
+			t.Errorf("expected %v, got %v", tc.shapeType, f.ShapeType)
+		}
+
+		if len(features) != 1 || !reflect.DeepEqual(features[0].Geometry, tc.exp) {
+			t.Errorf("%v: expected %v, got %v", tc.shapeType, tc.exp, features)
+		}
+	}
+}
+
+func TestSearch(t *testing.T) {
+	dir, err := ioutil.TempDir("", "shapefile")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// A row of 1 km squares along the bottom of SV, with every third one
+	// deleted
+	var shapes []testShape
+	for i := 0; i < 30; i++ {
+		shapes = append(shapes, testShape{
+			shapeType: ShapePolygon,
+			parts:     [][]vector.Point{vectortest.Square(float64(i*1000), 0, 1000)},
+			deleted:   i%3 == 2,
+			attrs:     []string{fmt.Sprint(i)},
+		})
+	}
+
+	base := filepath.Join(dir, "squares")
+	writeTestShapefile(t, base, ShapePolygon, testFields[:1], shapes)
+
+	rect := osgrid.Rect{
+		BottomLeft: osgridtest.MustRef(t, 4500, 500),
+		Width:      4 * osgrid.Kilometre,
+		Height:     osgrid.Kilometre,
+	}
+
+	// Touches squares 4 to 8 (and 5 and 8 are deleted)
+	exp := []string{"4", "6", "7"}
+
+	for _, withIndex := range []bool{true, false} {
+		if !withIndex {
+			os.Remove(base + ".shx")
+		}
+
+		f, err := Open(base)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if f.Len() != 30 {
+			t.Errorf("expected 30 records, got %d", f.Len())
+		}
+
+		// Move Next() along, to check Search doesn't affect it
+		first, err := f.Next()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		features, err := f.Search(rect)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var names []string
+		for _, feat := range features {
+			names = append(names, feat.StringAttr("NAME"))
+		}
+
+		if !reflect.DeepEqual(names, exp) {
+			t.Errorf("index %v: expected %v, got %v", withIndex, exp, names)
+		}
+
+		if features[0].ID != 5 {
+			t.Errorf("expected ID 5, got %d", features[0].ID)
+		}
+
+		second, err := f.Next()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if first.StringAttr("NAME") != "0" || second.StringAttr("NAME") != "1" {
+			t.Errorf("Next() affected by Search()")
+		}
+
+		features, err = f.Search(osgrid.Rect{BottomLeft: osgridtest.MustRef(t, 50000, 50000), Width: 100, Height: 100})
+		if err != nil || len(features) != 0 {
+			t.Errorf("expected no features, got %v, %v", features, err)
+		}
+
+		f.Close()
+	}
+}
+
+func TestProjection(t *testing.T) {
+	for _, tc := range []struct {
+		wkt string
+		ok  bool
+	}{
+		{bngWKT, true},
+		{`PROJCS["OSGB 1936 / British National Grid",GEOGCS["OSGB 1936",DATUM["OSGB_1936"]],AUTHORITY["EPSG","27700"]]`, true},
+		{`PROJCRS["OSGB36 / British National Grid",BASEGEOGCRS["OSGB36"],ID["EPSG",27700]]`, true},
+		{`GEOGCS["GCS_WGS_1984",DATUM["D_WGS_1984",SPHEROID["WGS_1984",6378137.0,298.257223563]]]`, false},
+		{`PROJCS["WGS_1984_Web_Mercator_Auxiliary_Sphere",GEOGCS["GCS_WGS_1984"]]`, false},
+	} {
+		if isBritishNationalGrid(tc.wkt) != tc.ok {
+			t.Errorf("expected %v for %s", tc.ok, tc.wkt)
+		}
+	}
+
+	dir, err := ioutil.TempDir("", "shapefile")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	base := filepath.Join(dir, "wgs84")
+	writeTestShapefile(t, base, ShapePolygon, testFields, testShapes)
+	ioutil.WriteFile(base+".prj", []byte(`GEOGCS["GCS_WGS_1984"]`), 0644)
+
+	if _, err := Open(base); err == nil {
+		t.Errorf("expected error for WGS84 shapefile")
+	}
+
+	// No .prj is assumed to be OK
+	os.Remove(base + ".prj")
+	f, err := Open(base)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+}
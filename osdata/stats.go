@@ -0,0 +1,101 @@
+package osdata
+
+import (
+	"expvar"
+	"time"
+)
+
+// Upper bounds of the LoadLatency histogram buckets. There's an implicit
+// final bucket for anything slower.
+var LatencyBuckets = []time.Duration{
+	1 * time.Millisecond,
+	2 * time.Millisecond,
+	5 * time.Millisecond,
+	10 * time.Millisecond,
+	20 * time.Millisecond,
+	50 * time.Millisecond,
+	100 * time.Millisecond,
+	200 * time.Millisecond,
+	500 * time.Millisecond,
+	1 * time.Second,
+	2 * time.Second,
+	5 * time.Second,
+}
+
+type Histogram struct {
+	// Upper bound of each bucket, the same as LatencyBuckets
+	Bounds []time.Duration `json:"bounds"`
+	// Non-cumulative count of observations in each bucket.
+	// len(Counts) == len(Bounds) + 1, the last entry counts observations
+	// larger than all of the bounds.
+	Counts []uint64      `json:"counts"`
+	Count  uint64        `json:"count"`
+	Sum    time.Duration `json:"sum"`
+}
+
+func newHistogram() Histogram {
+	return Histogram{
+		Bounds: LatencyBuckets,
+		Counts: make([]uint64, len(LatencyBuckets)+1),
+	}
+}
+
+func (h *Histogram) observe(d time.Duration) {
+	i := 0
+	for ; i < len(h.Bounds); i++ {
+		if d <= h.Bounds[i] {
+			break
+		}
+	}
+
+	h.Counts[i]++
+	h.Count++
+	h.Sum += d
+}
+
+func (h *Histogram) add(o Histogram) {
+	for i, v := range o.Counts {
+		h.Counts[i] += v
+	}
+	h.Count += o.Count
+	h.Sum += o.Sum
+}
+
+// Stats describes the behaviour of a database's tile cache
+type Stats struct {
+	Hits        uint64 `json:"hits"`
+	Misses      uint64 `json:"misses"`
+	Evictions   uint64 `json:"evictions"`
+	Allocations uint64 `json:"allocations"`
+	// Bytes currently held in the cache
+	BytesResident int64 `json:"bytes_resident"`
+	// Time taken to load tiles on cache misses
+	LoadLatency Histogram `json:"load_latency"`
+}
+
+func newStats() Stats {
+	return Stats{
+		LoadLatency: newHistogram(),
+	}
+}
+
+func (s *Stats) add(o Stats) {
+	s.Hits += o.Hits
+	s.Misses += o.Misses
+	s.Evictions += o.Evictions
+	s.Allocations += o.Allocations
+	s.BytesResident += o.BytesResident
+	s.LoadLatency.add(o.LoadLatency)
+}
+
+type StatsProvider interface {
+	Stats() Stats
+}
+
+// PublishExpvar exports p's Stats as the expvar variable 'name'.
+// Like expvar.Publish, it panics if name is already registered.
+func PublishExpvar(name string, p StatsProvider) {
+	expvar.Publish(name, expvar.Func(func() interface{} {
+		return p.Stats()
+	}))
+}
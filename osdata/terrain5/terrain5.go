@@ -0,0 +1,218 @@
+package terrain5
+
+import (
+	"archive/zip"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/usedbytes/osgrid"
+	"github.com/usedbytes/osgrid/osdata"
+	"github.com/usedbytes/osgrid/osdata/ascgrid"
+)
+
+var mustBeFloat64Database osdata.Float64Database = &Database{}
+
+const (
+	TileSize  osgrid.Distance = 5 * osgrid.Kilometre
+	precision osgrid.Distance = 5 * osgrid.Metre
+)
+
+// Matches Terrain 5 tile names, e.g. "SH65NE"
+var tileNameRe = regexp.MustCompile(`(?i)^[a-z]{2}[0-9]{2}(ne|nw|se|sw)`)
+
+// TileName returns the name of the Terrain 5 tile containing ref, e.g.
+// "SH65SW" for SH 60986 54375
+func TileName(ref osgrid.GridRef) string {
+	ref = ref.Align(TileSize)
+
+	quadrant := "S"
+	if ref.TileNorthing()%(10*osgrid.Kilometre) != 0 {
+		quadrant = "N"
+	}
+
+	if ref.TileEasting()%(10*osgrid.Kilometre) != 0 {
+		quadrant += "E"
+	} else {
+		quadrant += "W"
+	}
+
+	return fmt.Sprintf("%s%d%d%s", ref.Tile(), ref.TileEasting()/(10*osgrid.Kilometre),
+		ref.TileNorthing()/(10*osgrid.Kilometre), quadrant)
+}
+
+// IsTileName returns true if name (a file or directory name) looks like it
+// belongs to a Terrain 5 tile
+func IsTileName(name string) bool {
+	return tileNameRe.MatchString(name)
+}
+
+// OpenTile opens a Terrain 5 tile, either as a '.asc' file, or a zip
+// containing one.
+func OpenTile(path string) (*ascgrid.Tile, error) {
+	if strings.ToLower(filepath.Ext(path)) == ".asc" {
+		return ascgrid.OpenTile(path)
+	}
+
+	zipFile, err := zip.OpenReader(path)
+	if err != nil {
+		return nil, err
+	}
+	defer zipFile.Close()
+
+	for _, f := range zipFile.File {
+		if strings.ToLower(filepath.Ext(f.Name)) == ".asc" {
+			r, err := f.Open()
+			if err != nil {
+				return nil, err
+			}
+			defer r.Close()
+
+			return ascgrid.ReadTile(r)
+		}
+	}
+
+	return nil, fmt.Errorf("%s doesn't contain a .asc file", path)
+}
+
+type Database struct {
+	cache *osdata.Cache
+
+	// Keyed by lower-case tile name, e.g. "sh65ne"
+	tiles map[string]string
+}
+
+// Find all of the tiles in the 'data' directory at path, which are in a
+// directory for each 100 km square, e.g. "sh/sh65ne...". Where there's more
+// than one file for a tile, the first one (by name) is used.
+func indexTiles(path string) (map[string]string, error) {
+	dir, err := ioutil.ReadDir(path)
+	if err != nil {
+		return nil, err
+	}
+
+	tiles := make(map[string]string)
+	for _, dirEntry := range dir {
+		if !dirEntry.IsDir() {
+			continue
+		}
+
+		tileDir, err := ioutil.ReadDir(filepath.Join(path, dirEntry.Name()))
+		if err != nil {
+			return nil, err
+		}
+
+		for _, tileEntry := range tileDir {
+			ext := strings.ToLower(filepath.Ext(tileEntry.Name()))
+			if tileEntry.IsDir() || (ext != ".zip" && ext != ".asc") {
+				continue
+			}
+
+			name := strings.ToLower(tileNameRe.FindString(tileEntry.Name()))
+			if name == "" {
+				continue
+			}
+
+			if _, ok := tiles[name]; !ok {
+				tiles[name] = filepath.Join(path, dirEntry.Name(), tileEntry.Name())
+			}
+		}
+	}
+
+	return tiles, nil
+}
+
+func (d *Database) findTile(ref osgrid.GridRef) (string, error) {
+	path, ok := d.tiles[strings.ToLower(TileName(ref))]
+	if !ok {
+		return "", fmt.Errorf("Tile %s %w", TileName(ref), osdata.ErrTileNotFound)
+	}
+
+	return path, nil
+}
+
+func (d *Database) getTile(ref osgrid.GridRef) (*ascgrid.Tile, error) {
+	ref = ref.Align(TileSize)
+
+	tile, err := d.cache.Load(ref, func() (osdata.Tile, error) {
+		path, err := d.findTile(ref)
+		if err != nil {
+			return nil, err
+		}
+
+		tile, err := OpenTile(path)
+		if err != nil {
+			return nil, err
+		}
+
+		if tile.BottomLeft() != ref || tile.Width() != TileSize ||
+			tile.Height() != TileSize || tile.Precision() != precision {
+			return nil, fmt.Errorf("%s isn't a valid Terrain 5 tile for %s", path, TileName(ref))
+		}
+
+		return tile, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return tile.(*ascgrid.Tile), nil
+}
+
+func (d *Database) GetTile(ref osgrid.GridRef) (osdata.Tile, error) {
+	return d.getTile(ref)
+}
+
+func (d *Database) GetFloat64Tile(ref osgrid.GridRef) (osdata.Float64Tile, error) {
+	return d.getTile(ref)
+}
+
+func (d *Database) GetFloat64(ref osgrid.GridRef) (float64, error) {
+	tile, err := d.getTile(ref)
+	if err != nil {
+		return osdata.NoData, err
+	}
+
+	return tile.GetFloat64(ref)
+}
+
+func (d *Database) Precision() osgrid.Distance {
+	return precision
+}
+
+func (d *Database) Stats() osdata.Stats {
+	return d.cache.Stats()
+}
+
+func (d *Database) DumpStats() string {
+	return "Cache stats: " + d.cache.DumpStats()
+}
+
+// OpenDatabase opens an OS Terrain 5 "ASCII Grid" dataset. path should be the
+// directory which contains the 'data' directory.
+func OpenDatabase(path string, opts ...osdata.DatabaseOpt) (osdata.Float64Database, error) {
+	datapath := filepath.Join(path, "data")
+
+	fi, err := os.Stat(datapath)
+	if err != nil {
+		return nil, err
+	}
+	if !fi.IsDir() {
+		return nil, fmt.Errorf("%s should be a directory", datapath)
+	}
+
+	tiles, err := indexTiles(datapath)
+	if err != nil {
+		return nil, err
+	}
+
+	cfg := osdata.NewDatabaseConfig(opts...)
+
+	return &Database{
+		cache: cfg.Cache,
+		tiles: tiles,
+	}, nil
+}
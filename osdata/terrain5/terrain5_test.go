@@ -0,0 +1,141 @@
+package terrain5
+
+import (
+	"archive/zip"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/usedbytes/osgrid"
+	"github.com/usedbytes/osgrid/osdata"
+)
+
+func TestTileName(t *testing.T) {
+	tests := map[string]string{
+		"SH 60986 54375": "SH65SW",
+		"SH 65986 55375": "SH65NE",
+		"SH 60000 50000": "SH65SW",
+		"SH 64999 54999": "SH65SW",
+		"SH 65000 50000": "SH65SE",
+		"SH 60000 55000": "SH65NW",
+		"TQ 2 8":         "TQ28SW",
+	}
+
+	for in, exp := range tests {
+		ref, err := osgrid.ParseGridRef(in)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if got := TileName(ref); got != exp {
+			t.Errorf("%s: expected %s, got %s", in, exp, got)
+		}
+
+		if !IsTileName(exp + ".zip") {
+			t.Errorf("%s should be a tile name", exp)
+		}
+	}
+
+	if IsTileName("SH65.zip") {
+		t.Errorf("SH65 is a Terrain 50 tile name")
+	}
+}
+
+// Make a tile where each post has the value of its column
+func makeTileData(ref osgrid.GridRef) string {
+	bld := &strings.Builder{}
+	fmt.Fprintf(bld, "ncols 1000\nnrows 1000\nxllcorner %d\nyllcorner %d\ncellsize 5\n",
+		ref.AbsEasting(), ref.AbsNorthing())
+
+	for y := 0; y < 1000; y++ {
+		for x := 0; x < 1000; x++ {
+			fmt.Fprintf(bld, "%d ", x)
+		}
+		bld.WriteString("\n")
+	}
+
+	return bld.String()
+}
+
+func writeZipTile(t *testing.T, path, name, data string) {
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	zw := zip.NewWriter(f)
+	w, err := zw.Create(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = w.Write([]byte(data)); err != nil {
+		t.Fatal(err)
+	}
+
+	if err = zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestDatabase(t *testing.T) {
+	dir, err := ioutil.TempDir("", "terrain5")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	sqdir := filepath.Join(dir, "data", "sh")
+	if err := os.MkdirAll(sqdir, 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	ne, _ := osgrid.ParseGridRef("SH 65000 55000")
+	writeZipTile(t, filepath.Join(sqdir, "sh65ne_OST5DTM.zip"), "SH65NE.asc", makeTileData(ne))
+
+	sw, _ := osgrid.ParseGridRef("SH 60000 50000")
+	err = ioutil.WriteFile(filepath.Join(sqdir, "SH65SW.asc"), []byte(makeTileData(sw)), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	db, err := OpenDatabase(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if db.Precision() != 5*osgrid.Metre {
+		t.Errorf("expected precision 5, got %v", db.Precision())
+	}
+
+	summit, _ := osgrid.ParseGridRef("SH 60986 54375")
+	v, err := db.GetFloat64(summit)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if v != 197 {
+		t.Errorf("expected 197, got %v", v)
+	}
+
+	ref, _ := osgrid.ParseGridRef("SH 66003 56000")
+	v, err = db.GetFloat64(ref)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if v != 200 {
+		t.Errorf("expected 200, got %v", v)
+	}
+
+	ref, _ = osgrid.ParseGridRef("SH 61000 56000")
+	_, err = db.GetFloat64(ref)
+	if !errors.Is(err, osdata.ErrTileNotFound) {
+		t.Errorf("expected ErrTileNotFound, got %v", err)
+	}
+}
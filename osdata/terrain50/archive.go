@@ -0,0 +1,134 @@
+package terrain50
+
+import (
+	"archive/zip"
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"regexp"
+	"strings"
+
+	"github.com/usedbytes/osgrid"
+	"github.com/usedbytes/osgrid/osdata"
+)
+
+// Matches the start of tile names, e.g. "sh65" in "sh65_OST50GRID_20210512.zip"
+var tileNameRe = regexp.MustCompile(`(?i)^[a-z]{2}[0-9]{2}`)
+
+// archive reads tiles directly from the distributed Terrain 50 zip, which
+// contains a zip file for each tile
+type archive struct {
+	file *os.File
+	zr   *zip.Reader
+
+	// Keyed by lower-case tile name, e.g. "sh65"
+	tiles map[string]*zip.File
+}
+
+func openArchive(filename string) (*archive, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	fi, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	zr, err := zip.NewReader(file, fi.Size())
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	a := &archive{
+		file:  file,
+		zr:    zr,
+		tiles: make(map[string]*zip.File),
+	}
+
+	for _, f := range zr.File {
+		base := path.Base(f.Name)
+		if strings.ToLower(path.Ext(base)) != ".zip" {
+			continue
+		}
+
+		name := tileNameRe.FindString(base)
+		if name == "" {
+			continue
+		}
+
+		a.tiles[strings.ToLower(name)] = f
+	}
+
+	if len(a.tiles) == 0 {
+		file.Close()
+		return nil, fmt.Errorf("%s doesn't contain any tiles", filename)
+	}
+
+	return a, nil
+}
+
+func (a *archive) Close() error {
+	return a.file.Close()
+}
+
+// Get random access to a zip file stored inside the archive
+func (a *archive) openNested(f *zip.File) (*zip.Reader, error) {
+	size := int64(f.UncompressedSize64)
+
+	if f.Method == zip.Store {
+		// Stored uncompressed, so it can be read in-place
+		offset, err := f.DataOffset()
+		if err != nil {
+			return nil, err
+		}
+
+		return zip.NewReader(io.NewSectionReader(a.file, offset, size), size)
+	}
+
+	// Compressed, so we need to inflate it. The tile zips are small.
+	r, err := f.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+
+	return zip.NewReader(bytes.NewReader(data), int64(len(data)))
+}
+
+// Open the zip file for the tile at ref
+func (a *archive) openTileZip(ref osgrid.GridRef) (*zip.Reader, error) {
+	name := strings.ToLower(ref.Tile() + ref.Digits())
+
+	f, ok := a.tiles[name]
+	if !ok {
+		return nil, fmt.Errorf("Tile %s %w", ref, osdata.ErrTileNotFound)
+	}
+
+	zr, err := a.openNested(f)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", f.Name, err)
+	}
+
+	return zr, nil
+}
+
+func (a *archive) openTile(ref osgrid.GridRef) (*Tile, error) {
+	zr, err := a.openTileZip(ref)
+	if err != nil {
+		return nil, err
+	}
+
+	return readZipTile(zr)
+}
@@ -0,0 +1,479 @@
+package terrain50
+
+import (
+	"archive/zip"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/usedbytes/osgrid"
+	"github.com/usedbytes/osgrid/osdata"
+	"github.com/usedbytes/osgrid/osdata/shapefile"
+	"github.com/usedbytes/osgrid/osdata/vector"
+)
+
+// The contour product is split into 10 km tiles, like the grid product
+const contourTileSize = 10 * osgrid.Kilometre
+
+// Attributes which hold the height of a contour, in the shapefile
+// ("PROP_VALUE") and GML ("propertyValue") formats
+var heightAttrs = []string{"PROP_VALUE", "propertyValue", "HEIGHT"}
+
+// Contour is a contour line, or several lines at the same height
+type Contour struct {
+	// Height in metres
+	Height   float64
+	Geometry *vector.MultiLineString
+}
+
+func (c *Contour) Bounds() vector.Bounds {
+	return c.Geometry.Bounds()
+}
+
+var mustBeContourTile osdata.Tile = &contourTile{}
+
+// contourTile holds all of the contours in one tile of the contour product
+type contourTile struct {
+	bottomLeft osgrid.GridRef
+	contours   []*Contour
+	numPoints  int
+}
+
+func (t *contourTile) String() string {
+	return t.bottomLeft.String()
+}
+
+func (t *contourTile) BottomLeft() osgrid.GridRef {
+	return t.bottomLeft
+}
+
+func (t *contourTile) Width() osgrid.Distance {
+	return contourTileSize
+}
+
+func (t *contourTile) Height() osgrid.Distance {
+	return contourTileSize
+}
+
+// Coordinates are in metres
+func (t *contourTile) Precision() osgrid.Distance {
+	return osgrid.Metre
+}
+
+func (t *contourTile) MemorySize() int {
+	return t.numPoints*16 + len(t.contours)*64
+}
+
+// Contours reads the Terrain 50 contour product, which has contour lines at
+// 10 m intervals.
+type Contours struct {
+	path string
+
+	// Set when reading directly from the distributed zip file
+	archive *archive
+
+	cache *osdata.Cache
+}
+
+// OpenContours opens a Terrain 50 contour dataset, in either the ESRI
+// Shapefile or GML format. Like OpenDatabase, path can either be the
+// directory containing the extracted 'data' directory, or the distributed
+// zip file itself.
+//
+// Within 'data', each tile can be left as the distributed zip, or extracted
+// into a directory, or the '.shp' or '.gml' files can be extracted directly
+// into the 'data' directory's subdirectories.
+func OpenContours(path string, opts ...osdata.DatabaseOpt) (*Contours, error) {
+	cfg := osdata.NewDatabaseConfig(opts...)
+
+	c := &Contours{
+		cache: cfg.Cache,
+	}
+
+	var err error
+	c.path, c.archive, err = openData(path)
+	if err != nil {
+		return nil, err
+	}
+
+	return c, nil
+}
+
+// Close releases the archive file, if the contours were opened from one
+func (c *Contours) Close() error {
+	if c.archive != nil {
+		return c.archive.Close()
+	}
+
+	return nil
+}
+
+func (c *Contours) String() string {
+	return c.path
+}
+
+func (c *Contours) Stats() osdata.Stats {
+	return c.cache.Stats()
+}
+
+// Tiles can be zipped, extracted into a directory, or just the '.shp' or
+// '.gml' files
+func isContourEntry(fi os.FileInfo) bool {
+	return fi.IsDir() || isContourFile(fi.Name()) ||
+		strings.ToLower(filepath.Ext(fi.Name())) == ".zip"
+}
+
+func (c *Contours) loadTile(ref osgrid.GridRef) (*contourTile, error) {
+	var contours []*Contour
+
+	if c.archive != nil {
+		zr, err := c.archive.openTileZip(ref)
+		if err != nil {
+			return nil, err
+		}
+
+		contours, err = readZipContours(zr)
+		if err != nil {
+			return nil, fmt.Errorf("Tile %s: %w", ref, err)
+		}
+	} else {
+		paths, err := findTileEntries(c.path, ref, isContourEntry)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, path := range contourSources(paths) {
+			found, err := OpenContourTile(path)
+			if err != nil {
+				return nil, err
+			}
+
+			contours = append(contours, found...)
+		}
+	}
+
+	t := &contourTile{
+		bottomLeft: ref,
+		contours:   contours,
+	}
+
+	for _, c := range contours {
+		for _, l := range c.Geometry.Lines {
+			t.numPoints += len(l)
+		}
+	}
+
+	return t, nil
+}
+
+func (c *Contours) getTile(ref osgrid.GridRef) (*contourTile, error) {
+	ref = ref.Align(contourTileSize)
+
+	tile, err := c.cache.Load(ref, func() (osdata.Tile, error) {
+		return c.loadTile(ref)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return tile.(*contourTile), nil
+}
+
+// Search returns the contours whose bounding boxes intersect r. Contours are
+// cut at the edges of the 10 km tiles, so a contour which crosses a tile edge
+// is returned once for each tile it's in.
+func (c *Contours) Search(r osgrid.Rect) ([]*Contour, error) {
+	return c.SearchBounds(vector.BoundsFromRect(r))
+}
+
+// SearchBounds is Search, with vector.Bounds. Tiles which are missing from
+// the data are skipped.
+func (c *Contours) SearchBounds(b vector.Bounds) ([]*Contour, error) {
+	if b.Empty() {
+		return nil, nil
+	}
+
+	size := float64(contourTileSize)
+	minE := math.Floor(b.Min.E/size) * size
+	minN := math.Floor(b.Min.N/size) * size
+
+	var found []*Contour
+	for n := minN; n <= b.Max.N; n += size {
+		for e := minE; e <= b.Max.E; e += size {
+			ref, err := osgrid.Origin().Add(osgrid.Distance(e), osgrid.Distance(n))
+			if err != nil {
+				// Off the edge of the grid
+				continue
+			}
+
+			tile, err := c.getTile(ref)
+			if errors.Is(err, osdata.ErrTileNotFound) {
+				continue
+			} else if err != nil {
+				return nil, err
+			}
+
+			for _, contour := range tile.contours {
+				if contour.Bounds().Intersects(b) {
+					found = append(found, contour)
+				}
+			}
+		}
+	}
+
+	return found, nil
+}
+
+func isContourFile(path string) bool {
+	ext := strings.ToLower(filepath.Ext(path))
+
+	return ext == ".shp" || ext == ".gml"
+}
+
+// Choose which of the files for a tile to read, so that each contour is only
+// read once. The shapefiles are used if there are any (there can be more than
+// one, e.g. "sh65_line.shp" and "sh65_point.shp"), otherwise the GML.
+func contourFiles(paths []string) []string {
+	var shp, gml []string
+	for _, path := range paths {
+		switch strings.ToLower(filepath.Ext(path)) {
+		case ".shp":
+			shp = append(shp, path)
+		case ".gml":
+			gml = append(gml, path)
+		}
+	}
+
+	if len(shp) > 0 {
+		return shp
+	}
+
+	return gml
+}
+
+// Choose which of the entries for a tile in the 'data' directory to read. A
+// tile can be there more than once, e.g. both zipped and extracted, so the
+// first zip or directory is used if there is one, otherwise the loose files.
+func contourSources(paths []string) []string {
+	for _, path := range paths {
+		if !isContourFile(path) {
+			return []string{path}
+		}
+	}
+
+	return contourFiles(paths)
+}
+
+// OpenContourTile reads the contours from a tile of the contour product,
+// which can be a zip file, a directory, or a '.shp' or '.gml' file.
+// Shapefiles of anything other than lines (e.g. the spot heights) are
+// ignored.
+func OpenContourTile(path string) ([]*Contour, error) {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+
+	if fi.IsDir() {
+		return readDirContours(path)
+	}
+
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".shp":
+		return readShapefileContours(path)
+	case ".gml":
+		f, err := os.Open(path)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+
+		contours, err := ParseContourGML(f)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+
+		return contours, nil
+	}
+
+	zipFile, err := zip.OpenReader(path)
+	if err != nil {
+		return nil, err
+	}
+	defer zipFile.Close()
+
+	contours, err := readZipContours(&zipFile.Reader)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+
+	return contours, nil
+}
+
+// Read the '.shp' files in dir, or the '.gml' files if there aren't any
+func readDirContours(dir string) ([]*Contour, error) {
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var paths []string
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			paths = append(paths, filepath.Join(dir, entry.Name()))
+		}
+	}
+
+	var contours []*Contour
+	for _, path := range contourFiles(paths) {
+		found, err := OpenContourTile(path)
+		if err != nil {
+			return nil, err
+		}
+
+		contours = append(contours, found...)
+	}
+
+	return contours, nil
+}
+
+// Read the shapefiles in a zip, or the '.gml' files if there aren't any.
+//
+// Shapefiles are made of several files, which the shapefile package opens
+// by name, so they're extracted from the zip into a temporary directory
+func readZipContours(zr *zip.Reader) ([]*Contour, error) {
+	var shapefiles, gml []*zip.File
+	for _, f := range zr.File {
+		switch strings.ToLower(filepath.Ext(f.Name)) {
+		case ".gml":
+			gml = append(gml, f)
+		case ".shp", ".shx", ".dbf", ".prj":
+			shapefiles = append(shapefiles, f)
+		}
+	}
+
+	if len(shapefiles) == 0 {
+		var contours []*Contour
+		for _, f := range gml {
+			found, err := readZipGML(f)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", f.Name, err)
+			}
+
+			contours = append(contours, found...)
+		}
+
+		return contours, nil
+	}
+
+	dir, err := ioutil.TempDir("", "terrain50")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+
+	for _, f := range shapefiles {
+		if err := extractFile(f, filepath.Join(dir, filepath.Base(f.Name))); err != nil {
+			return nil, err
+		}
+	}
+
+	return readDirContours(dir)
+}
+
+func readZipGML(f *zip.File) ([]*Contour, error) {
+	r, err := f.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+
+	return ParseContourGML(r)
+}
+
+func extractFile(f *zip.File, path string) error {
+	r, err := f.Open()
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	w, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+
+	if _, err := io.Copy(w, r); err != nil {
+		w.Close()
+		return err
+	}
+
+	return w.Close()
+}
+
+func readShapefileContours(path string) ([]*Contour, error) {
+	f, err := shapefile.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	switch f.ShapeType {
+	case shapefile.ShapePolyLine, shapefile.ShapePolyLineZ, shapefile.ShapePolyLineM:
+	default:
+		return nil, nil
+	}
+
+	features, err := f.ReadAll()
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+
+	contours := make([]*Contour, 0, len(features))
+	for _, feat := range features {
+		lines, ok := feat.Geometry.(*vector.MultiLineString)
+		if !ok {
+			continue
+		}
+
+		height, err := featureHeight(feat.Attributes)
+		if err != nil {
+			return nil, fmt.Errorf("%s: record %d: %w", path, feat.ID, err)
+		}
+
+		contours = append(contours, &Contour{
+			Height:   height,
+			Geometry: lines,
+		})
+	}
+
+	return contours, nil
+}
+
+// Find the height attribute, ignoring the case of its name
+func featureHeight(attrs map[string]interface{}) (float64, error) {
+	for _, name := range heightAttrs {
+		for k, v := range attrs {
+			if !strings.EqualFold(k, name) {
+				continue
+			}
+
+			switch v := v.(type) {
+			case float64:
+				return v, nil
+			case int64:
+				return float64(v), nil
+			case string:
+				return strconv.ParseFloat(v, 64)
+			}
+		}
+	}
+
+	return 0, fmt.Errorf("no height attribute")
+}
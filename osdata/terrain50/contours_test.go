@@ -0,0 +1,317 @@
+package terrain50
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/usedbytes/osgrid"
+	"github.com/usedbytes/osgrid/osdata"
+	"github.com/usedbytes/osgrid/osdata/vector"
+)
+
+// Contours for a slope rising 1 m every 100 m to the East across SH 65:
+// North-South lines every 1 km, from 0 m at the West edge to 100 m at the
+// East edge. They extend 1 km beyond the tile, like the neighbouring tiles
+// would.
+func testSlope() ([]float64, [][]vector.Point) {
+	var heights []float64
+	var lines [][]vector.Point
+	for k := 0; k <= 10; k++ {
+		e := 260000 + float64(k)*1000
+		heights = append(heights, float64(k)*10)
+		lines = append(lines, []vector.Point{
+			{E: e, N: 349000},
+			{E: e, N: 355000},
+			{E: e, N: 361000},
+		})
+	}
+
+	return heights, lines
+}
+
+func putDoubles(b *bytes.Buffer, vs ...float64) {
+	for _, v := range vs {
+		binary.Write(b, binary.LittleEndian, math.Float64bits(v))
+	}
+}
+
+// Encode a shapefile of lines, or an empty one of another type, with a
+// PROP_VALUE attribute. Returns the .shp and .dbf files.
+func encodeShapefile(shapeType uint32, heights []float64, lines [][]vector.Point) ([]byte, []byte) {
+	bounds := vector.EmptyBounds()
+	for _, l := range lines {
+		for _, p := range l {
+			bounds = bounds.Extend(p)
+		}
+	}
+
+	var records bytes.Buffer
+	for i, l := range lines {
+		content := &bytes.Buffer{}
+		binary.Write(content, binary.LittleEndian, shapeType)
+		lb := vector.EmptyBounds()
+		for _, p := range l {
+			lb = lb.Extend(p)
+		}
+		putDoubles(content, lb.Min.E, lb.Min.N, lb.Max.E, lb.Max.N)
+		binary.Write(content, binary.LittleEndian, []uint32{1, uint32(len(l)), 0})
+		for _, p := range l {
+			putDoubles(content, p.E, p.N)
+		}
+
+		binary.Write(&records, binary.BigEndian, []uint32{uint32(i + 1), uint32(content.Len() / 2)})
+		records.Write(content.Bytes())
+	}
+
+	shp := &bytes.Buffer{}
+	binary.Write(shp, binary.BigEndian, uint32(9994))
+	shp.Write(make([]byte, 20))
+	binary.Write(shp, binary.BigEndian, uint32((100+records.Len())/2))
+	binary.Write(shp, binary.LittleEndian, []uint32{1000, shapeType})
+	if bounds.Empty() {
+		bounds = vector.Bounds{}
+	}
+	putDoubles(shp, bounds.Min.E, bounds.Min.N, bounds.Max.E, bounds.Max.N, 0, 0, 0, 0)
+	shp.Write(records.Bytes())
+
+	dbf := &bytes.Buffer{}
+	dbf.Write([]byte{3, 121, 1, 1})
+	binary.Write(dbf, binary.LittleEndian, uint32(len(lines)))
+	binary.Write(dbf, binary.LittleEndian, []uint16{32 + 32 + 1, 1 + 10})
+	dbf.Write(make([]byte, 20))
+	field := make([]byte, 32)
+	copy(field, "PROP_VALUE")
+	field[11] = 'N'
+	field[16] = 10
+	dbf.Write(field)
+	dbf.WriteByte(0x0d)
+	for _, h := range heights {
+		dbf.WriteString(fmt.Sprintf(" %10.0f", h))
+	}
+	dbf.WriteByte(0x1a)
+
+	return shp.Bytes(), dbf.Bytes()
+}
+
+func encodeGML(heights []float64, lines [][]vector.Point) []byte {
+	b := &bytes.Buffer{}
+	b.WriteString(`<?xml version="1.0" encoding="UTF-8"?>
+<gml:FeatureCollection xmlns:gml="http://www.opengis.net/gml/3.2" xmlns:terrain-50="http://namespaces.os.uk/Open/Terrain50/1.0">
+`)
+	for i, l := range lines {
+		var coords []string
+		for _, p := range l {
+			coords = append(coords, fmt.Sprintf("%.2f %.2f", p.E, p.N))
+		}
+
+		fmt.Fprintf(b, `<gml:featureMember><terrain-50:ContourLine gml:id="c%d">
+<terrain-50:geometry><gml:LineString srsName="urn:ogc:def:crs:EPSG::27700">
+<gml:posList srsDimension="2" count="%d">%s</gml:posList>
+</gml:LineString></terrain-50:geometry>
+<terrain-50:propertyValue uom="m">%.0f</terrain-50:propertyValue>
+<terrain-50:contourLineType>ordinary</terrain-50:contourLineType>
+</terrain-50:ContourLine></gml:featureMember>
+`, i, len(l), strings.Join(coords, " "), heights[i])
+	}
+
+	// Spot heights are ignored
+	b.WriteString(`<gml:featureMember><terrain-50:SpotHeight gml:id="s1">
+<terrain-50:geometry><gml:Point><gml:pos>265000 355000</gml:pos></gml:Point></terrain-50:geometry>
+<terrain-50:propertyValue uom="m">51</terrain-50:propertyValue>
+</terrain-50:SpotHeight></gml:featureMember>
+</gml:FeatureCollection>`)
+
+	return b.Bytes()
+}
+
+func makeMultiZip(t *testing.T, files map[string][]byte) []byte {
+	buf := &bytes.Buffer{}
+	zw := zip.NewWriter(buf)
+
+	for name, data := range files {
+		w, err := zw.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err = w.Write(data); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	return buf.Bytes()
+}
+
+func checkSlope(t *testing.T, contours []*Contour) {
+	if len(contours) != 11 {
+		t.Fatalf("expected 11 contours, got %d", len(contours))
+	}
+
+	for _, c := range contours {
+		line := c.Geometry.Lines[0]
+		if len(c.Geometry.Lines) != 1 || len(line) != 3 {
+			t.Fatalf("unexpected geometry %v", c.Geometry.Lines)
+		}
+
+		if exp := (line[0].E - 260000) / 100; c.Height != exp {
+			t.Errorf("expected height %f, got %f", exp, c.Height)
+		}
+	}
+}
+
+func TestContourArchive(t *testing.T) {
+	heights, lines := testSlope()
+	shp, dbf := encodeShapefile(3, heights, lines)
+	pointSHP, pointDBF := encodeShapefile(1, nil, nil)
+
+	tile := makeMultiZip(t, map[string][]byte{
+		"SH65_line.shp":  shp,
+		"SH65_line.dbf":  dbf,
+		"SH65_point.shp": pointSHP,
+		"SH65_point.dbf": pointDBF,
+	})
+
+	archivePath := filepath.Join(t.TempDir(), "terr50_cesh_gb.zip")
+	err := ioutil.WriteFile(archivePath, makeMultiZip(t, map[string][]byte{
+		"data/sh/sh65_OST50CONT_20190530.zip": tile,
+	}), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c, err := OpenContours(archivePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	sh65, _ := osgrid.ParseGridRef("SH 65")
+	contours, err := c.Search(osgrid.Rect{BottomLeft: sh65, Width: 10 * osgrid.Kilometre, Height: 10 * osgrid.Kilometre})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	checkSlope(t, contours)
+
+	// Just the 20 m and 30 m contours
+	sh6254, _ := osgrid.ParseGridRef("SH 620 540")
+	contours, err = c.Search(osgrid.Rect{BottomLeft: sh6254, Width: osgrid.Kilometre, Height: osgrid.Kilometre})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(contours) != 2 || contours[0].Height != 20 || contours[1].Height != 30 {
+		t.Errorf("unexpected contours %v", contours)
+	}
+
+	// Neighbouring tiles are missing, which is fine for a search
+	sj00, _ := osgrid.ParseGridRef("SJ 00")
+	if contours, err := c.Search(osgrid.Rect{BottomLeft: sj00, Width: 1, Height: 1}); err != nil || len(contours) != 0 {
+		t.Errorf("expected nothing, got %v, %v", contours, err)
+	}
+}
+
+func TestContourGML(t *testing.T) {
+	heights, lines := testSlope()
+
+	// The same tile extracted and zipped, which should only be read once
+	dir := t.TempDir()
+	gml := encodeGML(heights, lines)
+	writeDataFile(t, dir, "sh/sh65_OST50CONT_20190530/SH65.gml", gml)
+	writeDataFile(t, dir, "sh/sh65_OST50CONT_20190530.zip", makeZip(t, "SH65.gml", gml))
+
+	c, err := OpenContours(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sh65, _ := osgrid.ParseGridRef("SH 65")
+	contours, err := c.Search(osgrid.Rect{BottomLeft: sh65, Width: 10 * osgrid.Kilometre, Height: 10 * osgrid.Kilometre})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	checkSlope(t, contours)
+
+	for name, gml := range map[string]string{
+		"projection": `<ContourLine><LineString srsName="EPSG:4326"><posList>1 2 3 4</posList></LineString>` +
+			`<propertyValue>10</propertyValue></ContourLine>`,
+		"no height":   `<ContourLine><LineString><posList>1 2 3 4</posList></LineString></ContourLine>`,
+		"coordinates": `<ContourLine><LineString><posList>1 2 3</posList></LineString><propertyValue>10</propertyValue></ContourLine>`,
+	} {
+		if _, err := ParseContourGML(strings.NewReader(gml)); err == nil {
+			t.Errorf("%s: expected error", name)
+		}
+	}
+}
+
+func TestContourDatabase(t *testing.T) {
+	heights, lines := testSlope()
+	shp, dbf := encodeShapefile(3, heights, lines)
+
+	dir := t.TempDir()
+	writeDataFile(t, dir, "sh/sh65_line.shp", shp)
+	writeDataFile(t, dir, "sh/sh65_line.dbf", dbf)
+	// The same contours in the other format are ignored
+	writeDataFile(t, dir, "sh/sh65.gml", encodeGML(heights, lines))
+
+	c, err := OpenContours(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sh65, _ := osgrid.ParseGridRef("SH 65")
+	contours, err := c.Search(osgrid.Rect{BottomLeft: sh65, Width: 10 * osgrid.Kilometre, Height: 10 * osgrid.Kilometre})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	checkSlope(t, contours)
+
+	if _, err := NewContourDatabase(c, 300); err == nil {
+		t.Errorf("expected error for precision which doesn't divide the tile")
+	}
+
+	db, err := NewContourDatabase(c, 50)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		ref    string
+		height float64
+	}{
+		{"SH 6000 5000", 0},
+		{"SH 6150 5500", 15},
+		{"SH 6300 5230", 30},
+		{"SH 6455 5995", 45.5},
+		{"SH 6995 5000", 99.5},
+	} {
+		ref, _ := osgrid.ParseGridRef(tc.ref)
+		v, err := db.GetFloat64(ref)
+		if err != nil {
+			t.Fatalf("%s: %v", tc.ref, err)
+		}
+
+		if math.Abs(v-tc.height) > 1e-3 {
+			t.Errorf("%s: expected %f, got %f", tc.ref, tc.height, v)
+		}
+	}
+
+	tile, err := db.GetFloat64Tile(osgrid.Origin())
+	if !errors.Is(err, osdata.ErrTileNotFound) {
+		t.Errorf("expected ErrTileNotFound, got %v, %v", tile, err)
+	}
+}
@@ -0,0 +1,111 @@
+package terrain50
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/usedbytes/osgrid"
+	"github.com/usedbytes/osgrid/osdata/bintile"
+)
+
+// List the lower-case names of all of the tiles in the original data, e.g.
+// "sh65"
+func (d *Database) tileNames() ([]string, error) {
+	names := make(map[string]bool)
+
+	if d.archive != nil {
+		for name := range d.archive.tiles {
+			names[name] = true
+		}
+	} else {
+		squares, err := ioutil.ReadDir(d.path)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, square := range squares {
+			if !square.IsDir() {
+				continue
+			}
+
+			entries, err := ioutil.ReadDir(filepath.Join(d.path, square.Name()))
+			if err != nil {
+				return nil, err
+			}
+
+			for _, entry := range entries {
+				if !isTileEntry(entry) {
+					continue
+				}
+
+				name := tileNameRe.FindString(entry.Name())
+				if name != "" {
+					names[strings.ToLower(name)] = true
+				}
+			}
+		}
+	}
+
+	list := make([]string, 0, len(names))
+	for name := range names {
+		list = append(list, name)
+	}
+	sort.Strings(list)
+
+	return list, nil
+}
+
+// Convert writes every tile in the Terrain 50 dataset at src into the binary
+// tile format, using the given encoding. src can be anything accepted by
+// OpenDatabase.
+//
+// The tiles are written to dst/data/<square>/<tile>.osbt, so dst can then be
+// opened with OpenDatabase. dst can be the same directory as src, in which
+// case the binary tiles will be used in preference to the originals.
+func Convert(src, dst string, tileSize osgrid.Distance, enc bintile.Encoding) error {
+	db, err := OpenDatabase(src, tileSize)
+	if err != nil {
+		return err
+	}
+	d := db.(*Database)
+	defer d.Close()
+
+	names, err := d.tileNames()
+	if err != nil {
+		return err
+	}
+
+	out := &Database{
+		path: filepath.Join(dst, "data"),
+	}
+
+	for _, name := range names {
+		ref, err := osgrid.ParseGridRef(name[:2] + " " + name[2:])
+		if err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+
+		tile, err := d.loadSourceTile(ref)
+		if err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+
+		path := out.binTilePath(ref)
+
+		err = os.MkdirAll(filepath.Dir(path), 0755)
+		if err != nil {
+			return err
+		}
+
+		err = bintile.WriteFile(path, tile, enc)
+		if err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+	}
+
+	return nil
+}
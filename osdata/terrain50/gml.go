@@ -0,0 +1,142 @@
+package terrain50
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/usedbytes/osgrid/osdata/vector"
+)
+
+// Parse the text of a gml:posList, gml:pos or gml:coordinates element
+func parseGMLCoords(text string, dim int, coordinates bool) ([]vector.Point, error) {
+	var values []string
+	if coordinates {
+		// "e,n e,n ..."
+		for _, tuple := range strings.Fields(text) {
+			values = append(values, strings.Split(tuple, ",")...)
+		}
+	} else {
+		values = strings.Fields(text)
+	}
+
+	if dim < 2 || len(values)%dim != 0 {
+		return nil, fmt.Errorf("invalid coordinates %q", text)
+	}
+
+	points := make([]vector.Point, 0, len(values)/dim)
+	for i := 0; i < len(values); i += dim {
+		e, err := strconv.ParseFloat(values[i], 64)
+		if err != nil {
+			return nil, err
+		}
+
+		n, err := strconv.ParseFloat(values[i+1], 64)
+		if err != nil {
+			return nil, err
+		}
+
+		points = append(points, vector.Point{E: e, N: n})
+	}
+
+	return points, nil
+}
+
+func xmlAttr(se xml.StartElement, name string) string {
+	for _, a := range se.Attr {
+		if a.Name.Local == name {
+			return a.Value
+		}
+	}
+
+	return ""
+}
+
+// ParseContourGML reads the contour lines from a tile of the Terrain 50
+// contour product in GML format. Other features, such as spot heights, are
+// ignored.
+func ParseContourGML(r io.Reader) ([]*Contour, error) {
+	dec := xml.NewDecoder(r)
+
+	var contours []*Contour
+
+	// The contour being read, if any
+	var contour *Contour
+	var haveHeight bool
+	// The dimension of the geometry being read, and the text of the element
+	// being read
+	dim := 2
+	var text strings.Builder
+
+	for {
+		tok, err := dec.Token()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+
+		switch tok := tok.(type) {
+		case xml.StartElement:
+			text.Reset()
+
+			switch tok.Name.Local {
+			case "ContourLine":
+				contour = &Contour{Geometry: &vector.MultiLineString{}}
+				haveHeight = false
+			case "LineString", "posList":
+				if srs := xmlAttr(tok, "srsName"); srs != "" && !strings.Contains(srs, "27700") {
+					return nil, fmt.Errorf("coordinate system isn't British National Grid (EPSG:27700): %s", srs)
+				}
+
+				if v := xmlAttr(tok, "srsDimension"); v != "" {
+					dim, err = strconv.Atoi(v)
+					if err != nil {
+						return nil, fmt.Errorf("invalid srsDimension %q", v)
+					}
+				} else if tok.Name.Local == "LineString" {
+					dim = 2
+				}
+			}
+		case xml.CharData:
+			text.Write(tok)
+		case xml.EndElement:
+			if contour == nil {
+				continue
+			}
+
+			switch tok.Name.Local {
+			case "ContourLine":
+				if !haveHeight {
+					return nil, fmt.Errorf("contour line with no height")
+				}
+
+				contours = append(contours, contour)
+				contour = nil
+			case "posList", "coordinates":
+				line, err := parseGMLCoords(text.String(), dim, tok.Name.Local == "coordinates")
+				if err != nil {
+					return nil, err
+				}
+
+				contour.Geometry.Lines = append(contour.Geometry.Lines, line)
+			default:
+				for _, name := range heightAttrs {
+					if tok.Name.Local != name {
+						continue
+					}
+
+					contour.Height, err = strconv.ParseFloat(strings.TrimSpace(text.String()), 64)
+					if err != nil {
+						return nil, fmt.Errorf("invalid height: %w", err)
+					}
+					haveHeight = true
+				}
+			}
+		}
+	}
+
+	return contours, nil
+}
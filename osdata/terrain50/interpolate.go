@@ -0,0 +1,310 @@
+package terrain50
+
+import (
+	"fmt"
+	"math"
+	"sort"
+
+	"github.com/usedbytes/osgrid"
+	"github.com/usedbytes/osgrid/osdata"
+	"github.com/usedbytes/osgrid/osdata/vector"
+)
+
+var mustBeContourFloat64Database osdata.Float64Database = &ContourDatabase{}
+
+// Contours further than this from a post aren't used to interpolate it.
+// Terrain 50 contours are 10 m apart, so they're only this far apart in very
+// flat areas.
+const maxContourDistance = 2 * osgrid.Kilometre
+
+// A point where a contour crosses a line of posts
+type crossing struct {
+	// Position along the line, in post spacings
+	pos    float64
+	height float64
+}
+
+// lineFamily is a set of parallel lines through the posts of a tile, along
+// which contours are interpolated. Positions are in post spacings, with post
+// (x, y) at (u, v) = (x, y).
+type lineFamily struct {
+	// Which line (u, v) is on, and its position along it
+	line func(u, v float64) float64
+	pos  func(u, v float64) float64
+	// Length of one unit of pos, in post spacings
+	scale float64
+
+	// Line number of lines[0]
+	first int
+	lines [][]crossing
+}
+
+// Rows, columns and both diagonals, for a tile of cols x rows posts
+func newLineFamilies(cols, rows int) []*lineFamily {
+	families := []*lineFamily{
+		{
+			line:  func(u, v float64) float64 { return v },
+			pos:   func(u, v float64) float64 { return u },
+			scale: 1,
+			first: 0,
+			lines: make([][]crossing, rows),
+		},
+		{
+			line:  func(u, v float64) float64 { return u },
+			pos:   func(u, v float64) float64 { return v },
+			scale: 1,
+			first: 0,
+			lines: make([][]crossing, cols),
+		},
+		{
+			line:  func(u, v float64) float64 { return v - u },
+			pos:   func(u, v float64) float64 { return u },
+			scale: math.Sqrt2,
+			first: -(cols - 1),
+			lines: make([][]crossing, cols+rows-1),
+		},
+		{
+			line:  func(u, v float64) float64 { return u + v },
+			pos:   func(u, v float64) float64 { return u },
+			scale: math.Sqrt2,
+			first: 0,
+			lines: make([][]crossing, cols+rows-1),
+		},
+	}
+
+	return families
+}
+
+// Record where the segment a-b, at height, crosses the lines
+func (f *lineFamily) addSegment(a, b vector.Point, height float64) {
+	la, lb := f.line(a.E, a.N), f.line(b.E, b.N)
+	if la == lb {
+		// Along a line (or not crossing any). The segments at either end
+		// will cross it.
+		return
+	}
+
+	lo, hi := math.Min(la, lb), math.Max(la, lb)
+	min := int(math.Max(math.Ceil(lo), float64(f.first)))
+	max := int(math.Min(math.Floor(hi), float64(f.first+len(f.lines)-1)))
+
+	pa, pb := f.pos(a.E, a.N), f.pos(b.E, b.N)
+	for k := min; k <= max; k++ {
+		t := (float64(k) - la) / (lb - la)
+		f.lines[k-f.first] = append(f.lines[k-f.first], crossing{
+			pos:    pa + t*(pb-pa),
+			height: height,
+		})
+	}
+}
+
+func (f *lineFamily) sort() {
+	for _, l := range f.lines {
+		sort.Slice(l, func(i, j int) bool {
+			return l[i].pos < l[j].pos
+		})
+	}
+}
+
+// Find the nearest crossings either side of (u, v). Distances are in post
+// spacings, and are infinite if there's no crossing on that side.
+func (f *lineFamily) bracket(u, v float64) (below, above crossing, dBelow, dAbove float64) {
+	l := f.lines[int(math.Round(f.line(u, v)))-f.first]
+	p := f.pos(u, v)
+
+	dBelow, dAbove = math.Inf(1), math.Inf(1)
+
+	i := sort.Search(len(l), func(i int) bool {
+		return l[i].pos > p
+	})
+
+	if i > 0 {
+		below = l[i-1]
+		dBelow = (p - below.pos) * f.scale
+	}
+
+	if i < len(l) {
+		above = l[i]
+		dAbove = (above.pos - p) * f.scale
+	}
+
+	return below, above, dBelow, dAbove
+}
+
+// Interpolate the post at (u, v), with maxDist in post spacings.
+//
+// Along each line through the post, the height is interpolated linearly
+// between the nearest contours on either side. The estimates from each line
+// are combined, weighted by the inverse square of the distance between the
+// contours, so that the steepest direction (which best describes the slope)
+// counts the most.
+func interpolatePost(families []*lineFamily, u, v, maxDist float64) float64 {
+	var sum, weights float64
+	nearest, nearestHeight := math.Inf(1), osdata.NoData
+
+	for _, f := range families {
+		below, above, dBelow, dAbove := f.bracket(u, v)
+
+		if dBelow < 1e-9 {
+			// On a contour
+			return below.height
+		}
+
+		if dBelow < nearest {
+			nearest, nearestHeight = dBelow, below.height
+		}
+
+		if dAbove < nearest {
+			nearest, nearestHeight = dAbove, above.height
+		}
+
+		if dBelow > maxDist || dAbove > maxDist {
+			continue
+		}
+
+		span := dBelow + dAbove
+		w := 1 / (span * span)
+
+		sum += w * (below.height + (above.height-below.height)*dBelow/span)
+		weights += w
+	}
+
+	if weights > 0 {
+		return sum / weights
+	}
+
+	// Not between contours in any direction, e.g. at the edge of the data.
+	// Use the height of the nearest one.
+	if nearest <= maxDist {
+		return nearestHeight
+	}
+
+	return osdata.NoData
+}
+
+// ContourDatabase is a Float64Database of elevations interpolated from the
+// Terrain 50 contours, for when the grid product isn't available.
+//
+// Each post is interpolated between the nearest contours on either side of
+// it, along the rows, columns and diagonals of the grid. Hilltops and the
+// bottoms of hollows, which have a contour on every side at the same height,
+// come out flat at the height of that contour. Posts further than 2 km
+// from any contour are NoData.
+type ContourDatabase struct {
+	contours  *Contours
+	precision osgrid.Distance
+	cache     *osdata.Cache
+}
+
+// NewContourDatabase interpolates a grid with posts every precision from
+// contours. Tiles are 10 km, like the contour product; precision must
+// divide that.
+func NewContourDatabase(contours *Contours, precision osgrid.Distance, opts ...osdata.DatabaseOpt) (*ContourDatabase, error) {
+	if precision <= 0 || contourTileSize%precision != 0 {
+		return nil, fmt.Errorf("precision (%d) must divide the tile size (%d)", precision, contourTileSize)
+	}
+
+	return &ContourDatabase{
+		contours:  contours,
+		precision: precision,
+		cache:     osdata.NewDatabaseConfig(opts...).Cache,
+	}, nil
+}
+
+func (d *ContourDatabase) generateTile(ref osgrid.GridRef) (*Tile, error) {
+	// Make sure there is a tile of contours here
+	if _, err := d.contours.getTile(ref); err != nil {
+		return nil, err
+	}
+
+	precision := float64(d.precision)
+	n := int(contourTileSize / d.precision)
+	origin := vector.FromGridRef(ref)
+
+	// Contours around the tile are needed for the posts near its edges
+	b := vector.BoundsFromRect(osgrid.Rect{BottomLeft: ref, Width: contourTileSize, Height: contourTileSize})
+	margin := float64(maxContourDistance)
+	b.Min = vector.Point{E: b.Min.E - margin, N: b.Min.N - margin}
+	b.Max = vector.Point{E: b.Max.E + margin, N: b.Max.N + margin}
+
+	contours, err := d.contours.SearchBounds(b)
+	if err != nil {
+		return nil, err
+	}
+
+	families := newLineFamilies(n, n)
+	for _, c := range contours {
+		for _, line := range c.Geometry.Lines {
+			for i := 1; i < len(line); i++ {
+				p0 := vector.Point{E: (line[i-1].E - origin.E) / precision, N: (line[i-1].N - origin.N) / precision}
+				p1 := vector.Point{E: (line[i].E - origin.E) / precision, N: (line[i].N - origin.N) / precision}
+
+				for _, f := range families {
+					f.addSegment(p0, p1, c.Height)
+				}
+			}
+		}
+	}
+
+	for _, f := range families {
+		f.sort()
+	}
+
+	t := &Tile{
+		bottomLeft: ref,
+		width:      contourTileSize,
+		height:     contourTileSize,
+		precision:  d.precision,
+		data:       make([][]float32, n),
+	}
+
+	maxDist := margin / precision
+	for y := range t.data {
+		t.data[y] = make([]float32, n)
+
+		for x := range t.data[y] {
+			t.data[y][x] = float32(interpolatePost(families, float64(x), float64(y), maxDist))
+		}
+	}
+
+	return t, nil
+}
+
+func (d *ContourDatabase) getTile(ref osgrid.GridRef) (*Tile, error) {
+	ref = ref.Align(contourTileSize)
+
+	tile, err := d.cache.Load(ref, func() (osdata.Tile, error) {
+		return d.generateTile(ref)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return tile.(*Tile), nil
+}
+
+func (d *ContourDatabase) GetTile(ref osgrid.GridRef) (osdata.Tile, error) {
+	return d.getTile(ref)
+}
+
+func (d *ContourDatabase) GetFloat64Tile(ref osgrid.GridRef) (osdata.Float64Tile, error) {
+	return d.getTile(ref)
+}
+
+func (d *ContourDatabase) GetFloat64(ref osgrid.GridRef) (float64, error) {
+	tile, err := d.getTile(ref)
+	if err != nil {
+		return osdata.NoData, err
+	}
+
+	return tile.GetFloat64(ref)
+}
+
+func (d *ContourDatabase) Precision() osgrid.Distance {
+	return d.precision
+}
+
+// Stats returns the stats of the interpolated tile cache
+func (d *ContourDatabase) Stats() osdata.Stats {
+	return d.cache.Stats()
+}
@@ -2,20 +2,18 @@ package terrain50
 
 import (
 	"archive/zip"
-	"bufio"
-	"encoding/csv"
 	"fmt"
 	"io"
 	"io/ioutil"
 	"math"
 	"os"
 	"path/filepath"
-	"regexp"
-	"strconv"
 	"strings"
 
 	"github.com/usedbytes/osgrid"
 	"github.com/usedbytes/osgrid/osdata"
+	"github.com/usedbytes/osgrid/osdata/ascgrid"
+	"github.com/usedbytes/osgrid/osdata/bintile"
 )
 
 var mustBeFloat64Tile osdata.Float64Tile = &Tile{}
@@ -52,6 +50,14 @@ func (t *Tile) Height() osgrid.Distance {
 	return t.height
 }
 
+func (t *Tile) MemorySize() int {
+	if len(t.data) == 0 {
+		return 0
+	}
+
+	return len(t.data) * len(t.data[0]) * 4
+}
+
 func (t *Tile) GetFloat64(ref osgrid.GridRef) (float64, error) {
 	if ref.Align(t.width) != t.bottomLeft {
 		return float64(math.NaN()), fmt.Errorf("Coordinate outside tile")
@@ -79,182 +85,164 @@ type Database struct {
 	tileSize  osgrid.Distance
 	precision osgrid.Distance
 
+	// Set when reading directly from the distributed zip file
+	archive *archive
+
+	mmap  bool
 	cache *osdata.Cache
 }
 
-func parseTileData(r io.Reader) ([][]float32, error) {
-	c := csv.NewReader(r)
-	c.Comma = ' '
-
-	records, err := c.ReadAll()
+// ParseASCTile reads a Terrain 50 tile in ESRI ASCII Grid format.
+// See the ascgrid package for reading other ASCII Grid data.
+func ParseASCTile(r io.Reader) (*Tile, error) {
+	at, err := ascgrid.ReadTile(r)
 	if err != nil {
-		fmt.Println("csverr")
 		return nil, err
 	}
 
-	vals := make([][]float32, len(records))
-	for i, _ := range vals {
-		vals[i] = make([]float32, len(records[0]))
+	t := &Tile{
+		bottomLeft: at.BottomLeft(),
+		width:      at.Width(),
+		height:     at.Height(),
+		precision:  at.Precision(),
+		data:       at.Data(),
 	}
 
-	nrows := len(records)
-	for y, row := range records {
-		for x, v := range row {
-			floatVal, err := strconv.ParseFloat(v, 32)
-			if err != nil {
-				return nil, err
-			}
-			// We need to flip the Y axis
-			// The Terrain 50 data is NW to SE
-			// But we want the origin in the bottom-left (SW to NE)
-			vals[nrows-y-1][x] = float32(floatVal)
-		}
+	if t.width == 0 || t.width != t.height {
+		return nil, fmt.Errorf("Invalid tile size")
 	}
 
-	return vals, nil
+	return t, nil
 }
 
-func ParseASCTile(r io.Reader) (*Tile, error) {
-	buf := bufio.NewReader(r)
-
-	var err error
-	var ncols, nrows int
-	var xllcorner, yllcorner, cellsize osgrid.Distance
-
-	header := true
-	for header {
-		line, err := buf.ReadString('\n')
-		if err != nil {
-			return nil, err
-		}
-		line = strings.TrimSpace(line)
-
-		if len(line) == 0 {
-			continue
-		}
-
-		fields := strings.Split(line, " ")
-		if len(fields) != 2 {
-			return nil, fmt.Errorf("Unexpected header data: %s", line)
-		}
-		value, err := strconv.Atoi(fields[1])
-		if err != nil {
-			return nil, err
-		}
-
-		switch fields[0] {
-		case "ncols":
-			ncols = value
-		case "nrows":
-			nrows = value
-		case "xllcorner":
-			xllcorner = osgrid.Distance(value)
-		case "yllcorner":
-			yllcorner = osgrid.Distance(value)
-		case "cellsize":
-			cellsize = osgrid.Distance(value)
-			header = false
-		}
-	}
-
-	var t Tile
-	t.bottomLeft, err = osgrid.Origin().Add(xllcorner, yllcorner)
+// OpenTile opens a tile, which can be a zip file containing a '.asc' file, a
+// directory containing one, or a '.asc' file itself
+func OpenTile(path string) (*Tile, error) {
+	fi, err := os.Stat(path)
 	if err != nil {
 		return nil, err
 	}
-	t.width = osgrid.Distance(ncols) * cellsize * osgrid.Metre
-	t.height = osgrid.Distance(nrows) * cellsize * osgrid.Metre
-	t.precision = cellsize * osgrid.Metre
 
-	if t.width == 0 || t.width != t.height {
-		return nil, fmt.Errorf("Invalid tile size")
+	if fi.IsDir() {
+		return openDirTile(path)
 	}
 
-	t.data, err = parseTileData(buf)
-	if err != nil {
-		return nil, err
+	if strings.ToLower(filepath.Ext(path)) == ".asc" {
+		return openASCTile(path)
 	}
 
-	if len(t.data) != nrows || len(t.data[0]) != ncols {
-		return nil, fmt.Errorf("Invalid amount of data")
+	zipFile, err := zip.OpenReader(path)
+	if err != nil {
+		return nil, err
 	}
+	defer zipFile.Close()
 
-	return &t, nil
+	return readZipTile(&zipFile.Reader)
 }
 
-func OpenTile(path string) (*Tile, error) {
-	fi, err := os.Stat(path)
+func openASCTile(path string) (*Tile, error) {
+	f, err := os.Open(path)
 	if err != nil {
 		return nil, err
 	}
+	defer f.Close()
 
-	if fi.IsDir() {
-		// TODO: Not implemented
-		return nil, fmt.Errorf("Tiles are expected to be zipped")
-	}
+	return ParseASCTile(f)
+}
 
-	zipFile, err := zip.OpenReader(path)
+// Open the first .asc file in dir
+func openDirTile(dir string) (*Tile, error) {
+	entries, err := ioutil.ReadDir(dir)
 	if err != nil {
 		return nil, err
 	}
-	defer zipFile.Close()
 
-	t := &Tile{}
+	for _, entry := range entries {
+		if !entry.IsDir() && strings.ToLower(filepath.Ext(entry.Name())) == ".asc" {
+			return openASCTile(filepath.Join(dir, entry.Name()))
+		}
+	}
+
+	return nil, fmt.Errorf("no .asc file found in %s", dir)
+}
 
-	for _, f := range zipFile.File {
-		if filepath.Ext(f.Name) == ".asc" {
+// Parse the first .asc file in zr
+func readZipTile(zr *zip.Reader) (*Tile, error) {
+	for _, f := range zr.File {
+		if strings.ToLower(filepath.Ext(f.Name)) == ".asc" {
 			r, err := f.Open()
 			if err != nil {
 				return nil, err
 			}
 			defer r.Close()
 
-			t, err = ParseASCTile(r)
-			if err != nil {
-				return nil, err
-			}
-			return t, nil
+			return ParseASCTile(r)
 		}
 	}
 
-	return nil, nil
+	return nil, fmt.Errorf("no .asc file found")
 }
 
-func (d *Database) findTile(ref osgrid.GridRef) (string, error) {
-	ref.Align(d.tileSize)
+// Tiles can be zipped, extracted into a directory, or just a '.asc' file.
+// Other files (e.g. the '.gml' metadata from extracted zips) are ignored.
+func isTileEntry(fi os.FileInfo) bool {
+	if fi.IsDir() {
+		return true
+	}
+
+	ext := strings.ToLower(filepath.Ext(fi.Name()))
 
-	dirExp := fmt.Sprintf("(?i)%s", ref.Tile())
-	dirRe := regexp.MustCompile(dirExp)
+	return ext == ".zip" || ext == ".asc"
+}
 
-	dir, err := ioutil.ReadDir(d.path)
+func (d *Database) findTile(ref osgrid.GridRef) (string, error) {
+	paths, err := findTileEntries(d.path, ref, isTileEntry)
 	if err != nil {
 		return "", err
 	}
 
+	return paths[0], nil
+}
+
+// Find the entries for the tile at ref in the extracted 'data' directory at
+// path, which are in a directory for each 100 km square, e.g. "sh/sh65...".
+// Returns an error wrapping osdata.ErrTileNotFound if there aren't any.
+func findTileEntries(path string, ref osgrid.GridRef, isEntry func(os.FileInfo) bool) ([]string, error) {
+	square := strings.ToLower(ref.Tile())
+	name := strings.ToLower(ref.Tile() + ref.Digits())
+
+	dir, err := ioutil.ReadDir(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var paths []string
 	for _, dirEntry := range dir {
-		if !dirEntry.IsDir() {
+		if !dirEntry.IsDir() || strings.ToLower(dirEntry.Name()) != square {
 			continue
 		}
 
-		if dirRe.MatchString(dirEntry.Name()) {
-			zipExp := fmt.Sprintf("(?i)%s%s", ref.Tile(), ref.Digits())
-			zipRe := regexp.MustCompile(zipExp)
+		tileDir, err := ioutil.ReadDir(filepath.Join(path, dirEntry.Name()))
+		if err != nil {
+			return nil, err
+		}
 
-			zipDir, err := ioutil.ReadDir(filepath.Join(d.path, dirEntry.Name()))
-			if err != nil {
-				return "", err
+		for _, tileEntry := range tileDir {
+			if !isEntry(tileEntry) {
+				continue
 			}
 
-			for _, zipEntry := range zipDir {
-				if zipRe.MatchString(zipEntry.Name()) {
-					ret := filepath.Join(d.path, dirEntry.Name(), zipEntry.Name())
-					return ret, nil
-				}
+			if tileNameRe.FindString(strings.ToLower(tileEntry.Name())) == name {
+				paths = append(paths, filepath.Join(path, dirEntry.Name(), tileEntry.Name()))
 			}
 		}
 	}
 
-	return "", fmt.Errorf("Tile %s not found", ref)
+	if len(paths) == 0 {
+		return nil, fmt.Errorf("Tile %s %w", ref, osdata.ErrTileNotFound)
+	}
+
+	return paths, nil
 }
 
 func (d *Database) GetFloat64(ref osgrid.GridRef) (float64, error) {
@@ -272,12 +260,24 @@ func (d *Database) GetData(ref osgrid.GridRef) (float32, error) {
 	return float32(f), err
 }
 
-func (d *Database) getTile(ref osgrid.GridRef) (*Tile, error) {
-	ref = ref.Align(d.tileSize)
+// Binary tiles (see Convert) are preferred over the original data
+func (d *Database) binTilePath(ref osgrid.GridRef) string {
+	return filepath.Join(d.path, strings.ToLower(ref.Tile()),
+		strings.ToLower(ref.Tile()+ref.Digits())+bintile.Ext)
+}
+
+func (d *Database) openBinTile(path string) (*bintile.Tile, error) {
+	if d.mmap {
+		return bintile.MapTile(path)
+	}
+
+	return bintile.OpenTile(path)
+}
 
-	if osdTile, ok := d.cache.Read(ref); ok {
-		// Cache hit
-		return osdTile.(*Tile), nil
+// Load a tile from the original data, ignoring any binary tiles
+func (d *Database) loadSourceTile(ref osgrid.GridRef) (*Tile, error) {
+	if d.archive != nil {
+		return d.archive.openTile(ref)
 	}
 
 	path, err := d.findTile(ref)
@@ -285,14 +285,31 @@ func (d *Database) getTile(ref osgrid.GridRef) (*Tile, error) {
 		return nil, err
 	}
 
-	tile, err := OpenTile(path)
+	return OpenTile(path)
+}
+
+func (d *Database) loadTile(ref osgrid.GridRef) (osdata.Float64Tile, error) {
+	if d.archive == nil {
+		path := d.binTilePath(ref)
+		if _, err := os.Stat(path); err == nil {
+			return d.openBinTile(path)
+		}
+	}
+
+	return d.loadSourceTile(ref)
+}
+
+func (d *Database) getTile(ref osgrid.GridRef) (osdata.Float64Tile, error) {
+	ref = ref.Align(d.tileSize)
+
+	tile, err := d.cache.Load(ref, func() (osdata.Tile, error) {
+		return d.loadTile(ref)
+	})
 	if err != nil {
 		return nil, err
 	}
 
-	d.cache.Allocate(tile)
-
-	return tile, nil
+	return tile.(osdata.Float64Tile), nil
 }
 
 func (d *Database) GetTile(ref osgrid.GridRef) (osdata.Tile, error) {
@@ -311,21 +328,24 @@ func (d *Database) Precision() osgrid.Distance {
 	return d.precision
 }
 
-func OpenDatabase(path string, tileSize osgrid.Distance) (osdata.Float64Database, error) {
-	datapath := filepath.Join(path, "data")
-
-	fi, err := os.Stat(datapath)
-	if err != nil {
-		return nil, err
-	}
-	if !fi.IsDir() {
-		return nil, fmt.Errorf("%s should be a directory", datapath)
-	}
+// OpenDatabase opens a Terrain 50 dataset. path can either be the directory
+// containing the extracted 'data' directory, or the distributed zip file
+// itself.
+// If the 'data' directory contains binary tiles (see Convert), they are used
+// in preference to the original data.
+func OpenDatabase(path string, tileSize osgrid.Distance, opts ...osdata.DatabaseOpt) (osdata.Float64Database, error) {
+	cfg := osdata.NewDatabaseConfig(opts...)
 
 	d := &Database{
-		path:     datapath,
 		tileSize: tileSize,
-		cache:    osdata.NewCache(16),
+		mmap:     cfg.Mmap,
+		cache:    cfg.Cache,
+	}
+
+	var err error
+	d.path, d.archive, err = openData(path)
+	if err != nil {
+		return nil, err
 	}
 
 	// We assume that London is available in the data-set
@@ -334,11 +354,13 @@ func OpenDatabase(path string, tileSize osgrid.Distance) (osdata.Float64Database
 
 	tile, err := d.getTile(tq28)
 	if err != nil {
+		d.Close()
 		return nil, err
 	}
 
-	if tile.width != tileSize || tile.height != tileSize {
-		return nil, fmt.Errorf("Specified tileSize (%d) doesn't match data (%d)", tileSize, tile.width)
+	if tile.Width() != tileSize || tile.Height() != tileSize {
+		d.Close()
+		return nil, fmt.Errorf("Specified tileSize (%d) doesn't match data (%d)", tileSize, tile.Width())
 	}
 
 	d.precision = tile.Precision()
@@ -346,6 +368,52 @@ func OpenDatabase(path string, tileSize osgrid.Distance) (osdata.Float64Database
 	return d, nil
 }
 
+// Find the data in path, which is either the directory containing the
+// extracted 'data' directory, or the distributed zip file. Returns the path
+// of the 'data' directory, or the archive.
+func openData(path string) (string, *archive, error) {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return "", nil, err
+	}
+
+	if !fi.IsDir() {
+		a, err := openArchive(path)
+		if err != nil {
+			return "", nil, err
+		}
+
+		return path, a, nil
+	}
+
+	datapath := filepath.Join(path, "data")
+
+	fi, err = os.Stat(datapath)
+	if err != nil {
+		return "", nil, err
+	}
+	if !fi.IsDir() {
+		return "", nil, fmt.Errorf("%s should be a directory", datapath)
+	}
+
+	return datapath, nil, nil
+}
+
+// Close releases the archive file, if the database was opened from one.
+// Close isn't part of osdata.Float64Database, so code which only has the
+// interface should check for io.Closer.
+func (d *Database) Close() error {
+	if d.archive != nil {
+		return d.archive.Close()
+	}
+
+	return nil
+}
+
+func (d *Database) Stats() osdata.Stats {
+	return d.cache.Stats()
+}
+
 func (d *Database) DumpStats() string {
 	return "Cache stats: " + d.cache.DumpStats()
 }
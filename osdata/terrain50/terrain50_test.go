@@ -1,10 +1,18 @@
 package terrain50
 
 import (
+	"archive/zip"
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
 	"strings"
 	"testing"
 
 	"github.com/usedbytes/osgrid"
+	"github.com/usedbytes/osgrid/osdata"
+	"github.com/usedbytes/osgrid/osdata/bintile"
 )
 
 var testASCData string = `
@@ -115,3 +123,230 @@ func TestUseDatabase(t *testing.T) {
 	t.Log(ref, val)
 }
 */
+
+// Make a zip containing a single file
+func makeZip(t *testing.T, name string, data []byte) []byte {
+	buf := &bytes.Buffer{}
+	zw := zip.NewWriter(buf)
+
+	w, err := zw.Create(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = w.Write(data); err != nil {
+		t.Fatal(err)
+	}
+
+	if err = zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	return buf.Bytes()
+}
+
+// Like testASCData, but positioned at TQ 28, which OpenDatabase requires
+var testTQ28Data string = `ncols 5
+nrows 5
+xllcorner 520000
+yllcorner 180000
+cellsize 2000
+21.0 22.0 23.0 24.0 25.0
+16.0 17.0 18.0 19.0 20.0
+11.0 12.0 13.0 14.0 15.0
+6.0 7.0 8.0 9.0 10.0
+1.0 2.0 3.0 4.0 5.0`
+
+func TestOpenArchive(t *testing.T) {
+	dir, err := ioutil.TempDir("", "terrain50")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	archivePath := filepath.Join(dir, "terr50_gagg_gb.zip")
+	f, err := os.Create(archivePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	zw := zip.NewWriter(f)
+
+	// One tile stored (so read in-place) and one compressed
+	w, err := zw.CreateHeader(&zip.FileHeader{
+		Name:   "data/tq/tq28_OST50GRID_20210512.zip",
+		Method: zip.Store,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.Write(makeZip(t, "TQ28.asc", []byte(testTQ28Data)))
+
+	w, err = zw.CreateHeader(&zip.FileHeader{
+		Name:   "data/sv/sv12_OST50GRID_20210512.zip",
+		Method: zip.Deflate,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.Write(makeZip(t, "SV12.asc", []byte(testASCData)))
+
+	zw.Create("doc/licence.txt")
+
+	if err = zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+
+	db, err := OpenDatabase(archivePath, 10*osgrid.Kilometre)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.(*Database).Close()
+
+	if db.Precision() != 2*osgrid.Kilometre {
+		t.Errorf("precision: expected %d, got %d", 2*osgrid.Kilometre, db.Precision())
+	}
+
+	tq2282, _ := osgrid.ParseGridRef("TQ 2282")
+	val, err := db.GetFloat64(tq2282)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if val != 7.0 {
+		t.Errorf("TQ: expected %f got %f", 7.0, val)
+	}
+
+	sv1222, _ := osgrid.ParseGridRef("SV 1222")
+	val, err = db.GetFloat64(sv1222)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if val != 7.0 {
+		t.Errorf("SV: expected %f got %f", 7.0, val)
+	}
+
+	sh65, _ := osgrid.ParseGridRef("SH 65")
+	_, err = db.GetFloat64(sh65)
+	if !errors.Is(err, osdata.ErrTileNotFound) {
+		t.Errorf("expected ErrTileNotFound, got %v", err)
+	}
+}
+
+// Write a file into dir/data, creating directories as needed
+func writeDataFile(t *testing.T, dir, name string, data []byte) {
+	path := filepath.Join(dir, "data", name)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(path, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestOpenUnzipped(t *testing.T) {
+	dir, err := ioutil.TempDir("", "terrain50")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	write := func(name string, data []byte) {
+		writeDataFile(t, dir, name, data)
+	}
+
+	// A zipped tile, a loose .asc and an extracted directory, alongside
+	// metadata which should be ignored
+	write("tq/tq28_OST50GRID_20210512.zip", makeZip(t, "TQ28.asc", []byte(testTQ28Data)))
+	write("sv/SV12.gml", []byte("<gml/>"))
+	write("sv/SV12.asc", []byte(testASCData))
+	write("sh/sh65_OST50GRID_20210512/SH65.gml", []byte("<gml/>"))
+	write("sh/sh65_OST50GRID_20210512/SH65.asc", []byte(strings.Replace(
+		strings.Replace(testASCData, "10000", "260000", 1), "20000", "350000", 1)))
+
+	db, err := OpenDatabase(dir, 10*osgrid.Kilometre)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, str := range []string{"TQ 2282", "SV 1222", "SH 6252"} {
+		ref, _ := osgrid.ParseGridRef(str)
+		val, err := db.GetFloat64(ref)
+		if err != nil {
+			t.Fatalf("%s: %v", str, err)
+		}
+
+		if val != 7.0 {
+			t.Errorf("%s: expected %f got %f", str, 7.0, val)
+		}
+	}
+
+	sj00, _ := osgrid.ParseGridRef("SJ 00")
+	_, err = db.GetFloat64(sj00)
+	if !errors.Is(err, osdata.ErrTileNotFound) {
+		t.Errorf("expected ErrTileNotFound, got %v", err)
+	}
+}
+
+func TestConvert(t *testing.T) {
+	dir, err := ioutil.TempDir("", "terrain50")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	src := filepath.Join(dir, "src")
+	writeDataFile(t, src, "tq/tq28_OST50GRID_20210512.zip", makeZip(t, "TQ28.asc", []byte(testTQ28Data)))
+	writeDataFile(t, src, "sv/SV12.asc", []byte(testASCData))
+
+	check := func(db osdata.Float64Database) {
+		for _, str := range []string{"TQ 2282", "SV 1222"} {
+			ref, _ := osgrid.ParseGridRef(str)
+
+			tile, err := db.GetFloat64Tile(ref)
+			if err != nil {
+				t.Fatalf("%s: %v", str, err)
+			}
+
+			if _, ok := tile.(*bintile.Tile); !ok {
+				t.Errorf("%s: expected a binary tile, got %T", str, tile)
+			}
+
+			val, err := db.GetFloat64(ref)
+			if err != nil {
+				t.Fatalf("%s: %v", str, err)
+			}
+
+			if val != 7.0 {
+				t.Errorf("%s: expected %f got %f", str, 7.0, val)
+			}
+		}
+	}
+
+	// Into a new directory, which only has binary tiles
+	dst := filepath.Join(dir, "dst")
+	err = Convert(src, dst, 10*osgrid.Kilometre, bintile.EncodingInt16Decimetres)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	db, err := OpenDatabase(dst, 10*osgrid.Kilometre)
+	if err != nil {
+		t.Fatal(err)
+	}
+	check(db)
+
+	// Alongside the originals, which should be ignored
+	err = Convert(src, src, 10*osgrid.Kilometre, bintile.EncodingFloat32)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	db, err = OpenDatabase(src, 10*osgrid.Kilometre, osdata.DatabaseMmapOpt())
+	if err != nil {
+		t.Fatal(err)
+	}
+	check(db)
+}
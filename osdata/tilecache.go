@@ -1,125 +1,275 @@
 package osdata
 
 import (
+	"container/list"
 	"fmt"
+	"sync"
+	"time"
+
 	"github.com/usedbytes/osgrid"
 )
 
-type slot struct {
-	ts  int
-	ref osgrid.GridRef
+// Tiles which implement MemorySizer are charged their reported size against
+// the Cache budget. Other tiles are charged DefaultTileMemorySize.
+type MemorySizer interface {
+	// Approximate number of bytes of memory used by the tile
+	MemorySize() int
+}
+
+const (
+	// Size charged for tiles which don't implement MemorySizer
+	DefaultTileMemorySize int = 1 << 20
+	// Cache budget used by databases when no other is specified
+	DefaultCacheSize int64 = 256 << 20
+)
+
+type key struct {
+	ns int
+	// The tile's GridRef, or the id passed to LoadItem
+	id interface{}
 }
 
 type entry struct {
-	slot int
-	tile Tile
+	key   key
+	size  int
+	value interface{}
+}
+
+// store is the state shared between all the namespaces of a Cache
+type store struct {
+	sync.Mutex
+
+	budget int64
+	used   int64
+	nextNs int
+
+	lru   *list.List
+	cache map[key]*list.Element
+	stats map[int]*Stats
 }
 
+// Cache is a least-recently-used cache of tiles, with a memory budget in bytes.
+//
+// A Cache can be shared between multiple databases, with each one using its
+// own namespace (see Share()), and all of them drawing from the same budget.
 type Cache struct {
-	nslots    int
-	timestamp int
-	slots     []slot
-	cache     map[osgrid.GridRef]*entry
-	stats     stats
+	*store
+	ns int
 }
 
-type stats struct {
-	hits        int
-	misses      int
-	evictions   int
-	allocations int
+// NewCache creates a cache which will try to keep the total size of the tiles
+// it holds below budget bytes.
+// The most recently allocated tile is always retained, even if it alone
+// exceeds the budget.
+func NewCache(budget int64) *Cache {
+	return &Cache{
+		store: &store{
+			budget: budget,
+			lru:    list.New(),
+			cache:  make(map[key]*list.Element),
+			stats:  make(map[int]*Stats),
+		},
+	}
 }
 
-func NewCache(nslots int) *Cache {
+// Share returns a new namespace in the same cache. Tiles allocated via the
+// returned Cache don't collide with those from any other namespace, but share
+// the same budget, so may be evicted to make room for them. Each namespace
+// keeps its own statistics (see Stats()); TotalStats() adds them up.
+func (c *Cache) Share() *Cache {
+	c.Lock()
+	defer c.Unlock()
+
+	c.nextNs++
+
 	return &Cache{
-		nslots: nslots,
-		slots:  make([]slot, nslots),
-		cache:  make(map[osgrid.GridRef]*entry),
+		store: c.store,
+		ns:    c.nextNs,
+	}
+}
+
+func (s *store) nsStats(ns int) *Stats {
+	st, ok := s.stats[ns]
+	if !ok {
+		v := newStats()
+		st = &v
+		s.stats[ns] = st
 	}
+
+	return st
+}
+
+func memorySize(v interface{}) int {
+	if s, ok := v.(MemorySizer); ok {
+		return s.MemorySize()
+	}
+
+	return DefaultTileMemorySize
 }
 
-func (c *Cache) read(ref osgrid.GridRef) (Tile, bool) {
-	if entry, ok := c.cache[ref]; ok {
-		c.timestamp++
-		c.slots[entry.slot].ts = c.timestamp
-		return entry.tile, ok
+func (c *Cache) read(id interface{}) (interface{}, bool) {
+	if elem, ok := c.cache[key{c.ns, id}]; ok {
+		c.lru.MoveToFront(elem)
+		return elem.Value.(*entry).value, true
 	}
 
 	return nil, false
 }
 
-func (c *Cache) Read(ref osgrid.GridRef) (Tile, bool) {
-	entry, ok := c.read(ref)
+func (c *Cache) readItem(id interface{}) (interface{}, bool) {
+	c.Lock()
+	defer c.Unlock()
+
+	v, ok := c.read(id)
 	if ok {
-		c.stats.hits++
+		c.nsStats(c.ns).Hits++
 	} else {
-		c.stats.misses++
+		c.nsStats(c.ns).Misses++
 	}
 
-	return entry, ok
+	return v, ok
 }
 
-func (c *Cache) findSlot() int {
-	oldest := c.timestamp
-	idx := 0
+func (c *Cache) Read(ref osgrid.GridRef) (Tile, bool) {
+	v, ok := c.readItem(ref)
+	if !ok {
+		return nil, false
+	}
 
-	for i, slot := range c.slots {
-		if slot.ref.Tile() == "" {
-			// Always prefer an empty slot
-			idx = i
-			return idx
-		}
+	return v.(Tile), true
+}
+
+func (c *Cache) loadItem(id interface{}, load func() (interface{}, error)) (interface{}, error) {
+	if v, ok := c.readItem(id); ok {
+		return v, nil
+	}
 
-		if slot.ts <= oldest {
-			oldest = slot.ts
-			idx = i
-		}
+	start := time.Now()
+	v, err := load()
+	if err != nil {
+		return nil, err
 	}
+	elapsed := time.Since(start)
 
-	c.stats.evictions++
+	c.Lock()
+	c.nsStats(c.ns).LoadLatency.observe(elapsed)
+	c.Unlock()
 
-	return idx
+	c.allocate(id, v)
+
+	return v, nil
+}
+
+// Load returns the tile for ref from the cache, or on a miss calls load() to
+// read it and allocates the result. The time taken by load() is recorded in
+// the LoadLatency statistics.
+func (c *Cache) Load(ref osgrid.GridRef, load func() (Tile, error)) (Tile, error) {
+	v, err := c.loadItem(ref, func() (interface{}, error) {
+		return load()
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return v.(Tile), nil
+}
+
+// LoadItem is Load for things which aren't tiles on the National Grid, such
+// as the tiles of a source with a different tile grid, which are identified
+// by id instead. id must be comparable. Items are charged against the budget
+// like tiles, so should implement MemorySizer.
+//
+// Items should be kept in their own namespace (see Share()), separate from
+// any tiles.
+func (c *Cache) LoadItem(id interface{}, load func() (interface{}, error)) (interface{}, error) {
+	return c.loadItem(id, load)
+}
+
+func (c *Cache) evict() {
+	elem := c.lru.Back()
+	e := c.lru.Remove(elem).(*entry)
+
+	delete(c.cache, e.key)
+	c.used -= int64(e.size)
+
+	st := c.nsStats(e.key.ns)
+	st.Evictions++
+	st.BytesResident -= int64(e.size)
 }
 
 func (c *Cache) Allocate(tile Tile) {
-	ref := tile.BottomLeft()
+	c.allocate(tile.BottomLeft(), tile)
+}
+
+func (c *Cache) allocate(id interface{}, v interface{}) {
+	c.Lock()
+	defer c.Unlock()
+
 	// Check we don't already have it
-	if _, ok := c.read(ref); ok {
+	if _, ok := c.read(id); ok {
 		return
 	}
 
-	idx := c.findSlot()
-	slot := &c.slots[idx]
-
-	delete(c.cache, slot.ref)
-	c.cache[ref] = &entry{
-		slot: idx,
-		tile: tile,
+	size := memorySize(v)
+	for c.lru.Len() > 0 && c.used+int64(size) > c.budget {
+		c.evict()
 	}
 
-	c.timestamp++
-	slot.ts = c.timestamp
-	slot.ref = ref
+	k := key{c.ns, id}
+	c.cache[k] = c.lru.PushFront(&entry{
+		key:   k,
+		size:  size,
+		value: v,
+	})
+	c.used += int64(size)
 
-	c.stats.allocations++
+	st := c.nsStats(c.ns)
+	st.Allocations++
+	st.BytesResident += int64(size)
 }
 
 func (c *Cache) dump() string {
 	s := ""
-	s += fmt.Sprintf("Num slots: %d\n", c.nslots)
-	s += fmt.Sprintf("Timestamp: %d\n", c.timestamp)
-	s += fmt.Sprintf("Slots:\n")
-	for i, v := range c.slots {
-		s += fmt.Sprintf("\t%v: %+v\n", i, v)
-	}
-	for k, v := range c.cache {
-		s += fmt.Sprintf("\t%v: %+v\n", k, v)
+	s += fmt.Sprintf("Budget: %d\n", c.budget)
+	s += fmt.Sprintf("Used: %d\n", c.used)
+	s += fmt.Sprintf("Entries:\n")
+	for elem := c.lru.Front(); elem != nil; elem = elem.Next() {
+		e := elem.Value.(*entry)
+		s += fmt.Sprintf("\t%v/%v: %v bytes\n", e.key.ns, e.key.id, e.size)
 	}
 
 	return s
 }
 
+// Stats returns the statistics for this namespace of the cache
+func (c *Cache) Stats() Stats {
+	c.Lock()
+	defer c.Unlock()
+
+	st := newStats()
+	if v, ok := c.stats[c.ns]; ok {
+		st.add(*v)
+	}
+
+	return st
+}
+
+// TotalStats returns the statistics for all namespaces sharing the cache
+func (c *Cache) TotalStats() Stats {
+	c.Lock()
+	defer c.Unlock()
+
+	st := newStats()
+	for _, v := range c.stats {
+		st.add(*v)
+	}
+
+	return st
+}
+
 func (c *Cache) DumpStats() string {
-	return fmt.Sprintf("Hits: %d, Miss: %d, Allocations: %d, Evictions: %d",
-		c.stats.hits, c.stats.misses, c.stats.allocations, c.stats.evictions)
+	st := c.Stats()
+
+	return fmt.Sprintf("Hits: %d, Miss: %d, Allocations: %d, Evictions: %d, Bytes: %d/%d",
+		st.Hits, st.Misses, st.Allocations, st.Evictions, st.BytesResident, c.budget)
 }
@@ -7,7 +7,16 @@ import (
 )
 
 type TestTile struct {
-	ref osgrid.GridRef
+	ref  osgrid.GridRef
+	size int
+}
+
+func (tt *TestTile) MemorySize() int {
+	if tt.size == 0 {
+		return 100
+	}
+
+	return tt.size
 }
 
 func (tt *TestTile) Width() osgrid.Distance {
@@ -37,7 +46,7 @@ func NewTestTile(ref osgrid.GridRef) Tile {
 }
 
 func TestCacheEmpty(t *testing.T) {
-	c := NewCache(1)
+	c := NewCache(100)
 
 	ref, err := osgrid.ParseGridRef("SH 60 54")
 	if err != nil {
@@ -51,7 +60,7 @@ func TestCacheEmpty(t *testing.T) {
 }
 
 func TestCacheSingleAllocate(t *testing.T) {
-	c := NewCache(1)
+	c := NewCache(100)
 
 	ref, err := osgrid.ParseGridRef("SH 60 54")
 	if err != nil {
@@ -67,24 +76,20 @@ func TestCacheSingleAllocate(t *testing.T) {
 	c.Allocate(tt)
 
 	if len(c.cache) != 1 {
-		t.Fatal("should have one occupied slot")
-	}
-
-	if c.cache[ref].slot != 0 {
-		t.Fatal("slot 0 should have been allocated")
+		t.Fatal("should have one entry")
 	}
 
-	if c.cache[ref].tile != tt {
+	if c.cache[key{c.ns, ref}].Value.(*entry).value != tt {
 		t.Fatal("entry tile doesn't match")
 	}
 
-	if c.slots[0].ref != ref {
-		t.Fatalf("slot ref not expected. want: %v, got: %v", ref, c.slots[0].ref)
+	if c.used != 100 {
+		t.Fatalf("used bytes not expected. want: %v, got: %v", 100, c.used)
 	}
 }
 
 func TestCacheSingleHit(t *testing.T) {
-	c := NewCache(1)
+	c := NewCache(100)
 
 	ref, err := osgrid.ParseGridRef("SH 60 54")
 	if err != nil {
@@ -101,7 +106,7 @@ func TestCacheSingleHit(t *testing.T) {
 }
 
 func TestCacheSingleEvict(t *testing.T) {
-	c := NewCache(1)
+	c := NewCache(100)
 
 	ref1, err := osgrid.ParseGridRef("SH 60 54")
 	if err != nil {
@@ -135,25 +140,21 @@ func TestCacheSingleEvict(t *testing.T) {
 	}
 
 	if len(c.cache) != 1 {
-		t.Fatal("should have one slot")
+		t.Fatal("should have one entry")
 	}
 
-	if c.cache[ref2].slot != 0 {
-		t.Fatal("slot 0 should have been replaced")
-	}
-
-	if c.cache[ref2].tile != tt2 {
+	if c.cache[key{c.ns, ref2}].Value.(*entry).value != tt2 {
 		t.Fatal("entry tile doesn't match")
 	}
 
-	if c.slots[0].ref != ref2 {
-		t.Fatalf("slot ref not expected. want: %v, got: %v", ref2, c.slots[0].ref)
+	if c.used != 100 {
+		t.Fatalf("used bytes not expected. want: %v, got: %v", 100, c.used)
 	}
 }
 
 func TestCacheMultiEvict(t *testing.T) {
 	const cacheSize int = 3
-	c := NewCache(cacheSize)
+	c := NewCache(int64(cacheSize) * 100)
 
 	tiles := make([]Tile, cacheSize*2)
 	ref := osgrid.Origin()
@@ -178,7 +179,7 @@ func TestCacheMultiEvict(t *testing.T) {
 
 func TestCacheMultiEvictOldest(t *testing.T) {
 	const cacheSize int = 3
-	c := NewCache(cacheSize)
+	c := NewCache(int64(cacheSize) * 100)
 
 	tiles := make([]Tile, cacheSize+1)
 	ref := osgrid.Origin()
@@ -213,3 +214,182 @@ func TestCacheMultiEvictOldest(t *testing.T) {
 		t.Fatalf("second oldest entry should have been evicted")
 	}
 }
+
+func TestCacheBudget(t *testing.T) {
+	c := NewCache(1000)
+
+	ref := osgrid.Origin()
+	small := &TestTile{ref: ref, size: 400}
+	c.Allocate(small)
+
+	ref, _ = ref.Add(10*osgrid.Kilometre, 0)
+	medium := &TestTile{ref: ref, size: 500}
+	c.Allocate(medium)
+
+	if _, ok := c.Read(small.BottomLeft()); !ok {
+		t.Fatal("should have hit, budget not exceeded")
+	}
+
+	// Doesn't fit with both, medium is least recently used
+	ref, _ = ref.Add(10*osgrid.Kilometre, 0)
+	large := &TestTile{ref: ref, size: 600}
+	c.Allocate(large)
+
+	if _, ok := c.Read(medium.BottomLeft()); ok {
+		t.Fatal("least recently used should have been evicted")
+	}
+
+	if _, ok := c.Read(small.BottomLeft()); !ok {
+		t.Fatal("should have hit for small")
+	}
+
+	if c.used != 1000 {
+		t.Fatalf("used bytes not expected. want: %v, got: %v", 1000, c.used)
+	}
+
+	// Bigger than the whole budget, should still be cached alone
+	ref, _ = ref.Add(10*osgrid.Kilometre, 0)
+	huge := &TestTile{ref: ref, size: 2000}
+	c.Allocate(huge)
+
+	if _, ok := c.Read(huge.BottomLeft()); !ok {
+		t.Fatal("should have hit for huge")
+	}
+
+	if len(c.cache) != 1 {
+		t.Fatalf("expected only one entry, got %d", len(c.cache))
+	}
+}
+
+func TestCacheShare(t *testing.T) {
+	a := NewCache(200)
+	b := a.Share()
+
+	ref, err := osgrid.ParseGridRef("SH 60 54")
+	if err != nil {
+		panic(err)
+	}
+
+	ta := NewTestTile(ref)
+	a.Allocate(ta)
+
+	if _, ok := b.Read(ref); ok {
+		t.Fatal("namespaces should not collide")
+	}
+
+	tb := NewTestTile(ref)
+	b.Allocate(tb)
+
+	tile, ok := a.Read(ref)
+	if !ok || tile != ta {
+		t.Fatal("should have hit for a's tile")
+	}
+
+	tile, ok = b.Read(ref)
+	if !ok || tile != tb {
+		t.Fatal("should have hit for b's tile")
+	}
+
+	// Shared budget, so this evicts from a
+	ref2, _ := ref.Add(10*osgrid.Kilometre, 0)
+	b.Allocate(NewTestTile(ref2))
+
+	if _, ok := a.Read(ref); ok {
+		t.Fatal("a's tile should have been evicted from the shared budget")
+	}
+}
+
+func TestCacheStats(t *testing.T) {
+	a := NewCache(200)
+	b := a.Share()
+
+	ref, err := osgrid.ParseGridRef("SH 60 54")
+	if err != nil {
+		panic(err)
+	}
+
+	loads := 0
+	load := func() (Tile, error) {
+		loads++
+		return NewTestTile(ref), nil
+	}
+
+	for i := 0; i < 3; i++ {
+		if _, err := a.Load(ref, load); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if loads != 1 {
+		t.Fatalf("expected 1 load, got %d", loads)
+	}
+
+	st := a.Stats()
+	if st.Hits != 2 || st.Misses != 1 || st.Allocations != 1 || st.BytesResident != 100 {
+		t.Fatalf("unexpected stats: %+v", st)
+	}
+
+	if st.LoadLatency.Count != 1 {
+		t.Fatalf("expected 1 latency observation, got %d", st.LoadLatency.Count)
+	}
+
+	ref2, _ := ref.Add(10*osgrid.Kilometre, 0)
+	ref3, _ := ref.Add(20*osgrid.Kilometre, 0)
+	b.Allocate(NewTestTile(ref2))
+	b.Allocate(NewTestTile(ref3))
+
+	// a's tile was evicted by b
+	st = a.Stats()
+	if st.Evictions != 1 || st.BytesResident != 0 {
+		t.Fatalf("unexpected stats after eviction: %+v", st)
+	}
+
+	total := a.TotalStats()
+	if total.Allocations != 3 || total.BytesResident != 200 || total.Hits != 2 {
+		t.Fatalf("unexpected total stats: %+v", total)
+	}
+}
+
+type testItem int
+
+func (i testItem) MemorySize() int {
+	return 100
+}
+
+func TestCacheLoadItem(t *testing.T) {
+	c := NewCache(200)
+
+	loads := 0
+	load := func(id [2]int) (interface{}, error) {
+		return c.LoadItem(id, func() (interface{}, error) {
+			loads++
+			return testItem(id[0] + id[1]), nil
+		})
+	}
+
+	for _, id := range [][2]int{{1, 2}, {1, 2}, {3, 4}, {1, 2}} {
+		v, err := load(id)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if v.(testItem) != testItem(id[0]+id[1]) {
+			t.Errorf("%v: unexpected item %v", id, v)
+		}
+	}
+
+	if loads != 2 {
+		t.Errorf("expected 2 loads, got %d", loads)
+	}
+
+	// Charged against the budget like tiles, so this evicts {3, 4}
+	load([2]int{5, 6})
+	if _, err := load([2]int{3, 4}); err != nil || loads != 4 {
+		t.Errorf("expected {3, 4} to have been evicted, %d loads", loads)
+	}
+
+	st := c.Stats()
+	if st.Hits != 2 || st.Allocations != 4 || st.Evictions != 2 {
+		t.Errorf("unexpected stats %+v", st)
+	}
+}
@@ -0,0 +1,102 @@
+package vector
+
+import (
+	"math"
+	"sort"
+
+	"github.com/usedbytes/osgrid"
+)
+
+// Index is a spatial index of bounding boxes, for quickly finding the ones
+// which intersect an area. Each box is added to all of the cells of a regular
+// grid which it touches, so the cell size should be around the size of a
+// typical box.
+type Index struct {
+	cellSize float64
+	bounds   []Bounds
+	cells    map[[2]int32][]int32
+	// Bounds of everything in the index
+	extent Bounds
+}
+
+// NewIndex creates an empty Index, with cells of cellSize
+func NewIndex(cellSize osgrid.Distance) *Index {
+	return &Index{
+		cellSize: float64(cellSize),
+		cells:    make(map[[2]int32][]int32),
+		extent:   EmptyBounds(),
+	}
+}
+
+func (idx *Index) cell(p Point) [2]int32 {
+	return [2]int32{int32(math.Floor(p.E / idx.cellSize)), int32(math.Floor(p.N / idx.cellSize))}
+}
+
+// Insert adds b to the index, returning its ID. IDs count up from zero, so
+// they can be used as indices into a slice of the things being indexed.
+func (idx *Index) Insert(b Bounds) int {
+	id := int32(len(idx.bounds))
+	idx.bounds = append(idx.bounds, b)
+
+	if b.Empty() {
+		return int(id)
+	}
+
+	idx.extent = idx.extent.Union(b)
+
+	min, max := idx.cell(b.Min), idx.cell(b.Max)
+	for y := min[1]; y <= max[1]; y++ {
+		for x := min[0]; x <= max[0]; x++ {
+			c := [2]int32{x, y}
+			idx.cells[c] = append(idx.cells[c], id)
+		}
+	}
+
+	return int(id)
+}
+
+// Len returns the number of boxes in the index
+func (idx *Index) Len() int {
+	return len(idx.bounds)
+}
+
+// Search returns the IDs of the boxes which intersect b, in ascending order
+func (idx *Index) Search(b Bounds) []int {
+	if !b.Intersects(idx.extent) {
+		return nil
+	}
+
+	// Don't visit cells which can't have anything in them
+	b = Bounds{
+		Min: Point{math.Max(b.Min.E, idx.extent.Min.E), math.Max(b.Min.N, idx.extent.Min.N)},
+		Max: Point{math.Min(b.Max.E, idx.extent.Max.E), math.Min(b.Max.N, idx.extent.Max.N)},
+	}
+
+	seen := make(map[int32]bool)
+	var ids []int
+
+	min, max := idx.cell(b.Min), idx.cell(b.Max)
+	for y := min[1]; y <= max[1]; y++ {
+		for x := min[0]; x <= max[0]; x++ {
+			for _, id := range idx.cells[[2]int32{x, y}] {
+				if seen[id] {
+					continue
+				}
+				seen[id] = true
+
+				if idx.bounds[id].Intersects(b) {
+					ids = append(ids, int(id))
+				}
+			}
+		}
+	}
+
+	sort.Ints(ids)
+
+	return ids
+}
+
+// SearchPoint returns the IDs of the boxes containing p, in ascending order
+func (idx *Index) SearchPoint(p Point) []int {
+	return idx.Search(Bounds{Min: p, Max: p})
+}
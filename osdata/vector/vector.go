@@ -0,0 +1,203 @@
+// Package vector holds the geometry types shared by the vector data readers,
+// with all coordinates in the National Grid.
+package vector
+
+import (
+	"math"
+
+	"github.com/usedbytes/osgrid"
+)
+
+// Point is a position in metres East and North of the grid's false origin
+// (SV 00). Unlike GridRef, it's floating point, as vector data is often more
+// precise than 1 m.
+type Point struct {
+	E, N float64
+}
+
+// FromGridRef returns the South-West corner of ref
+func FromGridRef(ref osgrid.GridRef) Point {
+	return Point{float64(ref.AbsEasting()), float64(ref.AbsNorthing())}
+}
+
+// GridRef returns the 1 m grid square containing p
+func (p Point) GridRef() (osgrid.GridRef, error) {
+	return osgrid.Origin().Add(osgrid.Distance(math.Floor(p.E)), osgrid.Distance(math.Floor(p.N)))
+}
+
+// Bounds is an axis-aligned bounding box. Unlike osgrid.Rect, all of its
+// edges are inside it, so a single point has valid Bounds.
+type Bounds struct {
+	Min, Max Point
+}
+
+// EmptyBounds contains nothing, and can be extended
+func EmptyBounds() Bounds {
+	return Bounds{
+		Min: Point{math.Inf(1), math.Inf(1)},
+		Max: Point{math.Inf(-1), math.Inf(-1)},
+	}
+}
+
+// BoundsFromRect converts a Rect to Bounds
+func BoundsFromRect(r osgrid.Rect) Bounds {
+	min := FromGridRef(r.BottomLeft)
+	return Bounds{
+		Min: min,
+		Max: Point{min.E + float64(r.Width), min.N + float64(r.Height)},
+	}
+}
+
+func (b Bounds) Empty() bool {
+	return b.Min.E > b.Max.E || b.Min.N > b.Max.N
+}
+
+// Extend b to include p
+func (b Bounds) Extend(p Point) Bounds {
+	return Bounds{
+		Min: Point{math.Min(b.Min.E, p.E), math.Min(b.Min.N, p.N)},
+		Max: Point{math.Max(b.Max.E, p.E), math.Max(b.Max.N, p.N)},
+	}
+}
+
+func (b Bounds) Union(o Bounds) Bounds {
+	if o.Empty() {
+		return b
+	}
+
+	return b.Extend(o.Min).Extend(o.Max)
+}
+
+func (b Bounds) Contains(p Point) bool {
+	return p.E >= b.Min.E && p.E <= b.Max.E && p.N >= b.Min.N && p.N <= b.Max.N
+}
+
+func (b Bounds) Intersects(o Bounds) bool {
+	return !b.Empty() && !o.Empty() &&
+		b.Min.E <= o.Max.E && o.Min.E <= b.Max.E &&
+		b.Min.N <= o.Max.N && o.Min.N <= b.Max.N
+}
+
+// Rect returns the smallest Rect, in whole metres, covering b
+func (b Bounds) Rect() (osgrid.Rect, error) {
+	min, err := osgrid.Origin().Add(osgrid.Distance(math.Floor(b.Min.E)), osgrid.Distance(math.Floor(b.Min.N)))
+	if err != nil {
+		return osgrid.Rect{}, err
+	}
+
+	max, err := osgrid.Origin().Add(osgrid.Distance(math.Ceil(b.Max.E)), osgrid.Distance(math.Ceil(b.Max.N)))
+	if err != nil {
+		return osgrid.Rect{}, err
+	}
+
+	return osgrid.NewRect(min, max), nil
+}
+
+// Geometry is implemented by all of the geometry types
+type Geometry interface {
+	Bounds() Bounds
+}
+
+var mustBeMultiPoint Geometry = &MultiPoint{}
+
+// MultiPoint is one or more points
+type MultiPoint struct {
+	Points []Point
+}
+
+func (m *MultiPoint) Bounds() Bounds {
+	b := EmptyBounds()
+	for _, pt := range m.Points {
+		b = b.Extend(pt)
+	}
+
+	return b
+}
+
+var mustBeMultiLineString Geometry = &MultiLineString{}
+
+// MultiLineString is one or more lines, each made up of connected points
+type MultiLineString struct {
+	Lines [][]Point
+}
+
+func (m *MultiLineString) Bounds() Bounds {
+	b := EmptyBounds()
+	for _, l := range m.Lines {
+		for _, pt := range l {
+			b = b.Extend(pt)
+		}
+	}
+
+	return b
+}
+
+// Length returns the total length of all of the lines, in metres
+func (m *MultiLineString) Length() float64 {
+	total := 0.0
+	for _, l := range m.Lines {
+		for i := 1; i < len(l); i++ {
+			total += math.Hypot(l[i].E-l[i-1].E, l[i].N-l[i-1].N)
+		}
+	}
+
+	return total
+}
+
+var mustBePolygon Geometry = &Polygon{}
+
+// Polygon is an area, made up of one or more rings. Each ring is closed
+// implicitly (the last point doesn't need to be the same as the first).
+//
+// The "even-odd" rule decides what's inside, so rings inside other rings are
+// holes, and a single Polygon can hold several separate areas. This matches
+// the way shapefiles store polygons.
+type Polygon struct {
+	Rings [][]Point
+}
+
+func (p *Polygon) Bounds() Bounds {
+	b := EmptyBounds()
+	for _, r := range p.Rings {
+		for _, pt := range r {
+			b = b.Extend(pt)
+		}
+	}
+
+	return b
+}
+
+// Contains returns true if pt is inside p
+func (p *Polygon) Contains(pt Point) bool {
+	inside := false
+
+	for _, r := range p.Rings {
+		for i := range r {
+			a, b := r[i], r[(i+1)%len(r)]
+
+			// Count crossings of a ray from pt towards +E
+			if (a.N > pt.N) != (b.N > pt.N) {
+				e := a.E + (pt.N-a.N)*(b.E-a.E)/(b.N-a.N)
+				if pt.E < e {
+					inside = !inside
+				}
+			}
+		}
+	}
+
+	return inside
+}
+
+// Feature is a geometry, with its attributes
+type Feature struct {
+	ID         int64
+	Geometry   Geometry
+	Attributes map[string]interface{}
+}
+
+// StringAttr returns the attribute called name, or "" if it doesn't exist or
+// isn't a string
+func (f *Feature) StringAttr(name string) string {
+	s, _ := f.Attributes[name].(string)
+	return s
+}
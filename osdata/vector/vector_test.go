@@ -0,0 +1,150 @@
+package vector_test
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/usedbytes/osgrid"
+	"github.com/usedbytes/osgrid/osdata/vector"
+	"github.com/usedbytes/osgrid/osdata/vector/vectortest"
+)
+
+func TestPolygonContains(t *testing.T) {
+	// A square with a square hole, and a separate triangle
+	p := &vector.Polygon{
+		Rings: [][]vector.Point{
+			vectortest.Square(0, 0, 100),
+			vectortest.Square(25, 25, 50),
+			{{E: 200, N: 0}, {E: 300, N: 0}, {E: 250, N: 100}},
+		},
+	}
+
+	for _, tc := range []struct {
+		pt     vector.Point
+		inside bool
+	}{
+		{vector.Point{E: 10, N: 10}, true},
+		{vector.Point{E: 50, N: 50}, false},
+		{vector.Point{E: 80, N: 50}, true},
+		{vector.Point{E: 150, N: 50}, false},
+		{vector.Point{E: 250, N: 50}, true},
+		{vector.Point{E: 210, N: 90}, false},
+		{vector.Point{E: -1, N: 50}, false},
+	} {
+		if p.Contains(tc.pt) != tc.inside {
+			t.Errorf("Contains(%v): expected %v", tc.pt, tc.inside)
+		}
+	}
+
+	exp := vector.Bounds{Min: vector.Point{E: 0, N: 0}, Max: vector.Point{E: 300, N: 100}}
+	if b := p.Bounds(); b != exp {
+		t.Errorf("expected bounds %v, got %v", exp, b)
+	}
+}
+
+func TestBounds(t *testing.T) {
+	b := vector.EmptyBounds()
+	if !b.Empty() {
+		t.Errorf("expected empty bounds")
+	}
+
+	b = b.Extend(vector.Point{E: 10.5, N: 20.5}).Extend(vector.Point{E: 100.2, N: 30})
+	if b.Empty() || b.Min != (vector.Point{E: 10.5, N: 20.5}) || b.Max != (vector.Point{E: 100.2, N: 30}) {
+		t.Errorf("unexpected bounds %v", b)
+	}
+
+	if !b.Intersects(vector.Bounds{Min: vector.Point{E: 100.2, N: 0}, Max: vector.Point{E: 200, N: 20.5}}) {
+		t.Errorf("expected touching bounds to intersect")
+	}
+
+	if b.Intersects(vector.EmptyBounds()) {
+		t.Errorf("expected empty bounds not to intersect")
+	}
+
+	r, err := b.Rect()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if r.BottomLeft.AbsEasting() != 10 || r.BottomLeft.AbsNorthing() != 20 || r.Width != 91 || r.Height != 10 {
+		t.Errorf("unexpected rect %v", r)
+	}
+
+	if vector.BoundsFromRect(r) != (vector.Bounds{Min: vector.Point{E: 10, N: 20}, Max: vector.Point{E: 101, N: 30}}) {
+		t.Errorf("unexpected bounds from rect %v", vector.BoundsFromRect(r))
+	}
+
+	ref, _ := osgrid.ParseGridRef("SH 60986 54375")
+	p := vector.FromGridRef(ref)
+	back, err := vector.Point{E: p.E + 0.9, N: p.N + 0.1}.GridRef()
+	if err != nil || back != ref {
+		t.Errorf("expected %v, got %v (%v)", ref, back, err)
+	}
+}
+
+func TestIndex(t *testing.T) {
+	idx := vector.NewIndex(10 * osgrid.Kilometre)
+
+	boxes := []vector.Bounds{
+		{Min: vector.Point{E: 0, N: 0}, Max: vector.Point{E: 5000, N: 5000}},
+		// Spans lots of cells
+		{Min: vector.Point{E: 0, N: 0}, Max: vector.Point{E: 95000, N: 95000}},
+		{Min: vector.Point{E: 50000, N: 50000}, Max: vector.Point{E: 51000, N: 51000}},
+		vector.EmptyBounds(),
+		{Min: vector.Point{E: 20000, N: 0}, Max: vector.Point{E: 20000, N: 0}},
+	}
+
+	for i, b := range boxes {
+		if id := idx.Insert(b); id != i {
+			t.Errorf("expected ID %d, got %d", i, id)
+		}
+	}
+
+	if idx.Len() != len(boxes) {
+		t.Errorf("expected %d boxes, got %d", len(boxes), idx.Len())
+	}
+
+	for _, tc := range []struct {
+		b   vector.Bounds
+		ids []int
+	}{
+		{vector.Bounds{Min: vector.Point{E: 1000, N: 1000}, Max: vector.Point{E: 1000, N: 1000}}, []int{0, 1}},
+		{vector.Bounds{Min: vector.Point{E: 6000, N: 6000}, Max: vector.Point{E: 7000, N: 7000}}, []int{1}},
+		{vector.Bounds{Min: vector.Point{E: 19000, N: -1000}, Max: vector.Point{E: 60000, N: 60000}}, []int{1, 2, 4}},
+		{vector.Bounds{Min: vector.Point{E: -100000, N: -100000}, Max: vector.Point{E: 1000000, N: 1000000}}, []int{0, 1, 2, 4}},
+		{vector.Bounds{Min: vector.Point{E: 200000, N: 200000}, Max: vector.Point{E: 300000, N: 300000}}, nil},
+		{vector.EmptyBounds(), nil},
+	} {
+		ids := idx.Search(tc.b)
+		if !reflect.DeepEqual(ids, tc.ids) {
+			t.Errorf("Search(%v): expected %v, got %v", tc.b, tc.ids, ids)
+		}
+	}
+
+	if ids := idx.SearchPoint(vector.Point{E: 50500, N: 50500}); !reflect.DeepEqual(ids, []int{1, 2}) {
+		t.Errorf("SearchPoint: expected [1 2], got %v", ids)
+	}
+}
+
+func TestLines(t *testing.T) {
+	m := &vector.MultiLineString{
+		Lines: [][]vector.Point{
+			{{E: 0, N: 0}, {E: 3, N: 4}, {E: 3, N: 10}},
+			{{E: -5, N: 20}, {E: -5, N: 21}},
+		},
+	}
+
+	if l := m.Length(); l != 12 {
+		t.Errorf("expected length 12, got %v", l)
+	}
+
+	exp := vector.Bounds{Min: vector.Point{E: -5, N: 0}, Max: vector.Point{E: 3, N: 21}}
+	if b := m.Bounds(); b != exp {
+		t.Errorf("expected bounds %v, got %v", exp, b)
+	}
+
+	p := &vector.MultiPoint{Points: []vector.Point{{E: 1, N: 2}}}
+	if b := p.Bounds(); b != (vector.Bounds{Min: vector.Point{E: 1, N: 2}, Max: vector.Point{E: 1, N: 2}}) {
+		t.Errorf("unexpected point bounds %v", b)
+	}
+}
@@ -0,0 +1,15 @@
+// Package vectortest has helpers for testing the packages which use vector
+// data
+package vectortest
+
+import (
+	"github.com/usedbytes/osgrid/osdata/vector"
+)
+
+// Square returns a closed ring around the square with its South-West corner
+// at (e, n), going anticlockwise from there.
+func Square(e, n, size float64) []vector.Point {
+	return []vector.Point{
+		{E: e, N: n}, {E: e + size, N: n}, {E: e + size, N: n + size}, {E: e, N: n + size}, {E: e, N: n},
+	}
+}
@@ -0,0 +1,226 @@
+package wmts
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// Metres per pixel at a scale denominator of 1, using the standard 0.28 mm
+// pixel
+const metresPerPixelPerScale = 0.00028
+
+// Capabilities is the subset of a WMTS GetCapabilities document needed to
+// fetch tiles
+type Capabilities struct {
+	Layers         []Layer
+	TileMatrixSets []TileMatrixSet
+	// URL for KVP-encoded GetTile requests, if the server supports them
+	GetTileURL string
+}
+
+type Layer struct {
+	Identifier string
+	Formats    []string
+	// Style identifiers, with the default first
+	Styles         []string
+	TileMatrixSets []string
+	// Templates for RESTful tile URLs, by format
+	ResourceURLs map[string]string
+}
+
+type TileMatrixSet struct {
+	Identifier   string
+	SupportedCRS string
+	TileMatrices []TileMatrix
+}
+
+type TileMatrix struct {
+	Identifier       string
+	ScaleDenominator float64
+	// Easting and northing of the top-left corner of the top-left tile
+	TopLeftCorner             [2]float64
+	TileWidth, TileHeight     int
+	MatrixWidth, MatrixHeight int
+}
+
+// Resolution returns the size of a pixel, in metres
+func (m *TileMatrix) Resolution() float64 {
+	return m.ScaleDenominator * metresPerPixelPerScale
+}
+
+// The XML structure, matching local names so that namespace prefixes don't
+// matter
+type capabilitiesXML struct {
+	Operations []struct {
+		Name string `xml:"name,attr"`
+		Gets []struct {
+			Href     string   `xml:"href,attr"`
+			Encoding []string `xml:"Constraint>AllowedValues>Value"`
+		} `xml:"DCP>HTTP>Get"`
+	} `xml:"OperationsMetadata>Operation"`
+	Layers []struct {
+		Identifier string   `xml:"Identifier"`
+		Formats    []string `xml:"Format"`
+		Styles     []struct {
+			IsDefault  bool   `xml:"isDefault,attr"`
+			Identifier string `xml:"Identifier"`
+		} `xml:"Style"`
+		TileMatrixSets []string `xml:"TileMatrixSetLink>TileMatrixSet"`
+		ResourceURLs   []struct {
+			Format       string `xml:"format,attr"`
+			ResourceType string `xml:"resourceType,attr"`
+			Template     string `xml:"template,attr"`
+		} `xml:"ResourceURL"`
+	} `xml:"Contents>Layer"`
+	TileMatrixSets []struct {
+		Identifier   string `xml:"Identifier"`
+		SupportedCRS string `xml:"SupportedCRS"`
+		TileMatrices []struct {
+			Identifier       string  `xml:"Identifier"`
+			ScaleDenominator float64 `xml:"ScaleDenominator"`
+			TopLeftCorner    string  `xml:"TopLeftCorner"`
+			TileWidth        int     `xml:"TileWidth"`
+			TileHeight       int     `xml:"TileHeight"`
+			MatrixWidth      int     `xml:"MatrixWidth"`
+			MatrixHeight     int     `xml:"MatrixHeight"`
+		} `xml:"TileMatrix"`
+	} `xml:"Contents>TileMatrixSet"`
+}
+
+func ParseCapabilities(r io.Reader) (*Capabilities, error) {
+	var doc capabilitiesXML
+	err := xml.NewDecoder(r).Decode(&doc)
+	if err != nil {
+		return nil, fmt.Errorf("invalid capabilities: %w", err)
+	}
+
+	caps := &Capabilities{}
+
+	for _, op := range doc.Operations {
+		if op.Name != "GetTile" {
+			continue
+		}
+
+		for _, get := range op.Gets {
+			kvp := len(get.Encoding) == 0
+			for _, enc := range get.Encoding {
+				kvp = kvp || enc == "KVP"
+			}
+
+			if kvp && caps.GetTileURL == "" {
+				caps.GetTileURL = get.Href
+			}
+		}
+	}
+
+	for _, l := range doc.Layers {
+		layer := Layer{
+			Identifier:     l.Identifier,
+			Formats:        l.Formats,
+			TileMatrixSets: l.TileMatrixSets,
+			ResourceURLs:   make(map[string]string),
+		}
+
+		for _, s := range l.Styles {
+			if s.IsDefault {
+				layer.Styles = append([]string{s.Identifier}, layer.Styles...)
+			} else {
+				layer.Styles = append(layer.Styles, s.Identifier)
+			}
+		}
+
+		for _, u := range l.ResourceURLs {
+			if u.ResourceType == "tile" {
+				layer.ResourceURLs[u.Format] = u.Template
+			}
+		}
+
+		caps.Layers = append(caps.Layers, layer)
+	}
+
+	for _, s := range doc.TileMatrixSets {
+		set := TileMatrixSet{
+			Identifier:   s.Identifier,
+			SupportedCRS: strings.TrimSpace(s.SupportedCRS),
+		}
+
+		for _, m := range s.TileMatrices {
+			fields := strings.Fields(m.TopLeftCorner)
+			if len(fields) != 2 {
+				return nil, fmt.Errorf("tile matrix %s: invalid TopLeftCorner '%s'", m.Identifier, m.TopLeftCorner)
+			}
+
+			var corner [2]float64
+			for i, f := range fields {
+				corner[i], err = strconv.ParseFloat(f, 64)
+				if err != nil {
+					return nil, fmt.Errorf("tile matrix %s: invalid TopLeftCorner '%s'", m.Identifier, m.TopLeftCorner)
+				}
+			}
+
+			if m.ScaleDenominator <= 0 || m.TileWidth <= 0 || m.TileHeight <= 0 {
+				return nil, fmt.Errorf("tile matrix %s: invalid size", m.Identifier)
+			}
+
+			set.TileMatrices = append(set.TileMatrices, TileMatrix{
+				Identifier:       strings.TrimSpace(m.Identifier),
+				ScaleDenominator: m.ScaleDenominator,
+				TopLeftCorner:    corner,
+				TileWidth:        m.TileWidth,
+				TileHeight:       m.TileHeight,
+				MatrixWidth:      m.MatrixWidth,
+				MatrixHeight:     m.MatrixHeight,
+			})
+		}
+
+		caps.TileMatrixSets = append(caps.TileMatrixSets, set)
+	}
+
+	return caps, nil
+}
+
+func (c *Capabilities) Layer(identifier string) (*Layer, bool) {
+	for i := range c.Layers {
+		if c.Layers[i].Identifier == identifier {
+			return &c.Layers[i], true
+		}
+	}
+
+	return nil, false
+}
+
+func (c *Capabilities) TileMatrixSet(identifier string) (*TileMatrixSet, bool) {
+	for i := range c.TileMatrixSets {
+		if c.TileMatrixSets[i].Identifier == identifier {
+			return &c.TileMatrixSets[i], true
+		}
+	}
+
+	return nil, false
+}
+
+// IsBritishNationalGrid returns true if the set is in EPSG:27700
+func (s *TileMatrixSet) IsBritishNationalGrid() bool {
+	crs := s.SupportedCRS
+	return strings.HasSuffix(crs, ":27700") || strings.HasSuffix(crs, "/27700")
+}
+
+// TileMatrix finds a matrix by its identifier, or by its index in the set
+// (i.e. zoom level) if identifier is a number which isn't an identifier.
+func (s *TileMatrixSet) TileMatrix(identifier string) (*TileMatrix, bool) {
+	for i := range s.TileMatrices {
+		if s.TileMatrices[i].Identifier == identifier {
+			return &s.TileMatrices[i], true
+		}
+	}
+
+	idx, err := strconv.Atoi(identifier)
+	if err == nil && idx >= 0 && idx < len(s.TileMatrices) {
+		return &s.TileMatrices[idx], true
+	}
+
+	return nil, false
+}
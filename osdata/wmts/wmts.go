@@ -0,0 +1,412 @@
+// Package wmts provides the tiles of a WMTS (Web Map Tile Service) layer in
+// British National Grid (EPSG:27700), such as the OS Maps API, as an
+// osdata.ImageDatabase.
+//
+// WMTS tiles aren't aligned to the National Grid, so the database's tiles are
+// assembled from the WMTS tiles covering them, and resampled (nearest
+// neighbour) to a whole number of pixels per metre.
+package wmts
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"image/draw"
+	"math"
+	"net/url"
+	"strconv"
+	"strings"
+
+	// Image formats which tiles can be in
+	_ "image/jpeg"
+	_ "image/png"
+
+	"github.com/usedbytes/osgrid"
+	"github.com/usedbytes/osgrid/osdata"
+	"github.com/usedbytes/osgrid/osdata/httptile"
+)
+
+var mustBeImageDatabase osdata.ImageDatabase = &Database{}
+
+// Database fetches the tiles of a single layer, at a single zoom level (tile
+// matrix)
+type Database struct {
+	client *httptile.Client
+
+	layer  string
+	style  string
+	format string
+	set    *TileMatrixSet
+	matrix *TileMatrix
+	// RESTful template, or empty to use KVP requests to getTileURL
+	template   string
+	getTileURL string
+
+	tileSize       osgrid.Distance
+	precision      osgrid.Distance
+	pixelPrecision int
+	cache          *osdata.Cache
+
+	// Decoded WMTS tiles, by row and column, so that neighbouring database
+	// tiles don't have to fetch them again. A namespace of cache.
+	sources *osdata.Cache
+}
+
+// A decoded WMTS tile. img is nil for missing tiles.
+type sourceTile struct {
+	img *image.RGBA
+}
+
+func (t *sourceTile) MemorySize() int {
+	if t.img == nil {
+		// Charged something, so missing tiles can't build up without
+		// limit
+		return 64
+	}
+
+	return len(t.img.Pix)
+}
+
+type Opt func(*Database)
+
+// Use the tile matrix set with identifier, instead of the first one linked to
+// the layer in EPSG:27700
+func TileMatrixSetOpt(identifier string) Opt {
+	return func(d *Database) {
+		d.set = &TileMatrixSet{Identifier: identifier}
+	}
+}
+
+// Use style, instead of the layer's default
+func StyleOpt(style string) Opt {
+	return func(d *Database) {
+		d.style = style
+	}
+}
+
+// Request tiles in format (a MIME type), instead of the layer's first format
+func FormatOpt(format string) Opt {
+	return func(d *Database) {
+		d.format = format
+	}
+}
+
+// Size of the database's tiles, default 1 km. Must divide 100 km.
+func TileSizeOpt(size osgrid.Distance) Opt {
+	return func(d *Database) {
+		d.tileSize = size
+	}
+}
+
+// Precision of the database's tiles, and pixels per precision. By default the
+// precision is the largest divisor of the tile size which isn't larger than a
+// WMTS pixel, with as many pixels as gets closest to the WMTS resolution.
+func PrecisionOpt(precision osgrid.Distance, pixelPrecision int) Opt {
+	return func(d *Database) {
+		d.precision = precision
+		d.pixelPrecision = pixelPrecision
+	}
+}
+
+// Options for the database's tile cache
+func DatabaseOpts(opts ...osdata.DatabaseOpt) Opt {
+	return func(d *Database) {
+		d.cache = osdata.NewDatabaseConfig(opts...).Cache
+	}
+}
+
+// OpenDatabase fetches the GetCapabilities document from capabilitiesURL, and
+// then calls NewDatabase.
+func OpenDatabase(client *httptile.Client, capabilitiesURL, layer, tileMatrix string, opts ...Opt) (*Database, error) {
+	body, err := client.Fetch(capabilitiesURL)
+	if err != nil {
+		return nil, err
+	}
+
+	caps, err := ParseCapabilities(bytes.NewReader(body))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", capabilitiesURL, err)
+	}
+
+	return NewDatabase(client, caps, layer, tileMatrix, opts...)
+}
+
+// Pick a precision and number of pixels per precision close to resolution
+func choosePrecision(tileSize osgrid.Distance, resolution float64) (osgrid.Distance, int) {
+	precision := osgrid.Distance(1)
+	// Allow for rounding errors in the scale denominator
+	for p := osgrid.Distance(math.Floor(resolution + 1e-6)); p > 1; p-- {
+		if tileSize%p == 0 {
+			precision = p
+			break
+		}
+	}
+
+	pixelPrecision := int(math.Round(float64(precision) / resolution))
+	if pixelPrecision < 1 {
+		pixelPrecision = 1
+	}
+
+	return precision, pixelPrecision
+}
+
+// NewDatabase provides the tiles of layer from the tile matrix with the given
+// identifier (or index, see TileMatrixSet.TileMatrix).
+func NewDatabase(client *httptile.Client, caps *Capabilities, layer, tileMatrix string, opts ...Opt) (*Database, error) {
+	d := &Database{
+		client:   client,
+		layer:    layer,
+		tileSize: 1 * osgrid.Kilometre,
+	}
+
+	for _, opt := range opts {
+		opt(d)
+	}
+
+	if d.cache == nil {
+		d.cache = osdata.NewDatabaseConfig().Cache
+	}
+	d.sources = d.cache.Share()
+
+	l, ok := caps.Layer(layer)
+	if !ok {
+		return nil, fmt.Errorf("layer '%s' not found", layer)
+	}
+
+	if d.set != nil {
+		d.set, ok = caps.TileMatrixSet(d.set.Identifier)
+		if !ok {
+			return nil, fmt.Errorf("tile matrix set not found")
+		}
+	} else {
+		for _, id := range l.TileMatrixSets {
+			set, ok := caps.TileMatrixSet(id)
+			if ok && set.IsBritishNationalGrid() {
+				d.set = set
+				break
+			}
+		}
+
+		if d.set == nil {
+			return nil, fmt.Errorf("layer '%s' has no tile matrix set in EPSG:27700", layer)
+		}
+	}
+
+	if !d.set.IsBritishNationalGrid() {
+		return nil, fmt.Errorf("tile matrix set '%s' is in %s, not EPSG:27700", d.set.Identifier, d.set.SupportedCRS)
+	}
+
+	d.matrix, ok = d.set.TileMatrix(tileMatrix)
+	if !ok {
+		return nil, fmt.Errorf("tile matrix '%s' not found in '%s'", tileMatrix, d.set.Identifier)
+	}
+
+	if d.style == "" && len(l.Styles) > 0 {
+		d.style = l.Styles[0]
+	}
+
+	if d.format == "" {
+		if len(l.Formats) == 0 {
+			return nil, fmt.Errorf("layer '%s' has no formats", layer)
+		}
+		d.format = l.Formats[0]
+	}
+
+	d.template = l.ResourceURLs[d.format]
+	d.getTileURL = caps.GetTileURL
+	if d.template == "" && d.getTileURL == "" {
+		return nil, fmt.Errorf("no tile URL for layer '%s' in %s", layer, d.format)
+	}
+
+	if d.tileSize <= 0 || (100*osgrid.Kilometre)%d.tileSize != 0 {
+		return nil, fmt.Errorf("tile size (%d) must divide 100 km", d.tileSize)
+	}
+
+	if d.precision == 0 {
+		d.precision, d.pixelPrecision = choosePrecision(d.tileSize, d.matrix.Resolution())
+	}
+
+	if d.precision <= 0 || d.pixelPrecision <= 0 || d.tileSize%d.precision != 0 {
+		return nil, fmt.Errorf("tile size (%d) must be a multiple of precision (%d)", d.tileSize, d.precision)
+	}
+
+	return d, nil
+}
+
+// TileURL returns the URL of the WMTS tile at row, col
+func (d *Database) TileURL(row, col int) string {
+	if d.template != "" {
+		return strings.NewReplacer(
+			"{TileMatrixSet}", d.set.Identifier,
+			"{TileMatrix}", d.matrix.Identifier,
+			"{TileRow}", strconv.Itoa(row),
+			"{TileCol}", strconv.Itoa(col),
+			"{Style}", d.style,
+		).Replace(d.template)
+	}
+
+	params := url.Values{}
+	params.Set("SERVICE", "WMTS")
+	params.Set("REQUEST", "GetTile")
+	params.Set("VERSION", "1.0.0")
+	params.Set("LAYER", d.layer)
+	params.Set("STYLE", d.style)
+	params.Set("FORMAT", d.format)
+	params.Set("TILEMATRIXSET", d.set.Identifier)
+	params.Set("TILEMATRIX", d.matrix.Identifier)
+	params.Set("TILEROW", strconv.Itoa(row))
+	params.Set("TILECOL", strconv.Itoa(col))
+
+	sep := "?"
+	if strings.Contains(d.getTileURL, "?") {
+		sep = "&"
+		if strings.HasSuffix(d.getTileURL, "?") || strings.HasSuffix(d.getTileURL, "&") {
+			sep = ""
+		}
+	}
+
+	return d.getTileURL + sep + params.Encode()
+}
+
+// Get a decoded WMTS tile, or nil if it doesn't exist
+func (d *Database) getSource(row, col int) (*image.RGBA, error) {
+	src, err := d.sources.LoadItem([2]int{row, col}, func() (interface{}, error) {
+		return d.loadSource(row, col)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return src.(*sourceTile).img, nil
+}
+
+func (d *Database) loadSource(row, col int) (*sourceTile, error) {
+	body, err := d.client.Fetch(d.TileURL(row, col))
+	if errors.Is(err, osdata.ErrTileNotFound) {
+		return &sourceTile{}, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	decoded, _, err := image.Decode(bytes.NewReader(body))
+	if err != nil {
+		return nil, fmt.Errorf("tile %d,%d: image.Decode: %w", row, col, err)
+	}
+
+	img := image.NewRGBA(image.Rect(0, 0, d.matrix.TileWidth, d.matrix.TileHeight))
+	draw.Draw(img, img.Bounds(), decoded, decoded.Bounds().Min, draw.Src)
+
+	return &sourceTile{img: img}, nil
+}
+
+func (d *Database) generateTile(bottomLeft osgrid.GridRef) (*osdata.RGBATile, error) {
+	size := int(d.tileSize/d.precision) * d.pixelPrecision
+	img := image.NewRGBA(image.Rect(0, 0, size, size))
+
+	m := d.matrix
+	res := m.Resolution()
+	left, top := m.TopLeftCorner[0], m.TopLeftCorner[1]
+
+	e0 := float64(bottomLeft.AbsEasting())
+	n0 := float64(bottomLeft.AbsNorthing())
+	// Metres per pixel
+	p := float64(d.precision) / float64(d.pixelPrecision)
+
+	// The source column and pixel for each output column are the same on
+	// every row. -1 marks columns outside the matrix.
+	pxs := make([]int, size)
+	for x := range pxs {
+		east := e0 + (float64(x)+0.5)*p
+		px := int(math.Floor((east - left) / res))
+		if px < 0 || px/m.TileWidth >= m.MatrixWidth {
+			px = -1
+		}
+		pxs[x] = px
+	}
+
+	found := false
+
+	for y := 0; y < size; y++ {
+		// Sample at the centre of each pixel. Image rows go from North to
+		// South.
+		north := n0 + (float64(size-y)-0.5)*p
+		py := int(math.Floor((top - north) / res))
+		row := py / m.TileHeight
+		if py < 0 || row >= m.MatrixHeight {
+			continue
+		}
+
+		// Only look up the source tile when the column changes
+		col := -1
+		var src *image.RGBA
+
+		for x, px := range pxs {
+			if px < 0 {
+				continue
+			}
+
+			if c := px / m.TileWidth; c != col {
+				var err error
+				src, err = d.getSource(row, c)
+				if err != nil {
+					return nil, err
+				}
+				col = c
+			}
+
+			if src == nil {
+				continue
+			}
+
+			found = true
+
+			si := src.PixOffset(px%m.TileWidth, py%m.TileHeight)
+			di := img.PixOffset(x, y)
+			copy(img.Pix[di:di+4], src.Pix[si:si+4])
+		}
+	}
+
+	if !found {
+		return nil, fmt.Errorf("Tile %s %w", bottomLeft, osdata.ErrTileNotFound)
+	}
+
+	return osdata.NewRGBATile(bottomLeft, d.precision, d.pixelPrecision, img), nil
+}
+
+func (d *Database) GetImageTile(ref osgrid.GridRef) (osdata.ImageTile, error) {
+	ref = ref.Align(d.tileSize)
+
+	tile, err := d.cache.Load(ref, func() (osdata.Tile, error) {
+		return d.generateTile(ref)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return tile.(*osdata.RGBATile), nil
+}
+
+func (d *Database) GetTile(ref osgrid.GridRef) (osdata.Tile, error) {
+	return d.GetImageTile(ref)
+}
+
+func (d *Database) Precision() osgrid.Distance {
+	return d.precision
+}
+
+// PixelPrecision returns the number of pixels per Precision() in the tiles
+func (d *Database) PixelPrecision() int {
+	return d.pixelPrecision
+}
+
+// Stats returns the statistics for the cache of generated tiles. The decoded
+// WMTS tiles they're made from are counted separately, in SourceStats.
+func (d *Database) Stats() osdata.Stats {
+	return d.cache.Stats()
+}
+
+// SourceStats returns the statistics for the cache of decoded WMTS tiles
+func (d *Database) SourceStats() osdata.Stats {
+	return d.sources.Stats()
+}
@@ -0,0 +1,369 @@
+package wmts
+
+import (
+	"errors"
+	"image"
+	"image/color"
+	"image/png"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/usedbytes/osgrid"
+	"github.com/usedbytes/osgrid/lib/texture"
+	"github.com/usedbytes/osgrid/osdata"
+	"github.com/usedbytes/osgrid/osdata/httptile"
+)
+
+// Modelled on the OS Maps API, but with a single 20x20 matrix of 100 px
+// tiles at 10 m/px, with its top-left corner 500 m West of the grid origin
+// and 20.5 km North of it.
+const testCapabilities = `<?xml version="1.0" encoding="UTF-8"?>
+<Capabilities xmlns="http://www.opengis.net/wmts/1.0" xmlns:ows="http://www.opengis.net/ows/1.1"
+	xmlns:xlink="http://www.w3.org/1999/xlink" version="1.0.0">
+	<ows:OperationsMetadata>
+		<ows:Operation name="GetTile">
+			<ows:DCP>
+				<ows:HTTP>
+					<ows:Get xlink:href="{{.URL}}/kvp?key=abc">
+						<ows:Constraint name="GetEncoding">
+							<ows:AllowedValues>
+								<ows:Value>KVP</ows:Value>
+							</ows:AllowedValues>
+						</ows:Constraint>
+					</ows:Get>
+				</ows:HTTP>
+			</ows:DCP>
+		</ows:Operation>
+	</ows:OperationsMetadata>
+	<Contents>
+		<Layer>
+			<ows:Title>Outdoor 27700</ows:Title>
+			<ows:Identifier>Outdoor_27700</ows:Identifier>
+			<Style isDefault="true">
+				<ows:Identifier>default</ows:Identifier>
+			</Style>
+			<Format>image/png</Format>
+			<TileMatrixSetLink>
+				<TileMatrixSet>EPSG:3857</TileMatrixSet>
+			</TileMatrixSetLink>
+			<TileMatrixSetLink>
+				<TileMatrixSet>EPSG:27700</TileMatrixSet>
+			</TileMatrixSetLink>
+			<ResourceURL format="image/png" resourceType="tile"
+				template="{{.URL}}/rest/{TileMatrixSet}/{TileMatrix}/{TileCol}/{TileRow}.png"/>
+		</Layer>
+		<Layer>
+			<ows:Identifier>Light_27700</ows:Identifier>
+			<Format>image/png</Format>
+			<TileMatrixSetLink>
+				<TileMatrixSet>EPSG:27700</TileMatrixSet>
+			</TileMatrixSetLink>
+		</Layer>
+		<TileMatrixSet>
+			<ows:Identifier>EPSG:3857</ows:Identifier>
+			<ows:SupportedCRS>urn:ogc:def:crs:EPSG::3857</ows:SupportedCRS>
+			<TileMatrix>
+				<ows:Identifier>EPSG:3857:0</ows:Identifier>
+				<ScaleDenominator>559082264.0287178</ScaleDenominator>
+				<TopLeftCorner>-20037508.3427892 20037508.3427892</TopLeftCorner>
+				<TileWidth>256</TileWidth>
+				<TileHeight>256</TileHeight>
+				<MatrixWidth>1</MatrixWidth>
+				<MatrixHeight>1</MatrixHeight>
+			</TileMatrix>
+		</TileMatrixSet>
+		<TileMatrixSet>
+			<ows:Identifier>EPSG:27700</ows:Identifier>
+			<ows:SupportedCRS>urn:ogc:def:crs:EPSG::27700</ows:SupportedCRS>
+			<TileMatrix>
+				<ows:Identifier>EPSG:27700:0</ows:Identifier>
+				<ScaleDenominator>35714.28571428571</ScaleDenominator>
+				<TopLeftCorner>-500.0 20500.0</TopLeftCorner>
+				<TileWidth>100</TileWidth>
+				<TileHeight>100</TileHeight>
+				<MatrixWidth>20</MatrixWidth>
+				<MatrixHeight>20</MatrixHeight>
+			</TileMatrix>
+		</TileMatrixSet>
+	</Contents>
+</Capabilities>`
+
+// Each tile is a solid colour, from its row and column
+func tileColor(row, col int) color.RGBA {
+	return color.RGBA{uint8(col), uint8(row), 0x80, 0xff}
+}
+
+type testServer struct {
+	*httptest.Server
+}
+
+func newTestServer(t *testing.T) *testServer {
+	s := &testServer{}
+
+	tile := func(w http.ResponseWriter, row, col string) {
+		r, err1 := strconv.Atoi(row)
+		c, err2 := strconv.Atoi(col)
+		if err1 != nil || err2 != nil || r < 0 || c < 0 || r >= 20 || c >= 20 {
+			http.Error(w, "bad tile", http.StatusBadRequest)
+			return
+		}
+
+		// The bottom-right tile is missing
+		if r == 19 && c == 19 {
+			http.Error(w, "not found", http.StatusNotFound)
+			return
+		}
+
+		img := image.NewRGBA(image.Rect(0, 0, 100, 100))
+		for i := 0; i < len(img.Pix); i += 4 {
+			col := tileColor(r, c)
+			copy(img.Pix[i:], []byte{col.R, col.G, col.B, col.A})
+		}
+
+		w.Header().Set("Content-Type", "image/png")
+		png.Encode(w, img)
+	}
+
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Path == "/capabilities.xml":
+			w.Write([]byte(strings.ReplaceAll(testCapabilities, "{{.URL}}", s.URL)))
+		case strings.HasPrefix(r.URL.Path, "/rest/EPSG:27700/EPSG:27700:0/"):
+			parts := strings.Split(strings.TrimSuffix(r.URL.Path, ".png"), "/")
+			tile(w, parts[len(parts)-1], parts[len(parts)-2])
+		case r.URL.Path == "/kvp":
+			q := r.URL.Query()
+			if q.Get("key") != "abc" || q.Get("LAYER") != "Light_27700" || q.Get("TILEMATRIX") != "EPSG:27700:0" {
+				http.Error(w, "bad request", http.StatusBadRequest)
+				return
+			}
+			tile(w, q.Get("TILEROW"), q.Get("TILECOL"))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+
+	return s
+}
+
+func checkColor(t *testing.T, tile osdata.ImageTile, name string, exp color.RGBA) {
+	ref, _ := osgrid.ParseGridRef(name)
+
+	x, y, err := tile.GetPixelCoord(ref)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c := color.RGBAModel.Convert(tile.GetImage().At(x, y-1))
+	if c != exp {
+		t.Errorf("%s: expected %v, got %v", name, exp, c)
+	}
+}
+
+func TestParseCapabilities(t *testing.T) {
+	caps, err := ParseCapabilities(strings.NewReader(testCapabilities))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(caps.Layers) != 2 || len(caps.TileMatrixSets) != 2 {
+		t.Fatalf("expected 2 layers and 2 tile matrix sets, got %d and %d",
+			len(caps.Layers), len(caps.TileMatrixSets))
+	}
+
+	if caps.GetTileURL != "{{.URL}}/kvp?key=abc" {
+		t.Errorf("unexpected GetTile URL %s", caps.GetTileURL)
+	}
+
+	layer, ok := caps.Layer("Outdoor_27700")
+	if !ok {
+		t.Fatal("layer not found")
+	}
+
+	if len(layer.Styles) != 1 || layer.Styles[0] != "default" || layer.ResourceURLs["image/png"] == "" {
+		t.Errorf("unexpected layer %+v", layer)
+	}
+
+	set, _ := caps.TileMatrixSet("EPSG:27700")
+	if !set.IsBritishNationalGrid() {
+		t.Errorf("expected EPSG:27700")
+	}
+
+	m, ok := set.TileMatrix("0")
+	if !ok || m.Identifier != "EPSG:27700:0" {
+		t.Fatalf("expected to find matrix by index, got %v", m)
+	}
+
+	if res := m.Resolution(); res < 9.999999 || res > 10.000001 {
+		t.Errorf("expected resolution 10, got %v", res)
+	}
+
+	if m.TopLeftCorner != [2]float64{-500, 20500} {
+		t.Errorf("unexpected top-left %v", m.TopLeftCorner)
+	}
+}
+
+func TestDatabase(t *testing.T) {
+	s := newTestServer(t)
+	defer s.Close()
+
+	client := httptile.NewClient(httptile.ClientRetriesOpt(0, 0))
+
+	for _, layer := range []string{"Outdoor_27700", "Light_27700"} {
+		t.Run(layer, func(t *testing.T) {
+			db, err := OpenDatabase(client, s.URL+"/capabilities.xml", layer, "EPSG:27700:0")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if db.Precision() != 10 || db.PixelPrecision() != 1 {
+				t.Errorf("expected 10 m precision with 1 pixel, got %d and %d", db.Precision(), db.PixelPrecision())
+			}
+
+			ref, _ := osgrid.ParseGridRef("SV 02000 03000")
+			tile, err := db.GetImageTile(ref)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if tile.BottomLeft() != ref || tile.Width() != 1*osgrid.Kilometre {
+				t.Errorf("unexpected tile %s, %d", tile.BottomLeft(), tile.Width())
+			}
+
+			if b := tile.GetImage().Bounds(); b.Dx() != 100 || b.Dy() != 100 {
+				t.Errorf("expected 100x100 image, got %v", b)
+			}
+
+			// The WMTS tiles are offset by half a tile, so each grid tile
+			// has parts of 4 WMTS tiles. Column 2 starts at 1500 m East,
+			// row 17 at 2500 m North.
+			checkColor(t, tile, "SV 02000 03000", tileColor(17, 2))
+			checkColor(t, tile, "SV 02490 03490", tileColor(17, 2))
+			checkColor(t, tile, "SV 02500 03000", tileColor(17, 3))
+			checkColor(t, tile, "SV 02000 03500", tileColor(16, 2))
+			checkColor(t, tile, "SV 02990 03990", tileColor(16, 3))
+
+			// Each of the 4 WMTS tiles is fetched once, and looked up once
+			// for each row it covers
+			st := db.SourceStats()
+			if st.Misses != 4 || st.Hits+st.Misses != 2*100 {
+				t.Errorf("expected 4 misses in 200 lookups, got %d in %d", st.Misses, st.Hits+st.Misses)
+			}
+		})
+	}
+}
+
+func TestDatabaseEdges(t *testing.T) {
+	s := newTestServer(t)
+	defer s.Close()
+
+	client := httptile.NewClient(httptile.ClientRetriesOpt(0, 0))
+	db, err := OpenDatabase(client, s.URL+"/capabilities.xml", "Outdoor_27700", "0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Partly outside the matrix (East of 19.5 km), and partly in the
+	// missing tile (row 19 is South of 1.5 km)
+	ref, _ := osgrid.ParseGridRef("SV 19000 01000")
+	tile, err := db.GetImageTile(ref)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	checkColor(t, tile, "SV 19000 01500", tileColor(18, 19))
+	checkColor(t, tile, "SV 19000 01000", color.RGBA{})
+	checkColor(t, tile, "SV 19500 01500", color.RGBA{})
+
+	// Entirely outside
+	ref, _ = osgrid.ParseGridRef("SV 30000 00000")
+	_, err = db.GetImageTile(ref)
+	if !errors.Is(err, osdata.ErrTileNotFound) {
+		t.Errorf("expected ErrTileNotFound, got %v", err)
+	}
+}
+
+func TestDatabaseErrors(t *testing.T) {
+	caps, err := ParseCapabilities(strings.NewReader(testCapabilities))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	client := httptile.NewClient()
+
+	for _, tc := range []struct {
+		name   string
+		layer  string
+		matrix string
+		opts   []Opt
+	}{
+		{"missing layer", "Road_27700", "0", nil},
+		{"missing matrix", "Outdoor_27700", "EPSG:27700:5", nil},
+		{"wrong CRS", "Outdoor_27700", "0", []Opt{TileMatrixSetOpt("EPSG:3857")}},
+		{"bad tile size", "Outdoor_27700", "0", []Opt{TileSizeOpt(3 * osgrid.Kilometre)}},
+	} {
+		_, err := NewDatabase(client, caps, tc.layer, tc.matrix, tc.opts...)
+		if err == nil {
+			t.Errorf("%s: expected error", tc.name)
+		}
+	}
+}
+
+func TestChoosePrecision(t *testing.T) {
+	for _, tc := range []struct {
+		tileSize   osgrid.Distance
+		resolution float64
+		precision  osgrid.Distance
+		pixels     int
+	}{
+		{1000, 10, 10, 1},
+		{1000, 7, 5, 1},
+		{1000, 0.875, 1, 1},
+		{1000, 0.4375, 1, 2},
+		{1000, 0.109375, 1, 9},
+		{10000, 28, 25, 1},
+	} {
+		precision, pixels := choosePrecision(tc.tileSize, tc.resolution)
+		if precision != tc.precision || pixels != tc.pixels {
+			t.Errorf("%v m/px: expected %d m with %d pixels, got %d with %d",
+				tc.resolution, tc.precision, tc.pixels, precision, pixels)
+		}
+	}
+}
+
+func TestGenerateTexture(t *testing.T) {
+	s := newTestServer(t)
+	defer s.Close()
+
+	client := httptile.NewClient(httptile.ClientRetriesOpt(0, 0))
+	db, err := OpenDatabase(client, s.URL+"/capabilities.xml", "Outdoor_27700", "0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 2 km square, over 4 grid tiles
+	centre, _ := osgrid.ParseGridRef("SV 05000 05000")
+	tex, err := texture.GenerateTexture(db, centre, 2*osgrid.Kilometre, 2*osgrid.Kilometre)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if b := tex.Image.Bounds(); b.Dx() != 200 || b.Dy() != 200 {
+		t.Fatalf("expected 200x200 texture, got %v", b)
+	}
+
+	// Top-left is SV 04000 06000, in row 14, column 4
+	c := color.RGBAModel.Convert(tex.Image.At(0, 0))
+	if c != tileColor(14, 4) {
+		t.Errorf("expected %v, got %v", tileColor(14, 4), c)
+	}
+
+	// Bottom-right is SV 05990 04000, in row 16, column 6
+	c = color.RGBAModel.Convert(tex.Image.At(199, 199))
+	if c != tileColor(16, 6) {
+		t.Errorf("expected %v, got %v", tileColor(16, 6), c)
+	}
+}
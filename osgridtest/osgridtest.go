@@ -0,0 +1,22 @@
+// Package osgridtest has helpers for tests which use grid references. It
+// imports "testing", so it mustn't be imported by anything but tests.
+package osgridtest
+
+import (
+	"testing"
+
+	"github.com/usedbytes/osgrid"
+)
+
+// MustRef returns the grid reference e metres East and n metres North of the
+// grid origin, failing the test if that's off the grid.
+func MustRef(t testing.TB, e, n osgrid.Distance) osgrid.GridRef {
+	t.Helper()
+
+	ref, err := osgrid.Origin().Add(e, n)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return ref
+}
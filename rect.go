@@ -0,0 +1,89 @@
+package osgrid
+
+import "fmt"
+
+// Rect is a rectangular area of the grid, extending Width to the East and
+// Height to the North of BottomLeft
+type Rect struct {
+	BottomLeft    GridRef
+	Width, Height Distance
+}
+
+// NewRect returns the smallest Rect with a and b as opposite corners
+func NewRect(a, b GridRef) Rect {
+	minE, maxE := a.AbsEasting(), b.AbsEasting()
+	if maxE < minE {
+		minE, maxE = maxE, minE
+	}
+
+	minN, maxN := a.AbsNorthing(), b.AbsNorthing()
+	if maxN < minN {
+		minN, maxN = maxN, minN
+	}
+
+	// Can't fail, as it's West and South of a valid reference
+	bottomLeft, _ := Origin().Add(minE, minN)
+
+	return Rect{
+		BottomLeft: bottomLeft,
+		Width:      maxE - minE,
+		Height:     maxN - minN,
+	}
+}
+
+func (r Rect) String() string {
+	return fmt.Sprintf("%s +%dx%d", r.BottomLeft, r.Width, r.Height)
+}
+
+func (r Rect) TopRight() (GridRef, error) {
+	return r.BottomLeft.Add(r.Width, r.Height)
+}
+
+func (r Rect) Centre() (GridRef, error) {
+	return r.BottomLeft.Add(r.Width/2, r.Height/2)
+}
+
+// Contains returns true if ref is inside r. The East and North edges are
+// outside, so that adjacent Rects don't overlap.
+func (r Rect) Contains(ref GridRef) bool {
+	e := ref.AbsEasting() - r.BottomLeft.AbsEasting()
+	n := ref.AbsNorthing() - r.BottomLeft.AbsNorthing()
+
+	return e >= 0 && n >= 0 && e < r.Width && n < r.Height
+}
+
+// Intersects returns true if r and o overlap
+func (r Rect) Intersects(o Rect) bool {
+	re, rn := r.BottomLeft.AbsEasting(), r.BottomLeft.AbsNorthing()
+	oe, on := o.BottomLeft.AbsEasting(), o.BottomLeft.AbsNorthing()
+
+	return re < oe+o.Width && oe < re+r.Width && rn < on+o.Height && on < rn+r.Height
+}
+
+// Union returns the smallest Rect containing both r and o
+func (r Rect) Union(o Rect) Rect {
+	minE, minN := r.BottomLeft.AbsEasting(), r.BottomLeft.AbsNorthing()
+	maxE, maxN := minE+r.Width, minN+r.Height
+
+	oe, on := o.BottomLeft.AbsEasting(), o.BottomLeft.AbsNorthing()
+	if oe < minE {
+		minE = oe
+	}
+	if on < minN {
+		minN = on
+	}
+	if oe+o.Width > maxE {
+		maxE = oe + o.Width
+	}
+	if on+o.Height > maxN {
+		maxN = on + o.Height
+	}
+
+	bottomLeft, _ := Origin().Add(minE, minN)
+
+	return Rect{
+		BottomLeft: bottomLeft,
+		Width:      maxE - minE,
+		Height:     maxN - minN,
+	}
+}
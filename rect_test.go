@@ -0,0 +1,78 @@
+package osgrid
+
+import "testing"
+
+func mustParse(t *testing.T, s string) GridRef {
+	ref, err := ParseGridRef(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return ref
+}
+
+func TestNewRect(t *testing.T) {
+	// Opposite corners, either way round, across a square boundary
+	a := mustParse(t, "SH 99000 54000")
+	b := mustParse(t, "SJ 01000 52000")
+
+	for _, r := range []Rect{NewRect(a, b), NewRect(b, a)} {
+		if r.BottomLeft != mustParse(t, "SH 99000 52000") || r.Width != 2000 || r.Height != 2000 {
+			t.Errorf("unexpected rect %s", r)
+		}
+	}
+
+	centre, err := NewRect(a, b).Centre()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if centre != mustParse(t, "SJ 00000 53000") {
+		t.Errorf("unexpected centre %s", centre)
+	}
+}
+
+func TestRectContains(t *testing.T) {
+	r := Rect{BottomLeft: mustParse(t, "SH 60000 54000"), Width: 1000, Height: 500}
+
+	for _, tc := range []struct {
+		ref string
+		exp bool
+	}{
+		{"SH 60000 54000", true},
+		{"SH 60999 54499", true},
+		{"SH 61000 54000", false},
+		{"SH 60000 54500", false},
+		{"SH 59999 54000", false},
+	} {
+		if r.Contains(mustParse(t, tc.ref)) != tc.exp {
+			t.Errorf("%s: expected %v", tc.ref, tc.exp)
+		}
+	}
+}
+
+func TestRectIntersects(t *testing.T) {
+	r := Rect{BottomLeft: mustParse(t, "SH 60000 54000"), Width: 1000, Height: 1000}
+
+	for _, tc := range []struct {
+		bottomLeft string
+		exp        bool
+	}{
+		{"SH 60500 54500", true},
+		{"SH 59500 53500", true},
+		// Touching edges don't intersect
+		{"SH 61000 54000", false},
+		{"SH 59000 54000", false},
+		{"SH 60000 55000", false},
+	} {
+		o := Rect{BottomLeft: mustParse(t, tc.bottomLeft), Width: 1000, Height: 1000}
+		if r.Intersects(o) != tc.exp || o.Intersects(r) != tc.exp {
+			t.Errorf("%s: expected %v", tc.bottomLeft, tc.exp)
+		}
+	}
+
+	u := r.Union(Rect{BottomLeft: mustParse(t, "SH 59000 54500"), Width: 500, Height: 2000})
+	if u.BottomLeft != mustParse(t, "SH 59000 54000") || u.Width != 2000 || u.Height != 2500 {
+		t.Errorf("unexpected union %s", u)
+	}
+}